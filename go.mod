@@ -17,6 +17,7 @@ require (
 	gitlab.com/diamondburned/dotfiles/Scripts/lineprompt v0.0.0-20230407082541-a6924ecdc0d4
 	golang.org/x/crypto v0.7.0
 	golang.org/x/image v0.6.0
+	golang.org/x/sys v0.6.0
 	libdb.so/go-mommy v0.1.1
 	libdb.so/libwebring-go v0.0.0-20230521133149-d80b3d3c5163
 	mvdan.cc/sh/v3 v3.6.1-0.20230510000419-96a1c48ec2d7
@@ -33,6 +34,5 @@ require (
 	github.com/soniakeys/quant v1.0.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
 	golang.org/x/sync v0.1.0 // indirect
-	golang.org/x/sys v0.6.0 // indirect
 	golang.org/x/term v0.6.0 // indirect
 )
@@ -13,12 +13,39 @@ declare global {
     sixel: boolean;
   }): void;
   function vm_start(): void;
-  function vm_set_public_fs(json: string, basePath: string): void;
+  function vm_set_public_fs(manifest: string): void;
+  function vm_export_home(): Promise<void>;
+  function vm_import_home(policy?: ImportPolicy): Promise<ImportStats | null>;
   var console_write: null | ((fd: number, bytes: Uint8Array) => void);
 }
 
 let running: Promise<void> | null = null;
 
+// ImportPolicy decides what importHome does with files that already exist:
+// merge keeps them, overwrite replaces them, and replace deletes everything
+// before importing.
+export type ImportPolicy = "merge" | "overwrite" | "replace";
+
+export type ImportStats = {
+  added: number;
+  replaced: number;
+  skipped: number;
+};
+
+// exportHome downloads a snapshot of the VM's persistent storage.
+export async function exportHome(): Promise<void> {
+  await globalThis.vm_export_home();
+}
+
+// importHome asks for a snapshot made by exportHome or the backup program and
+// restores it. It must be called from a user action like a click. It returns
+// null if no file was chosen.
+export async function importHome(
+  policy: ImportPolicy = "merge"
+): Promise<ImportStats | null> {
+  return await globalThis.vm_import_home(policy);
+}
+
 class TerminalProxy {
   private onDataDisposer: xterm.IDisposable;
   private onResizeDisposer: xterm.IDisposable;
@@ -121,8 +148,8 @@ export async function start(
     });
 
     console.log("initialize public httpfs");
-    const publicFS = await fetch(publicFSURL).then((r) => r.json());
-    globalThis.vm_set_public_fs(JSON.stringify(publicFS.tree), publicFS.base);
+    const publicFS = await fetch(publicFSURL).then((r) => r.text());
+    globalThis.vm_set_public_fs(publicFS);
 
     console.log("starting console...");
     proxy.updateQuery();
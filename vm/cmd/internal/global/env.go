@@ -9,9 +9,12 @@ import (
 	"github.com/lucasb-eyer/go-colorful"
 	"gitlab.com/diamondburned/dotfiles/Scripts/lineprompt/lineprompt"
 	"libdb.so/vm"
+	"libdb.so/vm/programs/mount"
 	"libdb.so/vm/rwfs"
 	"libdb.so/vm/rwfs/kvfs"
 
+	_ "libdb.so/vm/programs/archive"
+	_ "libdb.so/vm/programs/backup"
 	_ "libdb.so/vm/programs/coreutils"
 	_ "libdb.so/vm/programs/hewwo"
 	_ "libdb.so/vm/programs/neofetch"
@@ -30,16 +33,51 @@ const InitialCwd = "/"
 var shellrc []byte
 
 // RootFS is the filesystem that contains default read-only files, such as the
-// shellrc file.
+// shellrc file, and the directories that Namespace mounts on.
 var RootFS = rwfs.ReadOnlyFS(kvfs.New(kvfs.MemoryStorageFromExisting(
 	map[string]kvfs.StoredValue{
 		"/.shellrc": kvfs.StoredFile{Data: shellrc},
+		"/tmp":      kvfs.StoredDirectory{},
+		"/proc":     kvfs.StoredDirectory{},
 	},
 )))
 
+// Namespace returns the default mount namespace, with root mounted on "/", an
+// empty tmpfs on /tmp and the mount listing on /proc. root must have RootFS in
+// it for the mount points to exist.
+func Namespace(root rwfs.FS) (*rwfs.Namespace, error) {
+	ns := rwfs.NewNamespace(rwfs.Mount{
+		FS:     root,
+		Type:   "overlay",
+		Source: "rootfs",
+	})
+
+	if err := ns.Mount(rwfs.Mount{
+		Point:  "/tmp",
+		FS:     mount.TmpFS(),
+		Type:   "tmpfs",
+		Source: "tmpfs",
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := ns.Mount(rwfs.Mount{
+		Point:   "/proc",
+		FS:      mount.ProcFS(ns),
+		Type:    "proc",
+		Source:  "proc",
+		Options: []string{"ro"},
+	}); err != nil {
+		return nil, err
+	}
+
+	return ns, nil
+}
+
 var InitialEnv = vm.EnvironFromMap(map[string]string{
 	"TERM":  "xterm-256color",
 	"HOME":  "/",
+	"PATH":  vm.DefaultPath,
 	"SITE":  "libdb.so",
 	"SHELL": "github.com/mvdan/sh/v3",
 	"SHLVL": "1",
@@ -0,0 +1,178 @@
+// Command jsonfs generates the httpfs manifest of a local directory.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/httpfs"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: jsonfs <path>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	dir := flag.Arg(0)
+	if dir == "" {
+		dir = "."
+	}
+
+	tree, err := scanDir(dir, "/")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(httpfs.Manifest{
+		Version: httpfs.ManifestVersion,
+		Base:    filepath.ToSlash(dir),
+		Tree:    tree,
+	}); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// scanDir returns the tree of the directory at dir, whose path in the tree is
+// treePath.
+func scanDir(dir, treePath string) (httpfs.FileTree, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := make(httpfs.FileTree, len(entries))
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.Name())
+
+		switch {
+		case entry.IsDir():
+			sub, err := scanDir(name, path.Join(treePath, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			tree[entry.Name()] = sub
+
+		case entry.Type()&os.ModeSymlink != 0:
+			info, err := scanSymlink(name, treePath)
+			if err != nil {
+				log.Printf("Skipping %s: %v", name, err)
+				continue
+			}
+			tree[entry.Name()] = info
+
+		case entry.Type().IsRegular():
+			info, err := scanFile(name)
+			if err != nil {
+				return nil, err
+			}
+			tree[entry.Name()] = info
+
+		default:
+			log.Printf("Skipping %s since it's neither a file, a directory nor a symlink", name)
+		}
+	}
+
+	return tree, nil
+}
+
+func scanFile(name string) (httpfs.FileInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return httpfs.FileInfo{}, err
+	}
+	defer f.Close()
+
+	s, err := f.Stat()
+	if err != nil {
+		return httpfs.FileInfo{}, err
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return httpfs.FileInfo{}, err
+	}
+	head = head[:n]
+
+	h := sha256.New()
+	h.Write(head)
+	if _, err := io.Copy(h, f); err != nil {
+		return httpfs.FileInfo{}, err
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(head)
+	}
+
+	return httpfs.FileInfo{
+		Size:        s.Size(),
+		ModTime:     s.ModTime().Unix(),
+		Mode:        s.Mode().Perm(),
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+		ContentType: contentType,
+	}, nil
+}
+
+// scanSymlink returns the info of the symbolic link at name, whose directory
+// in the tree is treeDir. Links that point outside of the tree are rejected,
+// since they can't be followed on the server.
+func scanSymlink(name, treeDir string) (httpfs.FileInfo, error) {
+	target, err := os.Readlink(name)
+	if err != nil {
+		return httpfs.FileInfo{}, err
+	}
+	if filepath.IsAbs(target) {
+		return httpfs.FileInfo{}, fmt.Errorf("absolute target %q", target)
+	}
+
+	target = filepath.ToSlash(target)
+	if escapes(treeDir, target) {
+		return httpfs.FileInfo{}, fmt.Errorf("target %q is outside of the tree", target)
+	}
+
+	return httpfs.FileInfo{
+		Size:   int64(len(target)),
+		Target: target,
+	}, nil
+}
+
+// escapes returns true if the relative target goes above the root of the tree
+// when it's resolved from dir.
+func escapes(dir, target string) bool {
+	depth := len(rwfs.Split(dir))
+	for _, part := range strings.Split(target, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if depth--; depth < 0 {
+				return true
+			}
+		default:
+			depth++
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"syscall/js"
+	"time"
+
+	"libdb.so/vm/programs/backup"
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/kvfs"
+)
+
+// homeFS is the mount namespace of the VM. It is nil until the VM is started.
+var homeFS *rwfs.Namespace
+
+var errNotStarted = errors.New("the VM has not started yet")
+
+// homeStore returns the kvfs store that the root directory is on, along with
+// the root's path within it. It's found through the mounts, like the backup
+// and restore programs do.
+func homeStore(op string) (kvfs.Store, string, error) {
+	if homeFS == nil {
+		return nil, "", errNotStarted
+	}
+	return backup.StoreDir(homeFS, op, "/")
+}
+
+// pickerFocusDelay is how long to wait for a file after the page gets the
+// focus back from a file picker, in browsers without the cancel event.
+const pickerFocusDelay = time.Second
+
+// revokeDelay is how long a download's object URL is kept around after the
+// download is started.
+const revokeDelay = time.Minute
+
+// cancelPick settles the pending file picker of import_home, if any.
+var cancelPick func()
+
+// export_home offers a snapshot of the persistent storage as a download. The
+// returned promise settles once the snapshot is made.
+func export_home(this js.Value, args []js.Value) any { // () => Promise<void>
+	return newPromise(func() (any, error) {
+		store, dir, err := homeStore("export")
+		if err != nil {
+			return nil, err
+		}
+
+		var b bytes.Buffer
+		if err := kvfs.Export(&b, store, dir); err != nil {
+			return nil, err
+		}
+
+		name := "libdb.so-" + time.Now().Format("2006-01-02") + ".tar.gz"
+		download(b.Bytes(), "application/gzip", name)
+		return nil, nil
+	})
+}
+
+// import_home asks for a snapshot to upload and restores it into the
+// persistent storage with the given policy, which is merge by default. The
+// returned promise resolves to what was imported, or to null if no file was
+// chosen.
+//
+// It must be called while handling a user action, like a click, since that is
+// the only time the browser lets a page open a file picker.
+func import_home(this js.Value, args []js.Value) any { // (policy?: string) => Promise<{added, replaced, skipped} | null>
+	policy := kvfs.Merge
+	if len(args) > 0 && args[0].Type() == js.TypeString {
+		p, err := kvfs.ParseImportPolicy(args[0].String())
+		if err != nil {
+			return newPromise(func() (any, error) { return nil, err })
+		}
+		policy = p
+	}
+
+	// Only one picker is waited on at a time. An older one that never settled
+	// resolves to null.
+	if cancelPick != nil {
+		cancelPick()
+	}
+
+	picked := make(chan js.Value, 1)
+	pick := func(file js.Value) {
+		select {
+		case picked <- file:
+		default:
+		}
+	}
+	cancelPick = func() { pick(js.Null()) }
+
+	window := js.Global()
+	document := window.Get("document")
+	input := document.Call("createElement", "input")
+	input.Set("type", "file")
+	input.Set("accept", ".tar.gz,.tgz,.tar")
+
+	onchange := js.FuncOf(func(this js.Value, args []js.Value) any {
+		files := input.Get("files")
+		if files.Get("length").Int() == 0 {
+			pick(js.Null())
+		} else {
+			pick(files.Index(0))
+		}
+		return nil
+	})
+	oncancel := js.FuncOf(func(this js.Value, args []js.Value) any {
+		pick(js.Null())
+		return nil
+	})
+	// Browsers without the cancel event only give the focus back to the page
+	// when the picker is dismissed. The change event may come right after
+	// that, so it's given some time first.
+	onfocus := js.FuncOf(func(this js.Value, args []js.Value) any {
+		time.AfterFunc(pickerFocusDelay, func() { pick(js.Null()) })
+		return nil
+	})
+	input.Call("addEventListener", "change", onchange)
+	input.Call("addEventListener", "cancel", oncancel)
+	window.Call("addEventListener", "focus", onfocus)
+
+	// The picker must be opened now rather than in the promise's goroutine,
+	// which runs after the user action is handled.
+	input.Call("click")
+
+	return newPromise(func() (any, error) {
+		file := <-picked
+		window.Call("removeEventListener", "focus", onfocus)
+		input.Call("removeEventListener", "change", onchange)
+		input.Call("removeEventListener", "cancel", oncancel)
+		onchange.Release()
+		oncancel.Release()
+		onfocus.Release()
+
+		if file.IsNull() {
+			return js.Null(), nil
+		}
+
+		store, dir, err := homeStore("import")
+		if err != nil {
+			return nil, err
+		}
+
+		buf, err := awaitPromise(file.Call("arrayBuffer"))
+		if err != nil {
+			return nil, err
+		}
+
+		array := js.Global().Get("Uint8Array").New(buf)
+		data := make([]byte, array.Get("length").Int())
+		js.CopyBytesToGo(data, array)
+
+		stats, err := kvfs.Import(bytes.NewReader(data), store, dir, policy)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]any{
+			"added":    stats.Added,
+			"replaced": stats.Replaced,
+			"skipped":  stats.Skipped,
+		}, nil
+	})
+}
+
+// download makes the browser save data as a file with the given name.
+func download(data []byte, mimeType, name string) {
+	array := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(array, data)
+
+	blob := js.Global().Get("Blob").New([]any{array}, map[string]any{"type": mimeType})
+	url := js.Global().Get("URL").Call("createObjectURL", blob)
+
+	a := js.Global().Get("document").Call("createElement", "a")
+	a.Set("href", url)
+	a.Set("download", name)
+	a.Call("click")
+
+	// The browser may only start fetching the URL after click returns, so it's
+	// revoked later.
+	var revoke js.Func
+	revoke = js.FuncOf(func(this js.Value, args []js.Value) any {
+		js.Global().Get("URL").Call("revokeObjectURL", url)
+		revoke.Release()
+		return nil
+	})
+	js.Global().Call("setTimeout", revoke, revokeDelay.Milliseconds())
+}
@@ -1,8 +1,10 @@
+//go:build js && wasm
+
 package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -35,6 +37,9 @@ var startCh = make(chan struct{}, 1)
 var terminal vm.Terminal
 var publicFS *httpfs.FS
 
+// publicFSCacheSize is the number of bytes of public files to keep in memory.
+const publicFSCacheSize = 32 << 20
+
 func main() {
 	wr, ww := io.Pipe()
 	input = ww
@@ -53,21 +58,39 @@ func main() {
 		global.Set("vm_update_terminal", js.FuncOf(update_terminal))
 		global.Set("vm_start", js.FuncOf(start))
 		global.Set("vm_set_public_fs", js.FuncOf(set_public_fs))
+		global.Set("vm_export_home", js.FuncOf(export_home))
+		global.Set("vm_import_home", js.FuncOf(import_home))
 	}
 
 	<-startCh
 
+	var store kvfs.Store
+	if idb, err := kvfs.IndexedDBStorage("libdb.so"); err == nil {
+		store = idb
+		migrateLocalStorage(idb)
+	} else {
+		log.Println("cannot use IndexedDB, falling back to local storage:", err)
+		store = kvfs.LocalStorage()
+	}
+
+	ns, err := global.Namespace(rwfs.OverlayFS(
+		kvfs.New(store),
+		rwfs.ReadOnlyFS(global.RootFS),
+		rwfs.ReadOnlyFS(nsfw.WrapFS(publicFS)),
+	))
+	if err != nil {
+		log.Panicln("cannot make mount namespace:", err)
+	}
+	homeFS = ns
+
 	ctx := context.Background()
 	env := vm.Environment{
-		Terminal: terminal,
-		Programs: programs.All(),
-		Filesystem: rwfs.OverlayFS(
-			kvfs.New(kvfs.LocalStorage()),
-			rwfs.ReadOnlyFS(global.RootFS),
-			rwfs.ReadOnlyFS(nsfw.WrapFS(publicFS)),
-		),
-		Cwd:     global.InitialCwd,
-		Environ: global.InitialEnv,
+		Terminal:   terminal,
+		Programs:   programs.All(),
+		Filesystem: ns,
+		Cwd:        global.InitialCwd,
+		Environ:    global.InitialEnv,
+		Umask:      vm.DefaultUmask,
 	}
 
 	interp, err := vm.NewInterpreter(&env, vm.InterpreterOpts{
@@ -86,6 +109,19 @@ func main() {
 	log.Println("interpreter exited. Bye!")
 }
 
+// migrateLocalStorage moves the values that older versions persisted in local
+// storage into store. Once they're moved, there is nothing left to do.
+func migrateLocalStorage(store kvfs.Store) {
+	n, err := kvfs.MigrateStore(store, kvfs.LocalStorage())
+	if err != nil {
+		log.Println("cannot migrate from local storage:", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("migrated %d values from local storage to IndexedDB", n)
+	}
+}
+
 // start unblocks main and starts the interpreter loop. The JS side must have
 // called update_terminal before calling this function.
 func start(this js.Value, args []js.Value) any {
@@ -107,17 +143,16 @@ func write_stdin(this js.Value, args []js.Value) any { // (string) => void
 	return nil
 }
 
-// set_public_fs sets the public file system to the given JSON string.
-func set_public_fs(this js.Value, args []js.Value) any { // (string, string) => void
-	jsonStr := args[0].String()
-	basePath := args[1].String()
-
-	var tree httpfs.FileTree
-	if err := json.Unmarshal([]byte(jsonStr), &tree); err != nil {
-		log.Panicln("cannot unmarshal public fs:", err)
+// set_public_fs sets the public file system to the given JSON manifest.
+func set_public_fs(this js.Value, args []js.Value) any { // (string) => void
+	manifest, err := httpfs.ParseManifest([]byte(args[0].String()))
+	if err != nil {
+		log.Panicln("cannot load public fs:", err)
 	}
 
-	publicFS = httpfs.New(*http.DefaultClient, tree, basePath)
+	publicFS = httpfs.NewWithOptions(*http.DefaultClient, manifest.Tree, manifest.Base, httpfs.Options{
+		Cache: httpfs.MemoryCache(publicFSCacheSize),
+	})
 	return nil
 }
 
@@ -131,3 +166,49 @@ func update_terminal(this js.Value, args []js.Value) any { // ({row, col, xpixel
 	})
 	return nil
 }
+
+// newPromise returns a promise that settles with the result of fn. fn runs in
+// its own goroutine, so it may block on other JS events.
+func newPromise(fn func() (any, error)) js.Value {
+	executor := js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve, reject := args[0], args[1]
+		go func() {
+			v, err := fn()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(v)
+		}()
+		return nil
+	})
+	defer executor.Release()
+
+	return js.Global().Get("Promise").New(executor)
+}
+
+// awaitPromise blocks until the given promise settles.
+func awaitPromise(promise js.Value) (js.Value, error) {
+	type result struct {
+		v   js.Value
+		err error
+	}
+	done := make(chan result, 1)
+
+	then := js.FuncOf(func(this js.Value, args []js.Value) any {
+		done <- result{v: args[0]}
+		return nil
+	})
+	defer then.Release()
+
+	catch := js.FuncOf(func(this js.Value, args []js.Value) any {
+		done <- result{err: errors.New(js.Global().Get("String").Invoke(args[0]).String())}
+		return nil
+	})
+	defer catch.Release()
+
+	promise.Call("then", then, catch)
+
+	r := <-done
+	return r.v, r.err
+}
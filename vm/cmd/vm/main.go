@@ -1,43 +1,145 @@
+//go:build linux
+
 package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
-	"github.com/fatih/color"
+	"golang.org/x/sys/unix"
 	"libdb.so/vm"
+	"libdb.so/vm/cmd/internal/global"
+	"libdb.so/vm/internal/nsfw"
 	"libdb.so/vm/programs"
+	"libdb.so/vm/programs/mount"
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/kvfs"
+)
 
-	_ "libdb.so/vm/programs/hewwo"
+var (
+	dataDir   = defaultDataDir()
+	publicDir = "public/_fs"
+	sixel     = false
 )
 
-var colors = []color.Attribute{
-	color.FgRed,
-	color.FgGreen,
-	color.FgYellow,
-	color.FgBlue,
-	color.FgMagenta,
-	color.FgCyan,
-	color.FgWhite,
+func init() {
+	flag.StringVar(&dataDir, "data", dataDir, "directory to persist the read-write filesystem and kvfs mounts in")
+	flag.StringVar(&publicDir, "public", publicDir, "local directory to use as the public filesystem")
+	flag.BoolVar(&sixel, "sixel", sixel, "assume that the terminal supports SIXEL graphics")
 }
 
 func main() {
-	io := vm.IO{
-		Stdin:  os.Stdin,
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
+	flag.Parse()
+
+	if err := run(context.Background()); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func run(ctx context.Context) error {
+	store, err := openStore(dataDir)
+	if err != nil {
+		return fmt.Errorf("cannot open persistent store: %w", err)
+	}
+	defer store.Close()
+
+	mount.RegisterType("kvfs", mount.DiskKVFS(filepath.Join(dataDir, "mounts")))
+
+	terminal := vm.NewTerminal(
+		vm.IO{
+			Stdin:  os.Stdin,
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+		},
+		queryTerminal(),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go watchTerminal(ctx, terminal)
+
+	ns, err := global.Namespace(rwfs.OverlayFS(
+		kvfs.New(store),
+		rwfs.ReadOnlyFS(global.RootFS),
+		rwfs.ReadOnlyFS(nsfw.WrapFS(os.DirFS(publicDir))),
+	))
+	if err != nil {
+		return fmt.Errorf("cannot make mount namespace: %w", err)
+	}
+
+	env := vm.Environment{
+		Terminal:    terminal,
+		HasTerminal: true,
+		Programs:    programs.All(),
+		Filesystem:  ns,
+		Cwd:         global.InitialCwd,
+		Environ:     global.InitialEnv,
+		Umask:       vm.DefaultUmask,
 	}
 
-	inst, err := vm.NewInterpreter(io, vm.InterpreterOpts{
-		MakeRaw:  false, // maybe?
-		Programs: programs.All(),
+	interp, err := vm.NewInterpreter(&env, vm.InterpreterOpts{
+		Prompt:      global.PromptColored(),
+		RunCommands: ". .shellrc",
 	})
 	if err != nil {
-		log.Fatalln("failed to create console instance:", err)
+		return fmt.Errorf("cannot make new interpreter: %w", err)
 	}
+	// Close must be called before we exit so that the terminal is restored.
+	defer interp.Close()
 
-	if err := inst.Run(context.Background()); err != nil {
-		log.Fatalln("failed to run console instance:", err)
+	return interp.Run(ctx)
+}
+
+// queryTerminal queries the size of the terminal that stdout is attached to.
+func queryTerminal() vm.TerminalQuery {
+	q := vm.TerminalQuery{
+		Width:  80,
+		Height: 24,
+		SIXEL:  sixel,
+	}
+
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return q
+	}
+
+	if ws.Col > 0 && ws.Row > 0 {
+		q.Width = int(ws.Col)
+		q.Height = int(ws.Row)
+	}
+	q.XPixel = int(ws.Xpixel)
+	q.YPixel = int(ws.Ypixel)
+	return q
+}
+
+// watchTerminal updates the terminal query every time the terminal is resized.
+// It blocks until ctx is canceled.
+func watchTerminal(ctx context.Context, terminal vm.Terminal) {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-winch:
+			terminal.UpdateQuery(queryTerminal())
+		}
+	}
+}
+
+func defaultDataDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".libdb.so"
 	}
+	return filepath.Join(dir, "libdb.so")
 }
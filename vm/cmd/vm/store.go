@@ -0,0 +1,64 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"libdb.so/vm/rwfs/kvfs"
+)
+
+// openStore opens the on-disk store inside dir. If dir still has the JSON
+// file that older versions persisted the whole store in, its values are moved
+// into the new store first.
+func openStore(dir string) (*kvfs.DiskStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	store, err := kvfs.OpenDiskStorage(filepath.Join(dir, "kvfs.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateJSONStore(store, filepath.Join(dir, "kvfs.json")); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// migrateJSONStore copies every value in the legacy JSON file at path into
+// store, then renames the file away so that it's only done once. The JSON
+// values are encoded the same way as the browser's local storage does it.
+func migrateJSONStore(store kvfs.Store, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	var raws map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raws); err != nil {
+		return &fs.PathError{Op: "migrate", Path: path, Err: err}
+	}
+
+	for k, raw := range raws {
+		v, err := kvfs.UnmarshalStoredValue(raw)
+		if err != nil {
+			return &fs.PathError{Op: "migrate", Path: k, Err: err}
+		}
+		if err := store.Set(k, v); err != nil {
+			return &fs.PathError{Op: "migrate", Path: k, Err: err}
+		}
+	}
+
+	return os.Rename(path, path+".migrated")
+}
@@ -10,6 +10,9 @@ type ctxKey uint
 const (
 	environmentKey ctxKey = iota
 	loggerKey      ctxKey = iota
+	// shebangDepthKey is the number of shebang interpreters that the current
+	// program was started through.
+	shebangDepthKey ctxKey = iota
 )
 
 // EnvironmentFromContext returns the console environment from the context.
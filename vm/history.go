@@ -0,0 +1,453 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"libdb.so/vm/internal/liner"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+const (
+	// defaultHistFile is the history file used if $HISTFILE is unset. It is
+	// relative to $HOME.
+	defaultHistFile = ".shell_history"
+	// defaultHistSize is the number of entries kept if $HISTSIZE is unset.
+	defaultHistSize = liner.HistoryLimit
+	// defaultHistControl is the value used if $HISTCONTROL is unset.
+	defaultHistControl = "ignoreboth"
+)
+
+// history is the interpreter's command history. It is the source of truth for
+// the prompter's own history, which is only used for scrolling and searching.
+type history struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+// histOpts are the history options as set by the HIST* variables.
+type histOpts struct {
+	file        string // empty if history is not persisted
+	size        int    // negative if unlimited
+	ignoreSpace bool
+	ignoreDups  bool
+	eraseDups   bool
+}
+
+func histOptsFromEnv(env expand.Environ) histOpts {
+	opts := histOpts{size: defaultHistSize}
+
+	if file := env.Get("HISTFILE"); file.IsSet() {
+		opts.file = file.String()
+	} else {
+		opts.file = path.Join(env.Get("HOME").String(), defaultHistFile)
+	}
+
+	if size := env.Get("HISTSIZE"); size.IsSet() {
+		if n, err := strconv.Atoi(size.String()); err == nil {
+			opts.size = n
+		}
+	}
+
+	control := defaultHistControl
+	if v := env.Get("HISTCONTROL"); v.IsSet() {
+		control = v.String()
+	}
+	for _, c := range strings.Split(control, ":") {
+		switch c {
+		case "ignorespace":
+			opts.ignoreSpace = true
+		case "ignoredups":
+			opts.ignoreDups = true
+		case "ignoreboth":
+			opts.ignoreSpace = true
+			opts.ignoreDups = true
+		case "erasedups":
+			opts.eraseDups = true
+		}
+	}
+
+	return opts
+}
+
+// add adds the entry into the history. It returns false if the entry was
+// ignored.
+func (h *history) add(entry string, opts histOpts) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if opts.ignoreDups && len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry {
+		return false
+	}
+
+	if opts.eraseDups {
+		entries := h.entries[:0]
+		for _, e := range h.entries {
+			if e != entry {
+				entries = append(entries, e)
+			}
+		}
+		h.entries = entries
+	}
+
+	h.entries = append(h.entries, entry)
+	h.truncate(opts.size)
+	return true
+}
+
+func (h *history) truncate(size int) {
+	if size >= 0 && len(h.entries) > size {
+		h.entries = append([]string(nil), h.entries[len(h.entries)-size:]...)
+	}
+}
+
+// list returns a copy of all history entries.
+func (h *history) list() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]string(nil), h.entries...)
+}
+
+// get returns the nth entry, counting from 1. Negative numbers count from the
+// end.
+func (h *history) get(n int) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n < 0 {
+		n += len(h.entries) + 1
+	}
+	if n < 1 || n > len(h.entries) {
+		return "", false
+	}
+	return h.entries[n-1], true
+}
+
+// last returns the last entry that starts with prefix.
+func (h *history) last(prefix string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(h.entries[i], prefix) {
+			return h.entries[i], true
+		}
+	}
+	return "", false
+}
+
+// delete deletes the nth entry, counting from 1.
+func (h *history) delete(n int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n < 1 || n > len(h.entries) {
+		return false
+	}
+	h.entries = append(h.entries[:n-1], h.entries[n:]...)
+	return true
+}
+
+func (h *history) clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries = nil
+}
+
+// readFrom reads the history file from r, replacing all current entries.
+// Entries spanning multiple lines have all but their last line ending with a
+// backslash.
+func (h *history) readFrom(r io.Reader, size int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries = h.entries[:0]
+
+	var entry strings.Builder
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if trailingBackslashes(line)%2 == 1 {
+			entry.WriteString(strings.TrimSuffix(line, `\`))
+			entry.WriteByte('\n')
+			continue
+		}
+
+		entry.WriteString(line)
+		if entry.Len() > 0 {
+			h.entries = append(h.entries, entry.String())
+		}
+		entry.Reset()
+	}
+
+	h.truncate(size)
+	return scanner.Err()
+}
+
+// writeTo writes the history file into w.
+func (h *history) writeTo(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, entry := range h.entries {
+		bw.WriteString(strings.ReplaceAll(entry, "\n", "\\\n"))
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// expand expands history designators in the given line. It supports !! for
+// the last entry, !n for the nth entry, !-n for the nth last entry and !prefix
+// for the last entry starting with prefix. Designators within single quotes or
+// escaped with a backslash are left alone. It returns true if the line was
+// changed.
+func (h *history) expand(line string) (string, bool, error) {
+	if !strings.Contains(line, "!") {
+		return line, false, nil
+	}
+
+	var out strings.Builder
+	var inSingle, changed bool
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case c == '\'':
+			inSingle = !inSingle
+		case c == '\\' && !inSingle && i+1 < len(line):
+			out.WriteByte(c)
+			i++
+			c = line[i]
+		case c == '!' && !inSingle && isHistDesignator(line, i):
+			end := i + 1
+			for end < len(line) && !isHistDelimiter(line[end]) {
+				end++
+			}
+			if line[i+1] == '!' {
+				end = i + 2
+			}
+
+			designator := line[i+1 : end]
+
+			var entry string
+			var ok bool
+			switch n, err := strconv.Atoi(designator); {
+			case designator == "!":
+				entry, ok = h.get(-1)
+			case err == nil:
+				entry, ok = h.get(n)
+			default:
+				entry, ok = h.last(designator)
+			}
+			if !ok {
+				return "", false, fmt.Errorf("!%s: event not found", designator)
+			}
+
+			out.WriteString(entry)
+			changed = true
+			i = end - 1
+			continue
+		}
+
+		out.WriteByte(c)
+	}
+
+	return out.String(), changed, nil
+}
+
+// isHistDesignator returns true if the ! at line[i] starts a history
+// designator.
+func isHistDesignator(line string, i int) bool {
+	if i+1 >= len(line) || isHistDelimiter(line[i+1]) || line[i+1] == '=' {
+		return false
+	}
+	// Leave $! and ${!name} alone.
+	if i > 0 && (line[i-1] == '$' || line[i-1] == '{') {
+		return false
+	}
+	return true
+}
+
+func isHistDelimiter(c byte) bool {
+	return unicode.IsSpace(rune(c)) || strings.IndexByte(";|&<>()\"'`", c) >= 0
+}
+
+func trailingBackslashes(line string) int {
+	return len(line) - len(strings.TrimRight(line, `\`))
+}
+
+// loadHistory loads the history file into the history.
+func (inst *Interpreter) loadHistory() {
+	opts := histOptsFromEnv(inst.env.Environ)
+	if opts.file == "" {
+		return
+	}
+
+	f, err := inst.env.Filesystem.Open(opts.file)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			inst.logger.Println("history:", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	if err := inst.history.readFrom(f, opts.size); err != nil {
+		inst.logger.Println("history:", err)
+	}
+
+	inst.syncPrompterHistory()
+}
+
+// saveHistory writes the history into the history file.
+func (inst *Interpreter) saveHistory(env Environment) error {
+	opts := histOptsFromEnv(env.Environ)
+	if opts.file == "" {
+		return nil
+	}
+
+	f, err := env.Filesystem.OpenFile(opts.file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := inst.history.writeTo(f); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write history")
+	}
+
+	return f.Close()
+}
+
+// addHistory adds the given statement into the history and saves it.
+func (inst *Interpreter) addHistory(stmt string) {
+	opts := histOptsFromEnv(inst.env.Environ)
+	if opts.ignoreSpace && strings.HasPrefix(stmt, " ") {
+		return
+	}
+
+	if !inst.history.add(inst.historyEntry(stmt), opts) {
+		return
+	}
+
+	inst.syncPrompterHistory()
+
+	if err := inst.saveHistory(*inst.env); err != nil {
+		inst.logger.Println("history:", err)
+	}
+}
+
+// syncPrompterHistory replaces the prompter's history with ours.
+func (inst *Interpreter) syncPrompterHistory() {
+	inst.prompter.ClearHistory()
+	for _, entry := range inst.history.list() {
+		inst.prompter.AppendHistory(entry)
+	}
+}
+
+// historyEntry returns the history entry for the given statement. Statements
+// spanning multiple lines are joined into a single line where possible.
+func (inst *Interpreter) historyEntry(src string) string {
+	src = strings.TrimSpace(src)
+	if !strings.Contains(src, "\n") {
+		return src
+	}
+
+	shFile, err := inst.shParser.Parse(strings.NewReader(src), "")
+	if err != nil {
+		return src
+	}
+
+	// Here-documents can't be written in a single line.
+	var hasHeredoc bool
+	syntax.Walk(shFile, func(node syntax.Node) bool {
+		if redir, ok := node.(*syntax.Redirect); ok && redir.Hdoc != nil {
+			hasHeredoc = true
+		}
+		return !hasHeredoc
+	})
+	if hasHeredoc {
+		return src
+	}
+
+	var entry strings.Builder
+	if err := syntax.NewPrinter(syntax.SingleLine(true)).Print(&entry, shFile); err != nil {
+		return src
+	}
+	return strings.TrimSpace(entry.String())
+}
+
+const historyUsage = "history [-c] [-d N] [-g PATTERN] [N]"
+
+// historyBuiltin implements the history builtin. It lists the last N entries
+// or all of them, searches them with -g, deletes one with -d or clears them
+// all with -c. Entries are re-run using !n, !! or !prefix.
+func (inst *Interpreter) historyBuiltin(env Environment, args []string) error {
+	args = args[1:]
+
+	switch {
+	case len(args) == 0:
+		return printHistory(env, inst.history.list(), 1, "")
+
+	case args[0] == "-c" && len(args) == 1:
+		inst.history.clear()
+		inst.syncPrompterHistory()
+		return inst.saveHistory(env)
+
+	case args[0] == "-d" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || !inst.history.delete(n) {
+			return fmt.Errorf("%s: history position out of range", args[1])
+		}
+		inst.syncPrompterHistory()
+		return inst.saveHistory(env)
+
+	case args[0] == "-g" && len(args) == 2:
+		return printHistory(env, inst.history.list(), 1, args[1])
+
+	case len(args) == 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return &UsageError{
+				Err:   fmt.Errorf("%s: numeric argument required", args[0]),
+				Usage: historyUsage,
+			}
+		}
+
+		entries := inst.history.list()
+		if n > len(entries) {
+			n = len(entries)
+		}
+		return printHistory(env, entries[len(entries)-n:], len(entries)-n+1, "")
+
+	default:
+		return &UsageError{Usage: historyUsage}
+	}
+}
+
+// printHistory prints the given entries, numbered starting from start. Only
+// entries containing pattern are printed.
+func printHistory(env Environment, entries []string, start int, pattern string) error {
+	w := bufio.NewWriter(env.Terminal.Stdout)
+	for i, entry := range entries {
+		if strings.Contains(entry, pattern) {
+			entry = strings.ReplaceAll(entry, "\n", "\n       ")
+			fmt.Fprintf(w, "%5d  %s\n", start+i, entry)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,176 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+const (
+	ctrlC         = 0x03 // ^C, interrupts the foreground command
+	ctrlBackslash = 0x1C // ^\, kills the foreground command
+)
+
+// inputBuffer buffers everything that is read from the terminal's stdin. It
+// acts somewhat like a terminal's input queue: input that is typed ahead is
+// kept until someone reads it, so reading stdin never blocks on a program that
+// doesn't read its input.
+type inputBuffer struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	buf  bytes.Buffer
+	err  error
+	// wakes is incremented to wake up all blocked readers with ErrInterrupted.
+	wakes uint64
+}
+
+var _ io.ReadCloser = (*inputBuffer)(nil)
+
+func newInputBuffer() *inputBuffer {
+	b := &inputBuffer{}
+	b.cond.L = &b.mu
+	return b
+}
+
+// Read implements io.Reader. It blocks until there is input or until the
+// buffer is interrupted.
+func (b *inputBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wakes := b.wakes
+	for b.buf.Len() == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		if b.wakes != wakes {
+			return 0, ErrInterrupted
+		}
+		b.cond.Wait()
+	}
+
+	return b.buf.Read(p)
+}
+
+// Close implements io.Closer. It does nothing, since the buffer is shared by
+// every program.
+func (b *inputBuffer) Close() error { return nil }
+
+func (b *inputBuffer) write(p []byte) {
+	b.mu.Lock()
+	b.buf.Write(p)
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+func (b *inputBuffer) closeWithError(err error) {
+	b.mu.Lock()
+	b.err = err
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// interrupt discards all typed-ahead input and wakes up all blocked readers.
+func (b *inputBuffer) interrupt() {
+	b.mu.Lock()
+	b.buf.Reset()
+	b.wakes++
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// runningCmd is a command that is being run by the interpreter, either in the
+// foreground or as a background job.
+type runningCmd struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	killed chan struct{}
+	once   sync.Once
+}
+
+type runningCmdKey struct{}
+
+func newRunningCmd(ctx context.Context) *runningCmd {
+	cmd := &runningCmd{killed: make(chan struct{})}
+	cmd.ctx, cmd.cancel = context.WithCancelCause(ctx)
+	cmd.ctx = context.WithValue(cmd.ctx, runningCmdKey{}, cmd)
+	return cmd
+}
+
+func runningCmdFromContext(ctx context.Context) *runningCmd {
+	cmd, _ := ctx.Value(runningCmdKey{}).(*runningCmd)
+	return cmd
+}
+
+// kill cancels the command with the given cause and stops waiting for its
+// programs to return.
+func (cmd *runningCmd) kill(cause error) {
+	cmd.once.Do(func() {
+		cmd.cancel(cause)
+		close(cmd.killed)
+	})
+}
+
+// interrupted returns the error that the command was stopped with, if any.
+func (cmd *runningCmd) interrupted() error {
+	cause := context.Cause(cmd.ctx)
+	if cause == context.Canceled {
+		// Canceled without a cause, meaning we're done with the command.
+		return nil
+	}
+	return cause
+}
+
+// pumpInput copies everything from r into the interpreter's input buffer. It
+// intercepts ^C and ^\ while a command is running in the foreground. It
+// returns when r returns an error.
+func (inst *Interpreter) pumpInput(r io.Reader) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			inst.input.write(inst.handleSignals(buf[:n]))
+		}
+		if err != nil {
+			inst.input.closeWithError(err)
+			return
+		}
+	}
+}
+
+// handleSignals handles any signal characters in b and returns the rest of
+// the input. The returned slice reuses b's memory.
+func (inst *Interpreter) handleSignals(b []byte) []byte {
+	inst.foregroundMu.Lock()
+	fg := inst.foreground
+	inst.foregroundMu.Unlock()
+
+	if fg == nil {
+		// The prompt handles signal characters itself.
+		return b
+	}
+
+	filtered := b[:0]
+	for _, c := range b {
+		switch c {
+		case ctrlC:
+			inst.env.Print("^C\n")
+			fg.cancel(ErrInterrupted)
+			inst.input.interrupt()
+		case ctrlBackslash:
+			inst.env.Print("^\\\n")
+			fg.kill(ErrKilled)
+			inst.input.interrupt()
+		default:
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+func (inst *Interpreter) setForeground(fg *runningCmd) {
+	inst.foregroundMu.Lock()
+	inst.foreground = fg
+	inst.foregroundMu.Unlock()
+}
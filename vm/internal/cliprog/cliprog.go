@@ -3,11 +3,22 @@ package cliprog
 import (
 	"context"
 	"log"
+	"strings"
 
 	"github.com/urfave/cli/v3"
 	"libdb.so/vm"
 )
 
+func init() {
+	// Like GNU coreutils, only --help shows the help, which leaves -h to the
+	// programs, e.g. for human-readable sizes. Programs without their own -h
+	// still show the help on -h, since it is an unknown flag to them.
+	cli.HelpFlag = &cli.BoolFlag{
+		Name:  "help",
+		Usage: "show help",
+	}
+}
+
 // Wrap wraps a cli.App into a vm.Program.
 func Wrap(app cli.App) vm.Program {
 	app.UseShortOptionHandling = true
@@ -36,5 +47,67 @@ func (p program) Run(ctx context.Context, env vm.Environment, args []string) err
 	p.Writer = env.Terminal.Stdout
 	p.ErrWriter = env.Terminal.Stderr
 	p.ExitErrHandler = func(*cli.Context, error) {}
-	return p.App.RunContext(ctx, args)
+	return p.App.RunContext(ctx, splitShortValues(p.App.Flags, args))
+}
+
+// splitShortValues splits short options that have their values attached, e.g.
+// -d: or -nk2, into separate arguments, since the cli package can't parse
+// those. Bool options may still be grouped, e.g. -rn.
+func splitShortValues(flags []cli.Flag, args []string) []string {
+	takesValue := make(map[string]bool)
+	for _, flag := range flags {
+		doc, ok := flag.(cli.DocGenerationFlag)
+		for _, name := range flag.Names() {
+			takesValue[name] = ok && doc.TakesValue()
+		}
+	}
+
+	split := make([]string, 0, len(args))
+	split = append(split, args[0])
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "--" || arg == "-" || !strings.HasPrefix(arg, "-"):
+			// Flags end at the first positional argument.
+			return append(split, args[i:]...)
+		case strings.HasPrefix(arg, "--"):
+			split = append(split, arg)
+			if name := arg[2:]; !strings.Contains(name, "=") && takesValue[name] && i+1 < len(args) {
+				i++
+				split = append(split, args[i])
+			}
+			continue
+		}
+
+		var expanded []string
+		for j, r := range arg[1:] {
+			name := string(r)
+			hasValue, ok := takesValue[name]
+			if !ok {
+				// Unknown flag; let the cli package report it.
+				expanded = nil
+				break
+			}
+			expanded = append(expanded, "-"+name)
+			if hasValue {
+				if value := arg[1+j+len(name):]; value != "" {
+					expanded = append(expanded, value)
+				} else if i+1 < len(args) {
+					i++
+					expanded = append(expanded, args[i])
+				}
+				break
+			}
+		}
+
+		if expanded == nil {
+			split = append(split, arg)
+			continue
+		}
+		split = append(split, expanded...)
+	}
+
+	return split
 }
@@ -0,0 +1,155 @@
+// Package filemode parses and formats permission bits like chmod(1) and
+// umask(1) do.
+package filemode
+
+import (
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Apply applies the mode expression expr to the permission bits of mode and
+// returns the new permission bits. expr is either an octal number or a
+// comma-separated list of symbolic clauses like "u+x" or "go=r". Clauses that
+// don't say who they affect apply to everyone, except for the bits set in
+// umask. isDir decides whether X adds execute permissions.
+//
+// The setuid, setgid and sticky bits aren't supported, so s and t are
+// ignored.
+func Apply(expr string, mode, umask fs.FileMode, isDir bool) (fs.FileMode, error) {
+	mode = mode.Perm()
+
+	if IsOctal(expr) {
+		v, err := strconv.ParseUint(expr, 8, 32)
+		if err != nil || v > 07777 {
+			return 0, errors.Errorf("invalid mode: %q", expr)
+		}
+		return fs.FileMode(v).Perm(), nil
+	}
+
+	for _, clause := range strings.Split(expr, ",") {
+		var ok bool
+		mode, ok = applyClause(clause, mode, umask, isDir)
+		if !ok {
+			return 0, errors.Errorf("invalid mode: %q", expr)
+		}
+	}
+
+	return mode, nil
+}
+
+// IsOctal returns true if expr is an octal mode rather than a symbolic one.
+func IsOctal(expr string) bool {
+	if expr == "" {
+		return false
+	}
+	for _, c := range expr {
+		if c < '0' || c > '7' {
+			return false
+		}
+	}
+	return true
+}
+
+func applyClause(clause string, mode, umask fs.FileMode, isDir bool) (fs.FileMode, bool) {
+	var who fs.FileMode
+	i := 0
+who:
+	for ; i < len(clause); i++ {
+		switch clause[i] {
+		case 'u':
+			who |= 0700
+		case 'g':
+			who |= 0070
+		case 'o':
+			who |= 0007
+		case 'a':
+			who |= 0777
+		default:
+			break who
+		}
+	}
+
+	mask := who
+	if who == 0 {
+		mask = 0777 &^ umask
+	}
+
+	if i == len(clause) {
+		// There must be at least one operator.
+		return 0, false
+	}
+
+	for i < len(clause) {
+		op := clause[i]
+		if op != '+' && op != '-' && op != '=' {
+			return 0, false
+		}
+		i++
+
+		var perm fs.FileMode
+		for ; i < len(clause) && !strings.ContainsRune("+-=", rune(clause[i])); i++ {
+			switch clause[i] {
+			case 'r':
+				perm |= 0444
+			case 'w':
+				perm |= 0222
+			case 'x':
+				perm |= 0111
+			case 'X':
+				if isDir || mode&0111 != 0 {
+					perm |= 0111
+				}
+			case 's', 't':
+				// unsupported, see Apply
+			case 'u':
+				perm |= spread(mode >> 6)
+			case 'g':
+				perm |= spread(mode >> 3)
+			case 'o':
+				perm |= spread(mode)
+			default:
+				return 0, false
+			}
+		}
+
+		switch op {
+		case '+':
+			mode |= perm & mask
+		case '-':
+			mode &^= perm & mask
+		case '=':
+			mode = mode&^mask | perm&mask
+		}
+	}
+
+	return mode, true
+}
+
+// spread copies the lowest 3 bits of perm to the user, group and other bits.
+func spread(perm fs.FileMode) fs.FileMode {
+	perm &= 07
+	return perm<<6 | perm<<3 | perm
+}
+
+// Symbolic formats perm as a symbolic mode that sets it, like "u=rwx,g=rx,o=".
+func Symbolic(perm fs.FileMode) string {
+	var s strings.Builder
+	for i, who := range []string{"u", "g", "o"} {
+		if i > 0 {
+			s.WriteByte(',')
+		}
+		s.WriteString(who)
+		s.WriteByte('=')
+
+		bits := perm >> (6 - 3*i)
+		for j, c := range "rwx" {
+			if bits&(04>>j) != 0 {
+				s.WriteRune(c)
+			}
+		}
+	}
+	return s.String()
+}
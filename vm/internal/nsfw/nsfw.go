@@ -1,5 +1,3 @@
-//go:build js
-
 package nsfw
 
 import (
@@ -51,6 +49,20 @@ func (f nsfwFS) Stat(path string) (fs.FileInfo, error) {
 	return fs.Stat(f.ro, path)
 }
 
+func (f nsfwFS) Lstat(path string) (fs.FileInfo, error) {
+	if pathHasNSFW(path) && !IsEnabled() {
+		return nil, errDenied
+	}
+	return rwfs.Lstat(f.ro, path)
+}
+
+func (f nsfwFS) Readlink(path string) (string, error) {
+	if pathHasNSFW(path) && !IsEnabled() {
+		return "", errDenied
+	}
+	return rwfs.Readlink(f.ro, path)
+}
+
 func pathHasNSFW(path string) bool {
 	for _, part := range rwfs.Split(path) {
 		if part == ".nsfw" {
@@ -0,0 +1,28 @@
+package vars
+
+import "syscall/js"
+
+var localStorage = js.Global().Get("localStorage")
+var storageEvent = js.Global().Get("StorageEvent")
+var dispatchEvent = js.Global().Get("dispatchEvent")
+
+func storageGet(key string) (string, bool) {
+	s := localStorage.Get(key)
+	if s.IsUndefined() {
+		return "", false
+	}
+	return s.String(), true
+}
+
+func storageSet(key, value string) {
+	oldValue := localStorage.Get(key)
+	newValue := js.ValueOf(value)
+	localStorage.Set(key, newValue)
+
+	event := storageEvent.New("storage", map[string]any{
+		"key":      key,
+		"oldValue": oldValue,
+		"newValue": newValue,
+	})
+	dispatchEvent.Invoke(event)
+}
@@ -0,0 +1,21 @@
+//go:build !js
+
+package vars
+
+import "sync"
+
+// storage is the in-memory variable storage used outside the browser. Values
+// are not persisted across runs.
+var storage sync.Map // map[string]string
+
+func storageGet(key string) (string, bool) {
+	v, ok := storage.Load(key)
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
+
+func storageSet(key, value string) {
+	storage.Store(key, value)
+}
@@ -6,13 +6,8 @@ import (
 	"reflect"
 	"slices"
 	"strings"
-	"syscall/js"
 )
 
-var localStorage = js.Global().Get("localStorage")
-var storageEvent = js.Global().Get("StorageEvent")
-var dispatchEvent = js.Global().Get("dispatchEvent")
-
 var knownVariables = map[string]*VariableInfo{}
 
 // Variables defined in /site/lib/prefs.ts.
@@ -57,12 +52,12 @@ func Get(key string) *VariableInfo {
 // Get gets the value of the variable. If the variable does not exist, false is
 // returned.
 func (v *VariableInfo) Get(value any) (bool, error) {
-	s := localStorage.Get(v.Key)
-	if s.IsUndefined() {
+	s, ok := storageGet(v.Key)
+	if !ok {
 		return false, nil
 	}
 
-	if err := json.Unmarshal([]byte(s.String()), value); err != nil {
+	if err := json.Unmarshal([]byte(s), value); err != nil {
 		return false, err
 	}
 
@@ -83,16 +78,7 @@ func (v *VariableInfo) Set(value any) error {
 		return err
 	}
 
-	oldValue := localStorage.Get("nsfw-v1")
-	newValue := js.ValueOf(string(s))
-	localStorage.Set(v.Key, newValue)
-
-	event := storageEvent.New("storage", map[string]any{
-		"key":      v.Key,
-		"oldValue": oldValue,
-		"newValue": newValue,
-	})
-	dispatchEvent.Invoke(event)
+	storageSet(v.Key, string(s))
 	return nil
 }
 
@@ -2,15 +2,23 @@ package vm
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
+	"maps"
 	"math/rand"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"text/tabwriter"
+	"time"
 
+	pathpkg "path"
+
+	cryptorand "crypto/rand"
 	stderrors "errors"
 
 	"github.com/pkg/errors"
@@ -18,6 +26,7 @@ import (
 	"libdb.so/vm/internal/liner"
 	"libdb.so/vm/internal/nsfw"
 	"libdb.so/vm/internal/vars"
+	"libdb.so/vm/rwfs"
 	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/interp"
 	"mvdan.cc/sh/v3/syntax"
@@ -30,6 +39,10 @@ var shellMommy = vars.
 
 var shellMommyGenerator, _ = mommy.NewGenerator(mommy.DefaultResponses)
 
+// completionCmdSubstTimeout is the maximum duration that command substitutions
+// may run for while completing words.
+const completionCmdSubstTimeout = 2 * time.Second
+
 // PromptFunc is a function that returns the prompt string.
 type PromptFunc func(Environment) string
 
@@ -37,7 +50,8 @@ type PromptFunc func(Environment) string
 // prompting the user, printing to console, and running programs.
 type Interpreter struct {
 	shParser    *syntax.Parser
-	shRunner    *interp.Runner
+	shell       *shell
+	codeKey     []byte
 	shExpandCfg *expand.Config
 	prompter    *liner.State
 	logger      *log.Logger
@@ -45,6 +59,13 @@ type Interpreter struct {
 	closes      []func() error
 	opts        InterpreterOpts
 	progNames   []string
+
+	input        *inputBuffer
+	foreground   *runningCmd
+	foregroundMu sync.Mutex
+	jobs         jobTable
+	history      history
+	envMu        sync.RWMutex
 }
 
 // InterpreterOpts are options for creating a new instance.
@@ -53,6 +74,10 @@ type InterpreterOpts struct {
 	RunCommands string
 	// Prompt is a function that returns the prompt string.
 	Prompt PromptFunc
+	// ContinuationPrompt is a function that returns the prompt string used
+	// when the statement continues onto the next line. By default, $PS2 is
+	// used, or "> " if it's not set.
+	ContinuationPrompt PromptFunc
 	// IgnoreEOF, if true, will ignore EOF errors and continue prompting as
 	// usual.
 	IgnoreEOF bool
@@ -61,7 +86,8 @@ type InterpreterOpts struct {
 var builtinCommands = []string{
 	"true", "false", "exit", "set", "shift", "unset", "echo", "printf", "pwd",
 	"cd", "source", "command", "umask", "alias", "unalias", "eval", "test",
-	"exec", "read", "readarray", "shopt",
+	"exec", "read", "readarray", "shopt", "jobs", "fg", "bg", "kill", "wait",
+	"history",
 }
 
 // NewInterpreter creates a new interpreter.
@@ -82,29 +108,43 @@ func NewInterpreter(env *Environment, opts InterpreterOpts) (*Interpreter, error
 		inst.opts.Prompt = func(Environment) string { return "$ " }
 	}
 
-	inst.logger = log.New(inst.env.Terminal.Stderr, "", 0)
-
-	readDir := func(path string) ([]fs.FileInfo, error) {
-		entries, err := fs.ReadDir(env.Filesystem, path)
-		if err != nil {
-			return nil, err
-		}
-
-		infos := make([]fs.FileInfo, len(entries))
-		for i, info := range entries {
-			infos[i], err = info.Info()
-			if err != nil {
-				return nil, fmt.Errorf("%q: %v", info, err)
+	if inst.opts.ContinuationPrompt == nil {
+		inst.opts.ContinuationPrompt = func(env Environment) string {
+			if ps2 := env.Environ.Get("PS2"); ps2.IsSet() {
+				return ps2.String()
 			}
+			return "> "
 		}
+	}
 
-		return infos, nil
+	inst.logger = log.New(inst.env.Terminal.Stderr, "", 0)
+
+	inst.codeKey = make([]byte, sha256.Size)
+	if _, err := cryptorand.Read(inst.codeKey); err != nil {
+		return nil, errors.Wrap(err, "failed to generate key for shell code")
 	}
 
+	// Put the terminal into raw mode if we're given a real one. This must be
+	// done before the prompter is created, since it saves the current terminal
+	// mode to be restored on close. It is fine if this fails, since we might
+	// not be given a real terminal at all.
+	restoreTerminal, _ := inst.env.Terminal.IO.makeRaw()
+
+	// Everyone reads the terminal's input through our buffer, so that we can
+	// catch ^C and ^\ while a program is running.
+	inst.input = newInputBuffer()
+	go inst.pumpInput(inst.env.Terminal.Stdin)
+	inst.env.Terminal = inst.env.Terminal.WithIO(IO{
+		Stdin:  inst.input,
+		Stdout: inst.env.Terminal.Stdout,
+		Stderr: inst.env.Terminal.Stderr,
+	})
+
+	// This config is only used for expanding words during tab completion. Its
+	// CmdSubst is set once we have a context in Run.
 	inst.shExpandCfg = &expand.Config{
 		Env:     inst.env.Environ,
-		ReadDir: readDir,
-		// TODO: CmdSubst
+		ReadDir: inst.readDir,
 	}
 
 	inst.shParser = syntax.NewParser(
@@ -112,43 +152,31 @@ func NewInterpreter(env *Environment, opts InterpreterOpts) (*Interpreter, error
 		syntax.Variant(syntax.LangBash), // we love bash!
 	)
 
-	shRunner, err := interp.New(
-		// TODO: CmdSubst
-		interp.OpenHandler(func(ctx context.Context, path string, flag int, perm fs.FileMode) (io.ReadWriteCloser, error) {
-			inst.updateEnv()
-			return env.Filesystem.OpenFile(env.JoinCwd(path), flag, perm)
-		}),
-		interp.StatHandler(func(ctx context.Context, name string, followSymlinks bool) (fs.FileInfo, error) {
-			inst.updateEnv()
-			return fs.Stat(env.Filesystem, name)
-		}),
-		interp.ReadDirHandler(func(ctx context.Context, path string) ([]fs.FileInfo, error) {
-			inst.updateEnv()
-			return readDir(path)
-		}),
+	// Command substitutions don't need a handler here: they're rewritten to
+	// run in our own subshells, with their stdout captured by the runner.
+	sh, err := newShell(inst.env.Environ, inst.env.Umask, append(inst.runnerOptions(),
 		interp.StdIO(inst.env.Terminal.Stdin, inst.env.Terminal.Stdout, inst.env.Terminal.Stderr),
-		interp.ExecHandler(inst.execHandler),
-		interp.CallHandler(inst.callHandler),
-		interp.Env(inst.env.Environ),
-		interp.RunnerOption(func(r *interp.Runner) error {
-			r.Dir = "/"
-			return nil
-		}),
-	)
+		interp.Dir("/"),
+	)...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to init shell runner")
 	}
 
-	inst.shRunner = shRunner
+	inst.shell = sh
 
 	inst.prompter = liner.NewStateStdin(
-		inst.env.Terminal.IO.Stdin,
+		inst.input,
 		func() (row, col uint16, ok bool) {
 			q := inst.env.Terminal.Query()
 			return uint16(q.Height), uint16(q.Width), true
 		},
 	)
 
+	inst.closes = append(inst.closes, inst.prompter.Close)
+	if restoreTerminal != nil {
+		inst.closes = append(inst.closes, restoreTerminal)
+	}
+
 	return &inst, nil
 }
 
@@ -163,6 +191,57 @@ func (inst *Interpreter) Close() error {
 	return stderrors.Join(errs...)
 }
 
+// runnerOptions returns the options shared by all shell runners, including the
+// ones running scripts.
+func (inst *Interpreter) runnerOptions() []interp.RunnerOption {
+	return []interp.RunnerOption{
+		interp.OpenHandler(func(ctx context.Context, path string, flag int, perm fs.FileMode) (io.ReadWriteCloser, error) {
+			sh := shellFromContext(ctx)
+			if sh != nil {
+				perm &^= sh.getUmask()
+			}
+
+			f, err := inst.env.Filesystem.OpenFile(handlerJoinCwd(ctx, path), flag, perm)
+			if err != nil {
+				return nil, err
+			}
+
+			if sh != nil && sh.isSourcing(path) {
+				return inst.openSource(f)
+			}
+			return f, nil
+		}),
+		interp.StatHandler(func(ctx context.Context, name string, followSymlinks bool) (fs.FileInfo, error) {
+			if !followSymlinks {
+				return rwfs.Lstat(inst.env.Filesystem, handlerJoinCwd(ctx, name))
+			}
+			return fs.Stat(inst.env.Filesystem, handlerJoinCwd(ctx, name))
+		}),
+		interp.ReadDirHandler(func(ctx context.Context, path string) ([]fs.FileInfo, error) {
+			return inst.readDir(handlerJoinCwd(ctx, path))
+		}),
+		interp.CallHandler(inst.callHandler),
+		interp.ExecHandler(inst.execHandler),
+	}
+}
+
+func (inst *Interpreter) readDir(path string) ([]fs.FileInfo, error) {
+	entries, err := fs.ReadDir(inst.env.Filesystem, path)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]fs.FileInfo, len(entries))
+	for i, info := range entries {
+		infos[i], err = info.Info()
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", info, err)
+		}
+	}
+
+	return infos, nil
+}
+
 // Terminal returns the terminal for the interpreter.
 func (inst *Interpreter) Terminal() Terminal {
 	return inst.env.Terminal
@@ -175,16 +254,27 @@ func (inst *Interpreter) Run(ctx context.Context) error {
 	ctx = context.WithValue(ctx, loggerKey, inst.logger)
 
 	inst.exec(ctx, inst.opts.RunCommands)
+	inst.updateEnv()
+	inst.loadHistory()
+	inst.shExpandCfg.CmdSubst = inst.completionCmdSubst(ctx)
 	inst.prompter.SetWordCompleter(inst.wordCompleter(ctx))
 	inst.prompter.SetTabCompletionStyle(liner.TabPrints)
 	// Fully aborting lets us draw the entire prompt instead of just the
 	// incomplete line.
 	inst.prompter.SetCtrlCAborts(true)
 
+	// input holds the lines of a statement that spans multiple lines.
+	var input strings.Builder
+
 	for {
-		inst.env.Cwd = inst.shRunner.Dir
-		inst.env.Environ = inst.shRunner.Env
-		prompt := inst.opts.Prompt(*inst.env)
+		var prompt string
+		if input.Len() == 0 {
+			inst.updateEnv()
+			inst.reportJobs()
+			prompt = inst.opts.Prompt(*inst.env)
+		} else {
+			prompt = inst.opts.ContinuationPrompt(*inst.env)
+		}
 
 		// Support multiline prompts by splitting on newlines and printing each
 		// line separately except the last one.
@@ -197,9 +287,16 @@ func (inst *Interpreter) Run(ctx context.Context) error {
 		if err != nil {
 			switch {
 			case errors.Is(err, liner.ErrPromptAborted):
-				// Ctrl+C is pressed; redraw entire prompt.
+				// Ctrl+C is pressed; discard the statement and redraw the
+				// entire prompt.
+				input.Reset()
 				continue
 			case errors.Is(err, io.EOF):
+				if input.Len() > 0 {
+					inst.logger.Println("syntax error: unexpected end of file")
+					input.Reset()
+					continue
+				}
 				if inst.opts.IgnoreEOF {
 					inst.env.Println()
 					continue
@@ -210,36 +307,47 @@ func (inst *Interpreter) Run(ctx context.Context) error {
 			}
 		}
 
-		if line == "" {
+		if line == "" && input.Len() == 0 {
 			continue
 		}
 
-		inst.prompter.AppendHistory(line)
-		ok := inst.exec(ctx, line)
+		line, expanded, err := inst.history.expand(line)
+		if err != nil {
+			inst.logger.Println(err)
+			input.Reset()
+			continue
+		}
+		if expanded {
+			// Show the user what is actually being run.
+			inst.env.Println(line)
+		}
+
+		input.WriteString(line)
+		input.WriteByte('\n')
+
+		if inst.incomplete(input.String(), line) {
+			continue
+		}
+
+		stmt := input.String()
+		input.Reset()
+
+		inst.addHistory(stmt)
+		ok := inst.exec(ctx, stmt)
 		inst.printMommy(ok)
 	}
+}
+
+// incomplete returns true if src is an incomplete statement that continues
+// onto the next line, e.g. because of an unclosed quote, block or
+// here-document, or because the last line ends with a backslash.
+func (inst *Interpreter) incomplete(src, lastLine string) bool {
+	if trailingBackslashes(lastLine)%2 == 1 {
+		return true
+	}
 
-	// interactiveFunc := func(stmts []*syntax.Stmt) bool {
-	// 	if inst.shParser.Incomplete() {
-	// 		fmt.Fprintf(inst.env.Terminal, "> ")
-	// 		return true
-	// 	}
-	//
-	// 	for _, stmt := range stmts {
-	// 		if err := inst.shRunner.Run(ctx, stmt); err != nil {
-	// 			inst.logger.Println(err)
-	// 		}
-	// 		if inst.shRunner.Exited() || ctx.Err() != nil {
-	// 			return false
-	// 		}
-	// 	}
-	//
-	// 	fmt.Fprintf(inst.env.Terminal, inst.prompt())
-	// 	return true
-	// }
-	//
-	// fmt.Fprintf(inst.env.Terminal, inst.prompt())
-	// return inst.shParser.Interactive(inst.env.Terminal.Stdin, interactiveFunc)
+	_, err := inst.shParser.Parse(strings.NewReader(src), "")
+	return syntax.IsIncomplete(err)
 }
 
 func (inst *Interpreter) exec(ctx context.Context, line string) bool {
@@ -249,14 +357,75 @@ func (inst *Interpreter) exec(ctx context.Context, line string) bool {
 		return false
 	}
 
-	if err := inst.shRunner.Run(ctx, shFile); err != nil {
-		inst.logger.Println(err)
-		return false
+	// Give the command its own context, so that ^C only stops this command
+	// and not the whole interpreter.
+	fg := newRunningCmd(ctx)
+	defer fg.cancel(nil)
+
+	inst.setForeground(fg)
+	defer inst.setForeground(nil)
+
+	ok := true
+	for _, stmt := range shFile.Stmts {
+		// Only top-level background statements become jobs. Anything deeper
+		// runs in the background of the shell.
+		if stmt.Background {
+			inst.startJob(ctx, stmt)
+			ok = true
+			continue
+		}
+
+		ok = inst.runForeground(fg, stmt)
+		if inst.shell.runner.Exited() || fg.interrupted() != nil {
+			break
+		}
 	}
 
+	return ok
+}
+
+func (inst *Interpreter) runForeground(fg *runningCmd, stmt *syntax.Stmt) bool {
+	if err := inst.runStmt(fg.ctx, inst.shell, stmt); err != nil {
+		// Exit statuses are already reported by the programs themselves, and
+		// interruptions are reported by the input handler, so we only need to
+		// print actual errors.
+		_, isExit := interp.IsExitStatus(err)
+		if !isExit && fg.interrupted() == nil {
+			inst.logger.Println(err)
+		}
+		return false
+	}
 	return true
 }
 
+// completionCmdSubst returns a function that expands command substitutions
+// while completing words. The commands are run in a subshell with no input, so
+// they cannot change the state of the interpreter.
+func (inst *Interpreter) completionCmdSubst(ctx context.Context) func(io.Writer, *syntax.CmdSubst) error {
+	return func(w io.Writer, cs *syntax.CmdSubst) error {
+		ctx, cancel := context.WithTimeout(ctx, completionCmdSubstTimeout)
+		defer cancel()
+
+		subshell := inst.shell.subshell()
+		interp.StdIO(strings.NewReader(""), w, io.Discard)(subshell.runner)
+
+		for _, stmt := range cs.Stmts {
+			if err := inst.runStmt(ctx, subshell, stmt); err != nil {
+				// Like in a real shell, a failing command simply expands to
+				// whatever it has written so far.
+				if _, ok := interp.IsExitStatus(err); !ok {
+					return err
+				}
+			}
+			if subshell.runner.Exited() {
+				break
+			}
+		}
+
+		return nil
+	}
+}
+
 func (inst *Interpreter) printMommy(success bool) {
 	if !shellMommy.Getz() {
 		return
@@ -280,17 +449,52 @@ func (inst *Interpreter) printMommy(success bool) {
 }
 
 func (inst *Interpreter) callHandler(ctx context.Context, args []string) ([]string, error) {
-	inst.updateEnv()
+	// Some builtins are shadowed by the shell runner's own builtins, which
+	// don't know about our jobs or umask. Rename them so that they reach
+	// execHandler.
+	if _, ok := jobBuiltins[args[0]]; ok || args[0] == "umask" {
+		args[0] = builtinPrefix + args[0]
+	}
+
+	// eval and source parse code themselves, so it has to be rewritten like
+	// the statements that we run.
+	switch args[0] {
+	case "eval":
+		if src, ok := inst.rewriteSource(strings.Join(args[1:], " ")); ok {
+			args = []string{"eval", src}
+		}
+	case "source", ".":
+		if sh := shellFromContext(ctx); sh != nil && len(args) > 1 {
+			sh.sourceNext(args[1])
+		}
+	}
+
 	return args, nil
 }
 
 func (inst *Interpreter) execHandler(ctx context.Context, args []string) error {
+	if inst.isShellCode(args) {
+		// Code handed back by a rewritten statement is part of the shell, so
+		// it isn't run like a program. Calls that we didn't make are run like
+		// any other unknown program.
+		return inst.runShellCode(ctx, args)
+	}
+
 	handler := interp.HandlerCtx(ctx)
-	inst.updateEnv()
 
+	// Use the handler's state over the interpreter's, since we might be
+	// running within a subshell, e.g. for command substitutions, pipes or
+	// background jobs.
+	inst.envMu.RLock()
 	env := *inst.env
+	inst.envMu.RUnlock()
+
 	env.Cwd = handler.Dir
-	env.Execute = execHandler
+	env.Environ = handler.Env
+	if sh := shellFromContext(ctx); sh != nil {
+		env.Umask = sh.getUmask()
+	}
+	env.Execute = inst.execute
 	env.PromptLine = inst.prompter.Prompt
 	env.Terminal = env.Terminal.WithIO(IO{
 		Stdin:  io.NopCloser(handler.Stdin),
@@ -298,20 +502,98 @@ func (inst *Interpreter) execHandler(ctx context.Context, args []string) error {
 		Stderr: handler.Stderr,
 	})
 
-	// This could work? We have a terminal if gosh gave us the same stdout as
-	// the one we give to gosh, otherwise it's probably a pipe.
-	env.HasTerminal = env.Terminal.Stdout == inst.env.Terminal.Stdout
+	// Programs reading from a pipe may close it to tell the previous stage of
+	// the pipeline that they're done reading.
+	if pipe, ok := handler.Stdin.(pipeReader); ok {
+		env.Terminal.Stdin = pipe
+	}
+
+	// A stream is connected to the terminal if gosh gave us the same stream
+	// as the one we give to gosh. Otherwise, it's been redirected into a file
+	// or a pipe.
+	env.terminals = [3]bool{
+		Stdin:  handler.Stdin == inst.env.Terminal.Stdin,
+		Stdout: handler.Stdout == inst.env.Terminal.Stdout,
+		Stderr: handler.Stderr == inst.env.Terminal.Stderr,
+	}
+	env.HasTerminal = env.IsTerminal(Stdout)
 
 	if len(args) == 0 {
 		return nil
 	}
 
-	switch args[0] {
-	case "help":
-		return inst.help(env)
+	logger := log.New(handler.Stderr, "", 0)
+	ctx = context.WithValue(ctx, loggerKey, logger)
+
+	var err error
+	switch name, isBuiltin := strings.CutPrefix(args[0], builtinPrefix); {
+	case args[0] == "help":
+		err = inst.help(env)
+	case args[0] == "history":
+		err = inst.historyBuiltin(env, args)
+	case isBuiltin && name == "umask":
+		err = inst.umaskBuiltin(ctx, env, args)
+	case isBuiltin && jobBuiltins[name] != nil:
+		args[0] = name
+		err = jobBuiltins[name](inst, ctx, env, args)
+	default:
+		err = execInterruptible(ctx, env, args...)
+	}
+
+	if err != nil {
+		if cmd := runningCmdFromContext(ctx); cmd != nil && cmd.interrupted() != nil {
+			// Already reported to the user.
+			return interp.NewExitStatus(uint8(ExitCode(cmd.interrupted())))
+		}
+
+		if _, ok := interp.IsExitStatus(err); ok {
+			// Builtins like wait pass through exit statuses silently.
+			return err
+		}
+
+		if errors.Is(err, ErrBrokenPipe) {
+			// Like SIGPIPE, a broken pipe silently stops the program.
+			return interp.NewExitStatus(uint8(ExitCode(ErrBrokenPipe)))
+		}
+
+		// Report the error to the shell as an exit status. Returning any
+		// other error would halt the runner entirely.
+		logger.Println(err)
+		if ErrorIsUnknownProgram(err) {
+			return interp.NewExitStatus(127)
+		}
+		return interp.NewExitStatus(uint8(ExitCode(err)))
 	}
 
-	return execHandler(ctx, env, args...)
+	return nil
+}
+
+// execInterruptible executes a program using env.Execute. If the program is
+// run by the interpreter, it can be stopped by ^C, ^\ or kill. Interrupting
+// only cancels the program's context and waits for it to return, while killing
+// immediately returns without waiting, abandoning the program.
+func execInterruptible(ctx context.Context, env Environment, args ...string) error {
+	cmd := runningCmdFromContext(ctx)
+	if cmd == nil {
+		return env.Execute(ctx, env, args...)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- env.Execute(ctx, env, args...) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-cmd.killed:
+	}
+
+	if interrupt := cmd.interrupted(); interrupt != nil {
+		// Always report the interruption, even if the program handled the
+		// cancellation gracefully.
+		return interrupt
+	}
+
+	return err
 }
 
 func execHandler(ctx context.Context, env Environment, args ...string) error {
@@ -323,9 +605,18 @@ func execHandler(ctx context.Context, env Environment, args ...string) error {
 	ctx = context.WithValue(ctx, environmentKey, &env)
 
 	if err := prog.Run(ctx, env, args); err != nil {
+		if ctx.Err() != nil && errors.Is(err, context.Canceled) {
+			// The program was told to stop, so it's not really an error.
+			return context.Cause(ctx)
+		}
+		if _, ok := interp.IsExitStatus(err); ok || errors.Is(err, ErrBrokenPipe) {
+			// Silent exits are reported by the caller.
+			return err
+		}
 		log := LoggerFromContext(ctx)
 		log.Println(err)
-		return fmt.Errorf("%s: exit status %d", args[0], ExitCode(err))
+		code := ExitCode(err)
+		return WrapError(code, fmt.Errorf("%s: exit status %d", args[0], code))
 	}
 
 	return nil
@@ -333,6 +624,8 @@ func execHandler(ctx context.Context, env Environment, args ...string) error {
 
 func (inst *Interpreter) wordCompleter(ctx context.Context) func(string, int) (string, []string, string) {
 	return func(line string, pos int) (head string, completions []string, tail string) {
+		inst.shExpandCfg.Env = inst.env.Environ
+
 		shf, err := inst.shParser.Parse(strings.NewReader(line), "")
 		if err != nil {
 			// cannot be parsed, ignore
@@ -465,7 +758,7 @@ func (inst *Interpreter) wordCompleter(ctx context.Context) func(string, int) (s
 			env := Environment{
 				Terminal:   inst.env.Terminal.WithIO(NoIOExceptStderr(inst.env.Terminal.Stderr)),
 				Filesystem: inst.env.Filesystem,
-				Cwd:        inst.shRunner.Dir,
+				Cwd:        inst.shell.runner.Dir,
 				Programs:   inst.env.Programs,
 				Environ:    inst.env.Environ,
 			}
@@ -496,8 +789,53 @@ func (inst *Interpreter) wordCompleter(ctx context.Context) func(string, int) (s
 }
 
 func (inst *Interpreter) updateEnv() {
-	inst.env.Cwd = inst.shRunner.Dir
-	inst.env.Environ = inst.shRunner.Env
+	inst.envMu.Lock()
+	inst.env.Cwd = inst.shell.runner.Dir
+	inst.env.Environ = runnerEnviron(inst.shell.runner)
+	inst.env.Umask = inst.shell.getUmask()
+	inst.envMu.Unlock()
+}
+
+// runnerEnviron returns a snapshot of the environment of the given runner. It
+// must only be called while the runner isn't running. Variables set within the
+// shell are only visible once a Run call has returned. The runner keeps
+// changing its variables on later runs, so they're copied rather than shared
+// with completion and running programs.
+func runnerEnviron(r *interp.Runner) expand.Environ {
+	if len(r.Vars) == 0 {
+		return r.Env
+	}
+	vars := make(varsEnviron, len(r.Vars))
+	for name, vr := range r.Vars {
+		vr.List = slices.Clone(vr.List)
+		vr.Map = maps.Clone(vr.Map)
+		vars[name] = vr
+	}
+	return vars
+}
+
+// varsEnviron implements expand.Environ over interp.Runner's Vars.
+type varsEnviron map[string]expand.Variable
+
+func (env varsEnviron) Get(name string) expand.Variable {
+	return env[name]
+}
+
+func (env varsEnviron) Each(f func(name string, vr expand.Variable) bool) {
+	for name, vr := range env {
+		if !f(name, vr) {
+			return
+		}
+	}
+}
+
+// handlerJoinCwd joins path to the current directory of the shell that is
+// calling the handler, unless it is already absolute.
+func handlerJoinCwd(ctx context.Context, path string) string {
+	if strings.HasPrefix(path, "/") {
+		return path
+	}
+	return pathpkg.Join(interp.HandlerCtx(ctx).Dir, path)
 }
 
 func (inst *Interpreter) programAutocomplete(word string) []string {
@@ -507,6 +845,13 @@ func (inst *Interpreter) programAutocomplete(word string) []string {
 			completions = append(completions, name)
 		}
 	}
+
+	if execs := pathExecutables(*inst.env, word); len(execs) > 0 {
+		completions = append(completions, execs...)
+		sort.Strings(completions)
+		completions = slices.Compact(completions)
+	}
+
 	return completions
 }
 
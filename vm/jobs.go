@@ -0,0 +1,514 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"unicode"
+	"unicode/utf8"
+
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// job is a statement that was started in the background using &.
+type job struct {
+	id      int
+	command string
+	cmd     *runningCmd
+	input   *jobInput
+	done    chan struct{}
+	status  int // only valid after done is closed
+}
+
+// finished returns true if the job has returned.
+func (j *job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// state describes the job's state the same way that Bash does.
+func (j *job) state() string {
+	if !j.finished() {
+		if j.input.blocked() {
+			return "Stopped (tty input)"
+		}
+		return "Running"
+	}
+
+	switch interrupt := j.cmd.interrupted(); {
+	case interrupt != nil:
+		return capitalize(interrupt.Error())
+	case j.status == 0:
+		return "Done"
+	default:
+		return fmt.Sprintf("Exit %d", j.status)
+	}
+}
+
+// capitalize returns s with its first letter in upper case.
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// jobInput is the standard input of a background job. Reading from it blocks
+// until the job is brought to the foreground by fg, like a job stopped by
+// SIGTTIN, and then reads from the terminal. Reads fail once the job is
+// interrupted.
+type jobInput struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	fg      io.Reader     // nil while in the background
+	wake    chan struct{} // closed when fg changes
+	waiting int           // number of blocked reads
+}
+
+var _ io.ReadCloser = (*jobInput)(nil)
+
+func newJobInput(ctx context.Context) *jobInput {
+	return &jobInput{ctx: ctx, wake: make(chan struct{})}
+}
+
+// setForeground makes reads go to r, or block again if r is nil.
+func (in *jobInput) setForeground(r io.Reader) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.fg = r
+	close(in.wake)
+	in.wake = make(chan struct{})
+}
+
+// blocked returns true if the job is waiting to be given the terminal.
+func (in *jobInput) blocked() bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	return in.waiting > 0
+}
+
+func (in *jobInput) Read(b []byte) (int, error) {
+	for {
+		in.mu.Lock()
+		fg, wake := in.fg, in.wake
+		if fg == nil {
+			in.waiting++
+		}
+		in.mu.Unlock()
+
+		if fg != nil {
+			return fg.Read(b)
+		}
+
+		select {
+		case <-wake:
+		case <-in.ctx.Done():
+		}
+
+		in.mu.Lock()
+		in.waiting--
+		in.mu.Unlock()
+
+		if err := in.ctx.Err(); err != nil {
+			return 0, context.Cause(in.ctx)
+		}
+	}
+}
+
+func (in *jobInput) Close() error { return nil }
+
+// jobTable keeps track of the interpreter's background jobs.
+type jobTable struct {
+	mu   sync.Mutex
+	jobs []*job // sorted by ID
+}
+
+// add adds a new job into the table. The job is given the lowest unused ID.
+func (t *jobTable) add(command string, cmd *runningCmd, input *jobInput) *job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	j := &job{
+		id:      len(t.jobs) + 1,
+		command: command,
+		cmd:     cmd,
+		input:   input,
+		done:    make(chan struct{}),
+	}
+
+	for i, other := range t.jobs {
+		if other.id != i+1 {
+			j.id = i + 1
+			break
+		}
+	}
+
+	t.jobs = append(t.jobs, j)
+	sort.Slice(t.jobs, func(i, k int) bool {
+		return t.jobs[i].id < t.jobs[k].id
+	})
+
+	return j
+}
+
+// remove removes the given job from the table.
+func (t *jobTable) remove(j *job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, other := range t.jobs {
+		if other == j {
+			t.jobs = append(t.jobs[:i], t.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// list returns a copy of all jobs in the table.
+func (t *jobTable) list() []*job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]*job(nil), t.jobs...)
+}
+
+// lookup finds a job using the given job specification. The specification may
+// be %n, %% (or %+) for the current job, %- for the previous job or %prefix
+// for the job whose command starts with prefix. The leading % is optional for
+// numbers.
+func (t *jobTable) lookup(spec string) (*job, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.jobs) == 0 {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+
+	// Jobs are sorted by ID, so the most recent one is usually the last one.
+	// This isn't exactly what Bash does, but it's close enough.
+	switch spec {
+	case "", "%", "%%", "%+":
+		return t.jobs[len(t.jobs)-1], nil
+	case "%-":
+		if len(t.jobs) < 2 {
+			return t.jobs[0], nil
+		}
+		return t.jobs[len(t.jobs)-2], nil
+	}
+
+	name := strings.TrimPrefix(spec, "%")
+	if id, err := strconv.Atoi(name); err == nil {
+		for _, j := range t.jobs {
+			if j.id == id {
+				return j, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+
+	if !strings.HasPrefix(spec, "%") {
+		return nil, fmt.Errorf("%s: arguments must be job IDs", spec)
+	}
+
+	var found *job
+	for _, j := range t.jobs {
+		if strings.HasPrefix(j.command, name) {
+			if found != nil {
+				return nil, fmt.Errorf("%s: ambiguous job spec", spec)
+			}
+			found = j
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return found, nil
+}
+
+// startJob starts the given statement as a background job. The statement is
+// run in a subshell, so it cannot change the state of the interpreter.
+func (inst *Interpreter) startJob(ctx context.Context, stmt *syntax.Stmt) *job {
+	st := *stmt
+	st.Background = false
+
+	var command strings.Builder
+	syntax.NewPrinter(syntax.SingleLine(true)).Print(&command, &st)
+
+	cmd := newRunningCmd(ctx)
+
+	// Background jobs only get to read from the terminal once they're
+	// brought to the foreground.
+	input := newJobInput(cmd.ctx)
+	stdio := IO{
+		Stdin:  input,
+		Stdout: inst.env.Terminal.Stdout,
+		Stderr: inst.env.Terminal.Stderr,
+	}
+
+	subshell := inst.shell.subshell()
+	interp.StdIO(stdio.Stdin, stdio.Stdout, stdio.Stderr)(subshell.runner)
+
+	j := inst.jobs.add(strings.TrimSpace(command.String()), cmd, input)
+	inst.env.Printf("[%d] %s\n", j.id, j.command)
+
+	go func() {
+		defer close(j.done)
+		defer cmd.cancel(nil)
+
+		err := inst.runStmt(cmd.ctx, subshell, &st)
+		if status, ok := interp.IsExitStatus(err); ok {
+			j.status = int(status)
+		} else if err != nil {
+			j.status = ExitCode(err)
+		}
+		if interrupt := cmd.interrupted(); interrupt != nil {
+			j.status = ExitCode(interrupt)
+		}
+	}()
+
+	return j
+}
+
+// reportJobs prints the jobs that have finished since the last call and
+// removes them from the job table.
+func (inst *Interpreter) reportJobs() {
+	for _, j := range inst.jobs.list() {
+		if j.finished() {
+			inst.env.Printf("[%d] %-24s %s\n", j.id, j.state(), j.command)
+			inst.jobs.remove(j)
+		}
+	}
+}
+
+// builtinPrefix is prepended to the names of our own builtins, such as the job
+// builtins, to keep them away from the shell runner's builtins of the same
+// name.
+const builtinPrefix = "vm:"
+
+type jobBuiltin func(inst *Interpreter, ctx context.Context, env Environment, args []string) error
+
+// jobBuiltins are the builtins for controlling jobs.
+var jobBuiltins = map[string]jobBuiltin{
+	"jobs": (*Interpreter).jobsBuiltin,
+	"fg":   (*Interpreter).fgBuiltin,
+	"bg":   (*Interpreter).bgBuiltin,
+	"kill": (*Interpreter).killBuiltin,
+	"wait": (*Interpreter).waitBuiltin,
+}
+
+func (inst *Interpreter) jobsBuiltin(ctx context.Context, env Environment, args []string) error {
+	if len(args) > 1 {
+		return &UsageError{Usage: "jobs"}
+	}
+
+	w := tabwriter.NewWriter(env.Terminal.Stdout, 0, 0, 2, ' ', 0)
+	for _, j := range inst.jobs.list() {
+		fmt.Fprintf(w, "[%d]\t%s\t%s\n", j.id, j.state(), j.command)
+	}
+	return w.Flush()
+}
+
+func (inst *Interpreter) fgBuiltin(ctx context.Context, env Environment, args []string) error {
+	if len(args) > 2 {
+		return &UsageError{Usage: "fg [%JOB]"}
+	}
+
+	j, err := inst.jobs.lookup(argOr(args, 1, "%%"))
+	if err != nil {
+		return err
+	}
+
+	env.Println(j.command)
+
+	j.input.setForeground(env.Terminal.Stdin)
+	defer j.input.setForeground(nil)
+
+	return inst.waitJob(ctx, j, true)
+}
+
+func (inst *Interpreter) bgBuiltin(ctx context.Context, env Environment, args []string) error {
+	if len(args) > 2 {
+		return &UsageError{Usage: "bg [%JOB]"}
+	}
+
+	j, err := inst.jobs.lookup(argOr(args, 1, "%%"))
+	if err != nil {
+		return err
+	}
+
+	// Jobs can never be stopped, so they're always in the background.
+	return fmt.Errorf("job %d already in background", j.id)
+}
+
+func (inst *Interpreter) waitBuiltin(ctx context.Context, env Environment, args []string) error {
+	var jobs []*job
+	if len(args) == 1 {
+		if err := waitBackground(ctx); err != nil {
+			return err
+		}
+		// Only the interactive shell has jobs.
+		if shellFromContext(ctx) == inst.shell {
+			jobs = inst.jobs.list()
+		}
+	} else {
+		for _, spec := range args[1:] {
+			j, err := inst.jobs.lookup(spec)
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, j)
+		}
+	}
+
+	var err error
+	for _, j := range jobs {
+		// The exit status of wait is the one of the last job.
+		err = inst.waitJob(ctx, j, false)
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+	}
+	return err
+}
+
+// waitJob waits for the given job to finish and removes it from the job table.
+// It returns the job's exit status. If forward is true, then
+// interrupting the waiting command also interrupts the job, otherwise only
+// the waiting is interrupted.
+func (inst *Interpreter) waitJob(ctx context.Context, j *job, forward bool) error {
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		if !forward {
+			return context.Cause(ctx)
+		}
+
+		cause := context.Cause(ctx)
+		if errors.Is(cause, ErrKilled) {
+			j.cmd.kill(cause)
+		} else {
+			j.cmd.cancel(cause)
+		}
+
+		select {
+		case <-j.done:
+		case <-j.cmd.killed:
+			return cause
+		}
+	}
+
+	inst.jobs.remove(j)
+	if j.status != 0 {
+		return interp.NewExitStatus(uint8(j.status))
+	}
+	return nil
+}
+
+// jobSignals are the signals supported by kill, which are emulated by
+// canceling the job's context. Killing signals also stop the shell from
+// waiting for the job's programs to return.
+var jobSignals = map[string]struct {
+	number int
+	desc   string
+	kills  bool
+}{
+	"HUP":  {1, "hangup", false},
+	"INT":  {2, "interrupted", false},
+	"QUIT": {3, "quit", true},
+	"KILL": {9, "killed", true},
+	"TERM": {15, "terminated", false},
+}
+
+func (inst *Interpreter) killBuiltin(ctx context.Context, env Environment, args []string) error {
+	const usage = "kill [-s SIGNAL | -SIGNAL] %JOB... or kill -l"
+
+	args = args[1:]
+	signal := "TERM"
+
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-l":
+			names := make([]string, 0, len(jobSignals))
+			for name := range jobSignals {
+				names = append(names, name)
+			}
+			sort.Slice(names, func(i, j int) bool {
+				return jobSignals[names[i]].number < jobSignals[names[j]].number
+			})
+			for _, name := range names {
+				env.Printf("%2d) SIG%s\n", jobSignals[name].number, name)
+			}
+			return nil
+		case "-s":
+			if len(args) < 2 {
+				return &UsageError{Usage: usage}
+			}
+			signal = args[1]
+			args = args[2:]
+		default:
+			signal = strings.TrimPrefix(args[0], "-")
+			args = args[1:]
+		}
+	}
+
+	if len(args) == 0 {
+		return &UsageError{Usage: usage}
+	}
+
+	signal = strings.TrimPrefix(strings.ToUpper(signal), "SIG")
+	if n, err := strconv.Atoi(signal); err == nil {
+		for name, sig := range jobSignals {
+			if sig.number == n {
+				signal = name
+			}
+		}
+	}
+
+	sig, ok := jobSignals[signal]
+	if !ok {
+		return fmt.Errorf("%s: invalid signal specification", signal)
+	}
+
+	var errs []error
+	for _, spec := range args {
+		j, err := inst.jobs.lookup(spec)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		cause := WrapError(128+sig.number, errors.New(sig.desc))
+		if sig.number == 2 {
+			cause = ErrInterrupted
+		}
+
+		if sig.kills {
+			j.cmd.kill(cause)
+		} else {
+			j.cmd.cancel(cause)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func argOr(args []string, i int, or string) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return or
+}
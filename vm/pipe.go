@@ -0,0 +1,202 @@
+package vm
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// ErrBrokenPipe is returned when writing to a pipe whose reading end has been
+// closed. Like SIGPIPE, it also stops the stage of the pipeline that is
+// writing. Its exit code is 141.
+var ErrBrokenPipe error = WrapError(141, errors.New("broken pipe"))
+
+// pipeReader is the reading end of a pipe between two pipeline stages.
+type pipeReader struct {
+	*io.PipeReader
+}
+
+// Close closes the reading end of the pipe. Further writes to the pipe will
+// fail with ErrBrokenPipe.
+func (r pipeReader) Close() error {
+	return r.PipeReader.CloseWithError(ErrBrokenPipe)
+}
+
+// pipeWriter is the writing end of a pipe between two pipeline stages.
+type pipeWriter struct {
+	*io.PipeWriter
+	broken func()
+}
+
+func (w pipeWriter) Write(b []byte) (int, error) {
+	n, err := w.PipeWriter.Write(b)
+	if errors.Is(err, ErrBrokenPipe) {
+		w.broken()
+	}
+	return n, err
+}
+
+// newPipe creates a new synchronous pipe. Writes block until the reading end
+// has consumed all of the data, so a fast writer can't outrun a slow reader.
+// broken is called when a write fails because the reading end is closed.
+func newPipe(broken func()) (pipeReader, pipeWriter) {
+	r, w := io.Pipe()
+	return pipeReader{r}, pipeWriter{w, broken}
+}
+
+// pipelineStage is a single command within a pipeline.
+type pipelineStage struct {
+	stmt *syntax.Stmt
+	// pipeStderr is true if the stage's stderr is also piped into the next
+	// stage, i.e. |&.
+	pipeStderr bool
+}
+
+// pipeline returns the pipeline that stmt consists of, if stmt is a plain
+// pipeline that can be flattened into its parent.
+func pipeline(stmt *syntax.Stmt) (*syntax.BinaryCmd, bool) {
+	bin, ok := stmt.Cmd.(*syntax.BinaryCmd)
+	if !ok || (bin.Op != syntax.Pipe && bin.Op != syntax.PipeAll) {
+		return nil, false
+	}
+	if stmt.Negated || stmt.Background || len(stmt.Redirs) > 0 {
+		return nil, false
+	}
+	return bin, true
+}
+
+// pipelineStages flattens the given pipeline into its stages.
+func pipelineStages(bin *syntax.BinaryCmd) []pipelineStage {
+	var stages []pipelineStage
+	if left, ok := pipeline(bin.X); ok {
+		stages = append(stages, pipelineStages(left)...)
+	} else {
+		stages = append(stages, pipelineStage{stmt: bin.X})
+	}
+	stages[len(stages)-1].pipeStderr = bin.Op == syntax.PipeAll
+
+	if right, ok := pipeline(bin.Y); ok {
+		stages = append(stages, pipelineStages(right)...)
+	} else {
+		stages = append(stages, pipelineStage{stmt: bin.Y})
+	}
+
+	return stages
+}
+
+// runPipeline runs the stages of a pipeline that the given shell is running.
+// Its exit status is the one of the last stage, or of the last failing stage
+// if the pipefail option is set. The status of every stage is stored in
+// $PIPESTATUS.
+func runPipeline(ctx context.Context, sh *shell, stages []pipelineStage) error {
+	handler := interp.HandlerCtx(ctx)
+
+	subshells := make([]*shell, len(stages))
+	for i := range subshells {
+		subshells[i] = sh.subshell()
+	}
+	failFast := pipefail(subshells[0].runner)
+
+	statuses := runStages(ctx, subshells, stages, handler.Stdin, handler.Stdout, handler.Stderr)
+	sh.setPipeStatus(statuses)
+
+	status := statuses[len(statuses)-1]
+	if failFast {
+		for _, s := range statuses {
+			if s != 0 {
+				status = s
+			}
+		}
+	}
+
+	if status != 0 {
+		return interp.NewExitStatus(uint8(status))
+	}
+	return nil
+}
+
+// runStages runs all stages of a pipeline concurrently, each in its own
+// subshell. It returns the exit status of each stage.
+//
+// Once a stage returns, its pipe ends are closed: the next stage reads EOF
+// and the previous stage gets ErrBrokenPipe on its next write, which also
+// stops that stage.
+func runStages(ctx context.Context, subshells []*shell, stages []pipelineStage, stdin io.Reader, stdout, stderr io.Writer) []int {
+	statuses := make([]int, len(stages))
+
+	var wg sync.WaitGroup
+	wg.Add(len(stages))
+
+	for i, stage := range stages {
+		stageCtx, cancel := context.WithCancelCause(ctx)
+
+		var closes []func() error
+		stageOut := stdout
+		stageErr := stderr
+
+		if r, ok := stdin.(pipeReader); ok {
+			closes = append(closes, r.Close)
+		}
+
+		var next io.Reader
+		if i < len(stages)-1 {
+			r, w := newPipe(func() { cancel(ErrBrokenPipe) })
+			closes = append(closes, w.Close)
+			stageOut = w
+			if stage.pipeStderr {
+				stageErr = w
+			}
+			next = r
+		}
+
+		sub := subshells[i]
+		interp.StdIO(stdin, stageOut, stageErr)(sub.runner)
+
+		go func(i int, stmt *syntax.Stmt) {
+			defer wg.Done()
+			defer cancel(nil)
+
+			err := sub.runner.Run(withShell(stageCtx, sub), stmt)
+			for _, close := range closes {
+				close()
+			}
+
+			switch status, ok := interp.IsExitStatus(err); {
+			case ok:
+				statuses[i] = int(status)
+			case err != nil:
+				statuses[i] = ExitCode(err)
+			}
+
+			if cause := context.Cause(stageCtx); errors.Is(cause, ErrBrokenPipe) {
+				statuses[i] = ExitCode(ErrBrokenPipe)
+			}
+		}(i, stage.stmt)
+
+		stdin = next
+	}
+
+	wg.Wait()
+	return statuses
+}
+
+// pipefail returns true if the runner has the pipefail option set. The runner
+// only reports its options by printing them like set -o does, so this
+// replaces its standard streams.
+func pipefail(runner *interp.Runner) bool {
+	var options strings.Builder
+	interp.StdIO(nil, &options, nil)(runner)
+	interp.Params("-o")(runner)
+
+	for _, line := range strings.Split(options.String(), "\n") {
+		if name, state, _ := strings.Cut(line, "\t"); name == "pipefail" {
+			return state == "on"
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package vm
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+var pipeTestPrograms = []Program{
+	// yes writes lines until it fails to.
+	testProgram{"yes", func(ctx context.Context, env Environment, args []string) error {
+		for {
+			if _, err := io.WriteString(env.Terminal.Stdout, "y\n"); err != nil {
+				return err
+			}
+		}
+	}},
+	// head1 copies the first line of its input, then stops reading.
+	testProgram{"head1", func(ctx context.Context, env Environment, args []string) error {
+		line, err := bufio.NewReader(env.Terminal.Stdin).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		env.Print(line)
+		return env.Terminal.Stdin.Close()
+	}},
+	testProgram{"cat", func(ctx context.Context, env Environment, args []string) error {
+		_, err := io.Copy(env.Terminal.Stdout, env.Terminal.Stdin)
+		return err
+	}},
+	testProgram{"fail", func(ctx context.Context, env Environment, args []string) error {
+		return ExitStatus(3)
+	}},
+}
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   string
+		stdout string
+	}{
+		{
+			name:   "broken_pipe",
+			code:   "yes | head1 | cat; echo ${PIPESTATUS[@]}",
+			stdout: "y\n141 0 0\n",
+		},
+		{
+			name:   "broken_pipe_through_stage",
+			code:   "yes | cat | head1; echo ${PIPESTATUS[@]}",
+			stdout: "y\n141 141 0\n",
+		},
+		{
+			name:   "pipestatus",
+			code:   "true | fail | true; echo $? ${PIPESTATUS[@]} ${PIPESTATUS[1]}",
+			stdout: "0 0 3 0 3\n",
+		},
+		{
+			name:   "pipefail",
+			code:   "fail | true; echo $?; set -o pipefail; fail | true; echo $?; true | true; echo $?",
+			stdout: "0\n3\n0\n",
+		},
+		{
+			name:   "negated",
+			code:   "! fail | fail; echo $?",
+			stdout: "0\n",
+		},
+		{
+			name:   "subshells",
+			code:   `x=$(echo a | cat); (echo "$x" | cat) | cat`,
+			stdout: "a\n",
+		},
+		{
+			name:   "functions",
+			code:   "f() { echo a | cat; }; f; f() { echo b | cat; }; f",
+			stdout: "a\nb\n",
+		},
+		{
+			name:   "eval_in_loop",
+			code:   `for i in 1 2 3; do eval "echo $i | cat"; done`,
+			stdout: "1\n2\n3\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inst := newTestInterpreter(t, pipeTestPrograms...)
+			inst.run(test.code)
+			assert.Equal(t, test.stdout, inst.stdout.String())
+			assert.Equal(t, "", inst.stderr.String())
+		})
+	}
+}
+
+func TestShellCodeBuiltins(t *testing.T) {
+	inst := newTestInterpreter(t, pipeTestPrograms...)
+
+	// Only calls made by rewriting statements may run code, and only the code
+	// that they were made for.
+	sig := inst.signCode(subshellBuiltin, "echo a\n")
+	inst.run(`` +
+		`vm:pipeline 'echo a | cat' 0; echo $?; ` +
+		`vm:subshell 'echo b' ` + sig + `; echo $?; ` +
+		`x=vm:background; $x 'echo c'; echo $?`)
+	assert.Equal(t, "127\n127\n127\n", inst.stdout.String())
+	assert.Equal(t, ""+
+		"unknown program \"vm:pipeline\"\n"+
+		"unknown program \"vm:subshell\"\n"+
+		"unknown program \"vm:background\"\n",
+		inst.stderr.String())
+}
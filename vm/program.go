@@ -12,6 +12,7 @@ import (
 
 	"libdb.so/vm/rwfs"
 	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
 )
 
 // UnknownProgramError is returned when a program is not found.
@@ -43,14 +44,36 @@ func ExitCode(err error) int {
 		return 0
 	}
 
-	coder, ok := err.(ExitError)
-	if ok {
+	var coder ExitError
+	if errors.As(err, &coder) {
 		return coder.ExitCode()
 	}
 
+	if status, ok := interp.IsExitStatus(err); ok {
+		return int(status)
+	}
+
 	return 1
 }
 
+var (
+	// ErrInterrupted is the cause of a program's context being canceled when
+	// the user presses ^C. Its exit code is 130, like a process killed by
+	// SIGINT.
+	ErrInterrupted error = WrapError(130, errors.New("interrupted"))
+	// ErrKilled is the cause of a program's context being canceled when the
+	// user presses ^\. Unlike ErrInterrupted, the shell stops waiting for the
+	// program to return. Its exit code is 131, like a process killed by
+	// SIGQUIT.
+	ErrKilled error = WrapError(131, errors.New("killed"))
+)
+
+// ExitStatus returns an error that makes a program exit with the given code
+// without printing anything, e.g. grep exiting with 1 when nothing matched.
+func ExitStatus(code int) error {
+	return interp.NewExitStatus(uint8(code))
+}
+
 type exitCodedError struct {
 	error
 	code int
@@ -125,7 +148,9 @@ func EnvironFromMap(envs map[string]string) expand.Environ {
 type Environment struct {
 	// Terminal is the terminal to use.
 	Terminal Terminal
-	// HasTerminal is true if the terminal is a real terminal.
+	// HasTerminal is true if the terminal is a real terminal. Programs that
+	// care about which standard streams are connected to the terminal should
+	// use IsTerminal instead.
 	HasTerminal bool
 	// Filesystem is the filesystem to use.
 	Filesystem rwfs.FS
@@ -135,12 +160,48 @@ type Environment struct {
 	Programs map[string]Program
 	// Environ is the environment variables.
 	Environ expand.Environ
+	// Umask is the file mode creation mask. Programs creating files and
+	// directories should clear its bits from their permissions using
+	// CreatePerm.
+	Umask stdfs.FileMode
 	// Execute executes a program.
 	Execute func(ctx context.Context, env Environment, args ...string) error
 	// PromptLine prompts the user for one input line. The prompt will have
 	// line-editing capabilities. Note that this function bypasses the
 	// terminal's Stdin.
 	PromptLine func(prompt string) (string, error)
+
+	// terminals is the set of standard streams that are connected to the
+	// terminal. It is set by the interpreter.
+	terminals [3]bool
+}
+
+// DefaultUmask is the usual Umask for an Environment.
+const DefaultUmask stdfs.FileMode = 0022
+
+// CreatePerm returns perm with the bits in the umask cleared. It should be used
+// for the permissions of new files and directories.
+func (env *Environment) CreatePerm(perm stdfs.FileMode) stdfs.FileMode {
+	return perm &^ env.Umask
+}
+
+// Stream is one of the standard streams.
+type Stream uint8
+
+const (
+	Stdin Stream = iota
+	Stdout
+	Stderr
+)
+
+// IsTerminal returns true if the given standard stream is connected to the
+// terminal, like isatty(3). It returns false if the stream is redirected, e.g.
+// into a file or a pipe.
+func (env *Environment) IsTerminal(stream Stream) bool {
+	if int(stream) >= len(env.terminals) {
+		return false
+	}
+	return env.terminals[stream]
 }
 
 // Env returns the environment variable with the given key.
@@ -0,0 +1,303 @@
+// Package archive provides the tar, zip, unzip and gzip programs.
+package archive
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"libdb.so/vm"
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/archivefs"
+)
+
+// absPath returns p relative to dir if it's not absolute.
+func absPath(dir, p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(dir, p)
+}
+
+// archiveName returns the name that the file at p has in an archive. Leading
+// slashes and parent directories are removed, like tar does.
+func archiveName(p string) string {
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// entryName returns the name of the entry in an archive, which ends in a
+// slash for directories.
+func entryName(name string, info fs.FileInfo) string {
+	if info.IsDir() && name != "." {
+		return name + "/"
+	}
+	return name
+}
+
+// walkFunc is called for every file that is added to an archive, with its name
+// in the archive and its file info. target is the target of symbolic links.
+type walkFunc func(name, fullpath string, info fs.FileInfo, target string) error
+
+// walk calls fn for every file at the given paths, which are relative to dir.
+// Directories are walked into if recursive is true. Symbolic links are never
+// followed. Files at the full paths in skip are left out.
+func walk(ctx context.Context, fsys rwfs.FS, dir string, paths []string, recursive bool, skip []string, fn walkFunc) error {
+	var errs []error
+	for _, p := range paths {
+		err := walkPath(ctx, fsys, absPath(dir, p), archiveName(p), recursive, skip, fn)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func walkPath(ctx context.Context, fsys rwfs.FS, fullpath, name string, recursive bool, skip []string, fn walkFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range skip {
+		if fullpath == s {
+			return nil
+		}
+	}
+
+	info, err := rwfs.Lstat(fsys, fullpath)
+	if err != nil {
+		return err
+	}
+
+	var target string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err = rwfs.Readlink(fsys, fullpath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := fn(name, fullpath, info, target); err != nil {
+		return err
+	}
+
+	if !info.IsDir() || !recursive {
+		return nil
+	}
+
+	entries, err := fs.ReadDir(fsys, fullpath)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		err := walkPath(ctx, fsys, path.Join(fullpath, entry.Name()), path.Join(name, entry.Name()), true, skip, fn)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// extractOpts are the options of extract.
+type extractOpts struct {
+	// Overwrite replaces existing files. Otherwise, they're skipped.
+	Overwrite bool
+	// Extracted is called with the name of every extracted file, if not nil.
+	Extracted func(name string)
+	// Skipped is called with the name of every existing file that is skipped,
+	// if not nil.
+	Skipped func(name string)
+}
+
+// extract copies the files of archive into dir. Permissions are masked by the
+// environment's umask, and modification times are kept if the filesystem
+// supports it.
+func extract(ctx context.Context, env vm.Environment, archive fs.FS, dir string, opts extractOpts) error {
+	// Directories get their times last, since extracting their contents
+	// changes them.
+	type dirTime struct {
+		path  string
+		mtime time.Time
+	}
+	var dirTimes []dirTime
+
+	err := fs.WalkDir(archive, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		dst := path.Join(dir, name)
+		if name == "." {
+			return env.Filesystem.MkdirAll(dst, env.CreatePerm(0777))
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		// Files are created in their parent directories, while the
+		// contents of directories are created in them.
+		parent := path.Dir(name)
+		if info.IsDir() {
+			parent = name
+		}
+		if err := checkNoSymlinks(env, dir, parent); err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			if _, err := rwfs.Lstat(env.Filesystem, dst); err == nil {
+				if !opts.Overwrite {
+					if opts.Skipped != nil {
+						opts.Skipped(entryName(name, info))
+					}
+					return nil
+				}
+				if err := env.Filesystem.Remove(dst); err != nil {
+					return err
+				}
+			}
+		}
+
+		switch {
+		case info.IsDir():
+			err = env.Filesystem.MkdirAll(dst, env.CreatePerm(info.Mode().Perm()))
+		case info.Mode()&fs.ModeSymlink != 0:
+			err = extractSymlink(env, archive, name, dst)
+		default:
+			err = extractFile(env, archive, name, dst, info.Mode().Perm())
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			dirTimes = append(dirTimes, dirTime{dst, info.ModTime()})
+		case info.Mode()&fs.ModeSymlink == 0:
+			if err := setModTime(env, dst, info.ModTime()); err != nil {
+				return err
+			}
+		}
+
+		if opts.Extracted != nil {
+			opts.Extracted(entryName(name, info))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		if err := setModTime(env, dirTimes[i].path, dirTimes[i].mtime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// errThroughSymlink is returned when extracting a file would write through a
+// symbolic link.
+var errThroughSymlink = errors.New("path goes through a symbolic link")
+
+// checkNoSymlinks returns an error if any directory along name within dir is a
+// symbolic link. Otherwise, an archive could write anywhere by having a link
+// to a directory before the files to put there, e.g. evil -> /home and then
+// evil/.profile, or by being extracted over such a link.
+func checkNoSymlinks(env vm.Environment, dir, name string) error {
+	p := dir
+	for _, part := range rwfs.Split(name) {
+		p = path.Join(p, part)
+
+		info, err := rwfs.Lstat(env.Filesystem, p)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Anything below is created by us.
+				return nil
+			}
+			return err
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return &fs.PathError{Op: "extract", Path: name, Err: errThroughSymlink}
+		}
+	}
+	return nil
+}
+
+// setModTime sets the access and modification times of the file at path to
+// mtime, if the filesystem supports it.
+func setModTime(env vm.Environment, path string, mtime time.Time) error {
+	err := rwfs.Chtimes(env.Filesystem, path, mtime, mtime)
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
+
+func extractSymlink(env vm.Environment, archive fs.FS, name, dst string) error {
+	target, err := rwfs.Readlink(archive, name)
+	if err != nil {
+		return err
+	}
+	return rwfs.Symlink(env.Filesystem, target, dst)
+}
+
+func extractFile(env vm.Environment, archive fs.FS, name, dst string, perm fs.FileMode) error {
+	src, err := archive.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, err := env.Filesystem.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, env.CreatePerm(perm))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// openArchive opens the archive at name relative to dir, or reads it from r
+// if name is "-".
+func openArchive(env vm.Environment, r io.Reader, dir, name string) (*archivefs.FS, error) {
+	if name == "-" {
+		return archivefs.Read(r)
+	}
+	return archivefs.Open(env.Filesystem, absPath(dir, name))
+}
+
+// createFile creates the file at name relative to dir for writing, or returns
+// w if name is "-".
+func createFile(env vm.Environment, w io.Writer, dir, name string) (io.WriteCloser, error) {
+	if name == "-" {
+		return nopWriteCloser{w}, nil
+	}
+	return env.Filesystem.OpenFile(absPath(dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, env.CreatePerm(0666))
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,91 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"libdb.so/vm"
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/archivefs"
+	"libdb.so/vm/rwfs/kvfs"
+)
+
+// makeTar returns a tar archive of the given files. Names ending in a slash
+// are directories, and data starting with "-> " is the target of a symbolic
+// link.
+func makeTar(t *testing.T, files ...[2]string) *archivefs.FS {
+	var b bytes.Buffer
+	w := tar.NewWriter(&b)
+
+	for _, file := range files {
+		name, data := file[0], file[1]
+
+		hdr := &tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg}
+		switch {
+		case strings.HasSuffix(name, "/"):
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+		case strings.HasPrefix(data, "-> "):
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = strings.TrimPrefix(data, "-> ")
+			data = ""
+		default:
+			hdr.Size = int64(len(data))
+		}
+
+		assert.NoError(t, w.WriteHeader(hdr))
+		_, err := w.Write([]byte(data))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	archive, err := archivefs.NewTar(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	assert.NoError(t, err)
+	return archive
+}
+
+func TestExtractSymlinks(t *testing.T) {
+	env := vm.Environment{
+		Filesystem: kvfs.New(kvfs.MemoryStorage()),
+		Umask:      vm.DefaultUmask,
+	}
+	ctx := context.Background()
+
+	assert.NoError(t, env.Filesystem.MkdirAll("/outside", 0755))
+	assert.NoError(t, env.Filesystem.MkdirAll("/dst", 0755))
+
+	// Links themselves are extracted as they are.
+	err := extract(ctx, env, makeTar(t,
+		[2]string{"dir/file", "data"},
+		[2]string{"evil", "-> /outside"},
+		[2]string{"link", "-> dir/file"},
+	), "/dst", extractOpts{})
+	assert.NoError(t, err)
+
+	target, err := rwfs.Readlink(env.Filesystem, "/dst/evil")
+	assert.NoError(t, err)
+	assert.Equal(t, "/outside", target)
+
+	b, err := fs.ReadFile(env.Filesystem, "dst/link")
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(b))
+
+	// But nothing is written through them.
+	for _, files := range [][][2]string{
+		{{"evil/.profile", "pwned"}},
+		{{"evil/", ""}},
+		{{"evil/sub/", ""}, {"evil/sub/file", "pwned"}},
+	} {
+		err := extract(ctx, env, makeTar(t, files...), "/dst", extractOpts{Overwrite: true})
+		assert.IsError(t, err, errThroughSymlink)
+	}
+
+	entries, err := fs.ReadDir(env.Filesystem, "outside")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
@@ -0,0 +1,185 @@
+package archive
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(gzipApp))
+}
+
+var gzipApp = cli.App{
+	Name:      "gzip",
+	Usage:     "compress or decompress files",
+	UsageText: `gzip [-d] [-c] [-k] [-f] [FILE]...`,
+	Description: "Each FILE is replaced by FILE.gz, or the other way around with -d. " +
+		"Without files, the standard input is written to the standard output.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "decompress",
+			Aliases: []string{"d"},
+			Usage:   "decompress instead of compressing",
+		},
+		&cli.BoolFlag{
+			Name:    "stdout",
+			Aliases: []string{"c"},
+			Usage:   "write to the standard output and keep the files",
+		},
+		&cli.BoolFlag{
+			Name:    "keep",
+			Aliases: []string{"k"},
+			Usage:   "keep the input files",
+		},
+		&cli.BoolFlag{
+			Name:    "force",
+			Aliases: []string{"f"},
+			Usage:   "overwrite existing output files",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		log := vm.LoggerFromContext(c.Context)
+
+		args := c.Args().Slice()
+		if len(args) == 0 {
+			args = []string{"-"}
+		}
+
+		var failed bool
+		for _, arg := range args {
+			if err := gzipFile(c, arg); err != nil {
+				log.Println("gzip:", err)
+				failed = true
+			}
+		}
+
+		if failed {
+			return errors.New("failed to process one or more files")
+		}
+
+		return nil
+	},
+}
+
+func gzipFile(c *cli.Context, name string) error {
+	env := vm.EnvironmentFromContext(c.Context)
+	decompress := c.Bool("decompress")
+
+	if name == "-" {
+		if decompress {
+			return gunzipStream(c.App.Writer, c.App.Reader)
+		}
+		return gzipStream(c.App.Writer, c.App.Reader, "", nil)
+	}
+
+	src := absPath(env.Cwd, name)
+
+	var dst string
+	if decompress {
+		if !strings.HasSuffix(src, ".gz") {
+			return fmt.Errorf("%s: unknown suffix", name)
+		}
+		dst = strings.TrimSuffix(src, ".gz")
+	} else {
+		if strings.HasSuffix(src, ".gz") {
+			return fmt.Errorf("%s already has the .gz suffix", name)
+		}
+		dst = src + ".gz"
+	}
+
+	info, err := fs.Stat(env.Filesystem, src)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", name)
+	}
+
+	in, err := env.Filesystem.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if c.Bool("stdout") {
+		if decompress {
+			return gunzipStream(c.App.Writer, in)
+		}
+		return gzipStream(c.App.Writer, in, path.Base(src), info)
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !c.Bool("force") {
+		flag |= os.O_EXCL
+	}
+
+	out, err := env.Filesystem.OpenFile(dst, flag, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if decompress {
+		err = gunzipStream(out, in)
+	} else {
+		err = gzipStream(out, in, path.Base(src), info)
+	}
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		env.Filesystem.Remove(dst)
+		return err
+	}
+
+	if err := setModTime(env, dst, info.ModTime()); err != nil {
+		return err
+	}
+
+	in.Close()
+	if !c.Bool("keep") {
+		return env.Filesystem.Remove(src)
+	}
+
+	return nil
+}
+
+// gzipStream compresses r into w. The name and modification time of info are
+// stored in the gzip header, if any.
+func gzipStream(w io.Writer, r io.Reader, name string, info fs.FileInfo) error {
+	gz := gzip.NewWriter(w)
+	gz.Name = name
+	if info != nil {
+		gz.ModTime = info.ModTime()
+	}
+
+	if _, err := io.Copy(gz, r); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// gunzipStream decompresses r into w.
+func gunzipStream(w io.Writer, r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	_, err = io.Copy(w, gz)
+	return err
+}
@@ -0,0 +1,233 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(tarApp))
+}
+
+var tarApp = cli.App{
+	Name:  "tar",
+	Usage: "create, list and extract tar archives",
+	UsageText: `tar -c [-z] [-v] [-C DIR] -f ARCHIVE FILE...
+tar -t [-v] -f ARCHIVE
+tar -x [-v] [-C DIR] -f ARCHIVE`,
+	Description: "ARCHIVE is - for the standard input or output. " +
+		"Gzipped and zip archives are detected when listing and extracting.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "create",
+			Aliases: []string{"c"},
+			Usage:   "create a new archive",
+		},
+		&cli.BoolFlag{
+			Name:    "list",
+			Aliases: []string{"t"},
+			Usage:   "list the contents of an archive",
+		},
+		&cli.BoolFlag{
+			Name:    "extract",
+			Aliases: []string{"x"},
+			Usage:   "extract files from an archive",
+		},
+		&cli.StringFlag{
+			Name:    "file",
+			Aliases: []string{"f"},
+			Usage:   "the archive file",
+			Value:   "-",
+		},
+		&cli.BoolFlag{
+			Name:    "gzip",
+			Aliases: []string{"z"},
+			Usage:   "compress the created archive with gzip",
+		},
+		&cli.StringFlag{
+			Name:    "directory",
+			Aliases: []string{"C"},
+			Usage:   "change to DIR before creating or extracting",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "list the files processed",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+
+		dir := env.Cwd
+		if d := c.String("directory"); d != "" {
+			dir = absPath(env.Cwd, d)
+		}
+
+		switch {
+		case c.Bool("create") && !c.Bool("list") && !c.Bool("extract"):
+			if c.NArg() == 0 {
+				return errors.New("refusing to create an empty archive")
+			}
+			return createTar(c, env, dir)
+		case c.Bool("list") && !c.Bool("create") && !c.Bool("extract"):
+			return listTar(c, env)
+		case c.Bool("extract") && !c.Bool("create") && !c.Bool("list"):
+			return extractTar(c, env, dir)
+		default:
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+	},
+}
+
+func createTar(c *cli.Context, env vm.Environment, dir string) error {
+	name := c.String("file")
+
+	// Verbose output goes to stderr if the archive is written to stdout.
+	verbose := c.App.Writer
+	if name == "-" {
+		verbose = c.App.ErrWriter
+	}
+
+	f, err := createFile(env, c.App.Writer, env.Cwd, name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+
+	var gz *gzip.Writer
+	if c.Bool("gzip") {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	var skip []string
+	if name != "-" {
+		skip = append(skip, absPath(env.Cwd, name))
+	}
+
+	tw := tar.NewWriter(w)
+	walkErr := walk(c.Context, env.Filesystem, dir, c.Args().Slice(), true, skip,
+		func(name, fullpath string, info fs.FileInfo, target string) error {
+			if err := writeTarEntry(env, tw, name, fullpath, info, target); err != nil {
+				return err
+			}
+			if c.Bool("verbose") {
+				fmt.Fprintln(verbose, entryName(name, info))
+			}
+			return nil
+		})
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return walkErr
+}
+
+func writeTarEntry(env vm.Environment, tw *tar.Writer, name, fullpath string, info fs.FileInfo, target string) error {
+	hdr, err := tar.FileInfoHeader(info, target)
+	if err != nil {
+		return errors.Wrap(err, fullpath)
+	}
+	hdr.Name = entryName(name, info)
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := env.Filesystem.Open(fullpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The header has the size from the stat, so the file must be copied
+	// exactly up to it even if it changed in the meantime.
+	n, err := io.Copy(tw, io.LimitReader(f, hdr.Size))
+	if err != nil {
+		return err
+	}
+	if n < hdr.Size {
+		return errors.Errorf("%s: file shrank while being read", fullpath)
+	}
+
+	return nil
+}
+
+func listTar(c *cli.Context, env vm.Environment) error {
+	archive, err := openArchive(env, c.App.Reader, env.Cwd, c.String("file"))
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	return fs.WalkDir(archive, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if !c.Bool("verbose") {
+			fmt.Fprintln(c.App.Writer, entryName(name, info))
+			return nil
+		}
+
+		entry := entryName(name, info)
+		if info.Mode()&fs.ModeSymlink != 0 {
+			target, err := archive.Readlink(name)
+			if err != nil {
+				return err
+			}
+			entry += " -> " + target
+		}
+
+		fmt.Fprintf(c.App.Writer, "%s %8d %s %s\n",
+			info.Mode(), info.Size(), info.ModTime().Format("2006-01-02 15:04"), entry)
+		return nil
+	})
+}
+
+func extractTar(c *cli.Context, env vm.Environment, dir string) error {
+	archive, err := openArchive(env, c.App.Reader, env.Cwd, c.String("file"))
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	opts := extractOpts{Overwrite: true}
+	if c.Bool("verbose") {
+		opts.Extracted = func(name string) { fmt.Fprintln(c.App.Writer, name) }
+	}
+
+	return extract(c.Context, env, archive, dir, opts)
+}
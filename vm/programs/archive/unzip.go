@@ -0,0 +1,116 @@
+package archive
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(unzip))
+}
+
+var unzip = cli.App{
+	Name:      "unzip",
+	Usage:     "list and extract files from a zip archive",
+	UsageText: `unzip [-l] [-o] [-q] [-d DIR] ARCHIVE`,
+	Description: "Existing files are skipped unless -o is given. " +
+		"ARCHIVE is - for the standard input.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "list",
+			Aliases: []string{"l"},
+			Usage:   "list the files in the archive",
+		},
+		&cli.BoolFlag{
+			Name:    "overwrite",
+			Aliases: []string{"o"},
+			Usage:   "overwrite existing files",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "don't list the extracted files",
+		},
+		&cli.StringFlag{
+			Name:    "directory",
+			Aliases: []string{"d"},
+			Usage:   "extract the files into DIR",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		if c.NArg() != 1 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		archive, err := openArchive(env, c.App.Reader, env.Cwd, c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+
+		if c.Bool("list") {
+			return listZip(c, archive)
+		}
+
+		dir := env.Cwd
+		if d := c.String("directory"); d != "" {
+			dir = absPath(env.Cwd, d)
+		}
+
+		opts := extractOpts{
+			Overwrite: c.Bool("overwrite"),
+			Skipped: func(name string) {
+				log.Printf("skipping %s: file already exists", name)
+			},
+		}
+		if !c.Bool("quiet") {
+			opts.Extracted = func(name string) {
+				fmt.Fprintln(c.App.Writer, "  extracting:", name)
+			}
+		}
+
+		return extract(c.Context, env, archive, dir, opts)
+	},
+}
+
+func listZip(c *cli.Context, archive fs.FS) error {
+	fmt.Fprintf(c.App.Writer, "%9s  %-16s  %s\n", "Length", "Date", "Name")
+
+	var total int64
+	var count int
+
+	err := fs.WalkDir(archive, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.App.Writer, "%9d  %-16s  %s\n",
+			info.Size(), info.ModTime().Format("2006-01-02 15:04"), entryName(name, info))
+
+		total += info.Size()
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.App.Writer, "%9d  %-16s  %d files\n", total, "", count)
+	return nil
+}
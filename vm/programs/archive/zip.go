@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(zipApp))
+}
+
+var zipApp = cli.App{
+	Name:      "zip",
+	Usage:     "package and compress files into a zip archive",
+	UsageText: `zip [-r] [-q] ARCHIVE FILE...`,
+	Description: "The archive is replaced if it already exists. " +
+		"ARCHIVE is - for the standard output.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "recurse-paths",
+			Aliases: []string{"r"},
+			Usage:   "add the contents of directories",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "don't list the added files",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+
+		if c.NArg() < 2 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		name := c.Args().First()
+		files := c.Args().Tail()
+
+		// Progress goes to stderr if the archive is written to stdout.
+		progress := c.App.Writer
+		if name == "-" {
+			progress = c.App.ErrWriter
+		}
+
+		var skip []string
+		if name != "-" {
+			if !strings.Contains(path.Base(name), ".") {
+				name += ".zip"
+			}
+			skip = append(skip, absPath(env.Cwd, name))
+		}
+
+		f, err := createFile(env, c.App.Writer, env.Cwd, name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		zw := zip.NewWriter(f)
+		walkErr := walk(c.Context, env.Filesystem, env.Cwd, files, c.Bool("recurse-paths"), skip,
+			func(name, fullpath string, info fs.FileInfo, target string) error {
+				if name == "." {
+					return nil
+				}
+				if err := writeZipEntry(env, zw, name, fullpath, info, target); err != nil {
+					return err
+				}
+				if !c.Bool("quiet") {
+					fmt.Fprintln(progress, "  adding:", entryName(name, info))
+				}
+				return nil
+			})
+
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		return walkErr
+	},
+}
+
+func writeZipEntry(env vm.Environment, zw *zip.Writer, name, fullpath string, info fs.FileInfo, target string) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return errors.Wrap(err, fullpath)
+	}
+	hdr.Name = entryName(name, info)
+	if info.Mode().IsRegular() {
+		hdr.Method = zip.Deflate
+	}
+
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		// Symbolic links are stored with their target as their contents.
+		_, err = io.WriteString(w, target)
+		return err
+	case !info.Mode().IsRegular():
+		return nil
+	}
+
+	f, err := env.Filesystem.Open(fullpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
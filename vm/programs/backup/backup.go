@@ -0,0 +1,184 @@
+// Package backup provides the backup and restore programs, which export and
+// import snapshots of the persistent storage.
+package backup
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/kvfs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(backup))
+	programs.Register(cliprog.Wrap(restore))
+}
+
+var errNotKVFS = errors.New("not on a kvfs filesystem")
+
+var backup = cli.App{
+	Name:      "backup",
+	Usage:     "save a snapshot of the persistent storage",
+	UsageText: `backup [-o FILE] [DIRECTORY]`,
+	Description: "The snapshot of DIRECTORY, or the whole storage by default, is a gzipped tar archive. " +
+		"It is written to the standard output unless -o is given. " +
+		"DIRECTORY must be on a kvfs filesystem, such as the root.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "write the snapshot to FILE",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+
+		if c.NArg() > 1 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		store, dir, err := storeDir(env, "backup", c.Args().First())
+		if err != nil {
+			return err
+		}
+
+		output := c.String("output")
+		if output == "" {
+			if env.IsTerminal(vm.Stdout) {
+				return errors.New("refusing to write a snapshot to a terminal, use -o")
+			}
+			return kvfs.Export(c.App.Writer, store, dir)
+		}
+
+		f, err := env.Filesystem.OpenFile(absPath(env, output), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, env.CreatePerm(0666))
+		if err != nil {
+			return err
+		}
+
+		if err := kvfs.Export(f, store, dir); err != nil {
+			f.Close()
+			return err
+		}
+
+		return f.Close()
+	},
+}
+
+var restore = cli.App{
+	Name:      "restore",
+	Usage:     "restore a snapshot of the persistent storage",
+	UsageText: `restore [-p POLICY] [-C DIRECTORY] FILE`,
+	Description: "The snapshot made by backup is restored into DIRECTORY, or the root by default, " +
+		"which must be on a kvfs filesystem. " +
+		"FILE is - for the standard input. " +
+		"POLICY is merge to keep existing files, overwrite to replace them, " +
+		"or replace to delete everything in DIRECTORY first.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "policy",
+			Aliases: []string{"p"},
+			Usage:   "what to do with existing files: " + policyNames(),
+			Value:   kvfs.Merge.String(),
+		},
+		&cli.StringFlag{
+			Name:    "directory",
+			Aliases: []string{"C"},
+			Usage:   "restore into DIRECTORY",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+
+		if c.NArg() != 1 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		policy, err := kvfs.ParseImportPolicy(c.String("policy"))
+		if err != nil {
+			return err
+		}
+
+		store, dir, err := storeDir(env, "restore", c.String("directory"))
+		if err != nil {
+			return err
+		}
+
+		var r io.Reader = c.App.Reader
+		if name := c.Args().First(); name != "-" {
+			f, err := env.Filesystem.Open(absPath(env, name))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		stats, err := kvfs.Import(r, store, dir, policy)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.App.Writer, "%d added, %d replaced, %d skipped\n",
+			stats.Added, stats.Replaced, stats.Skipped)
+		return nil
+	},
+}
+
+// storeDir resolves the directory p through the environment's filesystem and
+// returns the kvfs store that has it, along with its path within the store.
+// The directory is the root if p is empty.
+func storeDir(env vm.Environment, op, p string) (kvfs.Store, string, error) {
+	return StoreDir(env.Filesystem, op, absPath(env, p))
+}
+
+// StoreDir resolves the absolute directory dir through the mounts of fsys, if
+// it's a namespace, and returns the kvfs store that has it, along with its
+// path within the store. op names the operation in the returned errors.
+func StoreDir(fsys rwfs.FS, op, dir string) (kvfs.Store, string, error) {
+	rel := rwfs.ConvertAbs(dir)
+	if ns, ok := fsys.(*rwfs.Namespace); ok {
+		m, mrel, err := ns.Lookup(dir)
+		if err != nil {
+			return nil, "", err
+		}
+		fsys, rel = m.FS, mrel
+	}
+
+	store, ok := kvfs.StoreOf(fsys)
+	if !ok {
+		return nil, "", &fs.PathError{Op: op, Path: dir, Err: errNotKVFS}
+	}
+
+	return store, path.Join("/", rel), nil
+}
+
+// absPath returns p relative to the current working directory if it's not
+// absolute, or the root if it's empty.
+func absPath(env vm.Environment, p string) string {
+	switch {
+	case p == "":
+		return "/"
+	case path.IsAbs(p):
+		return path.Clean(p)
+	default:
+		return env.JoinCwd(p)
+	}
+}
+
+func policyNames() string {
+	names := make([]string, len(kvfs.ImportPolicies))
+	for i, p := range kvfs.ImportPolicies {
+		names[i] = p.String()
+	}
+	return strings.Join(names, ", ")
+}
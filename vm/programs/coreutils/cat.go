@@ -11,6 +11,7 @@ import (
 	"libdb.so/vm/internal/cliprog"
 	"libdb.so/vm/internal/nsfw"
 	"libdb.so/vm/programs"
+	"libdb.so/vm/rwfs"
 )
 
 func init() {
@@ -28,17 +29,19 @@ var cat = cli.App{
 		},
 	},
 	Action: func(c *cli.Context) error {
-		if c.NArg() == 0 {
-			if nsfw.IsEnabled() {
-				env := vm.EnvironmentFromContext(c.Context)
+		env := vm.EnvironmentFromContext(c.Context)
+
+		args := c.Args().Slice()
+		if len(args) == 0 {
+			if nsfw.IsEnabled() && env.IsTerminal(vm.Stdin) {
 				env.Println("nyaa~")
 				return nil
 			}
-			return errors.New("no files given")
+			args = []string{"-"}
 		}
 
 		var failed bool
-		for _, arg := range c.Args().Slice() {
+		for _, arg := range args {
 			if !printFile(c, arg) {
 				failed = true
 			}
@@ -56,6 +59,15 @@ func printFile(c *cli.Context, path string) bool {
 	env := vm.EnvironmentFromContext(c.Context)
 	log := vm.LoggerFromContext(c.Context)
 
+	if path == "-" {
+		_, err := io.Copy(env.Terminal.Stdout, ctxReader{c.Context, env.Terminal.Stdin})
+		if err != nil {
+			log.Println("io.Copy:", err)
+			return false
+		}
+		return true
+	}
+
 	f, err := env.Open(path)
 	if err != nil {
 		log.Println("open:", err)
@@ -63,10 +75,17 @@ func printFile(c *cli.Context, path string) bool {
 	}
 	defer f.Close()
 
-	mime, r, err := readMIME(f)
-	if err != nil {
-		log.Println("readMIME:", err)
-		return false
+	var mime string
+	var r io.Reader = f
+	if s, err := f.Stat(); err == nil {
+		mime = rwfs.ContentType(s)
+	}
+	if mime == "" {
+		mime, r, err = readMIME(f)
+		if err != nil {
+			log.Println("readMIME:", err)
+			return false
+		}
 	}
 
 	switch mime {
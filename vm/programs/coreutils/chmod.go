@@ -0,0 +1,166 @@
+package coreutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/internal/filemode"
+	"libdb.so/vm/programs"
+	"libdb.so/vm/rwfs"
+)
+
+func init() {
+	programs.Register(chmodProgram{cliprog.Wrap(chmod)})
+}
+
+var chmod = cli.App{
+	Name:      "chmod",
+	Usage:     "change file mode bits",
+	UsageText: `chmod [OPTION]... MODE[,MODE]... FILE...`,
+	Description: "MODE is an octal number like 755, or symbolic like u+x or go=r. " +
+		"Symbolic modes that don't say who they affect, like +x, are limited by the umask.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "recursive",
+			Aliases: []string{"R"},
+			Usage:   "change files and directories recursively",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "output a diagnostic for every file processed",
+		},
+		&cli.BoolFlag{
+			Name:    "changes",
+			Aliases: []string{"c"},
+			Usage:   "like verbose but report only when a change is made",
+		},
+		&cli.BoolFlag{
+			Name:    "silent",
+			Aliases: []string{"f", "quiet"},
+			Usage:   "suppress most error messages",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		if c.NArg() < 2 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		mode := c.Args().First()
+		if _, err := filemode.Apply(mode, 0, 0, false); err != nil {
+			return &vm.UsageError{Err: err, Usage: c.App.UsageText}
+		}
+
+		var failed bool
+		report := func(err error) {
+			if !c.Bool("silent") {
+				log.Println("chmod:", err)
+			}
+			failed = true
+		}
+
+		for _, arg := range c.Args().Tail() {
+			root := absPath(env, arg)
+
+			if !c.Bool("recursive") {
+				if err := chmodFile(c, env, root, arg, mode); err != nil {
+					report(err)
+				}
+				continue
+			}
+
+			err := fs.WalkDir(env.Filesystem, root, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					report(err)
+					return nil
+				}
+				if err := c.Context.Err(); err != nil {
+					return err
+				}
+				name := arg + strings.TrimPrefix(p, root)
+				if err := chmodFile(c, env, p, name, mode); err != nil {
+					report(err)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if failed {
+			return errors.New("failed to change the mode of one or more files")
+		}
+
+		return nil
+	},
+}
+
+// chmodFile applies mode to the file at path. name is the file's name as the
+// user gave it.
+func chmodFile(c *cli.Context, env vm.Environment, path, name, mode string) error {
+	s, err := fs.Stat(env.Filesystem, path)
+	if err != nil {
+		return err
+	}
+
+	oldPerm := s.Mode().Perm()
+	newPerm, err := filemode.Apply(mode, oldPerm, env.Umask, s.IsDir())
+	if err != nil {
+		return err
+	}
+
+	if err := rwfs.Chmod(env.Filesystem, path, newPerm); err != nil {
+		return err
+	}
+
+	switch {
+	case oldPerm != newPerm && (c.Bool("verbose") || c.Bool("changes")):
+		fmt.Fprintf(c.App.Writer, "mode of '%s' changed from %04o (%s) to %04o (%s)\n",
+			name, oldPerm, permString(oldPerm), newPerm, permString(newPerm))
+	case oldPerm == newPerm && c.Bool("verbose"):
+		fmt.Fprintf(c.App.Writer, "mode of '%s' retained as %04o (%s)\n",
+			name, oldPerm, permString(oldPerm))
+	}
+
+	return nil
+}
+
+// permString formats perm like ls does, without the file type.
+func permString(perm fs.FileMode) string {
+	return perm.String()[1:]
+}
+
+// chmodProgram passes modes like -x through to chmod as arguments, since
+// they'd otherwise be parsed as flags.
+type chmodProgram struct {
+	vm.Program
+}
+
+func (p chmodProgram) Usage() string {
+	return chmod.Usage
+}
+
+func (p chmodProgram) Run(ctx context.Context, env vm.Environment, args []string) error {
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		if len(arg) > 1 && strings.Trim(arg[1:], "rwxXstugoa+-=,") == "" {
+			// Put the mode after "--", so that it is taken as an argument.
+			args = append(args[:i:i], append([]string{"--"}, args[i:]...)...)
+			break
+		}
+	}
+	return p.Program.Run(ctx, env, args)
+}
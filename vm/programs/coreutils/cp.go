@@ -0,0 +1,82 @@
+package coreutils
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(cp))
+}
+
+var cp = cli.App{
+	Name:      "cp",
+	Usage:     "copy files and directories",
+	UsageText: `cp [OPTION]... SOURCE... DEST`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "recursive",
+			Aliases: []string{"r", "R"},
+			Usage:   "copy directories recursively",
+		},
+		&cli.BoolFlag{
+			Name:    "no-clobber",
+			Aliases: []string{"n"},
+			Usage:   "do not overwrite an existing file",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "explain what is being done",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		args := c.Args().Slice()
+		if len(args) < 2 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		dst := absPath(env, args[len(args)-1])
+		srcs := args[:len(args)-1]
+
+		if len(srcs) > 1 && !isDir(env, dst) {
+			return &fs.PathError{Op: "cp", Path: dst, Err: errors.New("target is not a directory")}
+		}
+
+		var failed bool
+		for _, arg := range srcs {
+			src := absPath(env, arg)
+			target := targetPath(env, dst, src)
+
+			if c.Bool("no-clobber") {
+				if _, err := fs.Stat(env.Filesystem, target); err == nil {
+					continue
+				}
+			}
+
+			if err := copyAll(c.Context, env.Filesystem, target, src, c.Bool("recursive")); err != nil {
+				log.Println("cp:", err)
+				failed = true
+				continue
+			}
+
+			if c.Bool("verbose") {
+				env.Printf("%q -> %q\n", src, target)
+			}
+		}
+
+		if failed {
+			return errors.New("failed to copy one or more files")
+		}
+
+		return nil
+	},
+}
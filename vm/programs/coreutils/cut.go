@@ -0,0 +1,165 @@
+package coreutils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(cut))
+}
+
+var cut = cli.App{
+	Name:      "cut",
+	Usage:     "remove sections from each line of files",
+	UsageText: `cut OPTION... [FILE]...`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "bytes",
+			Aliases: []string{"b"},
+			Usage:   "select only these bytes",
+		},
+		&cli.StringFlag{
+			Name:    "characters",
+			Aliases: []string{"c"},
+			Usage:   "select only these characters",
+		},
+		&cli.StringFlag{
+			Name:    "fields",
+			Aliases: []string{"f"},
+			Usage:   "select only these fields",
+		},
+		&cli.StringFlag{
+			Name:    "delimiter",
+			Aliases: []string{"d"},
+			Usage:   "use DELIM instead of TAB for field delimiter",
+			Value:   "\t",
+		},
+		&cli.BoolFlag{
+			Name:    "only-delimited",
+			Aliases: []string{"s"},
+			Usage:   "do not print lines not containing delimiters",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		var mode string
+		for _, name := range []string{"bytes", "characters", "fields"} {
+			if c.IsSet(name) {
+				if mode != "" {
+					return &vm.UsageError{
+						Err:   errors.New("only one type of list may be specified"),
+						Usage: c.App.UsageText,
+					}
+				}
+				mode = name
+			}
+		}
+		if mode == "" {
+			return &vm.UsageError{
+				Err:   errors.New("you must specify a list of bytes, characters, or fields"),
+				Usage: c.App.UsageText,
+			}
+		}
+
+		list, err := parseCutList(c.String(mode))
+		if err != nil {
+			return &vm.UsageError{Err: err, Usage: c.App.UsageText}
+		}
+
+		delim := c.String("delimiter")
+		if len([]rune(delim)) != 1 {
+			return &vm.UsageError{
+				Err:   errors.New("the delimiter must be a single character"),
+				Usage: c.App.UsageText,
+			}
+		}
+
+		return eachInput(c, c.Args().Slice(), func(_ string, r io.Reader) error {
+			return readLines(r, func(line string) error {
+				var out string
+				switch mode {
+				case "bytes":
+					out = string(cutSelect(list, []byte(line)))
+				case "characters":
+					out = string(cutSelect(list, []rune(line)))
+				case "fields":
+					if !strings.Contains(line, delim) {
+						if c.Bool("only-delimited") {
+							return nil
+						}
+						out = line
+						break
+					}
+					out = strings.Join(cutSelect(list, strings.Split(line, delim)), delim)
+				}
+				_, err := fmt.Fprintln(c.App.Writer, out)
+				return err
+			})
+		})
+	},
+}
+
+// cutList is a list of 1-indexed, inclusive ranges.
+type cutList [][2]int
+
+// parseCutList parses a LIST, which is made up of ranges separated by commas.
+// Each range is one of N, N-, N-M or -M.
+func parseCutList(s string) (cutList, error) {
+	var list cutList
+	for _, part := range strings.Split(s, ",") {
+		lo, hi := 1, math.MaxInt
+
+		loStr, hiStr, isRange := strings.Cut(part, "-")
+		if !isRange {
+			hiStr = loStr
+		}
+
+		var err error
+		if loStr != "" {
+			if lo, err = strconv.Atoi(loStr); err != nil || lo < 1 {
+				return nil, errors.Errorf("invalid field value %q", part)
+			}
+		}
+		if hiStr != "" {
+			if hi, err = strconv.Atoi(hiStr); err != nil || hi < lo {
+				return nil, errors.Errorf("invalid field range %q", part)
+			}
+		}
+		if loStr == "" && hiStr == "" {
+			return nil, errors.Errorf("invalid range with no endpoint %q", part)
+		}
+
+		list = append(list, [2]int{lo, hi})
+	}
+	return list, nil
+}
+
+// has returns true if the 1-indexed position is in the list.
+func (l cutList) has(i int) bool {
+	for _, r := range l {
+		if r[0] <= i && i <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// cutSelect returns the elements of s whose positions are in the list.
+func cutSelect[T any](l cutList, s []T) []T {
+	var out []T
+	for i := range s {
+		if l.has(i + 1) {
+			out = append(out, s[i])
+		}
+	}
+	return out
+}
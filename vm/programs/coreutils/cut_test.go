@@ -0,0 +1,45 @@
+package coreutils
+
+import (
+	"math"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestParseCutList(t *testing.T) {
+	tests := []struct {
+		list string
+		want cutList
+		err  bool
+	}{
+		{list: "3", want: cutList{{3, 3}}},
+		{list: "2-4", want: cutList{{2, 4}}},
+		{list: "2-", want: cutList{{2, math.MaxInt}}},
+		{list: "-3", want: cutList{{1, 3}}},
+		{list: "1,3-4,6-", want: cutList{{1, 1}, {3, 4}, {6, math.MaxInt}}},
+		{list: "0", err: true},
+		{list: "-", err: true},
+		{list: "4-2", err: true},
+		{list: "a", err: true},
+		{list: "1,,2", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.list, func(t *testing.T) {
+			list, err := parseCutList(test.list)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, list)
+		})
+	}
+}
+
+func TestCutSelect(t *testing.T) {
+	list := cutList{{1, 1}, {3, 4}, {6, math.MaxInt}}
+	fields := []string{"a", "b", "c", "d", "e", "f", "g"}
+	assert.Equal(t, []string{"a", "c", "d", "f", "g"}, cutSelect(list, fields))
+}
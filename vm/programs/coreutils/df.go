@@ -0,0 +1,130 @@
+package coreutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+	"libdb.so/vm/rwfs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(df))
+}
+
+var df = cli.App{
+	Name:      "df",
+	Usage:     "report file system space usage",
+	UsageText: `df [OPTION]...`,
+	Description: "Most filesystems have no fixed size, so only the space " +
+		"used by all files and the number of files are reported for each mount. " +
+		"Filesystems whose storage knows its usage, like IndexedDB, report " +
+		"that instead, along with their quota if there is one.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "human-readable",
+			Aliases: []string{"h"},
+			Usage:   "print sizes in human readable format (e.g., 1K 234M 2G)",
+		},
+		&cli.BoolFlag{
+			Name:    "print-type",
+			Aliases: []string{"T"},
+			Usage:   "print file system type",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "use a JSON output format",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		mounts := []rwfs.Mount{{
+			Point:  "/",
+			FS:     env.Filesystem,
+			Source: "rootfs",
+		}}
+		if ns, ok := env.Filesystem.(*rwfs.Namespace); ok {
+			mounts = ns.Mounts()
+		}
+
+		usages := make([]dfEntry, len(mounts))
+		for i, m := range mounts {
+			usages[i] = dfEntry{
+				Filesystem: m.Source,
+				Type:       m.Type,
+				MountedOn:  m.Point,
+			}
+
+			// Mounts are walked on their own, so that files on mounts under
+			// them aren't counted twice.
+			used, err := walkSizes(c.Context, m.FS, ".", func(string, fs.DirEntry, int64, int) {
+				usages[i].Files++
+			})
+			if err != nil {
+				// Report whatever we could count.
+				log.Println("df:", err)
+			}
+			usages[i].Used = used
+
+			// Prefer what the storage itself reports, since it includes
+			// its own overhead.
+			used, quota, err := rwfs.Usage(m.FS)
+			switch {
+			case err == nil:
+				usages[i].Used = used
+				usages[i].Size = quota
+			case !errors.Is(err, errors.ErrUnsupported):
+				log.Println("df:", err)
+			}
+		}
+
+		if c.Bool("json") {
+			enc := json.NewEncoder(c.App.Writer)
+			enc.SetIndent("", "  ")
+			return enc.Encode(usages)
+		}
+
+		human := c.Bool("human-readable")
+		printType := c.Bool("print-type")
+
+		w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+		if printType {
+			fmt.Fprintln(w, "Filesystem\tType\tSize\tUsed\tAvail\tFiles\tMounted on")
+		} else {
+			fmt.Fprintln(w, "Filesystem\tSize\tUsed\tAvail\tFiles\tMounted on")
+		}
+		for _, usage := range usages {
+			fmt.Fprintf(w, "%s\t", usage.Filesystem)
+			if printType {
+				fmt.Fprintf(w, "%s\t", usage.Type)
+			}
+
+			// Sizes are unknown for most filesystems.
+			size, avail := "-", "-"
+			if usage.Size > 0 {
+				size = formatSize(usage.Size, human)
+				avail = formatSize(max(usage.Size-usage.Used, 0), human)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", size, formatSize(usage.Used, human), avail, usage.Files, usage.MountedOn)
+		}
+		return w.Flush()
+	},
+}
+
+type dfEntry struct {
+	Filesystem string `json:"filesystem"`
+	Type       string `json:"type,omitempty"`
+	Size       int64  `json:"size,omitempty"` // 0 if unknown
+	Used       int64  `json:"used"`
+	Files      int64  `json:"files"`
+	MountedOn  string `json:"mounted_on"`
+}
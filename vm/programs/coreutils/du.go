@@ -0,0 +1,125 @@
+package coreutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(du))
+}
+
+var du = cli.App{
+	Name:      "du",
+	Usage:     "estimate file space usage",
+	UsageText: `du [OPTION]... [FILE]...`,
+	Description: "Sizes are the total number of bytes in each file or " +
+		"directory, unless -h is given.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "all",
+			Aliases: []string{"a"},
+			Usage:   "write counts for all files, not just directories",
+		},
+		&cli.BoolFlag{
+			Name:    "summarize",
+			Aliases: []string{"s"},
+			Usage:   "display only a total for each argument",
+		},
+		&cli.BoolFlag{
+			Name:    "human-readable",
+			Aliases: []string{"h"},
+			Usage:   "print sizes in human readable format (e.g., 1K 234M 2G)",
+		},
+		&cli.IntFlag{
+			Name:    "max-depth",
+			Aliases: []string{"d"},
+			Usage:   "print the total for a directory only if it is N or fewer levels below the argument",
+			Value:   -1,
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "use a JSON output format",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		args := c.Args().Slice()
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+
+		maxDepth := c.Int("max-depth")
+		if c.Bool("summarize") {
+			maxDepth = 0
+		}
+
+		var entries []duEntry
+		var failed bool
+
+		for _, arg := range args {
+			root := absPath(env, arg)
+
+			_, err := walkSizes(c.Context, env.Filesystem, root, func(p string, d fs.DirEntry, size int64, depth int) {
+				if maxDepth >= 0 && depth > maxDepth {
+					return
+				}
+				if !d.IsDir() && !c.Bool("all") && depth > 0 {
+					return
+				}
+				entries = append(entries, duEntry{
+					Path: path.Join(arg, pathRel(root, p)),
+					Size: size,
+				})
+			})
+			if err != nil {
+				log.Println("du:", err)
+				failed = true
+			}
+		}
+
+		if c.Bool("json") {
+			enc := json.NewEncoder(c.App.Writer)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(entries); err != nil {
+				return err
+			}
+		} else {
+			for _, entry := range entries {
+				fmt.Fprintf(c.App.Writer, "%s\t%s\n", formatSize(entry.Size, c.Bool("human-readable")), entry.Path)
+			}
+		}
+
+		if failed {
+			return errors.New("failed to read one or more files")
+		}
+
+		return nil
+	},
+}
+
+type duEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// pathRel returns p relative to root. p must be within root.
+func pathRel(root, p string) string {
+	if p == root {
+		return "."
+	}
+	if root == "/" {
+		return p[1:]
+	}
+	return p[len(root)+1:]
+}
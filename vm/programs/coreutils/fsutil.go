@@ -0,0 +1,199 @@
+package coreutils
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	stderrors "errors"
+
+	"github.com/pkg/errors"
+	"libdb.so/vm"
+	"libdb.so/vm/rwfs"
+)
+
+// absPath resolves the given path relative to the current working directory.
+func absPath(env vm.Environment, p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return env.JoinCwd(p)
+}
+
+// isDir returns true if the given path is an existing directory.
+func isDir(env vm.Environment, p string) bool {
+	s, err := fs.Stat(env.Filesystem, p)
+	return err == nil && s.IsDir()
+}
+
+// targetPath returns the path that src should be copied or moved to. If dst is
+// an existing directory, then src is put inside it.
+func targetPath(env vm.Environment, dst, src string) string {
+	if isDir(env, dst) {
+		return path.Join(dst, path.Base(src))
+	}
+	return dst
+}
+
+// copyAll copies src to dst. Directories are only copied if recursive is true.
+func copyAll(ctx context.Context, fsys rwfs.FS, dst, src string, recursive bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s, err := fs.Stat(fsys, src)
+	if err != nil {
+		return err
+	}
+
+	if !s.IsDir() {
+		return rwfs.Copy(fsys, dst, fsys, src)
+	}
+
+	if !recursive {
+		return fmt.Errorf("-r not specified; omitting directory %q", src)
+	}
+
+	if dst == src || strings.HasPrefix(dst, src+"/") {
+		return fmt.Errorf("cannot copy a directory, %q, into itself, %q", src, dst)
+	}
+
+	if err := fsys.MkdirAll(dst, s.Mode().Perm()); err != nil {
+		return err
+	}
+
+	entries, err := fs.ReadDir(fsys, src)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		dst := path.Join(dst, entry.Name())
+		src := path.Join(src, entry.Name())
+
+		var err error
+		if entry.Type()&fs.ModeSymlink != 0 {
+			// Like cp -r, symbolic links within directories are copied as
+			// links.
+			err = copySymlink(fsys, dst, src)
+		} else {
+			err = copyAll(ctx, fsys, dst, src, true)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Wrapf(joinErrors(errs), "cannot copy %q", src)
+}
+
+// copySymlink creates dst as a symbolic link to the same target as src.
+func copySymlink(fsys rwfs.FS, dst, src string) error {
+	target, err := rwfs.Readlink(fsys, src)
+	if err != nil {
+		return err
+	}
+	return rwfs.Symlink(fsys, target, dst)
+}
+
+// touchFile creates the file if it doesn't exist, then sets its modification
+// time to mtime.
+func touchFile(env vm.Environment, name string, mtime time.Time) error {
+	if _, err := fs.Stat(env.Filesystem, name); errors.Is(err, fs.ErrNotExist) {
+		f, err := env.Filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE, env.CreatePerm(0666))
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	return rwfs.Chtimes(env.Filesystem, name, mtime, mtime)
+}
+
+// walkSizes calls fn for each file or directory within root, including root
+// itself. The size given to fn for directories is the total size of their
+// contents.
+func walkSizes(ctx context.Context, fsys fs.FS, root string, fn func(path string, d fs.DirEntry, size int64, depth int)) (int64, error) {
+	s, err := fs.Stat(fsys, root)
+	if err != nil {
+		return 0, err
+	}
+	return walkSizes_(ctx, fsys, root, fakeDirEntry{s}, 0, fn)
+}
+
+func walkSizes_(ctx context.Context, fsys fs.FS, p string, d fs.DirEntry, depth int, fn func(path string, d fs.DirEntry, size int64, depth int)) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if !d.IsDir() {
+		s, err := d.Info()
+		if err != nil {
+			return 0, err
+		}
+		fn(p, d, s.Size(), depth)
+		return s.Size(), nil
+	}
+
+	entries, err := fs.ReadDir(fsys, p)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	var errs []error
+	for _, entry := range entries {
+		size, err := walkSizes_(ctx, fsys, path.Join(p, entry.Name()), entry, depth+1, fn)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		total += size
+	}
+
+	fn(p, d, total, depth)
+	return total, joinErrors(errs)
+}
+
+// formatSize formats the size in bytes. If human is true, then the size is
+// formatted using the largest fitting unit, e.g. 1.5K.
+func formatSize(size int64, human bool) string {
+	if !human {
+		return fmt.Sprint(size)
+	}
+
+	const units = "BKMGTPE"
+
+	f := float64(size)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%d", size)
+	}
+	if f < 10 {
+		return fmt.Sprintf("%.1f%c", f, units[i])
+	}
+	return fmt.Sprintf("%.0f%c", f, units[i])
+}
+
+// joinErrors is like errors.Join, except it returns the only error as-is.
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return stderrors.Join(errs...)
+	}
+}
@@ -0,0 +1,483 @@
+package coreutils
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(grep))
+}
+
+var grep = cli.App{
+	Name:      "grep",
+	Usage:     "print lines that match patterns",
+	UsageText: `grep [OPTION]... PATTERNS [FILE]...`,
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "regexp",
+			Aliases: []string{"e"},
+			Usage:   "use PATTERNS for matching",
+		},
+		&cli.BoolFlag{
+			Name:    "extended-regexp",
+			Aliases: []string{"E"},
+			Usage:   "PATTERNS are extended regular expressions",
+		},
+		&cli.BoolFlag{
+			Name:    "fixed-strings",
+			Aliases: []string{"F"},
+			Usage:   "PATTERNS are strings",
+		},
+		&cli.BoolFlag{
+			Name:    "ignore-case",
+			Aliases: []string{"i"},
+			Usage:   "ignore case distinctions in patterns and data",
+		},
+		&cli.BoolFlag{
+			Name:    "word-regexp",
+			Aliases: []string{"w"},
+			Usage:   "match only whole words",
+		},
+		&cli.BoolFlag{
+			Name:    "invert-match",
+			Aliases: []string{"v"},
+			Usage:   "select non-matching lines",
+		},
+		&cli.BoolFlag{
+			Name:    "line-number",
+			Aliases: []string{"n"},
+			Usage:   "print line number with output lines",
+		},
+		&cli.BoolFlag{
+			Name:    "with-filename",
+			Aliases: []string{"H"},
+			Usage:   "print file name with output lines",
+		},
+		&cli.BoolFlag{
+			Name:    "no-filename",
+			Aliases: []string{"h"},
+			Usage:   "suppress the file name prefix on output",
+		},
+		&cli.BoolFlag{
+			Name:    "only-matching",
+			Aliases: []string{"o"},
+			Usage:   "show only nonempty parts of lines that match",
+		},
+		&cli.BoolFlag{
+			Name:    "count",
+			Aliases: []string{"c"},
+			Usage:   "print only a count of selected lines per file",
+		},
+		&cli.BoolFlag{
+			Name:    "files-with-matches",
+			Aliases: []string{"l"},
+			Usage:   "print only names of files with selected lines",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "suppress all normal output",
+		},
+		&cli.BoolFlag{
+			Name:    "recursive",
+			Aliases: []string{"r", "R"},
+			Usage:   "search directories recursively",
+		},
+		&cli.StringFlag{
+			Name:  "color",
+			Usage: "use markers to highlight the matching strings; WHEN is 'always', 'never', or 'auto'",
+			Value: "auto",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		args := c.Args().Slice()
+
+		patterns := c.StringSlice("regexp")
+		if len(patterns) == 0 {
+			if len(args) == 0 {
+				return &vm.UsageError{Usage: c.App.UsageText}
+			}
+			patterns = []string{args[0]}
+			args = args[1:]
+		}
+
+		re, err := compileGrepPatterns(c, patterns)
+		if err != nil {
+			return vm.WrapError(2, err)
+		}
+
+		if len(args) == 0 && c.Bool("recursive") {
+			args = []string{"."}
+		}
+
+		files, err := grepFiles(c, args)
+		if err != nil {
+			log.Println("grep:", err)
+		}
+
+		g := grepper{
+			c:         c,
+			re:        re,
+			filenames: (len(args) > 1 || c.Bool("recursive")) && !c.Bool("no-filename"),
+		}
+		g.filenames = g.filenames || c.Bool("with-filename")
+
+		switch c.String("color") {
+		case "always":
+			g.colors = newGrepColors()
+		case "auto":
+			if env.IsTerminal(vm.Stdout) {
+				g.colors = newGrepColors()
+			}
+		case "never":
+		default:
+			return vm.WrapError(2, &vm.UsageError{
+				Err:   errors.Errorf("invalid --color argument %q", c.String("color")),
+				Usage: c.App.UsageText,
+			})
+		}
+
+		var matched bool
+		for _, file := range files {
+			m, ferr := g.grepFile(file)
+			if ferr != nil {
+				if ferr == errGrepQuit {
+					return nil
+				}
+				if c.Context.Err() != nil {
+					return c.Context.Err()
+				}
+				if errors.Is(ferr, vm.ErrBrokenPipe) {
+					return ferr
+				}
+				log.Println("grep:", ferr)
+				err = ferr
+			}
+			matched = matched || m
+		}
+
+		switch {
+		case err != nil && !(matched && c.Bool("quiet")):
+			return vm.ExitStatus(2)
+		case !matched:
+			return vm.ExitStatus(1)
+		default:
+			return nil
+		}
+	},
+}
+
+// errGrepQuit is returned by grepFile to stop grep early with a success status,
+// e.g. for -q.
+var errGrepQuit = errors.New("quit")
+
+// grepFile is a file to be searched by grep.
+type grepFile struct {
+	name string // name shown to the user, or "-" for stdin
+	path string // absolute path, or "-" for stdin
+}
+
+// grepFiles resolves the list of files to search. Directories are walked
+// recursively if -r is given. Errors are returned after all files have been
+// resolved.
+func grepFiles(c *cli.Context, args []string) ([]grepFile, error) {
+	env := vm.EnvironmentFromContext(c.Context)
+
+	if len(args) == 0 {
+		return []grepFile{{"-", "-"}}, nil
+	}
+
+	var files []grepFile
+	var errs []error
+
+	for _, arg := range args {
+		if arg == "-" {
+			files = append(files, grepFile{"-", "-"})
+			continue
+		}
+
+		root := absPath(env, arg)
+		if !isDir(env, root) {
+			files = append(files, grepFile{arg, root})
+			continue
+		}
+
+		if !c.Bool("recursive") {
+			errs = append(errs, fmt.Errorf("%s: is a directory", arg))
+			continue
+		}
+
+		err := fs.WalkDir(env.Filesystem, root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			if d.IsDir() {
+				return c.Context.Err()
+			}
+			rel := strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+			files = append(files, grepFile{path.Join(arg, rel), p})
+			return nil
+		})
+		if err != nil {
+			return files, err
+		}
+	}
+
+	return files, joinErrors(errs)
+}
+
+// compileGrepPatterns compiles the given patterns into a single regular
+// expression that matches any of them. Patterns containing newlines are split
+// into multiple patterns.
+func compileGrepPatterns(c *cli.Context, patterns []string) (*regexp.Regexp, error) {
+	var alts []string
+	for _, pattern := range patterns {
+		for _, p := range strings.Split(pattern, "\n") {
+			switch {
+			case c.Bool("fixed-strings"):
+				p = regexp.QuoteMeta(p)
+			case !c.Bool("extended-regexp"):
+				p = basicToExtended(p)
+			}
+			alts = append(alts, "(?:"+p+")")
+		}
+	}
+
+	expr := strings.Join(alts, "|")
+	if c.Bool("word-regexp") {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if c.Bool("ignore-case") {
+		expr = "(?i)" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid pattern")
+	}
+	return re, nil
+}
+
+// basicToExtended converts a POSIX basic regular expression into an extended
+// one. In basic regular expressions, the characters (){}|+? are literals,
+// unless they're escaped with a backslash.
+func basicToExtended(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; {
+		case ch == '\\' && i+1 < len(pattern):
+			i++
+			if strings.IndexByte("(){}|+?", pattern[i]) >= 0 {
+				b.WriteByte(pattern[i])
+			} else {
+				b.WriteByte('\\')
+				b.WriteByte(pattern[i])
+			}
+		case ch == '[':
+			// Bracket expressions are copied as-is.
+			end := bracketEnd(pattern, i)
+			b.WriteString(pattern[i:end])
+			i = end - 1
+		case strings.IndexByte("(){}|+?", ch) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+// bracketEnd returns the index right after the bracket expression starting at
+// pattern[start]. A ] right after the opening [ or [^ is a literal.
+func bracketEnd(pattern string, start int) int {
+	i := start + 1
+	if i < len(pattern) && pattern[i] == '^' {
+		i++
+	}
+	if i < len(pattern) && pattern[i] == ']' {
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "[:"):
+			if end := strings.Index(pattern[i+2:], ":]"); end >= 0 {
+				i += end + 3
+			}
+		case pattern[i] == ']':
+			return i + 1
+		}
+	}
+	return len(pattern)
+}
+
+// grepColors are the colors used by grep, which are the same as GNU grep's.
+// Nil colors are not painted.
+type grepColors struct {
+	match    *color.Color
+	filename *color.Color
+	lineNum  *color.Color
+	sep      *color.Color
+}
+
+func newGrepColors() grepColors {
+	colors := grepColors{
+		match:    color.New(color.FgRed, color.Bold),
+		filename: color.New(color.FgMagenta),
+		lineNum:  color.New(color.FgGreen),
+		sep:      color.New(color.FgCyan),
+	}
+	colors.match.EnableColor()
+	colors.filename.EnableColor()
+	colors.lineNum.EnableColor()
+	colors.sep.EnableColor()
+	return colors
+}
+
+type grepper struct {
+	c         *cli.Context
+	re        *regexp.Regexp
+	colors    grepColors // zero if no colors
+	filenames bool
+}
+
+// grepFile searches the given file and prints its matching lines. It returns
+// true if any line was selected.
+func (g *grepper) grepFile(file grepFile) (bool, error) {
+	env := vm.EnvironmentFromContext(g.c.Context)
+
+	var r io.Reader
+	if file.path == "-" {
+		r = env.Terminal.Stdin
+		file.name = "(standard input)"
+	} else {
+		f, err := env.Filesystem.Open(file.path)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		r = f
+	}
+	r = ctxReader{g.c.Context, r}
+
+	w := g.c.App.Writer
+	invert := g.c.Bool("invert-match")
+
+	var count int
+	var lineNum int
+
+	err := readLines(r, func(line string) error {
+		lineNum++
+
+		matches := g.re.FindAllStringIndex(line, -1)
+		if (len(matches) > 0) == invert {
+			return nil
+		}
+		count++
+
+		switch {
+		case g.c.Bool("quiet"):
+			return errGrepQuit
+		case g.c.Bool("files-with-matches"):
+			return io.EOF
+		case g.c.Bool("count"):
+			return nil
+		case g.c.Bool("only-matching"):
+			if invert {
+				return nil
+			}
+			for _, m := range matches {
+				if m[0] == m[1] {
+					continue
+				}
+				text := g.paint(g.colors.match, line[m[0]:m[1]])
+				if _, err := fmt.Fprintln(w, g.prefix(file.name, lineNum)+text); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if !invert {
+			line = g.highlight(line, matches)
+		}
+		_, err := fmt.Fprintln(w, g.prefix(file.name, lineNum)+line)
+		return err
+	})
+	if err != nil {
+		return count > 0, err
+	}
+
+	switch {
+	case g.c.Bool("files-with-matches"):
+		if count > 0 {
+			_, err = fmt.Fprintln(w, g.paint(g.colors.filename, file.name))
+		}
+	case g.c.Bool("count"):
+		if g.filenames {
+			_, err = fmt.Fprint(w, g.paint(g.colors.filename, file.name), g.paint(g.colors.sep, ":"))
+		}
+		if err == nil {
+			_, err = fmt.Fprintln(w, count)
+		}
+	}
+
+	return count > 0, err
+}
+
+// prefix returns the prefix of an output line, which contains the file name
+// and line number if requested.
+func (g *grepper) prefix(name string, lineNum int) string {
+	var prefix string
+	if g.filenames {
+		prefix += g.paint(g.colors.filename, name) + g.paint(g.colors.sep, ":")
+	}
+	if g.c.Bool("line-number") {
+		prefix += g.paint(g.colors.lineNum, fmt.Sprint(lineNum)) + g.paint(g.colors.sep, ":")
+	}
+	return prefix
+}
+
+// highlight colors the given matches within the line.
+func (g *grepper) highlight(line string, matches [][]int) string {
+	if g.colors.match == nil {
+		return line
+	}
+
+	var b strings.Builder
+	var last int
+	for _, m := range matches {
+		if m[0] == m[1] {
+			continue
+		}
+		b.WriteString(line[last:m[0]])
+		b.WriteString(g.colors.match.Sprint(line[m[0]:m[1]]))
+		last = m[1]
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+// paint paints s using the given color, unless it is nil.
+func (g *grepper) paint(c *color.Color, s string) string {
+	if c == nil {
+		return s
+	}
+	return c.Sprint(s)
+}
@@ -0,0 +1,127 @@
+package coreutils
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/urfave/cli/v3"
+)
+
+// grepRegexp parses args using grep's flags and compiles the patterns given
+// with -e.
+func grepRegexp(t *testing.T, args ...string) (*regexp.Regexp, error) {
+	var re *regexp.Regexp
+	var err error
+
+	app := cli.App{
+		Name:                   "grep",
+		Flags:                  grep.Flags,
+		UseShortOptionHandling: true,
+		Action: func(c *cli.Context) error {
+			re, err = compileGrepPatterns(c, c.StringSlice("regexp"))
+			return nil
+		},
+	}
+	assert.NoError(t, app.Run(append([]string{"grep"}, args...)))
+	return re, err
+}
+
+func TestCompileGrepPatterns(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		match []string
+		skip  []string
+	}{
+		{
+			name:  "basic",
+			args:  []string{"-e", `a+(b)`},
+			match: []string{"a+(b)"},
+			skip:  []string{"aab", "ab"},
+		},
+		{
+			name:  "basic with escapes",
+			args:  []string{"-e", `a\+\(b\)`},
+			match: []string{"aab"},
+			skip:  []string{"a+(b)"},
+		},
+		{
+			name:  "extended",
+			args:  []string{"-E", "-e", `a+(b|c)`},
+			match: []string{"aab", "ac"},
+			skip:  []string{"a+(b)"},
+		},
+		{
+			name:  "fixed strings",
+			args:  []string{"-F", "-e", `a.b`},
+			match: []string{"xa.by"},
+			skip:  []string{"axb"},
+		},
+		{
+			name:  "ignore case",
+			args:  []string{"-i", "-e", "hello"},
+			match: []string{"HeLLo there"},
+			skip:  []string{"help"},
+		},
+		{
+			name:  "word regexp",
+			args:  []string{"-w", "-e", "cat"},
+			match: []string{"a cat sat", "cat"},
+			skip:  []string{"concatenate"},
+		},
+		{
+			name:  "grouped flags",
+			args:  []string{"-wiF", "-e", "a.b"},
+			match: []string{"x A.B y"},
+			skip:  []string{"xa.by", "axb"},
+		},
+		{
+			name:  "multiple patterns",
+			args:  []string{"-e", "foo", "-e", "bar\nbaz"},
+			match: []string{"foo", "bar", "baz"},
+			skip:  []string{"qux"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			re, err := grepRegexp(t, test.args...)
+			assert.NoError(t, err)
+
+			for _, line := range test.match {
+				assert.True(t, re.MatchString(line), "%s should match %q", re, line)
+			}
+			for _, line := range test.skip {
+				assert.False(t, re.MatchString(line), "%s should not match %q", re, line)
+			}
+		})
+	}
+}
+
+func TestCompileGrepPatternsInvalid(t *testing.T) {
+	_, err := grepRegexp(t, "-E", "-e", "a(")
+	assert.Error(t, err)
+}
+
+func TestBasicToExtended(t *testing.T) {
+	tests := []struct {
+		basic    string
+		extended string
+	}{
+		{`abc`, `abc`},
+		{`a|b`, `a\|b`},
+		{`a\|b`, `a|b`},
+		{`x\{2\}`, `x{2}`},
+		{`\.`, `\.`},
+		{`[(|)]`, `[(|)]`},
+		{`[]a]+`, `[]a]\+`},
+		{`[[:alpha:]](`, `[[:alpha:]]\(`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.basic, func(t *testing.T) {
+			assert.Equal(t, test.extended, basicToExtended(test.basic))
+		})
+	}
+}
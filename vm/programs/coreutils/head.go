@@ -0,0 +1,73 @@
+package coreutils
+
+import (
+	"io"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(head))
+}
+
+var head = cli.App{
+	Name:      "head",
+	Usage:     "output the first part of files",
+	UsageText: `head [OPTION]... [FILE]...`,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:    "lines",
+			Aliases: []string{"n"},
+			Usage:   "print the first NUM lines",
+			Value:   10,
+		},
+		&cli.IntFlag{
+			Name:    "bytes",
+			Aliases: []string{"c"},
+			Usage:   "print the first NUM bytes; overrides -n",
+			Value:   -1,
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "never print headers giving file names",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		files := c.Args().Slice()
+		headers := len(files) > 1 && !c.Bool("quiet")
+
+		first := true
+		return eachInput(c, files, func(name string, r io.Reader) error {
+			if headers {
+				printHeader(c.App.Writer, name, first)
+			}
+			first = false
+
+			if n := c.Int("bytes"); n >= 0 {
+				_, err := io.CopyN(c.App.Writer, r, int64(n))
+				if err == io.EOF {
+					err = nil
+				}
+				return err
+			}
+
+			n := c.Int("lines")
+			if n <= 0 {
+				return nil
+			}
+
+			return readLines(r, func(line string) error {
+				if _, err := io.WriteString(c.App.Writer, line+"\n"); err != nil {
+					return err
+				}
+				if n--; n == 0 {
+					return io.EOF
+				}
+				return nil
+			})
+		})
+	},
+}
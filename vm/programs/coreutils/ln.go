@@ -0,0 +1,91 @@
+package coreutils
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+	"libdb.so/vm/rwfs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(ln))
+}
+
+var ln = cli.App{
+	Name:  "ln",
+	Usage: "make links between files",
+	Description: "None of the filesystems support hard links, so without -s, ln " +
+		"copies the target instead. Changes to either file are not reflected in " +
+		"the other.",
+	UsageText: `ln [OPTION]... TARGET... LINK_NAME`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "symbolic",
+			Aliases: []string{"s"},
+			Usage:   "make symbolic links instead of hard links",
+		},
+		&cli.BoolFlag{
+			Name:    "force",
+			Aliases: []string{"f"},
+			Usage:   "remove existing destination files",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		args := c.Args().Slice()
+		if len(args) < 2 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		dst := absPath(env, args[len(args)-1])
+		targets := args[:len(args)-1]
+
+		if len(targets) > 1 && !isDir(env, dst) {
+			return &fs.PathError{Op: "ln", Path: dst, Err: errors.New("target is not a directory")}
+		}
+
+		var failed bool
+		for _, arg := range targets {
+			target := absPath(env, arg)
+			link := targetPath(env, dst, target)
+
+			if _, err := rwfs.Lstat(env.Filesystem, link); err == nil {
+				if !c.Bool("force") {
+					log.Println("ln:", &fs.PathError{Op: "ln", Path: link, Err: fs.ErrExist})
+					failed = true
+					continue
+				}
+				if err := env.Filesystem.Remove(link); err != nil {
+					log.Println("ln:", err)
+					failed = true
+					continue
+				}
+			}
+
+			var err error
+			if c.Bool("symbolic") {
+				// The target is stored as given, so relative targets are
+				// relative to the link's directory.
+				err = rwfs.Symlink(env.Filesystem, arg, link)
+			} else {
+				err = copyAll(c.Context, env.Filesystem, link, target, false)
+			}
+			if err != nil {
+				log.Println("ln:", err)
+				failed = true
+			}
+		}
+
+		if failed {
+			return errors.New("failed to link one or more files")
+		}
+
+		return nil
+	},
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
-	"path"
+	pathpkg "path"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -20,6 +20,7 @@ import (
 	"libdb.so/vm/internal/cliprog"
 	"libdb.so/vm/internal/vmutil"
 	"libdb.so/vm/programs"
+	"libdb.so/vm/rwfs"
 )
 
 func init() {
@@ -65,16 +66,26 @@ var ls = cli.App{
 
 func ls_(c *cli.Context, arg string, multiple bool) error {
 	env := vm.EnvironmentFromContext(c.Context)
-	path := path.Join(env.Cwd, arg)
+	path := absPath(env, arg)
 
 	stat, err := fs.Stat(env.Filesystem, path)
 	if err != nil {
 		return errors.Wrap(err, "stat")
 	}
 
+	// Like GNU ls, long listings show symbolic links given as arguments
+	// rather than what they point to.
+	if c.Bool("long") {
+		if lstat, err := rwfs.Lstat(env.Filesystem, path); err == nil && lstat.Mode()&fs.ModeSymlink != 0 {
+			stat = lstat
+		}
+	}
+
 	var ents []fs.DirEntry
+	dir := pathpkg.Dir(path)
 
 	if stat.IsDir() {
+		dir = path
 		ents, err = fs.ReadDir(env.Filesystem, path)
 		if err != nil {
 			return errors.Wrap(err, "readdir")
@@ -132,9 +143,16 @@ func ls_(c *cli.Context, arg string, multiple bool) error {
 				size = s.Size()
 			}
 
+			name := printName(env, ent)
+			if ent.Type()&fs.ModeSymlink != 0 {
+				if target, err := rwfs.Readlink(env.Filesystem, pathpkg.Join(dir, ent.Name())); err == nil {
+					name += " -> " + target
+				}
+			}
+
 			fmt.Fprintf(w,
 				"%s\t%d\t%s\t%s\n",
-				printPerm(mode), size, printTime(modTime), printName(env, ent),
+				printPerm(mode), size, printTime(modTime), name,
 			)
 		}
 
@@ -150,7 +168,7 @@ func ls_(c *cli.Context, arg string, multiple bool) error {
 
 func printName(env vm.Environment, dirEntry fs.DirEntry) string {
 	name := dirEntry.Name()
-	if env.HasTerminal {
+	if env.IsTerminal(vm.Stdout) {
 		// TODO: skip either cd or cat if there is already input in the command
 		// prompt. This would require exposing *prompter in vm.Environment.
 		var cmd string
@@ -160,8 +178,11 @@ func printName(env vm.Environment, dirEntry fs.DirEntry) string {
 			cmd = "cat " + name
 		}
 		name = ansi.Link(name, vmutil.MakeTerminalWriteURI(cmd))
-		if dirEntry.IsDir() {
+		switch {
+		case dirEntry.IsDir():
 			return color.New(color.FgBlue, color.Bold).Sprint(name)
+		case dirEntry.Type()&fs.ModeSymlink != 0:
+			return color.New(color.FgCyan, color.Bold).Sprint(name)
 		}
 	}
 	return name
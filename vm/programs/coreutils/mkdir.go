@@ -0,0 +1,72 @@
+package coreutils
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(mkdir))
+}
+
+var mkdir = cli.App{
+	Name:      "mkdir",
+	Usage:     "make directories",
+	UsageText: `mkdir [OPTION]... DIRECTORY...`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "parents",
+			Aliases: []string{"p"},
+			Usage:   "no error if existing, make parent directories as needed",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "print a message for each created directory",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		if c.NArg() == 0 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		var failed bool
+		for _, arg := range c.Args().Slice() {
+			dir := absPath(env, arg)
+
+			var err error
+			if c.Bool("parents") {
+				err = env.Filesystem.MkdirAll(dir, env.CreatePerm(0777))
+			} else if !isDir(env, path.Dir(dir)) {
+				err = &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrNotExist}
+			} else {
+				err = env.Filesystem.Mkdir(dir, env.CreatePerm(0777))
+			}
+
+			if err != nil {
+				log.Println("mkdir:", err)
+				failed = true
+				continue
+			}
+
+			if c.Bool("verbose") {
+				env.Println("mkdir: created directory", arg)
+			}
+		}
+
+		if failed {
+			return errors.New("failed to create one or more directories")
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,110 @@
+package coreutils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+	"libdb.so/vm/rwfs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(mv))
+}
+
+var mv = cli.App{
+	Name:      "mv",
+	Usage:     "move (rename) files",
+	UsageText: `mv [OPTION]... SOURCE... DEST`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "no-clobber",
+			Aliases: []string{"n"},
+			Usage:   "do not overwrite an existing file",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "explain what is being done",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		args := c.Args().Slice()
+		if len(args) < 2 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		dst := absPath(env, args[len(args)-1])
+		srcs := args[:len(args)-1]
+
+		if len(srcs) > 1 && !isDir(env, dst) {
+			return &fs.PathError{Op: "mv", Path: dst, Err: errors.New("target is not a directory")}
+		}
+
+		var failed bool
+		for _, arg := range srcs {
+			src := absPath(env, arg)
+			target := targetPath(env, dst, src)
+
+			if target == src {
+				continue
+			}
+
+			if c.Bool("no-clobber") {
+				if _, err := fs.Stat(env.Filesystem, target); err == nil {
+					continue
+				}
+			}
+
+			if err := move(c, env, target, src); err != nil {
+				log.Println("mv:", err)
+				failed = true
+				continue
+			}
+
+			if c.Bool("verbose") {
+				env.Printf("renamed %q -> %q\n", src, target)
+			}
+		}
+
+		if failed {
+			return errors.New("failed to move one or more files")
+		}
+
+		return nil
+	},
+}
+
+// move renames src to dst. If the filesystem can't rename files, or if they're
+// on different mounts, then src is copied over and removed instead.
+func move(c *cli.Context, env vm.Environment, dst, src string) error {
+	err := rwfs.Rename(env.Filesystem, src, dst)
+	if !errors.Is(err, errors.ErrUnsupported) && !errors.Is(err, rwfs.ErrCrossDevice) {
+		return err
+	}
+
+	s, err := fs.Stat(env.Filesystem, src)
+	if err != nil {
+		return err
+	}
+
+	if s.IsDir() && isDir(env, dst) {
+		return fmt.Errorf("cannot overwrite directory %q", dst)
+	}
+
+	if err := copyAll(c.Context, env.Filesystem, dst, src, true); err != nil {
+		return err
+	}
+
+	if s.IsDir() {
+		return env.Filesystem.RemoveAll(src)
+	}
+	return env.Filesystem.Remove(src)
+}
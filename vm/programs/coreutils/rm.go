@@ -2,7 +2,6 @@ package coreutils
 
 import (
 	"errors"
-	"path"
 
 	"github.com/urfave/cli/v3"
 	"libdb.so/vm"
@@ -41,7 +40,7 @@ var rm = cli.App{
 
 		var failed bool
 		for _, arg := range c.Args().Slice() {
-			path := path.Join(env.Cwd, arg)
+			path := absPath(env, arg)
 
 			if err := rm(path); err != nil {
 				log.Println("rm:", err)
@@ -0,0 +1,86 @@
+package coreutils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(rmdir))
+}
+
+var rmdir = cli.App{
+	Name:      "rmdir",
+	Usage:     "remove empty directories",
+	UsageText: `rmdir [OPTION]... DIRECTORY...`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "parents",
+			Aliases: []string{"p"},
+			Usage:   "remove DIRECTORY and its ancestors",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		if c.NArg() == 0 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		var failed bool
+		for _, arg := range c.Args().Slice() {
+			dir := absPath(env, arg)
+
+			for {
+				if err := removeEmptyDir(env, dir); err != nil {
+					log.Println("rmdir:", err)
+					failed = true
+					break
+				}
+
+				if !c.Bool("parents") || path.Dir(arg) == "." || path.Dir(arg) == "/" {
+					break
+				}
+
+				arg = path.Dir(arg)
+				dir = path.Dir(dir)
+			}
+		}
+
+		if failed {
+			return errors.New("failed to remove one or more directories")
+		}
+
+		return nil
+	},
+}
+
+func removeEmptyDir(env vm.Environment, dir string) error {
+	s, err := fs.Stat(env.Filesystem, dir)
+	if err != nil {
+		return err
+	}
+
+	if !s.IsDir() {
+		return fmt.Errorf("failed to remove %q: not a directory", dir)
+	}
+
+	entries, err := fs.ReadDir(env.Filesystem, dir)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) > 0 {
+		return fmt.Errorf("failed to remove %q: directory not empty", dir)
+	}
+
+	return env.Filesystem.RemoveAll(dir)
+}
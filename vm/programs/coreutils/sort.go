@@ -0,0 +1,303 @@
+package coreutils
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(sort_))
+}
+
+var sort_ = cli.App{
+	Name:      "sort",
+	Usage:     "sort lines of text files",
+	UsageText: `sort [OPTION]... [FILE]...`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "numeric-sort",
+			Aliases: []string{"n"},
+			Usage:   "compare according to string numerical value",
+		},
+		&cli.BoolFlag{
+			Name:    "reverse",
+			Aliases: []string{"r"},
+			Usage:   "reverse the result of comparisons",
+		},
+		&cli.BoolFlag{
+			Name:    "ignore-case",
+			Aliases: []string{"f"},
+			Usage:   "fold lower case to upper case characters",
+		},
+		&cli.BoolFlag{
+			Name:    "unique",
+			Aliases: []string{"u"},
+			Usage:   "output only the first of an equal run",
+		},
+		&cli.StringSliceFlag{
+			Name:    "key",
+			Aliases: []string{"k"},
+			Usage:   "sort via a key; KEYDEF gives location and type, e.g. 2 or 2,3n",
+		},
+		&cli.StringFlag{
+			Name:    "field-separator",
+			Aliases: []string{"t"},
+			Usage:   "use SEP instead of non-blank to blank transition",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		global := sortOpts{
+			numeric: c.Bool("numeric-sort"),
+			reverse: c.Bool("reverse"),
+			fold:    c.Bool("ignore-case"),
+		}
+
+		var keys []sortKey
+		for _, def := range c.StringSlice("key") {
+			key, err := parseSortKey(def, global)
+			if err != nil {
+				return &vm.UsageError{Err: err, Usage: c.App.UsageText}
+			}
+			keys = append(keys, key)
+		}
+
+		sep := c.String("field-separator")
+		if len([]rune(sep)) > 1 {
+			return &vm.UsageError{
+				Err:   errors.New("the separator must be a single character"),
+				Usage: c.App.UsageText,
+			}
+		}
+
+		var lines []string
+		inputErr := eachInput(c, c.Args().Slice(), func(_ string, r io.Reader) error {
+			return readLines(r, func(line string) error {
+				lines = append(lines, line)
+				return nil
+			})
+		})
+		if inputErr != nil && inputErr != errInputFailed {
+			return inputErr
+		}
+
+		s := sorter{keys: keys, global: global, sep: sep}
+		slices.SortStableFunc(lines, s.compare)
+
+		if c.Bool("unique") {
+			lines = slices.CompactFunc(lines, func(a, b string) bool {
+				return s.compareKeys(a, b) == 0
+			})
+		}
+
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(c.App.Writer, line); err != nil {
+				return err
+			}
+		}
+
+		return inputErr
+	},
+}
+
+// sortOpts are the ordering options that apply to a key or to whole lines.
+type sortOpts struct {
+	numeric bool
+	reverse bool
+	fold    bool
+}
+
+// sortKey is a key given by -k. Fields are 1-indexed, and an end field of 0
+// means the end of the line.
+type sortKey struct {
+	start, end int
+	sortOpts
+}
+
+// parseSortKey parses a KEYDEF in the form of F[OPTS][,F[OPTS]], where OPTS is
+// any of the letters n, r and f. The options of both fields apply to the whole
+// key. Key options override the global ones, which otherwise apply.
+func parseSortKey(def string, global sortOpts) (sortKey, error) {
+	key := sortKey{sortOpts: global}
+
+	startStr, endStr, hasEnd := strings.Cut(def, ",")
+
+	var opts string
+	startStr, opts = splitKeyOpts(startStr)
+	if hasEnd {
+		var endOpts string
+		endStr, endOpts = splitKeyOpts(endStr)
+		opts += endOpts
+	}
+
+	if opts != "" {
+		key.sortOpts = sortOpts{}
+		for _, opt := range opts {
+			switch opt {
+			case 'n':
+				key.numeric = true
+			case 'r':
+				key.reverse = true
+			case 'f':
+				key.fold = true
+			default:
+				return key, errors.Errorf("invalid key option %q in %q", opt, def)
+			}
+		}
+	}
+
+	var err error
+	key.start, err = strconv.Atoi(startStr)
+	if err != nil || key.start < 1 {
+		return key, errors.Errorf("invalid key %q", def)
+	}
+
+	if hasEnd {
+		key.end, err = strconv.Atoi(endStr)
+		if err != nil || key.end < key.start {
+			return key, errors.Errorf("invalid key %q", def)
+		}
+	}
+
+	return key, nil
+}
+
+// splitKeyOpts splits a field of a KEYDEF into its number and its options.
+func splitKeyOpts(field string) (num, opts string) {
+	num = strings.TrimRightFunc(field, unicode.IsLetter)
+	return num, field[len(num):]
+}
+
+type sorter struct {
+	keys   []sortKey
+	global sortOpts
+	sep    string
+}
+
+// compare compares two lines using the keys. If the keys compare equal, then
+// the whole lines are compared as a last resort, like GNU sort.
+func (s sorter) compare(a, b string) int {
+	if cmp := s.compareKeys(a, b); cmp != 0 {
+		return cmp
+	}
+	cmp := strings.Compare(a, b)
+	if s.global.reverse {
+		cmp = -cmp
+	}
+	return cmp
+}
+
+// compareKeys compares two lines using only the keys, or the whole lines if
+// there are no keys.
+func (s sorter) compareKeys(a, b string) int {
+	if len(s.keys) == 0 {
+		return compareSortValues(a, b, s.global)
+	}
+	for _, key := range s.keys {
+		cmp := compareSortValues(s.field(a, key), s.field(b, key), key.sortOpts)
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// field returns the part of the line that the key covers.
+func (s sorter) field(line string, key sortKey) string {
+	fields := s.split(line)
+	if key.start > len(fields) {
+		return ""
+	}
+
+	end := len(fields)
+	if key.end != 0 && key.end < end {
+		end = key.end
+	}
+
+	// Fields keep their leading blanks when there's no separator, so joining
+	// them with the empty separator gives back the original text.
+	return strings.Join(fields[key.start-1:end], s.sep)
+}
+
+// split splits the line into fields. Without a separator, fields are separated
+// by the transition from non-blank to blank characters, and the blanks belong
+// to the following field.
+func (s sorter) split(line string) []string {
+	if s.sep != "" {
+		return strings.Split(line, s.sep)
+	}
+
+	var fields []string
+	var start int
+	var blank bool
+	for i, r := range line {
+		if unicode.IsSpace(r) && !blank && i > 0 {
+			fields = append(fields, line[start:i])
+			start = i
+		}
+		blank = unicode.IsSpace(r)
+	}
+	return append(fields, line[start:])
+}
+
+func compareSortValues(a, b string, opts sortOpts) int {
+	var cmp int
+	switch {
+	case opts.numeric:
+		cmp = compareNumeric(a, b)
+	case opts.fold:
+		cmp = strings.Compare(strings.ToUpper(a), strings.ToUpper(b))
+	default:
+		cmp = strings.Compare(a, b)
+	}
+	if opts.reverse {
+		cmp = -cmp
+	}
+	return cmp
+}
+
+// compareNumeric compares the leading numbers of a and b. Strings without a
+// leading number are treated as 0.
+func compareNumeric(a, b string) int {
+	x, y := leadingNumber(a), leadingNumber(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func leadingNumber(s string) float64 {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+
+	var end int
+loop:
+	for i, r := range s {
+		switch {
+		case r == '-' && i == 0:
+		case r == '.' && !strings.Contains(s[:i], "."):
+		case r >= '0' && r <= '9':
+		default:
+			break loop
+		}
+		end = i + 1
+	}
+
+	f, err := strconv.ParseFloat(s[:end], 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
@@ -0,0 +1,123 @@
+package coreutils
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestParseSortKey(t *testing.T) {
+	tests := []struct {
+		def    string
+		global sortOpts
+		key    sortKey
+		err    bool
+	}{
+		{def: "2", key: sortKey{start: 2}},
+		{def: "2,3", key: sortKey{start: 2, end: 3}},
+		{
+			def:    "2",
+			global: sortOpts{numeric: true, reverse: true},
+			key:    sortKey{start: 2, sortOpts: sortOpts{numeric: true, reverse: true}},
+		},
+		{
+			def:    "2,2n",
+			global: sortOpts{reverse: true},
+			key:    sortKey{start: 2, end: 2, sortOpts: sortOpts{numeric: true}},
+		},
+		{def: "2n,2", key: sortKey{start: 2, end: 2, sortOpts: sortOpts{numeric: true}}},
+		{def: "2n", key: sortKey{start: 2, sortOpts: sortOpts{numeric: true}}},
+		{def: "1r,3f", key: sortKey{start: 1, end: 3, sortOpts: sortOpts{reverse: true, fold: true}}},
+		{def: "0", err: true},
+		{def: "x", err: true},
+		{def: "3,2", err: true},
+		{def: "2,", err: true},
+		{def: "2z", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.def, func(t *testing.T) {
+			key, err := parseSortKey(test.def, test.global)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.key, key)
+		})
+	}
+}
+
+func TestSorterCompare(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     sorter
+		lines []string
+		want  []string
+	}{
+		{
+			name:  "lexical",
+			lines: []string{"b", "a", "B", "c"},
+			want:  []string{"B", "a", "b", "c"},
+		},
+		{
+			name:  "numeric",
+			s:     sorter{global: sortOpts{numeric: true}},
+			lines: []string{"10", "9", "-1", "x", "1.5"},
+			want:  []string{"-1", "x", "1.5", "9", "10"},
+		},
+		{
+			name:  "reverse",
+			s:     sorter{global: sortOpts{reverse: true}},
+			lines: []string{"a", "c", "b"},
+			want:  []string{"c", "b", "a"},
+		},
+		{
+			name:  "fold",
+			s:     sorter{global: sortOpts{fold: true}},
+			lines: []string{"b", "A", "a", "B"},
+			want:  []string{"A", "a", "B", "b"},
+		},
+		{
+			name: "numeric key",
+			s: sorter{keys: []sortKey{
+				{start: 2, end: 2, sortOpts: sortOpts{numeric: true}},
+			}},
+			lines: []string{"a 10", "b 9", "c 100"},
+			want:  []string{"b 9", "a 10", "c 100"},
+		},
+		{
+			name: "key with separator",
+			s: sorter{sep: ":", keys: []sortKey{
+				{start: 3, end: 3, sortOpts: sortOpts{numeric: true}},
+			}},
+			lines: []string{"root:x:0", "daemon:x:2", "bin:x:1"},
+			want:  []string{"root:x:0", "bin:x:1", "daemon:x:2"},
+		},
+		{
+			name: "ties fall back to whole lines",
+			s: sorter{keys: []sortKey{
+				{start: 2, end: 2},
+			}},
+			lines: []string{"b x", "a x", "c w"},
+			want:  []string{"c w", "a x", "b x"},
+		},
+		{
+			name: "missing fields",
+			s: sorter{keys: []sortKey{
+				{start: 3},
+			}},
+			lines: []string{"a b c", "a b"},
+			want:  []string{"a b", "a b c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := slices.Clone(test.lines)
+			slices.SortStableFunc(lines, test.s.compare)
+			assert.Equal(t, test.want, lines)
+		})
+	}
+}
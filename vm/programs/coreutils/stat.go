@@ -0,0 +1,126 @@
+package coreutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+	"libdb.so/vm/rwfs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(stat))
+}
+
+var stat = cli.App{
+	Name:      "stat",
+	Usage:     "display file or file system status",
+	UsageText: `stat [OPTION]... FILE...`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "dereference",
+			Aliases: []string{"L"},
+			Usage:   "follow links",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "use a JSON output format",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		if c.NArg() == 0 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		var stats []statEntry
+		var failed bool
+
+		for _, arg := range c.Args().Slice() {
+			file := absPath(env, arg)
+
+			lstat := rwfs.Lstat
+			if c.Bool("dereference") {
+				lstat = fs.Stat
+			}
+
+			s, err := lstat(env.Filesystem, file)
+			if err != nil {
+				log.Println("stat:", err)
+				failed = true
+				continue
+			}
+
+			var target string
+			if s.Mode()&fs.ModeSymlink != 0 {
+				target, _ = rwfs.Readlink(env.Filesystem, file)
+			}
+
+			stats = append(stats, statEntry{
+				Name:    arg,
+				Target:  target,
+				Size:    s.Size(),
+				Type:    fileTypeName(s.Mode()),
+				Mode:    s.Mode(),
+				Perm:    fmt.Sprintf("%04o", s.Mode().Perm()),
+				ModTime: s.ModTime(),
+			})
+		}
+
+		if c.Bool("json") {
+			enc := json.NewEncoder(c.App.Writer)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(stats); err != nil {
+				return err
+			}
+		} else {
+			for _, s := range stats {
+				if s.Target != "" {
+					fmt.Fprintf(c.App.Writer, "  File: %s -> %s\n", s.Name, s.Target)
+				} else {
+					fmt.Fprintf(c.App.Writer, "  File: %s\n", s.Name)
+				}
+				fmt.Fprintf(c.App.Writer, "  Size: %-12d Type: %s\n", s.Size, s.Type)
+				fmt.Fprintf(c.App.Writer, "Access: (%s/%s)\n", s.Perm, s.Mode)
+				fmt.Fprintf(c.App.Writer, "Modify: %s\n", s.ModTime.Format(time.RFC3339))
+			}
+		}
+
+		if failed {
+			return errors.New("failed to stat one or more files")
+		}
+
+		return nil
+	},
+}
+
+type statEntry struct {
+	Name    string      `json:"name"`
+	Target  string      `json:"target,omitempty"`
+	Size    int64       `json:"size"`
+	Type    string      `json:"type"`
+	Mode    fs.FileMode `json:"mode"`
+	Perm    string      `json:"perm"`
+	ModTime time.Time   `json:"mod_time"`
+}
+
+func fileTypeName(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symbolic link"
+	case mode.IsRegular():
+		return "regular file"
+	default:
+		return "special file"
+	}
+}
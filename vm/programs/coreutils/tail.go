@@ -0,0 +1,290 @@
+package coreutils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+	"libdb.so/vm/rwfs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(tail))
+}
+
+// tailFollowInterval is how often tail -f checks the files for new data if
+// the filesystem can't be watched.
+const tailFollowInterval = 500 * time.Millisecond
+
+var tail = cli.App{
+	Name:      "tail",
+	Usage:     "output the last part of files",
+	UsageText: `tail [OPTION]... [FILE]...`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "lines",
+			Aliases: []string{"n"},
+			Usage:   "output the last NUM lines, or use +NUM to output starting with line NUM",
+			Value:   "10",
+		},
+		&cli.StringFlag{
+			Name:    "bytes",
+			Aliases: []string{"c"},
+			Usage:   "output the last NUM bytes, or use +NUM to output starting with byte NUM; overrides -n",
+		},
+		&cli.BoolFlag{
+			Name:    "follow",
+			Aliases: []string{"f"},
+			Usage:   "output appended data as the files grow",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "never print headers giving file names",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		bytesMode := c.IsSet("bytes")
+
+		count := c.String("lines")
+		if bytesMode {
+			count = c.String("bytes")
+		}
+
+		fromStart := strings.HasPrefix(count, "+")
+		n, err := strconv.Atoi(strings.TrimPrefix(count, "+"))
+		if err != nil || n < 0 {
+			return &vm.UsageError{
+				Err:   errors.Errorf("invalid number %q", count),
+				Usage: c.App.UsageText,
+			}
+		}
+
+		files := c.Args().Slice()
+		headers := len(files) > 1 && !c.Bool("quiet")
+
+		// offsets keeps track of how much of each file has been read for
+		// following.
+		offsets := make(map[string]int64, len(files))
+
+		first := true
+		err = eachInput(c, files, func(name string, r io.Reader) error {
+			if headers {
+				printHeader(c.App.Writer, name, first)
+			}
+			first = false
+
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			offsets[name] = int64(len(data))
+
+			if bytesMode {
+				_, err = c.App.Writer.Write(tailBytes(data, n, fromStart))
+			} else {
+				_, err = c.App.Writer.Write(tailLines(data, n, fromStart))
+			}
+			return err
+		})
+		if err != nil || !c.Bool("follow") {
+			return err
+		}
+
+		f := tailFollower{
+			c:       c,
+			offsets: offsets,
+			headers: headers,
+		}
+		if len(files) > 0 {
+			f.last = files[len(files)-1]
+		}
+		return f.follow(files)
+	},
+}
+
+// tailBytes returns the last n bytes of data, or the bytes starting from the
+// nth byte if fromStart is true.
+func tailBytes(data []byte, n int, fromStart bool) []byte {
+	if fromStart {
+		if n > 0 {
+			n--
+		}
+		if n > len(data) {
+			return nil
+		}
+		return data[n:]
+	}
+	if n > len(data) {
+		return data
+	}
+	return data[len(data)-n:]
+}
+
+// tailLines returns the last n lines of data, or the lines starting from the
+// nth line if fromStart is true.
+func tailLines(data []byte, n int, fromStart bool) []byte {
+	if fromStart {
+		for i := 1; i < n && len(data) > 0; i++ {
+			j := bytes.IndexByte(data, '\n')
+			if j == -1 {
+				return nil
+			}
+			data = data[j+1:]
+		}
+		return data
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	// Skip the trailing newline, since it doesn't start a new line.
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			if n--; n == 0 {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
+
+// tailFollower follows the files given to tail -f.
+type tailFollower struct {
+	c       *cli.Context
+	offsets map[string]int64
+	headers bool
+	last    string // file whose data was last printed
+}
+
+// follow prints data appended to the given files until the context is
+// canceled. Standard input is not followed.
+func (f *tailFollower) follow(files []string) error {
+	var names []string
+	for _, name := range files {
+		if _, ok := f.offsets[name]; ok && name != "-" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(f.c.Context)
+	defer cancel()
+
+	// Wait for the files to change if the filesystem can tell us, otherwise
+	// check them every once in a while.
+	changed, err := f.watch(ctx, names)
+	var tick <-chan time.Time
+	if err != nil {
+		ticker := time.NewTicker(tailFollowInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+		case <-changed:
+		}
+
+		for _, name := range names {
+			if err := f.poll(name); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watch returns a channel that receives a value whenever any of the given
+// files changes. An error is returned if the filesystem can't watch them.
+func (f *tailFollower) watch(ctx context.Context, names []string) (<-chan struct{}, error) {
+	env := vm.EnvironmentFromContext(ctx)
+
+	changed := make(chan struct{}, 1)
+	for _, name := range names {
+		events, err := rwfs.Watch(ctx, env.Filesystem, absPath(env, name), false)
+		if err != nil {
+			return nil, err
+		}
+
+		go func() {
+			for range events {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+
+	return changed, nil
+}
+
+// poll prints the data appended to the file since it was last polled.
+func (f *tailFollower) poll(name string) error {
+	env := vm.EnvironmentFromContext(f.c.Context)
+	log := vm.LoggerFromContext(f.c.Context)
+
+	s, err := fs.Stat(env.Filesystem, absPath(env, name))
+	if err != nil {
+		// The file may be recreated later, so keep following it.
+		return nil
+	}
+
+	offset := f.offsets[name]
+	switch {
+	case s.Size() == offset:
+		return nil
+	case s.Size() < offset:
+		log.Printf("tail: %s: file truncated", name)
+		offset = 0
+	}
+
+	file, err := env.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, file, offset)
+	}
+	if err != nil {
+		return nil
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	if f.headers && f.last != name {
+		printHeader(f.c.App.Writer, name, false)
+		f.last = name
+	}
+
+	f.offsets[name] = offset + int64(len(data))
+	_, err = f.c.App.Writer.Write(data)
+	return err
+}
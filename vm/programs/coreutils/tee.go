@@ -0,0 +1,102 @@
+package coreutils
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+	"libdb.so/vm/rwfs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(tee))
+}
+
+var tee = cli.App{
+	Name:      "tee",
+	Usage:     "read from standard input and write to standard output and files",
+	UsageText: `tee [OPTION]... [FILE]...`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "append",
+			Aliases: []string{"a"},
+			Usage:   "append to the given FILEs, do not overwrite",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if c.Bool("append") {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+
+		writers := []io.Writer{c.App.Writer}
+		var files []rwfs.File
+		var failed bool
+
+		for _, arg := range c.Args().Slice() {
+			f, err := env.Filesystem.OpenFile(absPath(env, arg), flag, env.CreatePerm(0666))
+			if err != nil {
+				log.Println("tee:", err)
+				failed = true
+				continue
+			}
+			files = append(files, f)
+			writers = append(writers, f)
+		}
+
+		// Write to each output separately, so that one failing output doesn't
+		// stop the others, like GNU tee.
+		buf := make([]byte, 32*1024)
+		stdin := ctxReader{c.Context, env.Terminal.Stdin}
+		for {
+			n, err := stdin.Read(buf)
+			for i, w := range writers {
+				if w == nil || n == 0 {
+					continue
+				}
+				if _, werr := w.Write(buf[:n]); werr != nil {
+					if i == 0 {
+						// Stop if standard output is broken, like SIGPIPE.
+						closeAll(files)
+						return werr
+					}
+					log.Println("tee:", werr)
+					writers[i] = nil
+					failed = true
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					closeAll(files)
+					return err
+				}
+				break
+			}
+		}
+
+		for _, f := range files {
+			if err := f.Close(); err != nil {
+				log.Println("tee:", err)
+				failed = true
+			}
+		}
+
+		if failed {
+			return errors.New("failed to write to one or more files")
+		}
+		return nil
+	},
+}
+
+func closeAll(files []rwfs.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
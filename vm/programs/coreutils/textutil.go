@@ -0,0 +1,109 @@
+package coreutils
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+)
+
+// errInputFailed is returned when one or more of the inputs given to a text
+// processing program could not be read. The actual errors are logged.
+var errInputFailed = errors.New("failed to read one or more files")
+
+// eachInput calls fn for each of the given files in order. If no files are
+// given, then fn is called once for stdin. Like GNU coreutils, "-" also means
+// stdin. Files that can't be opened are logged and skipped, while an error
+// returned by fn stops the iteration.
+//
+// The readers given to fn stop with the context's error once it is canceled.
+func eachInput(c *cli.Context, files []string, fn func(name string, r io.Reader) error) error {
+	env := vm.EnvironmentFromContext(c.Context)
+	log := vm.LoggerFromContext(c.Context)
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	var failed bool
+	for _, file := range files {
+		if file == "-" {
+			if err := fn("-", ctxReader{c.Context, env.Terminal.Stdin}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		f, err := env.Open(file)
+		if err != nil {
+			log.Printf("%s: %v", c.App.Name, err)
+			failed = true
+			continue
+		}
+
+		err = fn(file, ctxReader{c.Context, f})
+		f.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if failed {
+		return errInputFailed
+	}
+
+	return c.Context.Err()
+}
+
+// ctxReader is a reader that stops reading once its context is canceled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
+
+// readLines calls fn for each line read from r. The line is given without its
+// trailing newline. fn may return io.EOF to stop reading early.
+func readLines(r io.Reader, fn func(line string) error) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if err := fn(strings.TrimSuffix(line, "\n")); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// printHeader prints the "==> name <==" header used by head and tail when
+// given multiple files.
+func printHeader(w io.Writer, name string, first bool) {
+	if name == "-" {
+		name = "standard input"
+	}
+	if !first {
+		io.WriteString(w, "\n")
+	}
+	io.WriteString(w, "==> "+name+" <==\n")
+}
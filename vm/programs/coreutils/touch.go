@@ -0,0 +1,168 @@
+package coreutils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(touch))
+}
+
+var touch = cli.App{
+	Name:      "touch",
+	Usage:     "change file timestamps, creating files that don't exist",
+	UsageText: `touch [OPTION]... FILE...`,
+	Description: "STRING for -d may be a date like 2006-01-02, a date and time like " +
+		"2006-01-02 15:04:05 or 2006-01-02T15:04:05Z07:00, or @SECONDS since the epoch.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "no-create",
+			Aliases: []string{"c"},
+			Usage:   "do not create any files",
+		},
+		&cli.StringFlag{
+			Name:    "date",
+			Aliases: []string{"d"},
+			Usage:   "parse STRING and use it instead of current time",
+		},
+		&cli.StringFlag{
+			Name:  "t",
+			Usage: "use [[CC]YY]MMDDhhmm[.ss] instead of current time",
+		},
+		&cli.StringFlag{
+			Name:    "reference",
+			Aliases: []string{"r"},
+			Usage:   "use this file's times instead of current time",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		if c.NArg() == 0 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		mtime := time.Now()
+		var err error
+		switch {
+		case c.IsSet("date"):
+			mtime, err = parseTouchDate(c.String("date"))
+		case c.IsSet("t"):
+			mtime, err = parseTouchStamp(c.String("t"))
+		case c.IsSet("reference"):
+			var s fs.FileInfo
+			s, err = fs.Stat(env.Filesystem, absPath(env, c.String("reference")))
+			if err == nil {
+				mtime = s.ModTime()
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		var failed bool
+		for _, arg := range c.Args().Slice() {
+			file := absPath(env, arg)
+
+			if c.Bool("no-create") {
+				if _, err := fs.Stat(env.Filesystem, file); errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+			}
+
+			if err := touchFile(env, file, mtime); err != nil {
+				log.Println("touch:", err)
+				failed = true
+			}
+		}
+
+		if failed {
+			return errors.New("failed to touch one or more files")
+		}
+
+		return nil
+	},
+}
+
+// touchDateLayouts are the layouts accepted by touch -d, in local time unless
+// they have a zone.
+var touchDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.UnixDate,
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+func parseTouchDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	switch {
+	case s == "now":
+		return time.Now(), nil
+	case strings.HasPrefix(s, "@"):
+		sec, err := strconv.ParseInt(s[1:], 10, 64)
+		if err == nil {
+			return time.Unix(sec, 0), nil
+		}
+	default:
+		for _, layout := range touchDateLayouts {
+			if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+				return t, nil
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date format %q", s)
+}
+
+// parseTouchStamp parses a time stamp for touch -t, which looks like
+// [[CC]YY]MMDDhhmm[.ss].
+func parseTouchStamp(s string) (time.Time, error) {
+	invalid := fmt.Errorf("invalid date format %q", s)
+
+	stamp, sec, hasSec := strings.Cut(s, ".")
+	if hasSec && len(sec) != 2 {
+		return time.Time{}, invalid
+	}
+	if !hasSec {
+		sec = "00"
+	}
+
+	switch len(stamp) {
+	case 8:
+		stamp = strconv.Itoa(time.Now().Year()) + stamp
+	case 10:
+		// Like POSIX, 69-99 are in the 1900s and 00-68 in the 2000s.
+		if stamp[:2] >= "69" {
+			stamp = "19" + stamp
+		} else {
+			stamp = "20" + stamp
+		}
+	case 12:
+	default:
+		return time.Time{}, invalid
+	}
+
+	t, err := time.ParseInLocation("200601021504.05", stamp+"."+sec, time.Local)
+	if err != nil {
+		return time.Time{}, invalid
+	}
+
+	return t, nil
+}
@@ -0,0 +1,286 @@
+package coreutils
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(tr))
+}
+
+var tr = cli.App{
+	Name:      "tr",
+	Usage:     "translate, squeeze, and/or delete characters",
+	UsageText: `tr [OPTION]... SET1 [SET2]`,
+	Description: "SETs are strings of characters. They may contain escapes like \\n, " +
+		"ranges like a-z and classes like [:upper:].",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "complement",
+			Aliases: []string{"c", "C"},
+			Usage:   "use the complement of SET1",
+		},
+		&cli.BoolFlag{
+			Name:    "delete",
+			Aliases: []string{"d"},
+			Usage:   "delete characters in SET1, do not translate",
+		},
+		&cli.BoolFlag{
+			Name:    "squeeze-repeats",
+			Aliases: []string{"s"},
+			Usage:   "replace each sequence of a repeated character that is listed in the last specified SET with a single occurrence of that character",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+
+		args := c.Args().Slice()
+		del := c.Bool("delete")
+		squeeze := c.Bool("squeeze-repeats")
+
+		var valid bool
+		switch {
+		case del && squeeze:
+			valid = len(args) == 2
+		case del:
+			valid = len(args) == 1
+		case squeeze:
+			valid = len(args) == 1 || len(args) == 2
+		default:
+			valid = len(args) == 2
+		}
+		if !valid {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		sets := make([][]rune, len(args))
+		for i, arg := range args {
+			set, err := parseTrSet(arg)
+			if err != nil {
+				return &vm.UsageError{Err: err, Usage: c.App.UsageText}
+			}
+			sets[i] = set
+		}
+
+		t := trTranslator{
+			set1:       newRuneSet(sets[0], c.Bool("complement")),
+			delete:     del,
+			translated: make(map[rune]rune),
+		}
+
+		if len(sets) == 2 && !del {
+			set2 := sets[1]
+			if len(set2) == 0 {
+				return &vm.UsageError{
+					Err:   errors.New("SET2 must be non-empty"),
+					Usage: c.App.UsageText,
+				}
+			}
+			if c.Bool("complement") {
+				// Everything not in SET1 becomes the last character of SET2.
+				t.fallback = set2[len(set2)-1]
+				t.hasFallback = true
+			} else {
+				for i, r := range sets[0] {
+					t.translated[r] = set2[min(i, len(set2)-1)]
+				}
+			}
+		}
+
+		if squeeze {
+			if len(sets) == 1 {
+				t.squeeze = t.set1
+			} else {
+				t.squeeze = newRuneSet(sets[1], false)
+			}
+		}
+
+		return t.translate(ctxReader{c.Context, env.Terminal.Stdin}, c.App.Writer)
+	},
+}
+
+type trTranslator struct {
+	set1        runeSet
+	squeeze     runeSet // nil if not squeezing
+	delete      bool
+	translated  map[rune]rune
+	fallback    rune
+	hasFallback bool
+}
+
+func (t trTranslator) translate(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+
+	var last rune = -1
+	for {
+		// Flush before blocking on more input, so interactive use works.
+		if br.Buffered() == 0 {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+		}
+
+		r, _, err := br.ReadRune()
+		if err != nil {
+			if err == io.EOF {
+				return bw.Flush()
+			}
+			return err
+		}
+
+		if t.set1.has(r) {
+			switch {
+			case t.delete:
+				continue
+			case t.hasFallback:
+				r = t.fallback
+			default:
+				if to, ok := t.translated[r]; ok {
+					r = to
+				}
+			}
+		}
+
+		if t.squeeze != nil && r == last && t.squeeze.has(r) {
+			continue
+		}
+		last = r
+
+		if _, err := bw.WriteRune(r); err != nil {
+			return err
+		}
+	}
+}
+
+// runeSet is a set of runes that may be complemented.
+type runeSet map[rune]bool
+
+// complementKey is the key in a runeSet that marks it as complemented. It is
+// not a valid rune, so it can't be in the set otherwise.
+const complementKey rune = -1
+
+func newRuneSet(runes []rune, complement bool) runeSet {
+	set := make(runeSet, len(runes)+1)
+	for _, r := range runes {
+		set[r] = true
+	}
+	if complement {
+		set[complementKey] = true
+	}
+	return set
+}
+
+func (s runeSet) has(r rune) bool {
+	return s[r] != s[complementKey]
+}
+
+// trClasses are the character classes supported in SETs.
+var trClasses = map[string]func(rune) bool{
+	"alnum":  func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+	"alpha":  unicode.IsLetter,
+	"blank":  func(r rune) bool { return r == ' ' || r == '\t' },
+	"cntrl":  unicode.IsControl,
+	"digit":  unicode.IsDigit,
+	"graph":  func(r rune) bool { return unicode.IsGraphic(r) && !unicode.IsSpace(r) },
+	"lower":  unicode.IsLower,
+	"print":  unicode.IsPrint,
+	"punct":  unicode.IsPunct,
+	"space":  unicode.IsSpace,
+	"upper":  unicode.IsUpper,
+	"xdigit": func(r rune) bool { return strings.ContainsRune("0123456789abcdefABCDEF", r) },
+}
+
+// parseTrSet expands a SET into its characters. Classes only cover ASCII, so
+// that [:lower:] and [:upper:] line up when translating.
+func parseTrSet(s string) ([]rune, error) {
+	var chars []rune
+
+	src := []rune(s)
+	for i := 0; i < len(src); i++ {
+		if src[i] == '[' && i+1 < len(src) && src[i+1] == ':' {
+			end := strings.Index(string(src[i+2:]), ":]")
+			if end >= 0 {
+				name := string(src[i+2:])[:end]
+				class, ok := trClasses[name]
+				if !ok {
+					return nil, errors.Errorf("invalid character class %q", name)
+				}
+				for r := rune(0); r < 128; r++ {
+					if class(r) {
+						chars = append(chars, r)
+					}
+				}
+				i += 2 + len([]rune(name)) + 1
+				continue
+			}
+		}
+
+		r, n := trChar(src[i:])
+		i += n - 1
+
+		// Ranges like a-z.
+		if i+2 < len(src) && src[i+1] == '-' {
+			to, m := trChar(src[i+2:])
+			if to < r {
+				return nil, errors.Errorf("range-endpoints of '%c-%c' are in reverse collating sequence order", r, to)
+			}
+			for c := r; c <= to; c++ {
+				chars = append(chars, c)
+			}
+			i += 1 + m
+			continue
+		}
+
+		chars = append(chars, r)
+	}
+
+	return chars, nil
+}
+
+// trChar parses a single, possibly escaped, character at the start of src. It
+// returns the character and the number of runes it took up.
+func trChar(src []rune) (rune, int) {
+	if src[0] != '\\' || len(src) == 1 {
+		return src[0], 1
+	}
+
+	switch src[1] {
+	case 'n':
+		return '\n', 2
+	case 't':
+		return '\t', 2
+	case 'r':
+		return '\r', 2
+	case 'a':
+		return '\a', 2
+	case 'b':
+		return '\b', 2
+	case 'f':
+		return '\f', 2
+	case 'v':
+		return '\v', 2
+	}
+
+	// Octal escapes like \012.
+	n := 1
+	for n < len(src) && n < 4 && src[n] >= '0' && src[n] <= '7' {
+		n++
+	}
+	if n > 1 {
+		v, _ := strconv.ParseUint(string(src[1:n]), 8, 8)
+		return rune(v), n
+	}
+
+	return src[1], 2
+}
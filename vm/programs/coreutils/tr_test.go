@@ -0,0 +1,52 @@
+package coreutils
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestParseTrSet(t *testing.T) {
+	tests := []struct {
+		set  string
+		want string
+		err  bool
+	}{
+		{set: "abc", want: "abc"},
+		{set: "a-e", want: "abcde"},
+		{set: "a-c0-2", want: "abc012"},
+		{set: "-a", want: "-a"},
+		{set: "a-", want: "a-"},
+		{set: `\n\t\\`, want: "\n\t\\"},
+		{set: `\101\60`, want: "A0"},
+		{set: `\141-\143`, want: "abc"},
+		{set: "[:digit:]", want: "0123456789"},
+		{set: "[:xdigit:]", want: "0123456789ABCDEFabcdef"},
+		{set: "x[:blank:]y", want: "x\t y"},
+		{set: "[:upper", want: "[:upper"},
+		{set: "z-a", err: true},
+		{set: "[:nope:]", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.set, func(t *testing.T) {
+			set, err := parseTrSet(test.set)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, string(set))
+		})
+	}
+}
+
+func TestRuneSet(t *testing.T) {
+	set := newRuneSet([]rune("ab"), false)
+	assert.True(t, set.has('a'))
+	assert.False(t, set.has('c'))
+
+	set = newRuneSet([]rune("ab"), true)
+	assert.False(t, set.has('a'))
+	assert.True(t, set.has('c'))
+}
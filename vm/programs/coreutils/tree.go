@@ -0,0 +1,191 @@
+package coreutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(tree))
+}
+
+var tree = cli.App{
+	Name:      "tree",
+	Usage:     "list contents of directories in a tree-like format",
+	UsageText: `tree [OPTION]... [DIRECTORY]...`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "all",
+			Aliases: []string{"a"},
+			Usage:   "do not ignore entries starting with .",
+		},
+		&cli.BoolFlag{
+			Name:    "dirs-only",
+			Aliases: []string{"d"},
+			Usage:   "list directories only",
+		},
+		&cli.IntFlag{
+			Name:    "level",
+			Aliases: []string{"L"},
+			Usage:   "descend only LEVEL directories deep",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "use a JSON output format",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+
+		args := c.Args().Slice()
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+
+		t := treeWalker{
+			c:   c,
+			env: env,
+		}
+
+		var roots []*treeNode
+		for _, arg := range args {
+			root, err := t.walk(absPath(env, arg), arg, 0)
+			if err != nil {
+				return err
+			}
+			roots = append(roots, root)
+		}
+
+		if c.Bool("json") {
+			enc := json.NewEncoder(c.App.Writer)
+			enc.SetIndent("", "  ")
+			return enc.Encode(roots)
+		}
+
+		for _, root := range roots {
+			t.print(c.App.Writer, root, "")
+		}
+
+		fmt.Fprintf(c.App.Writer, "\n%s", plural(t.dirs, "directory", "directories"))
+		if !c.Bool("dirs-only") {
+			fmt.Fprintf(c.App.Writer, ", %s", plural(t.files, "file", "files"))
+		}
+		fmt.Fprintln(c.App.Writer)
+
+		if t.failed {
+			return errors.New("failed to read one or more directories")
+		}
+
+		return nil
+	},
+}
+
+type treeNode struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Contents []*treeNode `json:"contents,omitempty"`
+}
+
+type treeWalker struct {
+	c      *cli.Context
+	env    vm.Environment
+	dirs   int
+	files  int
+	failed bool
+}
+
+func (t *treeWalker) walk(p, name string, depth int) (*treeNode, error) {
+	if err := t.c.Context.Err(); err != nil {
+		return nil, err
+	}
+
+	s, err := fs.Stat(t.env.Filesystem, p)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &treeNode{
+		Name: name,
+		Type: "file",
+	}
+
+	if !s.IsDir() {
+		return node, nil
+	}
+
+	node.Type = "directory"
+
+	if level := t.c.Int("level"); level > 0 && depth >= level {
+		return node, nil
+	}
+
+	entries, err := fs.ReadDir(t.env.Filesystem, p)
+	if err != nil {
+		vm.LoggerFromContext(t.c.Context).Println("tree:", err)
+		t.failed = true
+		return node, nil
+	}
+
+	for _, entry := range entries {
+		if !t.c.Bool("all") && strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		if entry.IsDir() {
+			t.dirs++
+		} else if t.c.Bool("dirs-only") {
+			continue
+		} else {
+			t.files++
+		}
+
+		child, err := t.walk(path.Join(p, entry.Name()), entry.Name(), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		node.Contents = append(node.Contents, child)
+	}
+
+	return node, nil
+}
+
+func (t *treeWalker) print(w io.Writer, node *treeNode, indent string) {
+	if indent == "" {
+		fmt.Fprintln(w, t.name(node))
+	}
+
+	for i, child := range node.Contents {
+		branch, next := "├── ", "│   "
+		if i == len(node.Contents)-1 {
+			branch, next = "└── ", "    "
+		}
+
+		fmt.Fprintln(w, indent+branch+t.name(child))
+		t.print(w, child, indent+next)
+	}
+}
+
+func (t *treeWalker) name(node *treeNode) string {
+	if node.Type == "directory" && t.env.IsTerminal(vm.Stdout) {
+		return color.New(color.FgBlue, color.Bold).Sprint(node.Name)
+	}
+	return node.Name
+}
+
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
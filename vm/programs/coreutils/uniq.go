@@ -0,0 +1,95 @@
+package coreutils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(uniq))
+}
+
+var uniq = cli.App{
+	Name:      "uniq",
+	Usage:     "report or omit repeated lines",
+	UsageText: `uniq [OPTION]... [INPUT]`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "count",
+			Aliases: []string{"c"},
+			Usage:   "prefix lines by the number of occurrences",
+		},
+		&cli.BoolFlag{
+			Name:    "repeated",
+			Aliases: []string{"d"},
+			Usage:   "only print duplicate lines, one for each group",
+		},
+		&cli.BoolFlag{
+			Name:    "unique",
+			Aliases: []string{"u"},
+			Usage:   "only print unique lines",
+		},
+		&cli.BoolFlag{
+			Name:    "ignore-case",
+			Aliases: []string{"i"},
+			Usage:   "ignore differences in case when comparing",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() > 1 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		equal := func(a, b string) bool { return a == b }
+		if c.Bool("ignore-case") {
+			equal = strings.EqualFold
+		}
+
+		var prev string
+		var count int
+
+		flush := func() error {
+			if count == 0 {
+				return nil
+			}
+			if c.Bool("repeated") && count == 1 {
+				return nil
+			}
+			if c.Bool("unique") && count > 1 {
+				return nil
+			}
+			if c.Bool("count") {
+				_, err := fmt.Fprintf(c.App.Writer, "%7d %s\n", count, prev)
+				return err
+			}
+			_, err := fmt.Fprintln(c.App.Writer, prev)
+			return err
+		}
+
+		err := eachInput(c, c.Args().Slice(), func(_ string, r io.Reader) error {
+			return readLines(r, func(line string) error {
+				if count > 0 && equal(prev, line) {
+					count++
+					return nil
+				}
+				if err := flush(); err != nil {
+					return err
+				}
+				prev = line
+				count = 1
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		return flush()
+	},
+}
@@ -0,0 +1,149 @@
+package coreutils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(wc))
+}
+
+var wc = cli.App{
+	Name:      "wc",
+	Usage:     "print newline, word, and byte counts for each file",
+	UsageText: `wc [OPTION]... [FILE]...`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "lines",
+			Aliases: []string{"l"},
+			Usage:   "print the newline counts",
+		},
+		&cli.BoolFlag{
+			Name:    "words",
+			Aliases: []string{"w"},
+			Usage:   "print the word counts",
+		},
+		&cli.BoolFlag{
+			Name:    "chars",
+			Aliases: []string{"m"},
+			Usage:   "print the character counts",
+		},
+		&cli.BoolFlag{
+			Name:    "bytes",
+			Aliases: []string{"c"},
+			Usage:   "print the byte counts",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		show := [4]bool{
+			c.Bool("lines"),
+			c.Bool("words"),
+			c.Bool("chars"),
+			c.Bool("bytes"),
+		}
+		if show == [4]bool{} {
+			show = [4]bool{true, true, false, true}
+		}
+
+		type result struct {
+			name   string
+			counts [4]int64
+		}
+
+		var results []result
+		var total [4]int64
+
+		files := c.Args().Slice()
+		inputErr := eachInput(c, files, func(name string, r io.Reader) error {
+			counts, err := wcCount(r)
+			if err != nil {
+				return err
+			}
+			for i := range total {
+				total[i] += counts[i]
+			}
+			if len(files) == 0 {
+				name = ""
+			}
+			results = append(results, result{name, counts})
+			return nil
+		})
+		if inputErr != nil && inputErr != errInputFailed {
+			return inputErr
+		}
+
+		if len(results) > 1 {
+			results = append(results, result{"total", total})
+		}
+
+		// Align all columns to the widest number.
+		var width int
+		var columns int
+		for i, counts := range total {
+			if show[i] {
+				width = max(width, len(fmt.Sprint(counts)))
+				columns++
+			}
+		}
+		if columns == 1 && len(results) == 1 {
+			width = 0
+		}
+
+		for _, result := range results {
+			var fields []string
+			for i, n := range result.counts {
+				if show[i] {
+					fields = append(fields, fmt.Sprintf("%*d", width, n))
+				}
+			}
+			if result.name != "" {
+				fields = append(fields, result.name)
+			}
+			fmt.Fprintln(c.App.Writer, strings.Join(fields, " "))
+		}
+
+		return inputErr
+	},
+}
+
+// wcCount counts the lines, words, characters and bytes in r, in that order.
+func wcCount(r io.Reader) ([4]int64, error) {
+	var counts [4]int64
+	var inWord bool
+
+	br := bufio.NewReader(r)
+	for {
+		r, size, err := br.ReadRune()
+		if err != nil {
+			if err == io.EOF {
+				return counts, nil
+			}
+			return counts, err
+		}
+
+		counts[3] += int64(size)
+		if r != utf8.RuneError || size > 1 {
+			counts[2]++
+		}
+
+		if r == '\n' {
+			counts[0]++
+		}
+
+		if unicode.IsSpace(r) {
+			inWord = false
+		} else if !inWord {
+			inWord = true
+			counts[1]++
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package coreutils
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(xargs))
+}
+
+var xargs = cli.App{
+	Name:      "xargs",
+	Usage:     "build and execute command lines from standard input",
+	UsageText: `xargs [OPTION]... [COMMAND [INITIAL-ARGS]...]`,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:    "max-args",
+			Aliases: []string{"n"},
+			Usage:   "use at most MAX-ARGS arguments per command line",
+		},
+		&cli.BoolFlag{
+			Name:    "null",
+			Aliases: []string{"0"},
+			Usage:   "items are separated by a null, not whitespace",
+		},
+		&cli.StringFlag{
+			Name:    "delimiter",
+			Aliases: []string{"d"},
+			Usage:   "items are separated by DELIM, not whitespace",
+		},
+		&cli.StringFlag{
+			Name:    "replace",
+			Aliases: []string{"I"},
+			Usage:   "replace R in INITIAL-ARGS with names read from standard input; implies one line per command",
+		},
+		&cli.BoolFlag{
+			Name:    "no-run-if-empty",
+			Aliases: []string{"r"},
+			Usage:   "if there are no arguments, then do not run COMMAND",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"t"},
+			Usage:   "print commands before executing them",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		command := c.Args().Slice()
+		if len(command) == 0 {
+			command = []string{"echo"}
+		}
+
+		if c.Int("max-args") < 0 {
+			return &vm.UsageError{
+				Err:   errors.New("value for -n must be positive"),
+				Usage: c.App.UsageText,
+			}
+		}
+
+		stdin := ctxReader{c.Context, env.Terminal.Stdin}
+
+		var items []string
+		var err error
+		switch {
+		case c.IsSet("replace"):
+			items, err = splitXargsLines(stdin)
+		case c.Bool("null"):
+			items, err = splitXargsDelim(stdin, "\x00")
+		case c.IsSet("delimiter"):
+			delim := []rune(c.String("delimiter"))
+			if len(delim) == 0 {
+				return &vm.UsageError{
+					Err:   errors.New("the delimiter must not be empty"),
+					Usage: c.App.UsageText,
+				}
+			}
+			// Allow escapes like \n, like tr.
+			d, _ := trChar(delim)
+			items, err = splitXargsDelim(stdin, string(d))
+		default:
+			items, err = splitXargsWords(stdin)
+		}
+		if err != nil {
+			return err
+		}
+
+		var commands [][]string
+		switch {
+		case c.IsSet("replace"):
+			for _, item := range items {
+				argv := make([]string, len(command))
+				for i, arg := range command {
+					argv[i] = strings.ReplaceAll(arg, c.String("replace"), item)
+				}
+				commands = append(commands, argv)
+			}
+		case len(items) == 0:
+			if !c.Bool("no-run-if-empty") {
+				commands = append(commands, command)
+			}
+		default:
+			n := c.Int("max-args")
+			if n == 0 {
+				n = len(items)
+			}
+			for len(items) > 0 {
+				chunk := items[:min(n, len(items))]
+				items = items[len(chunk):]
+				commands = append(commands, append(append([]string(nil), command...), chunk...))
+			}
+		}
+
+		// Commands get an empty stdin, since we've already consumed ours.
+		env.Terminal.Stdin = io.NopCloser(strings.NewReader(""))
+
+		var failed bool
+		for _, argv := range commands {
+			if err := c.Context.Err(); err != nil {
+				return err
+			}
+
+			if c.Bool("verbose") {
+				log.Println(strings.Join(argv, " "))
+			}
+
+			err := env.Execute(c.Context, env, argv...)
+			switch {
+			case err == nil:
+			case vm.ErrorIsUnknownProgram(err):
+				return vm.WrapError(127, err)
+			case errors.Is(err, vm.ErrBrokenPipe), c.Context.Err() != nil:
+				return err
+			default:
+				failed = true
+			}
+		}
+
+		if failed {
+			// Like GNU xargs, exit with 123 if any command failed. The
+			// command already reported its own error.
+			return vm.ExitStatus(123)
+		}
+		return nil
+	},
+}
+
+// splitXargsWords splits the input into items separated by blanks and
+// newlines. Items may be quoted using single or double quotes, and a
+// backslash escapes the next character.
+func splitXargsWords(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []string
+	var item strings.Builder
+	var inItem bool
+	var quote rune
+
+	src := []rune(string(data))
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+				continue
+			}
+			if ch == '\n' {
+				return nil, errors.Errorf("unmatched %s quote", xargsQuoteName(quote))
+			}
+			item.WriteRune(ch)
+		case ch == '\'' || ch == '"':
+			quote = ch
+			inItem = true
+		case ch == '\\' && i+1 < len(src):
+			i++
+			item.WriteRune(src[i])
+			inItem = true
+		case unicode.IsSpace(ch):
+			if inItem {
+				items = append(items, item.String())
+				item.Reset()
+				inItem = false
+			}
+		default:
+			item.WriteRune(ch)
+			inItem = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.Errorf("unmatched %s quote", xargsQuoteName(quote))
+	}
+	if inItem {
+		items = append(items, item.String())
+	}
+
+	return items, nil
+}
+
+func xargsQuoteName(quote rune) string {
+	if quote == '"' {
+		return "double"
+	}
+	return "single"
+}
+
+// splitXargsDelim splits the input into items separated by delim. Quotes and
+// backslashes are not special.
+func splitXargsDelim(r io.Reader, delim string) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	items := strings.Split(string(data), delim)
+	if items[len(items)-1] == "" {
+		items = items[:len(items)-1]
+	}
+	return items, nil
+}
+
+// splitXargsLines splits the input into lines for -I. Leading blanks are
+// ignored, and blank lines are skipped.
+func splitXargsLines(r io.Reader) ([]string, error) {
+	var items []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimLeftFunc(scanner.Text(), unicode.IsSpace)
+		if line != "" {
+			items = append(items, line)
+		}
+	}
+
+	return items, scanner.Err()
+}
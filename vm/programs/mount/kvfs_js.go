@@ -0,0 +1,23 @@
+package mount
+
+import (
+	"context"
+
+	"libdb.so/vm"
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/kvfs"
+)
+
+func init() {
+	RegisterType("kvfs", Type{New: newKVFS})
+}
+
+// newKVFS mounts the IndexedDB database named by source, creating it if
+// needed.
+func newKVFS(ctx context.Context, env vm.Environment, source string) (rwfs.FS, error) {
+	store, err := kvfs.IndexedDBStorage(source)
+	if err != nil {
+		return nil, err
+	}
+	return kvfs.New(store), nil
+}
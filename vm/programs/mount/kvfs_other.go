@@ -0,0 +1,49 @@
+//go:build !js
+
+package mount
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"libdb.so/vm"
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/kvfs"
+)
+
+// DiskKVFS returns the kvfs type, whose filesystems are on-disk stores kept in
+// the host directory dir. The source given to mount is the name of the store
+// within dir, so that only files in dir can be opened. The type isn't
+// registered by default, since there is no directory to put stores in.
+func DiskKVFS(dir string) Type {
+	return Type{New: func(ctx context.Context, env vm.Environment, source string) (rwfs.FS, error) {
+		return newKVFS(dir, source)
+	}}
+}
+
+// newKVFS mounts the on-disk store named by source in dir, creating it if
+// needed. The store is closed when it's unmounted.
+func newKVFS(dir, source string) (rwfs.FS, error) {
+	if !filepath.IsLocal(source) || strings.ContainsAny(source, `/\`) {
+		return nil, fmt.Errorf("invalid store name %q", source)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	store, err := kvfs.OpenDiskStorage(filepath.Join(dir, source))
+	if err != nil {
+		return nil, err
+	}
+	return closingFS{kvfs.New(store), store}, nil
+}
+
+type closingFS struct {
+	*kvfs.FS
+	io.Closer
+}
@@ -0,0 +1,166 @@
+// Package mount provides the mount and umount programs, which change the
+// mounts of the environment's filesystem if it is an rwfs.Namespace.
+package mount
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"libdb.so/vm"
+	"libdb.so/vm/internal/cliprog"
+	"libdb.so/vm/programs"
+	"libdb.so/vm/rwfs"
+)
+
+func init() {
+	programs.Register(cliprog.Wrap(mount))
+	programs.Register(cliprog.Wrap(umount))
+}
+
+var errNoNamespace = errors.New("the filesystem doesn't support mounts")
+
+var mount = cli.App{
+	Name:  "mount",
+	Usage: "mount a filesystem",
+	UsageText: `mount
+mount -t TYPE [-o OPTIONS] SOURCE DIRECTORY`,
+	Description: "Without arguments, the mounted filesystems are listed. " +
+		"The only options are ro and rw. Run mount --list-types for the list of types.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "types",
+			Aliases: []string{"t"},
+			Usage:   "the type of the filesystem",
+		},
+		&cli.StringSliceFlag{
+			Name:    "options",
+			Aliases: []string{"o"},
+			Usage:   "comma-separated mount options",
+		},
+		&cli.BoolFlag{
+			Name:  "list-types",
+			Usage: "list the types of filesystems that can be mounted",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+
+		if c.Bool("list-types") {
+			for _, name := range typeNames() {
+				fmt.Fprintln(c.App.Writer, name)
+			}
+			return nil
+		}
+
+		ns, ok := env.Filesystem.(*rwfs.Namespace)
+		if !ok {
+			return errNoNamespace
+		}
+
+		if c.NArg() == 0 {
+			for _, m := range ns.Mounts() {
+				fmt.Fprintf(c.App.Writer, "%s on %s type %s (%s)\n",
+					mountSource(m), m.Point, m.Type, strings.Join(mountOptions(m), ","))
+			}
+			return nil
+		}
+
+		if c.NArg() != 2 || c.String("types") == "" {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		typeName := c.String("types")
+		source := c.Args().Get(0)
+		point := absPath(env, c.Args().Get(1))
+
+		t, ok := lookupType(typeName)
+		if !ok {
+			return fmt.Errorf("unknown filesystem type %q", typeName)
+		}
+
+		readOnly := t.ReadOnly
+		for _, opt := range parseOptions(c.StringSlice("options")) {
+			switch opt {
+			case "ro":
+				readOnly = true
+			case "rw":
+				if t.ReadOnly {
+					return fmt.Errorf("%s filesystems are read-only", typeName)
+				}
+				readOnly = false
+			default:
+				return fmt.Errorf("unknown option %q", opt)
+			}
+		}
+
+		fsys, err := t.New(c.Context, env, source)
+		if err != nil {
+			return errors.Wrapf(err, "cannot mount %s", source)
+		}
+
+		opts := []string{"rw"}
+		if readOnly {
+			opts = []string{"ro"}
+			if !t.ReadOnly {
+				fsys = rwfs.ReadOnlyFS(fsys)
+			}
+		}
+
+		return ns.Mount(rwfs.Mount{
+			Point:   point,
+			FS:      fsys,
+			Type:    typeName,
+			Source:  source,
+			Options: opts,
+		})
+	},
+}
+
+var umount = cli.App{
+	Name:      "umount",
+	Usage:     "unmount filesystems",
+	UsageText: `umount DIRECTORY...`,
+	Action: func(c *cli.Context) error {
+		env := vm.EnvironmentFromContext(c.Context)
+		log := vm.LoggerFromContext(c.Context)
+
+		if c.NArg() == 0 {
+			return &vm.UsageError{Usage: c.App.UsageText}
+		}
+
+		ns, ok := env.Filesystem.(*rwfs.Namespace)
+		if !ok {
+			return errNoNamespace
+		}
+
+		var failed bool
+		for _, arg := range c.Args().Slice() {
+			if err := ns.Unmount(absPath(env, arg)); err != nil {
+				log.Println("umount:", err)
+				failed = true
+			}
+		}
+
+		if failed {
+			return errors.New("failed to unmount one or more filesystems")
+		}
+
+		return nil
+	},
+}
+
+func mountSource(m rwfs.Mount) string {
+	if m.Source == "" {
+		return "none"
+	}
+	return m.Source
+}
+
+func mountOptions(m rwfs.Mount) []string {
+	if len(m.Options) == 0 {
+		return []string{"rw"}
+	}
+	return m.Options
+}
@@ -0,0 +1,187 @@
+package mount
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"libdb.so/vm"
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/archivefs"
+	"libdb.so/vm/rwfs/httpfs"
+	"libdb.so/vm/rwfs/kvfs"
+)
+
+// Type is a type of filesystem that mount can mount.
+type Type struct {
+	// ReadOnly is true if filesystems of this type can't be written to.
+	ReadOnly bool
+	// New makes a filesystem from the source given to mount.
+	New func(ctx context.Context, env vm.Environment, source string) (rwfs.FS, error)
+}
+
+var (
+	typesMu sync.RWMutex
+	types   = map[string]Type{}
+)
+
+// RegisterType registers a filesystem type under the given name, replacing
+// any existing one.
+func RegisterType(name string, t Type) {
+	typesMu.Lock()
+	defer typesMu.Unlock()
+
+	types[name] = t
+}
+
+// lookupType returns the filesystem type with the given name.
+func lookupType(name string) (Type, bool) {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+
+	t, ok := types[name]
+	return t, ok
+}
+
+// typeNames returns the names of all registered types in alphabetical order.
+func typeNames() []string {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterType("tmpfs", Type{New: newTmpFS})
+	RegisterType("httpfs", Type{ReadOnly: true, New: newHTTPFS})
+	RegisterType("zip", Type{ReadOnly: true, New: newArchiveFS})
+	RegisterType("tar", Type{ReadOnly: true, New: newArchiveFS})
+	RegisterType("proc", Type{ReadOnly: true, New: newProcFS})
+}
+
+// TmpFS returns a new empty in-memory filesystem.
+func TmpFS() rwfs.FS {
+	return kvfs.New(kvfs.MemoryStorage())
+}
+
+func newTmpFS(ctx context.Context, env vm.Environment, source string) (rwfs.FS, error) {
+	return TmpFS(), nil
+}
+
+// ProcFS returns the filesystem usually mounted on /proc. Its mounts file
+// lists the mounts of ns.
+func ProcFS(ns *rwfs.Namespace) rwfs.FS {
+	return rwfs.ProcFS(map[string]func() []byte{
+		"mounts": func() []byte {
+			var b bytes.Buffer
+			for _, m := range ns.Mounts() {
+				fmt.Fprintln(&b, m)
+			}
+			return b.Bytes()
+		},
+	})
+}
+
+func newProcFS(ctx context.Context, env vm.Environment, source string) (rwfs.FS, error) {
+	ns, ok := env.Filesystem.(*rwfs.Namespace)
+	if !ok {
+		return nil, errNoNamespace
+	}
+	return ProcFS(ns), nil
+}
+
+// httpFSCacheSize is the number of bytes of files that each mounted httpfs
+// keeps in memory.
+const httpFSCacheSize = 8 << 20
+
+// newHTTPFS mounts the files of the httpfs manifest at the source URL. The
+// manifest's base is relative to its URL.
+func newHTTPFS(ctx context.Context, env vm.Environment, source string) (rwfs.FS, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("cannot fetch manifest: unexpected status %q", resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read manifest")
+	}
+
+	m, err := httpfs.ParseManifest(b)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceURL, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL, err := url.Parse(m.Base)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid manifest base")
+	}
+	if m.Base == "" {
+		baseURL = &url.URL{Path: "."}
+	}
+
+	base := sourceURL.ResolveReference(baseURL).String()
+	return rwfs.ReadOnlyFS(httpfs.NewWithOptions(*http.DefaultClient, m.Tree, base, httpfs.Options{
+		Cache: httpfs.MemoryCache(httpFSCacheSize),
+	})), nil
+}
+
+// newArchiveFS mounts the zip or tar archive at the source path. The format of
+// the archive is detected from its contents.
+func newArchiveFS(ctx context.Context, env vm.Environment, source string) (rwfs.FS, error) {
+	archive, err := archivefs.Open(env.Filesystem, absPath(env, source))
+	if err != nil {
+		return nil, err
+	}
+	return rwfs.ReadOnlyFS(archive), nil
+}
+
+// absPath returns p relative to the current working directory if it's not
+// absolute.
+func absPath(env vm.Environment, p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return env.JoinCwd(p)
+}
+
+// parseOptions parses the comma-separated options given with -o.
+func parseOptions(opts []string) []string {
+	var parsed []string
+	for _, o := range opts {
+		for _, o := range strings.Split(o, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				parsed = append(parsed, o)
+			}
+		}
+	}
+	return parsed
+}
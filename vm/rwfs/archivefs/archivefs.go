@@ -0,0 +1,557 @@
+// Package archivefs provides read-only filesystems over zip and tar archives.
+package archivefs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"libdb.so/vm/rwfs"
+)
+
+// Format is the format of an archive.
+type Format uint8
+
+const (
+	// UnknownFormat is the format of anything that isn't an archive.
+	UnknownFormat Format = iota
+	// Zip is a zip archive.
+	Zip
+	// Tar is an uncompressed tar archive.
+	Tar
+	// Gzip is a gzip-compressed file, usually a tar archive.
+	Gzip
+)
+
+// String returns the usual name of the format.
+func (f Format) String() string {
+	switch f {
+	case Zip:
+		return "zip"
+	case Tar:
+		return "tar"
+	case Gzip:
+		return "gzip"
+	default:
+		return "unknown"
+	}
+}
+
+// Detect detects the format of an archive from its first 512 bytes. Fewer
+// bytes may be given for short files.
+func Detect(head []byte) Format {
+	switch {
+	case bytes.HasPrefix(head, []byte("\x1f\x8b")):
+		return Gzip
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return Zip
+	case len(head) >= 262 && bytes.HasPrefix(head[257:], []byte("ustar")):
+		return Tar
+	default:
+		return UnknownFormat
+	}
+}
+
+// MaxDecompressedSize is the largest size that compressed data is decompressed
+// into. Gzip-compressed archives, compressed zip files and sparse tar files
+// are decompressed into memory, so this keeps a small archive from taking up
+// all of it.
+var MaxDecompressedSize int64 = 512 << 20 // 512 MiB
+
+// ErrTooLarge is returned when compressed data is larger than
+// MaxDecompressedSize once decompressed.
+var ErrTooLarge = errors.New("too large to decompress")
+
+// FS is a read-only filesystem over the files of an archive. It implements
+// rwfs.ReadlinkFS, and rwfs.ReadOnlyFS turns it into an rwfs.FS.
+type FS struct {
+	root   *node
+	closer io.Closer
+}
+
+var (
+	_ fs.FS           = (*FS)(nil)
+	_ fs.StatFS       = (*FS)(nil)
+	_ fs.ReadDirFS    = (*FS)(nil)
+	_ rwfs.ReadlinkFS = (*FS)(nil)
+	_ io.Closer       = (*FS)(nil)
+)
+
+// Open opens the archive at name in fsys, whose format is detected from its
+// contents. Gzip-compressed files are taken to be tar archives. Uncompressed
+// archives are read from the file as needed if it implements io.ReaderAt, in
+// which case the file stays open until the FS is closed. Otherwise, the whole
+// archive is read into memory.
+func Open(fsys fs.FS, name string) (*FS, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		defer f.Close()
+		return Read(f)
+	}
+
+	archive, err := newFS(ra, s.Size())
+	if err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	archive.closer = f
+	return archive, nil
+}
+
+// Read reads a whole archive from r into memory. Its format is detected like
+// Open does.
+func Read(r io.Reader) (*FS, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return newFS(bytes.NewReader(b), int64(len(b)))
+}
+
+func newFS(r io.ReaderAt, size int64) (*FS, error) {
+	head := make([]byte, 512)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch Detect(head[:n]) {
+	case Zip:
+		return NewZip(r, size)
+	case Tar:
+		return NewTar(r, size)
+	case Gzip:
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, err
+		}
+		b, err := readLimited(gz)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot decompress")
+		}
+		return NewTar(bytes.NewReader(b), int64(len(b)))
+	default:
+		return nil, errors.New("unknown archive format")
+	}
+}
+
+// NewZip returns the FS of the zip archive in r. Stored files are read from r
+// as needed, while compressed ones are decompressed into memory when opened.
+func NewZip(r io.ReaderAt, size int64) (*FS, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := newEmptyFS()
+	for _, f := range zr.File {
+		f := f
+		mode := f.Mode()
+
+		n := &node{
+			mode:    mode,
+			modTime: f.Modified,
+			size:    int64(f.UncompressedSize64),
+		}
+
+		switch {
+		case mode.IsDir():
+			n.size = 0
+		case mode&fs.ModeSymlink != 0:
+			target, err := readAll(f.Open)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot read link %s", f.Name)
+			}
+			n.target = string(target)
+		case f.Method == zip.Store:
+			off, err := f.DataOffset()
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot find %s", f.Name)
+			}
+			sr := io.NewSectionReader(r, off, n.size)
+			n.open = func() (readSeekerAt, error) {
+				return io.NewSectionReader(sr, 0, sr.Size()), nil
+			}
+		default:
+			n.open = func() (readSeekerAt, error) {
+				b, err := readAll(f.Open)
+				if err != nil {
+					return nil, err
+				}
+				return bytes.NewReader(b), nil
+			}
+		}
+
+		archive.add(f.Name, n)
+	}
+
+	return archive, nil
+}
+
+// NewTar returns the FS of the uncompressed tar archive in r. Files are read
+// from r as needed.
+func NewTar(r io.ReaderAt, size int64) (*FS, error) {
+	// The section reader is also an io.Seeker, so the tar reader seeks past
+	// the data of each file instead of reading it, and its position is where
+	// the data of the current file starts.
+	sr := io.NewSectionReader(r, 0, size)
+	tr := tar.NewReader(sr)
+
+	archive := newEmptyFS()
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		n := &node{
+			mode:    hdr.FileInfo().Mode(),
+			modTime: hdr.ModTime,
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeSymlink:
+			n.target = hdr.Linkname
+		case tar.TypeReg, tar.TypeGNUSparse:
+			n.size = hdr.Size
+
+			if isSparse(hdr) {
+				// Sparse files aren't stored contiguously, so they're read
+				// through the tar reader right away.
+				b, err := readLimited(tr)
+				if err != nil {
+					return nil, errors.Wrapf(err, "cannot read %s", hdr.Name)
+				}
+				n.open = func() (readSeekerAt, error) { return bytes.NewReader(b), nil }
+				break
+			}
+
+			off, err := sr.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			data := io.NewSectionReader(r, off, hdr.Size)
+			n.open = func() (readSeekerAt, error) {
+				return io.NewSectionReader(data, 0, data.Size()), nil
+			}
+		default:
+			// Hard links, devices and the like aren't supported.
+			continue
+		}
+
+		archive.add(hdr.Name, n)
+	}
+
+	return archive, nil
+}
+
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+func readAll(open func() (io.ReadCloser, error)) ([]byte, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readLimited(rc)
+}
+
+// readLimited reads all of r, which must not have more than
+// MaxDecompressedSize bytes.
+func readLimited(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > MaxDecompressedSize {
+		return nil, ErrTooLarge
+	}
+	return b, nil
+}
+
+func newEmptyFS() *FS {
+	return &FS{root: &node{
+		name:     ".",
+		mode:     fs.ModeDir | 0755,
+		children: make(map[string]*node),
+	}}
+}
+
+// add adds n at the given path in the archive. Missing parent directories are
+// made up.
+func (a *FS) add(name string, n *node) {
+	parts := rwfs.Split(name)
+	if len(parts) == 0 {
+		return
+	}
+
+	dir := a.root
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := dir.children[part]
+		if !ok || !child.mode.IsDir() {
+			child = &node{
+				name:     part,
+				mode:     fs.ModeDir | 0755,
+				children: make(map[string]*node),
+			}
+			dir.children[part] = child
+		}
+		dir = child
+	}
+
+	n.name = parts[len(parts)-1]
+	if n.mode.IsDir() {
+		// Keep the children of a directory that was made up or listed twice.
+		if old, ok := dir.children[n.name]; ok && old.mode.IsDir() {
+			n.children = old.children
+		} else {
+			n.children = make(map[string]*node)
+		}
+	}
+
+	dir.children[n.name] = n
+}
+
+// Close closes the archive's file, if any.
+func (a *FS) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// Open implements fs.FS.
+func (a *FS) Open(name string) (fs.File, error) {
+	n, err := a.lookup(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	if n.mode.IsDir() {
+		return &dir{info: n.info(), entries: n.entries()}, nil
+	}
+
+	r, err := n.open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &file{readSeekerAt: r, info: n.info()}, nil
+}
+
+// Stat implements fs.StatFS.
+func (a *FS) Stat(name string) (fs.FileInfo, error) {
+	n, err := a.lookup(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return n.info(), nil
+}
+
+// Lstat implements rwfs.ReadlinkFS.
+func (a *FS) Lstat(name string) (fs.FileInfo, error) {
+	n, err := a.lookup(name, false)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return n.info(), nil
+}
+
+// Readlink implements rwfs.ReadlinkFS.
+func (a *FS) Readlink(name string) (string, error) {
+	n, err := a.lookup(name, false)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.target, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (a *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := a.lookup(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.entries(), nil
+}
+
+// lookup returns the node at the given path. Symbolic links are followed,
+// except for the last component if followLast is false. Absolute link targets
+// are relative to the root of the archive.
+func (a *FS) lookup(name string, followLast bool) (*node, error) {
+	parts := rwfs.Split(name)
+	resolved := make([]string, 0, len(parts))
+	links := 0
+
+	n := a.root
+	for i := 0; i < len(parts); i++ {
+		if !n.mode.IsDir() {
+			return nil, fs.ErrNotExist
+		}
+
+		next, ok := n.children[parts[i]]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+
+		if next.mode&fs.ModeSymlink == 0 || (i == len(parts)-1 && !followLast) {
+			resolved = append(resolved, parts[i])
+			n = next
+			continue
+		}
+
+		if links++; links > rwfs.MaxSymlinks {
+			return nil, rwfs.ErrSymlinkLoop
+		}
+
+		target := next.target
+		if !path.IsAbs(target) {
+			target = path.Join(rwfs.JoinAbs(resolved), target)
+		}
+
+		parts = append(rwfs.Split(target), parts[i+1:]...)
+		resolved = resolved[:0]
+		n = a.root
+		i = -1
+	}
+
+	return n, nil
+}
+
+type readSeekerAt interface {
+	io.Reader
+	io.Seeker
+	io.ReaderAt
+}
+
+// node is a file, directory or symbolic link in an archive.
+type node struct {
+	name     string
+	mode     fs.FileMode
+	modTime  time.Time
+	size     int64
+	target   string                       // symbolic links only
+	children map[string]*node             // directories only
+	open     func() (readSeekerAt, error) // files only
+}
+
+func (n *node) info() fileInfo {
+	size := n.size
+	if n.mode&fs.ModeSymlink != 0 {
+		size = int64(len(n.target))
+	}
+	return fileInfo{n, size}
+}
+
+func (n *node) entries() []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(n.children))
+	for _, child := range n.children {
+		entries = append(entries, child.info())
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+type fileInfo struct {
+	n    *node
+	size int64
+}
+
+var (
+	_ fs.FileInfo = fileInfo{}
+	_ fs.DirEntry = fileInfo{}
+)
+
+func (i fileInfo) Name() string       { return i.n.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() fs.FileMode  { return i.n.mode }
+func (i fileInfo) ModTime() time.Time { return i.n.modTime }
+func (i fileInfo) IsDir() bool        { return i.n.mode.IsDir() }
+func (i fileInfo) Sys() any           { return nil }
+
+func (i fileInfo) Type() fs.FileMode          { return i.n.mode.Type() }
+func (i fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+type file struct {
+	readSeekerAt
+	info fileInfo
+}
+
+var (
+	_ fs.File     = (*file)(nil)
+	_ io.Seeker   = (*file)(nil)
+	_ io.ReaderAt = (*file)(nil)
+)
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *file) Close() error               { return nil }
+
+type dir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+}
+
+var _ fs.ReadDirFile = (*dir)(nil)
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Read([]byte) (int, error)   { return 0, fs.ErrInvalid }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,286 @@
+package archivefs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+var modTime = time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+
+type testEntry struct {
+	name   string
+	data   string
+	target string
+	dir    bool
+}
+
+var testEntries = []testEntry{
+	{name: "dir", dir: true},
+	{name: "dir/a.txt", data: "hello, world\n"},
+	{name: "implicit/b.txt", data: "b\n"},
+	{name: "link", target: "dir/a.txt"},
+	{name: "dirlink", target: "dir"},
+}
+
+func makeTar(t *testing.T, entries []testEntry) []byte {
+	var b bytes.Buffer
+	w := tar.NewWriter(&b)
+
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, ModTime: modTime}
+		switch {
+		case e.dir:
+			hdr.Name += "/"
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+		case e.target != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.target
+			hdr.Mode = 0777
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(e.data))
+		}
+		assert.NoError(t, w.WriteHeader(hdr))
+		_, err := io.WriteString(w, e.data)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+func makeZip(t *testing.T, entries []testEntry, method uint16) []byte {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: method, Modified: modTime}
+		switch {
+		case e.dir:
+			hdr.Name += "/"
+			hdr.SetMode(fs.ModeDir | 0755)
+		case e.target != "":
+			hdr.SetMode(fs.ModeSymlink | 0777)
+		default:
+			hdr.SetMode(0644)
+		}
+
+		f, err := w.CreateHeader(hdr)
+		assert.NoError(t, err)
+
+		_, err = io.WriteString(f, e.data+e.target)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	var out bytes.Buffer
+	w := gzip.NewWriter(&out)
+	_, err := w.Write(b)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return out.Bytes()
+}
+
+func TestDetect(t *testing.T) {
+	tarball := makeTar(t, testEntries)
+
+	tests := []struct {
+		name string
+		head []byte
+		want Format
+	}{
+		{"tar", tarball, Tar},
+		{"tar.gz", gzipBytes(t, tarball), Gzip},
+		{"zip", makeZip(t, testEntries, zip.Deflate), Zip},
+		{"empty zip", makeZip(t, nil, zip.Deflate), Zip},
+		{"text", []byte("hello"), UnknownFormat},
+		{"empty", nil, UnknownFormat},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			head := test.head[:min(len(test.head), 512)]
+			assert.Equal(t, test.want, Detect(head))
+		})
+	}
+}
+
+func TestFS(t *testing.T) {
+	tarball := makeTar(t, testEntries)
+
+	archives := []struct {
+		name string
+		data []byte
+	}{
+		{"tar", tarball},
+		{"tar.gz", gzipBytes(t, tarball)},
+		{"zip", makeZip(t, testEntries, zip.Deflate)},
+		{"zip stored", makeZip(t, testEntries, zip.Store)},
+	}
+
+	for _, archive := range archives {
+		t.Run(archive.name, func(t *testing.T) {
+			afs, err := Read(bytes.NewReader(archive.data))
+			assert.NoError(t, err)
+			defer afs.Close()
+
+			t.Run("read", func(t *testing.T) {
+				b, err := fs.ReadFile(afs, "dir/a.txt")
+				assert.NoError(t, err)
+				assert.Equal(t, "hello, world\n", string(b))
+
+				b, err = fs.ReadFile(afs, "/implicit/b.txt")
+				assert.NoError(t, err)
+				assert.Equal(t, "b\n", string(b))
+
+				_, err = fs.ReadFile(afs, "nope")
+				assert.IsError(t, err, fs.ErrNotExist)
+
+				_, err = fs.ReadFile(afs, "dir/a.txt/nope")
+				assert.Error(t, err)
+			})
+
+			t.Run("stat", func(t *testing.T) {
+				info, err := fs.Stat(afs, "dir/a.txt")
+				assert.NoError(t, err)
+				assert.Equal(t, "a.txt", info.Name())
+				assert.Equal(t, int64(13), info.Size())
+				assert.Equal(t, fs.FileMode(0644), info.Mode())
+				assert.True(t, info.ModTime().Equal(modTime))
+
+				info, err = fs.Stat(afs, "dir")
+				assert.NoError(t, err)
+				assert.True(t, info.IsDir())
+				assert.Equal(t, fs.ModeDir|0755, info.Mode())
+
+				info, err = fs.Stat(afs, "implicit")
+				assert.NoError(t, err)
+				assert.True(t, info.IsDir())
+			})
+
+			t.Run("readdir", func(t *testing.T) {
+				entries, err := fs.ReadDir(afs, ".")
+				assert.NoError(t, err)
+
+				var names []string
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+				}
+				assert.Equal(t, []string{"dir", "dirlink", "implicit", "link"}, names)
+				assert.Equal(t, fs.ModeSymlink, entries[1].Type())
+
+				_, err = fs.ReadDir(afs, "dir/a.txt")
+				assert.Error(t, err)
+			})
+
+			t.Run("symlinks", func(t *testing.T) {
+				info, err := afs.Lstat("link")
+				assert.NoError(t, err)
+				assert.Equal(t, fs.ModeSymlink, info.Mode().Type())
+
+				target, err := afs.Readlink("link")
+				assert.NoError(t, err)
+				assert.Equal(t, "dir/a.txt", target)
+
+				b, err := fs.ReadFile(afs, "link")
+				assert.NoError(t, err)
+				assert.Equal(t, "hello, world\n", string(b))
+
+				b, err = fs.ReadFile(afs, "dirlink/a.txt")
+				assert.NoError(t, err)
+				assert.Equal(t, "hello, world\n", string(b))
+
+				_, err = afs.Readlink("dir/a.txt")
+				assert.Error(t, err)
+			})
+
+			t.Run("seek", func(t *testing.T) {
+				f, err := afs.Open("dir/a.txt")
+				assert.NoError(t, err)
+				defer f.Close()
+
+				rs, ok := f.(io.ReadSeeker)
+				assert.True(t, ok, "file is not an io.ReadSeeker")
+
+				_, err = rs.Seek(7, io.SeekStart)
+				assert.NoError(t, err)
+
+				b, err := io.ReadAll(rs)
+				assert.NoError(t, err)
+				assert.Equal(t, "world\n", string(b))
+
+				ra, ok := f.(io.ReaderAt)
+				assert.True(t, ok, "file is not an io.ReaderAt")
+
+				buf := make([]byte, 5)
+				_, err = ra.ReadAt(buf, 0)
+				assert.NoError(t, err)
+				assert.Equal(t, "hello", string(buf))
+			})
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.zip":    {Data: makeZip(t, testEntries, zip.Store)},
+		"a.tar.gz": {Data: gzipBytes(t, makeTar(t, testEntries))},
+		"a.txt":    {Data: []byte("not an archive")},
+	}
+
+	for _, name := range []string{"a.zip", "a.tar.gz"} {
+		afs, err := Open(fsys, name)
+		assert.NoError(t, err)
+
+		b, err := fs.ReadFile(afs, "link")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello, world\n", string(b))
+
+		assert.NoError(t, afs.Close())
+	}
+
+	_, err := Open(fsys, "a.txt")
+	assert.Error(t, err)
+
+	_, err = Open(fsys, "nope.zip")
+	assert.IsError(t, err, fs.ErrNotExist)
+}
+
+func TestDecompressLimit(t *testing.T) {
+	defer func(max int64) { MaxDecompressedSize = max }(MaxDecompressedSize)
+
+	big := []testEntry{{name: "big", data: strings.Repeat("0", 64<<10)}}
+	tarball := makeTar(t, big)
+	MaxDecompressedSize = int64(len(big[0].data)) - 1
+
+	_, err := Read(bytes.NewReader(gzipBytes(t, tarball)))
+	assert.IsError(t, err, ErrTooLarge)
+
+	// Compressed zip files are only decompressed once they're opened.
+	afs, err := Read(bytes.NewReader(makeZip(t, big, zip.Deflate)))
+	assert.NoError(t, err)
+	_, err = fs.ReadFile(afs, "big")
+	assert.IsError(t, err, ErrTooLarge)
+
+	// Uncompressed archives aren't limited.
+	afs, err = Read(bytes.NewReader(tarball))
+	assert.NoError(t, err)
+	b, err := fs.ReadFile(afs, "big")
+	assert.NoError(t, err)
+	assert.Equal(t, big[0].data, string(b))
+}
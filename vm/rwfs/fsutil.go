@@ -6,6 +6,9 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
+
+	stderrors "errors"
 
 	"github.com/pkg/errors"
 )
@@ -44,6 +47,93 @@ func ConvertAbs(abs string) string {
 	return cleaned
 }
 
+// Rename renames oldname to newname within fsys. If fsys doesn't implement
+// RenameFS, then an error wrapping errors.ErrUnsupported is returned.
+func Rename(fsys FS, oldname, newname string) error {
+	rfs, ok := fsys.(RenameFS)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: stderrors.ErrUnsupported}
+	}
+	return rfs.Rename(oldname, newname)
+}
+
+// Chmod changes the permission bits of name within fsys. If fsys doesn't
+// implement ChmodFS, then an error wrapping errors.ErrUnsupported is returned.
+func Chmod(fsys FS, name string, mode fs.FileMode) error {
+	cfs, ok := fsys.(ChmodFS)
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: name, Err: stderrors.ErrUnsupported}
+	}
+	return cfs.Chmod(name, mode)
+}
+
+// Chtimes changes the times of name within fsys. If fsys doesn't implement
+// ChtimesFS, then an error wrapping errors.ErrUnsupported is returned.
+func Chtimes(fsys FS, name string, atime, mtime time.Time) error {
+	cfs, ok := fsys.(ChtimesFS)
+	if !ok {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: stderrors.ErrUnsupported}
+	}
+	return cfs.Chtimes(name, atime, mtime)
+}
+
+// Symlink creates newname as a symbolic link to oldname within fsys. If fsys
+// doesn't implement SymlinkFS, then an error wrapping errors.ErrUnsupported is
+// returned.
+func Symlink(fsys FS, oldname, newname string) error {
+	sfs, ok := fsys.(SymlinkFS)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: stderrors.ErrUnsupported}
+	}
+	return sfs.Symlink(oldname, newname)
+}
+
+// Usage returns the space used by fsys and its quota. If fsys doesn't
+// implement UsageFS, then an error wrapping errors.ErrUnsupported is returned.
+func Usage(fsys FS) (used, quota int64, err error) {
+	ufs, ok := fsys.(UsageFS)
+	if !ok {
+		return 0, 0, stderrors.ErrUnsupported
+	}
+	return ufs.Usage()
+}
+
+// Readlink returns the target of the symbolic link name within fsys. If fsys
+// doesn't implement ReadlinkFS, then fs.ErrInvalid is returned for existing
+// files, since they can't be symbolic links.
+func Readlink(fsys fs.FS, name string) (string, error) {
+	if rfs, ok := fsys.(ReadlinkFS); ok {
+		return rfs.Readlink(name)
+	}
+	if _, err := fs.Stat(fsys, name); err != nil {
+		return "", err
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+}
+
+// Lstat is like fs.Stat, except a symbolic link is described by itself rather
+// than by its target if fsys implements ReadlinkFS.
+func Lstat(fsys fs.FS, name string) (fs.FileInfo, error) {
+	if rfs, ok := fsys.(ReadlinkFS); ok {
+		return rfs.Lstat(name)
+	}
+	return fs.Stat(fsys, name)
+}
+
+// ContentType returns the MIME type of a file if its file info or the info's
+// Sys value knows it, or an empty string otherwise.
+func ContentType(info fs.FileInfo) string {
+	type contentTyper interface{ ContentType() string }
+
+	if ct, ok := info.(contentTyper); ok {
+		return ct.ContentType()
+	}
+	if ct, ok := info.Sys().(contentTyper); ok {
+		return ct.ContentType()
+	}
+	return ""
+}
+
 // Copy copies a file at srcPath to dstPath. dstPath and srcPath can be in
 // different filesystems.
 func Copy(dstFS FS, dstPath string, srcFS fs.FS, srcPath string) error {
@@ -66,12 +156,7 @@ func Copy(dstFS FS, dstPath string, srcFS fs.FS, srcPath string) error {
 		}
 	}
 
-	if srcStat.Size() == 0 {
-		// There's nothing to copy. We're done.
-		return nil
-	}
-
-	dstFile, err := dstFS.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE, srcStat.Mode())
+	dstFile, err := dstFS.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, srcStat.Mode())
 	if err != nil {
 		return &fs.PathError{
 			Op:   "open",
@@ -79,9 +164,8 @@ func Copy(dstFS FS, dstPath string, srcFS fs.FS, srcPath string) error {
 			Err:  errors.Wrap(err, "failed to create destination file"),
 		}
 	}
-	defer dstFile.Close()
-
 	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		dstFile.Close()
 		return &fs.PathError{
 			Op:   "copy",
 			Path: dstPath,
@@ -89,6 +173,15 @@ func Copy(dstFS FS, dstPath string, srcFS fs.FS, srcPath string) error {
 		}
 	}
 
+	// Writes might only be committed on close, so its error matters.
+	if err := dstFile.Close(); err != nil {
+		return &fs.PathError{
+			Op:   "close",
+			Path: dstPath,
+			Err:  errors.Wrap(err, "failed to write destination file"),
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,155 @@
+package httpfs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"libdb.so/vm/rwfs/kvfs"
+)
+
+// Cache caches the contents of files. Keys are content-addressed if the file
+// tree has a hash for the file, in which case the entry never needs to be
+// revalidated. Otherwise, the key is the file's path, and the entry is
+// revalidated with its ETag.
+type Cache interface {
+	// Get returns the entry with the given key.
+	Get(key string) (CacheEntry, bool)
+	// Put adds or replaces the entry with the given key. Caches may drop
+	// entries at any time.
+	Put(key string, entry CacheEntry)
+}
+
+// CacheEntry is a file's contents in a Cache.
+type CacheEntry struct {
+	// ETag is the ETag that the server sent with the file. It is empty if the
+	// entry is content-addressed.
+	ETag string
+	Data []byte
+}
+
+// cacheKey returns the cache key of the file at the given path.
+func cacheKey(filepath string, info FileInfo) string {
+	if info.SHA256 != "" {
+		return "sha256:" + info.SHA256
+	}
+	return "path:" + filepath
+}
+
+// MemoryCache returns a Cache that keeps up to maxSize bytes of files in
+// memory. The least recently used entries are evicted first.
+func MemoryCache(maxSize int64) Cache {
+	return &memoryCache{
+		max:     maxSize,
+		list:    list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+type memoryCache struct {
+	mu      sync.Mutex
+	max     int64
+	size    int64
+	list    *list.List // of *memoryCacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key string
+	CacheEntry
+}
+
+func (c *memoryCache) Get(key string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+
+	c.list.MoveToFront(elem)
+	return elem.Value.(*memoryCacheEntry).CacheEntry, true
+}
+
+func (c *memoryCache) Put(key string, entry CacheEntry) {
+	size := int64(len(entry.Data))
+	if size > c.max {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	c.entries[key] = c.list.PushFront(&memoryCacheEntry{key, entry})
+	c.size += size
+
+	for c.size > c.max {
+		c.remove(c.list.Back())
+	}
+}
+
+func (c *memoryCache) remove(elem *list.Element) {
+	entry := c.list.Remove(elem).(*memoryCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.Data))
+}
+
+// StoreCache returns a Cache that persists entries into the given kvfs store,
+// such as an IndexedDB or an on-disk one. Entries are never evicted, and keys
+// are hashed into paths at the store's root, so the store should only be used
+// for the cache.
+func StoreCache(store kvfs.Store) Cache {
+	return storeCache{store}
+}
+
+type storeCache struct {
+	store kvfs.Store
+}
+
+func (c storeCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "/" + hex.EncodeToString(sum[:])
+}
+
+func (c storeCache) Get(key string) (CacheEntry, bool) {
+	v, err := c.store.Get(c.path(key))
+	if err != nil {
+		return CacheEntry{}, false
+	}
+
+	f, ok := v.(kvfs.StoredFile)
+	if !ok {
+		return CacheEntry{}, false
+	}
+
+	// The ETag is prefixed to the data with its length.
+	n, i := binary.Uvarint(f.Data)
+	if i <= 0 || uint64(len(f.Data)-i) < n {
+		return CacheEntry{}, false
+	}
+
+	return CacheEntry{
+		ETag: string(f.Data[i : i+int(n)]),
+		Data: f.Data[i+int(n):],
+	}, true
+}
+
+func (c storeCache) Put(key string, entry CacheEntry) {
+	data := binary.AppendUvarint(nil, uint64(len(entry.ETag)))
+	data = append(data, entry.ETag...)
+	data = append(data, entry.Data...)
+
+	// The cache is best-effort, so failing to store an entry is fine.
+	c.store.Set(c.path(key), kvfs.StoredFile{
+		ModTime: time.Now().Unix(),
+		Data:    data,
+	})
+}
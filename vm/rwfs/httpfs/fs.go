@@ -1,77 +1,328 @@
 package httpfs
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"io/fs"
 	"net/http"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"libdb.so/vm/rwfs"
 )
 
+// DefaultRangeThreshold is the default Options.RangeThreshold.
+const DefaultRangeThreshold = 1 << 20
+
+// ErrChecksumMismatch is returned when a downloaded file doesn't match the
+// SHA-256 hash in the manifest.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Options are the optional settings of an FS. The zero value uses the
+// defaults.
+type Options struct {
+	// Timeout is the timeout of each request, unless the context given to
+	// OpenContext already has a deadline. It defaults to Timeout.
+	Timeout time.Duration
+	// Cache caches the contents of files. Nothing is cached if it is nil.
+	Cache Cache
+	// RangeThreshold is the size from which files are read with Range
+	// requests as they're needed, instead of being downloaded whole when
+	// they're opened. Such files are never cached. It defaults to
+	// DefaultRangeThreshold.
+	RangeThreshold int64
+}
+
 // FS is a file system that reads from an HTTP server.
 type FS struct {
 	tree   FileTree
 	client httpClient
+	opts   Options
 }
 
-var _ fs.FS = (*FS)(nil)
+var (
+	_ fs.FS           = (*FS)(nil)
+	_ fs.StatFS       = (*FS)(nil)
+	_ rwfs.ReadlinkFS = (*FS)(nil)
+)
 
-// New returns a new FS that obeys the given file tree. A cache may optionally
-// be provided to cache file contents.
+// New returns a new FS that obeys the given file tree with the default
+// options.
 func New(client http.Client, tree FileTree, basePath string) *FS {
+	return NewWithOptions(client, tree, basePath, Options{})
+}
+
+// NewWithOptions returns a new FS that obeys the given file tree.
+func NewWithOptions(client http.Client, tree FileTree, basePath string, opts Options) *FS {
+	if opts.Timeout == 0 {
+		opts.Timeout = Timeout
+	}
+	if opts.RangeThreshold == 0 {
+		opts.RangeThreshold = DefaultRangeThreshold
+	}
+
 	return &FS{
 		tree: tree,
 		client: httpClient{
 			client:   client,
 			basePath: basePath,
+			timeout:  opts.Timeout,
 		},
+		opts: opts,
 	}
 }
 
-func (h *FS) Open(path string) (fs.File, error) {
-	parts := rwfs.Split(path)
-	if len(parts) == 0 {
-		return fsDir{
-			i: dirInfo(path),
-			d: h.tree,
-		}, nil
+// Open implements fs.FS. It is OpenContext with a background context.
+func (h *FS) Open(name string) (fs.File, error) {
+	return h.OpenContext(context.Background(), name)
+}
+
+// OpenContext opens the named file. The context applies to every request made
+// for the file, including those made by reads after it's opened.
+func (h *FS) OpenContext(ctx context.Context, name string) (fs.File, error) {
+	resolved, entry, err := h.lookup(ctx, name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
 	}
 
-	current := h.tree
-	for i, part := range parts {
-		entry, ok := current[part]
-		if !ok {
-			return nil, fs.ErrNotExist
+	if info, ok := entry.(FileInfo); ok {
+		f, err := h.openFile(ctx, "/"+resolved, fileInfo(baseName(name), info), info)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
 		}
+		return f, nil
+	}
+
+	tree, err := h.dirTree(ctx, resolved, entry)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return fsDir{
+		i: dirInfo(baseName(name)),
+		d: tree,
+	}, nil
+}
+
+// Stat implements fs.StatFS. It only looks at the file tree, though
+// sub-manifests of the file's parent directories may be fetched.
+func (h *FS) Stat(name string) (fs.FileInfo, error) {
+	return h.stat("stat", name, true)
+}
+
+// Lstat implements rwfs.ReadlinkFS. It is like Stat.
+func (h *FS) Lstat(name string) (fs.FileInfo, error) {
+	return h.stat("lstat", name, false)
+}
+
+func (h *FS) stat(op, name string, followLast bool) (fs.FileInfo, error) {
+	_, entry, err := h.lookup(context.Background(), name, followLast)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	if info, ok := entry.(FileInfo); ok {
+		return fileInfo(baseName(name), info), nil
+	}
+	return dirInfo(baseName(name)), nil
+}
+
+// Readlink implements rwfs.ReadlinkFS.
+func (h *FS) Readlink(name string) (string, error) {
+	_, entry, err := h.lookup(context.Background(), name, false)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+
+	info, ok := entry.(FileInfo)
+	if !ok || !info.IsSymlink() {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return info.Target, nil
+}
 
-		if i == len(parts)-1 {
-			switch entry := entry.(type) {
-			case FileInfo:
-				r, err := h.client.get(rwfs.JoinAbs(parts), entry)
-				if err != nil {
-					return nil, errors.Wrap(err, "httpfs: error getting file")
-				}
-				return fsFile{
-					i: fileInfo(part, entry),
-					r: r,
-				}, nil
-			case FileTree:
-				return fsDir{
-					i: dirInfo(part),
-					d: entry,
-				}, nil
-			}
-
-			break
+// lookup returns the resolved path and the entry of the given path in the file
+// tree. Symbolic links are followed, except for the last component if
+// followLast is false. The resolved path has no leading slash, and it is empty
+// for the root. Sub-manifests of the directories that are walked through are
+// fetched as needed.
+func (h *FS) lookup(ctx context.Context, name string, followLast bool) (string, FileTreeValue, error) {
+	parts := rwfs.Split(name)
+	resolved := make([]string, 0, len(parts))
+	links := 0
+
+	var entry FileTreeValue = h.tree
+	for i := 0; i < len(parts); i++ {
+		dir, err := h.dirTree(ctx, strings.Join(resolved, "/"), entry)
+		if err != nil {
+			return "", nil, err
 		}
 
-		dir, ok := entry.(FileTree)
+		next, ok := dir[parts[i]]
 		if !ok {
-			return nil, fs.ErrNotExist
+			return "", nil, fs.ErrNotExist
+		}
+
+		info, ok := next.(FileInfo)
+		if !ok || !info.IsSymlink() || (i == len(parts)-1 && !followLast) {
+			resolved = append(resolved, parts[i])
+			entry = next
+			continue
 		}
 
-		current = dir
+		if links++; links > rwfs.MaxSymlinks {
+			return "", nil, rwfs.ErrSymlinkLoop
+		}
+
+		target := info.Target
+		if !path.IsAbs(target) {
+			target = path.Join(rwfs.JoinAbs(resolved), target)
+		}
+
+		parts = append(rwfs.Split(target), parts[i+1:]...)
+		resolved = resolved[:0]
+		entry = h.tree
+		i = -1
+	}
+
+	return strings.Join(resolved, "/"), entry, nil
+}
+
+// dirTree returns the tree of the directory entry at the given resolved path.
+// If the entry is a sub-manifest, then it is fetched the first time.
+// fs.ErrNotExist is returned if the entry isn't a directory.
+func (h *FS) dirTree(ctx context.Context, resolved string, entry FileTreeValue) (FileTree, error) {
+	switch entry := entry.(type) {
+	case FileTree:
+		return entry, nil
+	case *SubManifest:
+		return h.loadSubManifest(ctx, resolved, entry)
+	default:
+		return nil, fs.ErrNotExist
+	}
+}
+
+func (h *FS) loadSubManifest(ctx context.Context, resolved string, sub *SubManifest) (FileTree, error) {
+	// Holding the lock while fetching makes concurrent lookups wait for the
+	// same fetch instead of making their own.
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.tree != nil {
+		return sub.tree, nil
+	}
+
+	manifestPath := sub.Path
+	if !path.IsAbs(manifestPath) {
+		manifestPath = path.Join(path.Dir("/"+resolved), manifestPath)
+	}
+
+	data, err := h.fetch(ctx, manifestPath, FileInfo{})
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// The directory exists, so it's the manifest that's missing.
+			return nil, errors.Errorf("missing manifest %s", manifestPath)
+		}
+		return nil, errors.Wrapf(err, "cannot fetch manifest %s", manifestPath)
+	}
+
+	m, err := ParseManifest(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "manifest %s", manifestPath)
+	}
+
+	sub.tree = m.Tree
+	if sub.tree == nil {
+		sub.tree = FileTree{}
+	}
+
+	return sub.tree, nil
+}
+
+// baseName returns the name of the file at the given path as reported by its
+// file info.
+func baseName(name string) string {
+	parts := rwfs.Split(name)
+	if len(parts) == 0 {
+		return "."
+	}
+	return parts[len(parts)-1]
+}
+
+func (h *FS) openFile(ctx context.Context, filepath string, stat fsFileInfo, info FileInfo) (fs.File, error) {
+	if info.Size >= h.opts.RangeThreshold {
+		return &rangeFile{
+			i:      stat,
+			ctx:    ctx,
+			client: &h.client,
+			path:   filepath,
+		}, nil
+	}
+
+	data, err := h.fetch(ctx, filepath, info)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMemFile(stat, data), nil
+}
+
+// fetch downloads the whole file, going through the cache if there is one.
+func (h *FS) fetch(ctx context.Context, filepath string, info FileInfo) ([]byte, error) {
+	if h.opts.Cache == nil {
+		data, _, _, err := h.client.get(ctx, filepath, "")
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSHA256(filepath, info, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	key := cacheKey(filepath, info)
+
+	cached, ok := h.opts.Cache.Get(key)
+	if ok && info.SHA256 != "" {
+		return cached.Data, nil
+	}
+
+	data, etag, notModified, err := h.client.get(ctx, filepath, cached.ETag)
+	if err != nil {
+		return nil, err
+	}
+	if notModified {
+		return cached.Data, nil
+	}
+	if err := checkSHA256(filepath, info, data); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case info.SHA256 != "":
+		h.opts.Cache.Put(key, CacheEntry{Data: data})
+	case etag != "":
+		h.opts.Cache.Put(key, CacheEntry{ETag: etag, Data: data})
+	}
+
+	return data, nil
+}
+
+// checkSHA256 returns ErrChecksumMismatch if the file has a SHA-256 hash in
+// its manifest that doesn't match data.
+func checkSHA256(filepath string, info FileInfo, data []byte) error {
+	if info.SHA256 == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256(data)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), info.SHA256) {
+		return &fs.PathError{Op: "open", Path: filepath, Err: ErrChecksumMismatch}
 	}
 
-	return nil, fs.ErrNotExist
+	return nil
 }
@@ -1,16 +1,24 @@
 package httpfs
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
 	"io/fs"
 	"sort"
+	"sync"
 	"time"
 )
 
 type fsFileInfo struct {
-	name string
-	size int64
-	dir  bool
+	name        string
+	size        int64
+	modTime     time.Time
+	perm        fs.FileMode
+	contentType string
+	dir         bool
+	symlink     bool
 }
 
 var _ fs.FileInfo = fsFileInfo{}
@@ -24,46 +32,171 @@ func dirInfo(name string) fsFileInfo {
 }
 
 func fileInfo(name string, info FileInfo) fsFileInfo {
-	return fsFileInfo{
-		name: name,
-		size: int64(info.Size),
+	stat := fsFileInfo{
+		name:        name,
+		size:        int64(info.Size),
+		perm:        info.Mode.Perm(),
+		contentType: info.ContentType,
+		symlink:     info.IsSymlink(),
+	}
+	if info.ModTime != 0 {
+		stat.modTime = time.Unix(info.ModTime, 0)
 	}
+	return stat
 }
 
 func (stat fsFileInfo) Name() string       { return stat.name }
 func (stat fsFileInfo) Size() int64        { return stat.size }
-func (stat fsFileInfo) ModTime() time.Time { return time.Time{} }
+func (stat fsFileInfo) ModTime() time.Time { return stat.modTime }
 func (stat fsFileInfo) IsDir() bool        { return stat.dir }
 func (stat fsFileInfo) Sys() any           { return stat }
 func (stat fsFileInfo) Mode() fs.FileMode {
-	mode := fs.FileMode(0444)
+	if stat.symlink {
+		return fs.ModeSymlink | 0777
+	}
+
+	mode := stat.perm
+	if mode == 0 {
+		mode = 0444
+		if stat.IsDir() {
+			mode |= 0111
+		}
+	}
 	if stat.IsDir() {
-		mode |= 0111
 		mode |= fs.ModeDir
 	}
 	return mode
 }
 
+// ContentType returns the MIME type of the file from the file tree, if any.
+func (stat fsFileInfo) ContentType() string { return stat.contentType }
+
 func (stat fsFileInfo) Type() fs.FileMode          { return stat.Mode().Type() }
 func (stat fsFileInfo) Info() (fs.FileInfo, error) { return stat, nil }
 
-type fsFile struct {
+// memFile is a file that has been downloaded whole.
+type memFile struct {
+	*bytes.Reader
 	i fsFileInfo
-	r io.ReadCloser
 }
 
-var _ fs.File = fsFile{}
+var (
+	_ fs.File     = (*memFile)(nil)
+	_ io.Seeker   = (*memFile)(nil)
+	_ io.ReaderAt = (*memFile)(nil)
+)
+
+func newMemFile(i fsFileInfo, data []byte) *memFile {
+	return &memFile{bytes.NewReader(data), i}
+}
 
-func (f fsFile) Stat() (fs.FileInfo, error) {
+func (f *memFile) Stat() (fs.FileInfo, error) {
 	return f.i, nil
 }
 
-func (f fsFile) Read(b []byte) (int, error) {
-	return f.r.Read(b)
+func (f *memFile) Close() error { return nil }
+
+// rangeChunkSize is the minimum number of bytes that rangeFile.Read fetches
+// at once.
+const rangeChunkSize = 256 << 10
+
+// rangeFile is a file that is read with Range requests as it is needed.
+type rangeFile struct {
+	i      fsFileInfo
+	ctx    context.Context
+	client *httpClient
+	path   string
+
+	mu     sync.Mutex
+	off    int64
+	buf    []byte // data starting at bufOff
+	bufOff int64
+}
+
+var (
+	_ fs.File     = (*rangeFile)(nil)
+	_ io.Seeker   = (*rangeFile)(nil)
+	_ io.ReaderAt = (*rangeFile)(nil)
+)
+
+func (f *rangeFile) Stat() (fs.FileInfo, error) {
+	return f.i, nil
+}
+
+func (f *rangeFile) Read(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.off >= f.i.size {
+		return 0, io.EOF
+	}
+
+	if f.off < f.bufOff || f.off >= f.bufOff+int64(len(f.buf)) {
+		buf, err := f.client.getRange(f.ctx, f.path, f.off, max(int64(len(b)), rangeChunkSize))
+		if err != nil {
+			return 0, f.pathErr("read", err)
+		}
+		f.buf = buf
+		f.bufOff = f.off
+	}
+
+	n := copy(b, f.buf[f.off-f.bufOff:])
+	f.off += int64(n)
+	return n, nil
+}
+
+func (f *rangeFile) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, f.pathErr("readat", errors.New("negative offset"))
+	}
+	if off >= f.i.size {
+		return 0, io.EOF
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	data, err := f.client.getRange(f.ctx, f.path, off, int64(len(b)))
+	if err != nil {
+		return 0, f.pathErr("readat", err)
+	}
+
+	n := copy(b, data)
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
 }
 
-func (f fsFile) Close() error {
-	return f.r.Close()
+func (f *rangeFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.i.size
+	default:
+		return 0, f.pathErr("seek", errors.New("invalid whence"))
+	}
+
+	if offset < 0 {
+		return 0, f.pathErr("seek", errors.New("negative position"))
+	}
+
+	f.off = offset
+	return offset, nil
+}
+
+func (f *rangeFile) Close() error { return nil }
+
+func (f *rangeFile) pathErr(op string, err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.EOF
+	}
+	return &fs.PathError{Op: op, Path: f.path, Err: err}
 }
 
 type fsDir struct {
@@ -87,22 +220,13 @@ func (f fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
 	i := 0
 	ents := make([]fs.DirEntry, 0, len(f.d))
 	for name, df := range f.d {
-		var size int64
-		var isDir bool
-
 		switch df := df.(type) {
 		case FileInfo:
-			size = int64(df.Size)
-		case FileTree:
-			isDir = true
+			ents = append(ents, fileInfo(name, df))
+		case FileTree, *SubManifest:
+			ents = append(ents, dirInfo(name))
 		}
 
-		ents = append(ents, fsFileInfo{
-			name: name,
-			size: size,
-			dir:  isDir,
-		})
-
 		i++
 		if n > 0 && i >= n {
 			break
@@ -2,35 +2,132 @@ package httpfs
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
-	"path"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 type httpClient struct {
 	client   http.Client
 	basePath string
+	timeout  time.Duration
 }
 
 // Timeout is the default timeout for http requests.
 const Timeout = 8 * time.Second
 
-func (c *httpClient) get(filepath string, info FileInfo) (io.ReadCloser, error) {
-	filepath = path.Join(c.basePath, filepath)
+// url returns the URL of the file at the given absolute path.
+func (c *httpClient) url(filepath string) string {
+	return strings.TrimSuffix(c.basePath, "/") + (&url.URL{Path: filepath}).EscapedPath()
+}
+
+// withTimeout returns ctx with the client's timeout if it doesn't already have
+// a deadline.
+func (c *httpClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+// get fetches the whole file at filepath along with its ETag. If etag is not
+// empty, then it is sent as If-None-Match, and notModified is true if the
+// file still has it.
+func (c *httpClient) get(ctx context.Context, filepath, etag string) (data []byte, newETag string, notModified bool, err error) {
+	ctx, cancel := c.withTimeout(ctx)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, filepath, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(filepath), nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, etag, true, nil
+	default:
+		return nil, "", false, statusError(resp)
+	}
+
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, "cannot read body")
+	}
+
+	return data, resp.Header.Get("ETag"), false, nil
+}
+
+// getRange fetches n bytes of the file at filepath starting at off. Fewer
+// bytes are returned if the file ends before that. io.EOF is returned if off
+// is past the end of the file.
+func (c *httpClient) getRange(ctx context.Context, filepath string, off, n int64) ([]byte, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(filepath), nil)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
 
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The server doesn't do ranges, so it sent the whole file.
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, errors.Wrap(err, "cannot read body")
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil, io.EOF
+	default:
+		return nil, statusError(resp)
+	}
 
-	return resp.Body, nil
+	b, err := io.ReadAll(io.LimitReader(resp.Body, n))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read body")
+	}
+	if len(b) == 0 {
+		return nil, io.EOF
+	}
+
+	return b, nil
+}
+
+// statusError returns the error for an unsuccessful response. Statuses that
+// have an fs equivalent are mapped to it.
+func statusError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return fs.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fs.ErrPermission
+	default:
+		return errors.Errorf("unexpected status %q", resp.Status)
+	}
 }
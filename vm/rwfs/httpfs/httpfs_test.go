@@ -0,0 +1,453 @@
+package httpfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/kvfs"
+)
+
+// testServer serves files with ETags and Range support, and records the
+// requests that it gets.
+type testServer struct {
+	*httptest.Server
+	files map[string][]byte
+
+	mu       sync.Mutex
+	requests []testRequest
+}
+
+type testRequest struct {
+	path        string
+	rangeHeader string
+	ifNoneMatch string
+	status      int
+}
+
+func newTestServer(t *testing.T, files map[string][]byte) *testServer {
+	s := &testServer{files: files}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) serve(w http.ResponseWriter, r *http.Request) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		s.mu.Lock()
+		s.requests = append(s.requests, testRequest{
+			path:        r.URL.Path,
+			rangeHeader: r.Header.Get("Range"),
+			ifNoneMatch: r.Header.Get("If-None-Match"),
+			status:      rec.status,
+		})
+		s.mu.Unlock()
+	}()
+
+	switch r.URL.Path {
+	case "/secret":
+		rec.WriteHeader(http.StatusForbidden)
+		return
+	case "/broken":
+		rec.WriteHeader(http.StatusInternalServerError)
+		return
+	case "/slow":
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}
+
+	data, ok := s.files[r.URL.Path]
+	if !ok {
+		http.NotFound(rec, r)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	rec.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	http.ServeContent(rec, r, r.URL.Path, time.Time{}, bytes.NewReader(data))
+}
+
+// take returns the requests made since the last call.
+func (s *testServer) take() []testRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func TestFS(t *testing.T) {
+	big := make([]byte, 3<<20)
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+
+	server := newTestServer(t, map[string][]byte{
+		"/a.txt":      []byte("hello"),
+		"/hashed.txt": []byte("world"),
+		"/tampered":   []byte("evil!"),
+		"/big.bin":    big,
+		"/slow":       []byte("slow"),
+		"/dir/b.txt":  []byte("nested"),
+	})
+
+	tree := FileTree{
+		"a.txt":      FileInfo{Size: 5},
+		"hashed.txt": FileInfo{Size: 5, SHA256: "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7"},
+		"tampered":   FileInfo{Size: 5, SHA256: "d2e1bea084b9abb36da3334f54a65c677649765950ae80e63495ce1b1c640b05"},
+		"big.bin":    FileInfo{Size: int64(len(big))},
+		"slow":       FileInfo{Size: 4},
+		"missing":    FileInfo{Size: 1},
+		"secret":     FileInfo{Size: 1},
+		"broken":     FileInfo{Size: 1},
+		"dir": FileTree{
+			"b.txt": FileInfo{Size: 6},
+		},
+	}
+
+	httpfs := NewWithOptions(*server.Client(), tree, server.URL+"/", Options{
+		Cache: MemoryCache(1 << 20),
+	})
+
+	t.Run("read", func(t *testing.T) {
+		b, err := fs.ReadFile(httpfs, "dir/b.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "nested", string(b))
+		server.take()
+
+		s, err := fs.Stat(httpfs, "dir/b.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(6), s.Size())
+		assert.Equal(t, 0, len(server.take()), "stat shouldn't make requests")
+	})
+
+	t.Run("status", func(t *testing.T) {
+		_, err := httpfs.Open("missing")
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+		_, err = httpfs.Open("secret")
+		assert.True(t, errors.Is(err, fs.ErrPermission))
+
+		_, err = httpfs.Open("broken")
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, fs.ErrNotExist))
+
+		server.take()
+
+		_, err = httpfs.Open("dir/nope")
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+		assert.Equal(t, 0, len(server.take()))
+	})
+
+	t.Run("etag", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			b, err := fs.ReadFile(httpfs, "a.txt")
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(b))
+		}
+
+		requests := server.take()
+		assert.Equal(t, 2, len(requests))
+		assert.Equal(t, http.StatusOK, requests[0].status)
+		assert.Equal(t, http.StatusNotModified, requests[1].status)
+		assert.NotEqual(t, "", requests[1].ifNoneMatch)
+	})
+
+	t.Run("hash", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			b, err := fs.ReadFile(httpfs, "hashed.txt")
+			assert.NoError(t, err)
+			assert.Equal(t, "world", string(b))
+		}
+
+		assert.Equal(t, 1, len(server.take()))
+
+		// Files that don't match their hash are never cached, so every open
+		// fetches them again.
+		for i := 0; i < 2; i++ {
+			_, err := httpfs.Open("tampered")
+			assert.IsError(t, err, ErrChecksumMismatch)
+		}
+		assert.Equal(t, 2, len(server.take()))
+
+		uncached := NewWithOptions(*server.Client(), tree, server.URL+"/", Options{})
+		_, err := uncached.Open("tampered")
+		assert.IsError(t, err, ErrChecksumMismatch)
+		b, err := fs.ReadFile(uncached, "hashed.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(b))
+		server.take()
+	})
+
+	t.Run("range", func(t *testing.T) {
+		f, err := httpfs.Open("big.bin")
+		assert.NoError(t, err)
+		defer f.Close()
+		assert.Equal(t, 0, len(server.take()), "large files are fetched lazily")
+
+		b := make([]byte, 10)
+		_, err = io.ReadFull(f, b)
+		assert.NoError(t, err)
+		assert.Equal(t, big[:10], b)
+
+		requests := server.take()
+		assert.Equal(t, 1, len(requests))
+		assert.Equal(t, http.StatusPartialContent, requests[0].status)
+		assert.Equal(t, "bytes=0-262143", requests[0].rangeHeader)
+
+		_, err = f.(io.ReaderAt).ReadAt(b, 2<<20)
+		assert.NoError(t, err)
+		assert.Equal(t, big[2<<20:2<<20+10], b)
+
+		_, err = f.(io.Seeker).Seek(-5, io.SeekEnd)
+		assert.NoError(t, err)
+		rest, err := io.ReadAll(f)
+		assert.NoError(t, err)
+		assert.Equal(t, big[len(big)-5:], rest)
+
+		_, err = f.(io.Seeker).Seek(0, io.SeekStart)
+		assert.NoError(t, err)
+		all, err := io.ReadAll(f)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(big, all))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := httpfs.OpenContext(ctx, "slow")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+		short := NewWithOptions(*server.Client(), tree, server.URL, Options{
+			Timeout: 50 * time.Millisecond,
+		})
+		_, err = short.Open("slow")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
+
+func TestMemoryCache(t *testing.T) {
+	cache := MemoryCache(10)
+	cache.Put("a", CacheEntry{Data: []byte("aaaa")})
+	cache.Put("b", CacheEntry{Data: []byte("bbbb")})
+
+	_, ok := cache.Get("a")
+	assert.True(t, ok)
+
+	// b is now the least recently used one.
+	cache.Put("c", CacheEntry{Data: []byte("cccc")})
+
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	_, ok = cache.Get("a")
+	assert.True(t, ok)
+
+	cache.Put("huge", CacheEntry{Data: make([]byte, 11)})
+	_, ok = cache.Get("huge")
+	assert.False(t, ok)
+}
+
+func TestStoreCache(t *testing.T) {
+	cache := StoreCache(kvfs.MemoryStorage())
+
+	_, ok := cache.Get("path:/a.txt")
+	assert.False(t, ok)
+
+	cache.Put("path:/a.txt", CacheEntry{ETag: `"abc"`, Data: []byte("hello")})
+
+	entry, ok := cache.Get("path:/a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, CacheEntry{ETag: `"abc"`, Data: []byte("hello")}, entry)
+}
+
+func TestParseManifest(t *testing.T) {
+	t.Run("v1", func(t *testing.T) {
+		m, err := ParseManifest([]byte(`{
+			"base": "_fs",
+			"tree": {"a.txt": {"size": 5}, "dir/": {"b": {"size": 1}}}
+		}`))
+		assert.NoError(t, err)
+		assert.Equal(t, &Manifest{
+			Base: "_fs",
+			Tree: FileTree{
+				"a.txt": FileInfo{Size: 5},
+				"dir":   FileTree{"b": FileInfo{Size: 1}},
+			},
+		}, m)
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		m, err := ParseManifest([]byte(`{
+			"version": 2,
+			"base": "_fs",
+			"tree": {
+				"a.txt": {"size": 5, "mtime": 1700000000, "mode": 493, "type": "text/plain"},
+				"link": {"size": 5, "target": "a.txt"}
+			}
+		}`))
+		assert.NoError(t, err)
+
+		httpfs := New(http.Client{}, m.Tree, m.Base)
+
+		s, err := fs.Stat(httpfs, "a.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, fs.FileMode(0755), s.Mode())
+		assert.Equal(t, int64(1700000000), s.ModTime().Unix())
+		assert.Equal(t, "text/plain", rwfs.ContentType(s))
+
+		s, err = httpfs.Lstat("link")
+		assert.NoError(t, err)
+		assert.Equal(t, fs.ModeSymlink, s.Mode().Type())
+	})
+
+	t.Run("too new", func(t *testing.T) {
+		_, err := ParseManifest([]byte(`{"version": 999, "base": "", "tree": {}}`))
+		assert.Error(t, err)
+	})
+}
+
+func TestSymlinks(t *testing.T) {
+	server := newTestServer(t, map[string][]byte{
+		"/dir/a.txt": []byte("hello"),
+	})
+
+	httpfs := New(*server.Client(), FileTree{
+		"dir": FileTree{
+			"a.txt": FileInfo{Size: 5},
+			"rel":   FileInfo{Size: 5, Target: "a.txt"},
+		},
+		"abs":    FileInfo{Size: 6, Target: "/dir/a.txt"},
+		"dirln":  FileInfo{Size: 3, Target: "dir"},
+		"loop":   FileInfo{Size: 4, Target: "loop"},
+		"broken": FileInfo{Size: 4, Target: "nope"},
+	}, server.URL)
+
+	for _, name := range []string{"dir/rel", "abs", "dirln/a.txt", "dirln/rel"} {
+		b, err := fs.ReadFile(httpfs, name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, "hello", string(b), name)
+	}
+
+	target, err := httpfs.Readlink("dirln/rel")
+	assert.NoError(t, err)
+	assert.Equal(t, "a.txt", target)
+
+	_, err = httpfs.Readlink("dir/a.txt")
+	assert.True(t, errors.Is(err, fs.ErrInvalid))
+
+	_, err = httpfs.Open("loop")
+	assert.True(t, errors.Is(err, rwfs.ErrSymlinkLoop))
+
+	_, err = httpfs.Open("broken")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	entries, err := fs.ReadDir(httpfs, ".")
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		if entry.Name() == "abs" {
+			assert.Equal(t, fs.ModeSymlink, entry.Type())
+		}
+	}
+}
+
+func TestSubManifest(t *testing.T) {
+	server := newTestServer(t, map[string][]byte{
+		"/big/_fs.json":         []byte(`{"version": 3, "tree": {"a.txt": {"size": 5}, "sub/": "sub/_fs.json"}}`),
+		"/big/a.txt":            []byte("hello"),
+		"/big/sub/_fs.json":     []byte(`{"version": 3, "tree": {"b.txt": {"size": 5}}}`),
+		"/big/sub/b.txt":        []byte("world"),
+		"/manifests/other.json": []byte(`{"version": 3, "tree": {}}`),
+	})
+
+	m, err := ParseManifest([]byte(`{
+		"version": 3,
+		"base": "",
+		"tree": {
+			"big/": "big/_fs.json",
+			"other/": "/manifests/other.json",
+			"gone/": "gone/_fs.json"
+		}
+	}`))
+	assert.NoError(t, err)
+
+	httpfs := New(*server.Client(), m.Tree, server.URL)
+
+	s, err := fs.Stat(httpfs, "big")
+	assert.NoError(t, err)
+	assert.True(t, s.IsDir())
+	assert.Equal(t, 0, len(server.take()), "stat of the directory itself shouldn't fetch")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := fs.ReadFile(httpfs, "big/a.txt")
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(b))
+		}()
+	}
+	wg.Wait()
+
+	var manifests int
+	for _, r := range server.take() {
+		if r.path == "/big/_fs.json" {
+			manifests++
+		}
+	}
+	assert.Equal(t, 1, manifests)
+
+	_, err = httpfs.Open("big/nope")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	_, err = httpfs.Open("big/sub/nope/c")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	b, err := fs.ReadFile(httpfs, "big/sub/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(b))
+
+	entries, err := fs.ReadDir(httpfs, "big")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.True(t, entries[1].IsDir())
+
+	entries, err = fs.ReadDir(httpfs, "other")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+
+	_, err = httpfs.Open("gone/x")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, fs.ErrNotExist))
+
+	out, err := json.Marshal(m.Tree)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"big/":"big/_fs.json","gone/":"gone/_fs.json","other/":"/manifests/other.json"}`, string(out))
+}
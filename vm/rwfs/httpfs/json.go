@@ -1,21 +1,96 @@
 package httpfs
 
 import (
+	"bytes"
 	"encoding/json"
+	"io/fs"
 	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
 )
 
-// FileTreeValue is either a FileTree or a FileSize.
+// ManifestVersion is the latest version of the manifest format. Version 1 is
+// the original format, whose files only have a size. Version 2 adds file
+// metadata, and version 3 adds sub-manifests. Later versions only add to the
+// format, so every version can be read as the latest one.
+const ManifestVersion = 3
+
+// Manifest describes the files served under a base path. It is usually
+// generated by cmd/jsonfs.
+type Manifest struct {
+	// Version is the version of the manifest format. It is 0 for manifests
+	// made before it existed, which are the same as version 1.
+	Version int `json:"version,omitempty"`
+	// Base is the path that the files are served under.
+	Base string `json:"base"`
+	// Tree is the root directory.
+	Tree FileTree `json:"tree"`
+}
+
+// ParseManifest parses a JSON manifest. Manifests of a version newer than
+// ManifestVersion are rejected.
+func ParseManifest(b []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrap(err, "cannot parse manifest")
+	}
+	if m.Version > ManifestVersion {
+		return nil, errors.Errorf("unsupported manifest version %d", m.Version)
+	}
+	return &m, nil
+}
+
+// FileTreeValue is either a FileTree, a *SubManifest or a FileSize.
 type FileTreeValue interface {
 	fileTreeValue()
 }
 
-func (FileInfo) fileTreeValue() {}
-func (FileTree) fileTreeValue() {}
+func (FileInfo) fileTreeValue()     {}
+func (FileTree) fileTreeValue()     {}
+func (*SubManifest) fileTreeValue() {}
+
+// SubManifest is a directory whose tree is in a separate manifest, which is
+// only fetched once the directory is opened or something in it is looked up.
+// In JSON, it is the manifest's path in place of the directory's tree.
+//
+// The manifest has the same format as a Manifest, except that its base is
+// ignored, since its files are served under the directory's path.
+type SubManifest struct {
+	// Path is the path of the manifest. Relative paths are relative to the
+	// directory that has the entry, and absolute ones to the root of the tree.
+	Path string
+
+	mu   sync.Mutex
+	tree FileTree
+}
 
-// FileInfo is the information of a file.
+func (m *SubManifest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Path)
+}
+
+// FileInfo is the information of a file. Only Size is required.
 type FileInfo struct {
 	Size int64 `json:"size"`
+	// ModTime is the modification time in Unix seconds.
+	ModTime int64 `json:"mtime,omitempty"`
+	// Mode is the file's permission bits. Files without one are 0444.
+	Mode fs.FileMode `json:"mode,omitempty"`
+	// SHA256 is the hex-encoded SHA-256 hash of the file's contents, if
+	// known. Files with a hash are cached by it, so they never need to be
+	// revalidated.
+	SHA256 string `json:"sha256,omitempty"`
+	// ContentType is the file's MIME type, if known.
+	ContentType string `json:"type,omitempty"`
+	// Target is the target of the file if it is a symbolic link. Relative
+	// targets are relative to the link's directory, and absolute ones to the
+	// root of the tree.
+	Target string `json:"target,omitempty"`
+}
+
+// IsSymlink returns true if the file is a symbolic link.
+func (i FileInfo) IsSymlink() bool {
+	return i.Target != ""
 }
 
 // FileTree is a tree of files and directories. Directories will have its keys
@@ -31,7 +106,14 @@ func (t *FileTree) UnmarshalJSON(b []byte) error {
 	*t = make(FileTree)
 
 	for k, b := range m {
-		if strings.HasSuffix(k, "/") {
+		if strings.HasSuffix(k, "/") && bytes.HasPrefix(bytes.TrimSpace(b), []byte(`"`)) {
+			var v SubManifest
+			if err := json.Unmarshal(b, &v.Path); err != nil {
+				return err
+			}
+			k = strings.TrimSuffix(k, "/")
+			(*t)[k] = &v
+		} else if strings.HasSuffix(k, "/") {
 			var v FileTree
 			if err := json.Unmarshal(b, &v); err != nil {
 				return err
@@ -49,3 +131,15 @@ func (t *FileTree) UnmarshalJSON(b []byte) error {
 
 	return nil
 }
+
+func (t FileTree) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(t))
+	for k, v := range t {
+		switch v.(type) {
+		case FileTree, *SubManifest:
+			k += "/"
+		}
+		m[k] = v
+	}
+	return json.Marshal(m)
+}
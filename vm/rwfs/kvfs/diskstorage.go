@@ -0,0 +1,535 @@
+//go:build !js
+
+package kvfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// diskMagic is written at the start of every DiskStorage file.
+const diskMagic = "KVFSLOG1"
+
+// diskHeaderSize is the size of a record's header: the length of its payload
+// followed by the payload's checksum.
+const diskHeaderSize = 8
+
+// diskCompactMin is the minimum number of dead bytes in the log before
+// DiskStorage compacts it by itself.
+const diskCompactMin = 1 << 20
+
+const (
+	diskOpSet    byte = 's'
+	diskOpDelete byte = 'd'
+)
+
+var diskCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// DiskStorage is a Store that persists values into a single append-only log
+// file. Values are encoded as JSON, the same way LocalStorage encodes them, so
+// they can be moved between browser and native hosts.
+//
+// Each change is appended to the log as a checksummed record and synced
+// before it is applied, so a crash never leaves half a change behind: a torn
+// record at the end of the log is dropped the next time it is opened. The log
+// is compacted once most of it is taken up by overwritten values.
+//
+// Only the keys and the locations of their values are kept in memory. The
+// keys are kept sorted, so List only visits the keys under its prefix.
+//
+// A file must not be opened by more than one DiskStorage at a time.
+type DiskStorage struct {
+	notifier
+	mu    sync.RWMutex
+	f     *os.File
+	path  string
+	index map[string]diskValue
+	keys  []string // sorted
+	size  int64    // end of the last record
+	dead  int64    // bytes taken by overwritten or deleted values
+}
+
+// diskValue is the location of a value within the log.
+type diskValue struct {
+	off int64
+	len int64
+}
+
+var (
+	_ RenameStore = (*DiskStorage)(nil)
+	_ TxStore     = (*DiskStorage)(nil)
+	_ NotifyStore = (*DiskStorage)(nil)
+)
+
+// OpenDiskStorage opens the DiskStorage at the given path, creating it if it
+// does not exist.
+func OpenDiskStorage(path string) (*DiskStorage, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &DiskStorage{
+		f:     f,
+		path:  path,
+		index: make(map[string]diskValue),
+	}
+
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "load", Path: path, Err: err}
+	}
+
+	return s, nil
+}
+
+// load reads the whole log into the index. A torn or corrupted record ends
+// the log, so it is truncated there.
+func (s *DiskStorage) load() error {
+	stat, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() == 0 {
+		if _, err := s.f.WriteAt([]byte(diskMagic), 0); err != nil {
+			return err
+		}
+		s.size = int64(len(diskMagic))
+		return s.f.Sync()
+	}
+
+	magic := make([]byte, len(diskMagic))
+	if _, err := s.f.ReadAt(magic, 0); err != nil || string(magic) != diskMagic {
+		return errors.New("not a kvfs log")
+	}
+
+	r := io.NewSectionReader(s.f, 0, stat.Size())
+	s.size = int64(len(diskMagic))
+
+	var header [diskHeaderSize]byte
+	for {
+		if _, err := r.ReadAt(header[:], s.size); err != nil {
+			break
+		}
+
+		n := int64(binary.LittleEndian.Uint32(header[0:]))
+		sum := binary.LittleEndian.Uint32(header[4:])
+		if s.size+diskHeaderSize+n > stat.Size() {
+			break
+		}
+
+		payload := make([]byte, n)
+		if _, err := r.ReadAt(payload, s.size+diskHeaderSize); err != nil {
+			break
+		}
+		if crc32.Checksum(payload, diskCRC) != sum {
+			break
+		}
+
+		ops, err := decodeDiskRecord(payload, s.size+diskHeaderSize)
+		if err != nil {
+			break
+		}
+
+		s.apply(ops)
+		s.size += diskHeaderSize + n
+	}
+
+	if s.size < stat.Size() {
+		if err := s.f.Truncate(s.size); err != nil {
+			return errors.Wrap(err, "cannot drop torn record")
+		}
+		return s.f.Sync()
+	}
+
+	return nil
+}
+
+// diskOp is a single change within a record.
+type diskOp struct {
+	op    byte
+	key   string
+	value []byte
+	// off is the offset of value within the log. It is only set once the
+	// record is written.
+	off int64
+}
+
+// encodeDiskRecord encodes the given changes into a record, header included.
+// Each op's off is set relative to the start of the record.
+func encodeDiskRecord(ops []diskOp) []byte {
+	buf := make([]byte, diskHeaderSize, 64)
+	for i, op := range ops {
+		buf = append(buf, op.op)
+		buf = binary.AppendUvarint(buf, uint64(len(op.key)))
+		buf = append(buf, op.key...)
+		if op.op == diskOpSet {
+			buf = binary.AppendUvarint(buf, uint64(len(op.value)))
+			ops[i].off = int64(len(buf))
+			buf = append(buf, op.value...)
+		}
+	}
+
+	payload := buf[diskHeaderSize:]
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(payload, diskCRC))
+	return buf
+}
+
+// decodeDiskRecord decodes the payload of a record that starts at the given
+// offset of the log.
+func decodeDiskRecord(payload []byte, off int64) ([]diskOp, error) {
+	var ops []diskOp
+	r := bytes.NewReader(payload)
+
+	readBytes := func() ([]byte, int64, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return nil, 0, errors.New("invalid length")
+		}
+		pos := int64(len(payload) - r.Len())
+		b := payload[pos : pos+int64(n)]
+		r.Seek(int64(n), io.SeekCurrent)
+		return b, off + pos, nil
+	}
+
+	for r.Len() > 0 {
+		kind, _ := r.ReadByte()
+		if kind != diskOpSet && kind != diskOpDelete {
+			return nil, errors.Errorf("unknown op %q", kind)
+		}
+
+		key, _, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+
+		op := diskOp{op: kind, key: string(key)}
+		if kind == diskOpSet {
+			op.value, op.off, err = readBytes()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// apply applies the given changes to the index. Their values must already be
+// in the log.
+func (s *DiskStorage) apply(ops []diskOp) {
+	for _, op := range ops {
+		old, exists := s.index[op.key]
+		if exists {
+			s.dead += old.len
+		}
+
+		switch op.op {
+		case diskOpSet:
+			s.index[op.key] = diskValue{off: op.off, len: int64(len(op.value))}
+			if !exists {
+				i := sort.SearchStrings(s.keys, op.key)
+				s.keys = append(s.keys, "")
+				copy(s.keys[i+1:], s.keys[i:])
+				s.keys[i] = op.key
+			}
+		case diskOpDelete:
+			if exists {
+				delete(s.index, op.key)
+				i := sort.SearchStrings(s.keys, op.key)
+				s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			}
+		}
+	}
+}
+
+// write appends the given changes to the log as a single record, syncs it and
+// then applies it. The caller must hold the write lock.
+func (s *DiskStorage) write(ops []diskOp) error {
+	record := encodeDiskRecord(ops)
+
+	if _, err := s.f.WriteAt(record, s.size); err != nil {
+		// Don't leave the partial record behind for the next write.
+		s.f.Truncate(s.size)
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+
+	for i := range ops {
+		ops[i].off += s.size
+	}
+	s.size += int64(len(record))
+	s.apply(ops)
+
+	if s.dead > diskCompactMin && s.dead > s.size/2 {
+		// The changes are already durable, so a failed compaction is left for
+		// the next write to retry.
+		if err := s.compact(); err != nil {
+			log.Printf("kvfs: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// read reads the raw value of the given key. The caller must hold the lock.
+func (s *DiskStorage) read(key string) ([]byte, error) {
+	v, ok := s.index[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	b := make([]byte, v.len)
+	if _, err := s.f.ReadAt(b, v.off); err != nil {
+		return nil, errors.Wrapf(err, "cannot read %q", key)
+	}
+
+	return b, nil
+}
+
+func (s *DiskStorage) get(key string) (StoredValue, error) {
+	b, err := s.read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := UnmarshalStoredValue(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "file contains invalid json")
+	}
+
+	return v, nil
+}
+
+func (s *DiskStorage) Get(fullpath string) (StoredValue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.get(fullpath)
+}
+
+func (s *DiskStorage) Set(fullpath string, v StoredValue) error {
+	return s.Apply([]Change{{Path: fullpath, Value: v}})
+}
+
+func (s *DiskStorage) Delete(fullpath string) error {
+	return s.Apply([]Change{{Path: fullpath}})
+}
+
+func (s *DiskStorage) Apply(changes []Change) error {
+	ops := make([]diskOp, len(changes))
+	for i, c := range changes {
+		if c.Value == nil {
+			ops[i] = diskOp{op: diskOpDelete, key: c.Path}
+			continue
+		}
+
+		b, err := json.Marshal(c.Value)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal value")
+		}
+
+		ops[i] = diskOp{op: diskOpSet, key: c.Path, value: b}
+	}
+
+	s.mu.Lock()
+	err := s.write(ops)
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	s.notify(changedPaths(changes))
+	return nil
+}
+
+func (s *DiskStorage) List(prefix string, recursive bool) ([]PathedStoreValue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var values []PathedStoreValue
+
+	i := sort.SearchStrings(s.keys, prefix)
+	for i < len(s.keys) && strings.HasPrefix(s.keys[i], prefix) {
+		key := s.keys[i]
+
+		if !recursive {
+			name := strings.TrimPrefix(key, prefix)
+			if j := strings.IndexByte(name, '/'); j != -1 {
+				// Skip everything under this child. '0' sorts right
+				// after '/'.
+				i = sort.SearchStrings(s.keys, prefix+name[:j]+"0")
+				continue
+			}
+		}
+
+		v, err := s.get(key)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, PathedStoreValue{
+			StoredValue: v,
+			Path:        key,
+		})
+		i++
+	}
+
+	return values, nil
+}
+
+func (s *DiskStorage) Rename(oldpath, newpath string) error {
+	s.mu.Lock()
+	ops, err := s.rename(oldpath, newpath)
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, len(ops))
+	for i, op := range ops {
+		paths[i] = op.key
+	}
+
+	s.notify(paths)
+	return nil
+}
+
+func (s *DiskStorage) rename(oldpath, newpath string) ([]diskOp, error) {
+	if _, ok := s.index[oldpath]; !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	moved := []string{oldpath}
+	for i := sort.SearchStrings(s.keys, oldpath+"/"); i < len(s.keys); i++ {
+		if !strings.HasPrefix(s.keys[i], oldpath+"/") {
+			break
+		}
+		moved = append(moved, s.keys[i])
+	}
+
+	ops := make([]diskOp, 0, 2*len(moved))
+	for _, key := range moved {
+		ops = append(ops, diskOp{op: diskOpDelete, key: key})
+	}
+	for _, key := range moved {
+		b, err := s.read(key)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, diskOp{
+			op:    diskOpSet,
+			key:   newpath + strings.TrimPrefix(key, oldpath),
+			value: b,
+		})
+	}
+
+	return ops, s.write(ops)
+}
+
+// Compact rewrites the log so that it only contains the current values. It
+// is done automatically once enough of the log is dead, so it rarely needs to
+// be called.
+func (s *DiskStorage) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+// compact writes the current values into a new log next to the old one, then
+// renames it over the old one.
+func (s *DiskStorage) compact() error {
+	tmpPath := s.path + ".compact"
+
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "cannot create compacted log")
+	}
+
+	index, size, err := s.copyTo(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "cannot compact log")
+	}
+
+	// Make sure the rename itself is durable. Not every platform can sync a
+	// directory, so this is best-effort.
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	s.f.Close()
+	s.f = tmp
+	s.index = index
+	s.size = size
+	s.dead = 0
+
+	return nil
+}
+
+// copyTo writes every current value into w as a new log. The new index and
+// size of the log are returned.
+func (s *DiskStorage) copyTo(w io.Writer) (map[string]diskValue, int64, error) {
+	index := make(map[string]diskValue, len(s.keys))
+
+	size, err := io.WriteString(w, diskMagic)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, key := range s.keys {
+		b, err := s.read(key)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		ops := []diskOp{{op: diskOpSet, key: key, value: b}}
+		record := encodeDiskRecord(ops)
+
+		if _, err := w.Write(record); err != nil {
+			return nil, 0, err
+		}
+
+		index[key] = diskValue{off: int64(size) + ops[0].off, len: int64(len(b))}
+		size += len(record)
+	}
+
+	return index, int64(size), nil
+}
+
+// Close closes the underlying file.
+func (s *DiskStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.f.Close()
+}
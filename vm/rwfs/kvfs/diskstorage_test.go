@@ -0,0 +1,101 @@
+//go:build !js
+
+package kvfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestDiskStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvfs.db")
+
+	store, err := OpenDiskStorage(path)
+	assert.NoError(t, err)
+
+	rwfs := New(store)
+	assert.NoError(t, rwfs.MkdirAll("a/b", 0755))
+	writeFile(t, rwfs, "a/b/c", "hello")
+	writeFile(t, rwfs, "a/d", "world")
+	writeFile(t, rwfs, "a/d", "world!")
+	assert.NoError(t, rwfs.Rename("a/b", "a/e"))
+	assert.NoError(t, store.Close())
+
+	assertTree := func(t *testing.T, store Store) {
+		t.Helper()
+
+		rwfs := New(store)
+
+		b, err := fs.ReadFile(rwfs, "a/e/c")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+
+		b, err = fs.ReadFile(rwfs, "a/d")
+		assert.NoError(t, err)
+		assert.Equal(t, "world!", string(b))
+
+		_, err = fs.Stat(rwfs, "a/b")
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+		values, err := store.List("/a/", false)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(values))
+		assert.Equal(t, "/a/d", values[0].Path)
+		assert.Equal(t, "/a/e", values[1].Path)
+	}
+
+	t.Run("reopen", func(t *testing.T) {
+		store, err := OpenDiskStorage(path)
+		assert.NoError(t, err)
+		defer store.Close()
+
+		assertTree(t, store)
+	})
+
+	t.Run("torn", func(t *testing.T) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		assert.NoError(t, err)
+		_, err = f.Write([]byte{0xff, 0x00, 0x00, 0x00, 's'})
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+
+		store, err := OpenDiskStorage(path)
+		assert.NoError(t, err)
+		defer store.Close()
+
+		assertTree(t, store)
+		writeFile(t, New(store), "a/f", "after")
+
+		b, err := fs.ReadFile(New(store), "a/f")
+		assert.NoError(t, err)
+		assert.Equal(t, "after", string(b))
+		assert.NoError(t, New(store).Remove("a/f"))
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		store, err := OpenDiskStorage(path)
+		assert.NoError(t, err)
+		defer store.Close()
+
+		before, err := os.Stat(path)
+		assert.NoError(t, err)
+
+		assert.NoError(t, store.Compact())
+		assertTree(t, store)
+
+		after, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.True(t, after.Size() < before.Size())
+
+		assert.NoError(t, store.Close())
+
+		store, err = OpenDiskStorage(path)
+		assert.NoError(t, err)
+		assertTree(t, store)
+	})
+}
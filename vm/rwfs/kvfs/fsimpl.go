@@ -1,19 +1,21 @@
 package kvfs
 
 import (
-	"bytes"
+	"io"
 	"io/fs"
 	"os"
 	"path"
+	"sync"
 	"sync/atomic"
 	"time"
-)
 
-// dmask is the default mask.
-const dmask = 0777
+	"github.com/pkg/errors"
+	"libdb.so/vm/rwfs"
+)
 
 type fsFileInfo struct {
 	path string
+	name string // overrides the base of path if not empty
 	size int64
 	time time.Time
 	mode fs.FileMode
@@ -24,8 +26,8 @@ var _ fs.FileInfo = fsFileInfo{}
 func dirInfo(store Store, path string, d StoredDirectory) fsFileInfo {
 	return fsFileInfo{
 		path: path,
-		time: time.Unix(dirMtime(store, path, d.CreateTime), 0),
-		mode: dmask | os.ModeDir,
+		time: time.Unix(dirMtime(store, path, d), 0),
+		mode: storedMode(d.Mode) | os.ModeDir,
 	}
 }
 
@@ -34,13 +36,37 @@ func fileInfo(path string, f StoredFile) fsFileInfo {
 		path: path,
 		size: int64(len(f.Data)),
 		time: time.Unix(f.ModTime, 0),
-		mode: dmask,
+		mode: storedMode(f.Mode),
 	}
 }
 
-func (stat fsFileInfo) Name() string       { return path.Base(stat.path) }
+// symlinkInfo returns the file info of a symbolic link. Like on Linux, the
+// permission bits of links are always 0777.
+func symlinkInfo(path string, l StoredSymlink) fsFileInfo {
+	return fsFileInfo{
+		path: path,
+		size: int64(len(l.Target)),
+		time: time.Unix(l.ModTime, 0),
+		mode: 0777 | os.ModeSymlink,
+	}
+}
+
+// withName returns stat with its name overridden, which is used when stat is
+// for the target of a symbolic link.
+func (stat fsFileInfo) withName(name string) fsFileInfo {
+	stat.name = name
+	return stat
+}
+
+func (stat fsFileInfo) Name() string {
+	if stat.name != "" {
+		return stat.name
+	}
+	return path.Base(stat.path)
+}
+
 func (stat fsFileInfo) Size() int64        { return stat.size }
-func (stat fsFileInfo) ModTime() time.Time { return time.Time{} }
+func (stat fsFileInfo) ModTime() time.Time { return stat.time }
 func (stat fsFileInfo) IsDir() bool        { return stat.mode&os.ModeDir != 0 }
 func (stat fsFileInfo) Sys() any           { return stat }
 func (stat fsFileInfo) Mode() fs.FileMode  { return stat.mode }
@@ -48,44 +74,198 @@ func (stat fsFileInfo) Mode() fs.FileMode  { return stat.mode }
 func (stat fsFileInfo) Type() fs.FileMode          { return stat.mode.Type() }
 func (stat fsFileInfo) Info() (fs.FileInfo, error) { return stat, nil }
 
+// fileData is the data of an open file. All handles of the file share it, so
+// that they see each other's writes like on any other filesystem. It is
+// written back to the store whenever a handle that can write is closed.
+type fileData struct {
+	mu   sync.Mutex
+	data []byte
+	// refs is the number of open handles. It is guarded by FS.filesMu.
+	refs int
+}
+
 type fsFile struct {
 	parent *FS
 	info   fsFileInfo
-	buf    bytes.Buffer
 	flag   int // open mode
 	closed int32
+
+	*fileData
+	off int64 // guarded by fileData.mu
 }
 
-var _ fs.File = (*fsFile)(nil)
+var (
+	_ fs.File           = (*fsFile)(nil)
+	_ rwfs.SeekableFile = (*fsFile)(nil)
+)
+
+func newFile(parent *FS, info fsFileInfo, data *fileData, flag int) *fsFile {
+	return &fsFile{
+		parent:   parent,
+		info:     info,
+		flag:     flag,
+		fileData: data,
+	}
+}
 
 func (f *fsFile) Stat() (fs.FileInfo, error) {
-	return f.info, nil
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info := f.info
+	info.size = int64(len(f.data))
+	return info, nil
 }
 
 func (f *fsFile) Read(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.readAt(b, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *fsFile) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, f.pathErr("read", errors.New("negative offset"))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.readAt(b, off)
+	if err == nil && n < len(b) {
+		// ReadAt must return an error if it reads less than len(b).
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *fsFile) readAt(b []byte, off int64) (int, error) {
 	if !flagRead(f.flag) {
 		return 0, fs.ErrPermission
 	}
-	return f.buf.Read(b)
+	if off >= int64(len(f.data)) {
+		if len(b) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	return copy(b, f.data[off:]), nil
 }
 
 func (f *fsFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if flagHas(f.flag, os.O_APPEND) {
+		f.off = int64(len(f.data))
+	}
+
+	n, err := f.writeAt(b, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *fsFile) WriteAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, f.pathErr("write", errors.New("negative offset"))
+	}
+	if flagHas(f.flag, os.O_APPEND) {
+		// Same as os.File.
+		return 0, f.pathErr("write", errors.New("invalid use of WriteAt on file opened with O_APPEND"))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.writeAt(b, off)
+}
+
+// writeAt writes b at the given offset, overwriting existing data. If the
+// offset is past the end of the file, then the gap is filled with zeros.
+func (f *fsFile) writeAt(b []byte, off int64) (int, error) {
 	if !flagWrite(f.flag) {
 		return 0, fs.ErrPermission
 	}
-	return f.buf.Write(b)
+
+	if end := off + int64(len(b)); end > int64(len(f.data)) {
+		f.resize(end)
+	}
+	return copy(f.data[off:], b), nil
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	default:
+		return 0, f.pathErr("seek", fs.ErrInvalid)
+	}
+
+	if offset < 0 {
+		return 0, f.pathErr("seek", fs.ErrInvalid)
+	}
+
+	f.off = offset
+	return offset, nil
+}
+
+func (f *fsFile) Truncate(size int64) error {
+	if size < 0 {
+		return f.pathErr("truncate", fs.ErrInvalid)
+	}
+	if !flagWrite(f.flag) {
+		return f.pathErr("truncate", fs.ErrPermission)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.resize(size)
+	return nil
+}
+
+// resize resizes the data to the given size, filling new bytes with zeros.
+func (f *fsFile) resize(size int64) {
+	if size <= int64(len(f.data)) {
+		f.data = f.data[:size]
+		return
+	}
+	if size <= int64(cap(f.data)) {
+		n := len(f.data)
+		f.data = f.data[:size]
+		clear(f.data[n:])
+		return
+	}
+	data := make([]byte, size, max(size, int64(2*cap(f.data))))
+	copy(data, f.data)
+	f.data = data
 }
 
 func (f *fsFile) Close() error {
 	if !atomic.CompareAndSwapInt32(&f.closed, 0, 1) {
 		return fs.ErrClosed
 	}
+	defer f.parent.closeData(f.info.path, f.fileData)
+
 	if flagWrite(f.flag) {
 		return f.parent.write(f)
 	}
 	return nil
 }
 
+func (f *fsFile) pathErr(op string, err error) error {
+	return pathErr(op, f.info.path, err)
+}
+
 type fsDir struct {
 	parent *FS
 	info   fsFileInfo
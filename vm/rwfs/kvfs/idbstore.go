@@ -0,0 +1,544 @@
+package kvfs
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrQuotaExceeded is returned when a Store runs out of space.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// IDBBlockSize is the size of the blocks that IDBStore splits file contents
+// into.
+const IDBBlockSize = 64 << 10
+
+// The object stores that IDBStore keeps its data in.
+const (
+	// IDBMetaStore maps each path to its idbMeta.
+	IDBMetaStore = "meta"
+	// IDBBlockStore maps each block of a file to its contents.
+	IDBBlockStore = "blocks"
+	// IDBDirStore maps each directory prefix to the sorted names of the
+	// paths directly within it.
+	IDBDirStore = "dirs"
+)
+
+// IDBStores lists every object store that an IDBBackend must have.
+var IDBStores = []string{IDBMetaStore, IDBBlockStore, IDBDirStore}
+
+// IDBBackend is the database that IDBStore keeps its data in. It is shaped
+// after IndexedDB, which implements it in the browser, while MemoryIDB
+// implements it for tests.
+type IDBBackend interface {
+	// Get returns the values of the given keys within an object store. Keys
+	// that don't exist have a nil value.
+	Get(store string, keys []string) ([][]byte, error)
+	// Write applies all of the given writes in order within a single
+	// transaction. Either all of them are applied or none of them are.
+	Write(writes []IDBWrite) error
+	// Estimate returns the number of bytes used and the number of bytes
+	// available in total. A quota of 0 means that it is unknown.
+	Estimate() (usage, quota int64, err error)
+}
+
+// IDBWrite is a single write within IDBBackend.Write.
+type IDBWrite struct {
+	Store string
+	Key   string
+	// Value is the new value. The key is deleted if it is nil.
+	Value []byte
+}
+
+// idbMeta is what IDBStore keeps for each path. For files, the data is kept
+// separately in blocks.
+type idbMeta struct {
+	// Value is the JSON of the StoredValue, encoded like LocalStorage does
+	// it but without a file's data.
+	Value json.RawMessage `json:"value"`
+	// Size is the size of a file's data.
+	Size int64 `json:"size,omitempty"`
+	// Blocks has a hash of each block of a file's data. Only blocks whose
+	// hash changed are written again.
+	Blocks []string `json:"blocks,omitempty"`
+}
+
+// IDBStore is a Store that keeps its values in an IDBBackend, usually
+// IndexedDB. File contents are split into blocks of IDBBlockSize so that
+// small changes to large files only rewrite the blocks that changed, and every
+// directory has an index of its entries so that listing it doesn't need to
+// look at any other key.
+type IDBStore struct {
+	notifier
+	db IDBBackend
+	mu sync.Mutex
+
+	// broadcast, if not nil, is called with the paths of every change after
+	// the local subscribers. It is used to tell other instances of the same
+	// database about them.
+	broadcast func(paths []string)
+}
+
+var (
+	_ RenameStore = (*IDBStore)(nil)
+	_ TxStore     = (*IDBStore)(nil)
+	_ NotifyStore = (*IDBStore)(nil)
+	_ UsageStore  = (*IDBStore)(nil)
+)
+
+// NewIDBStore creates a new IDBStore on top of the given backend.
+func NewIDBStore(db IDBBackend) *IDBStore {
+	return &IDBStore{db: db}
+}
+
+// Usage returns the number of bytes used and the number of bytes available in
+// total. A quota of 0 means that it is unknown.
+func (s *IDBStore) Usage() (used, quota int64, err error) {
+	return s.db.Estimate()
+}
+
+func (s *IDBStore) Get(fullpath string) (StoredValue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values, err := s.values([]string{fullpath})
+	if err != nil {
+		return nil, err
+	}
+	if values[0] == nil {
+		return nil, fs.ErrNotExist
+	}
+
+	return values[0], nil
+}
+
+func (s *IDBStore) Set(fullpath string, v StoredValue) error {
+	return s.Apply([]Change{{Path: fullpath, Value: v}})
+}
+
+func (s *IDBStore) Delete(fullpath string) error {
+	return s.Apply([]Change{{Path: fullpath}})
+}
+
+func (s *IDBStore) Apply(changes []Change) error {
+	return s.change(func(b *idbBatch) error {
+		for _, c := range changes {
+			var err error
+			if c.Value == nil {
+				err = b.delete(c.Path)
+			} else {
+				err = b.set(c.Path, c.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *IDBStore) List(prefix string, recursive bool) ([]PathedStoreValue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.list(prefix, recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := s.values(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	pathed := make([]PathedStoreValue, 0, len(keys))
+	for i, v := range values {
+		if v != nil {
+			pathed = append(pathed, PathedStoreValue{StoredValue: v, Path: keys[i]})
+		}
+	}
+
+	return pathed, nil
+}
+
+func (s *IDBStore) Rename(oldpath, newpath string) error {
+	return s.change(func(b *idbBatch) error {
+		keys, err := s.list(oldpath+"/", true)
+		if err != nil {
+			return err
+		}
+		keys = append([]string{oldpath}, keys...)
+
+		values, err := s.values(keys)
+		if err != nil {
+			return err
+		}
+		if values[0] == nil {
+			return fs.ErrNotExist
+		}
+
+		for _, key := range keys {
+			if err := b.delete(key); err != nil {
+				return err
+			}
+		}
+		for i, key := range keys {
+			if values[i] == nil {
+				continue
+			}
+			if err := b.set(newpath+strings.TrimPrefix(key, oldpath), values[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// change calls fn with a new batch and commits it, then tells subscribers
+// about the paths that it changed.
+func (s *IDBStore) change(fn func(b *idbBatch) error) error {
+	s.mu.Lock()
+	b := s.batch()
+	err := fn(b)
+	if err == nil {
+		err = b.commit()
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	s.notify(b.paths)
+	if s.broadcast != nil {
+		s.broadcast(b.paths)
+	}
+
+	return nil
+}
+
+// list returns the keys within the given prefix using the directory index.
+func (s *IDBStore) list(prefix string, recursive bool) ([]string, error) {
+	var keys []string
+
+	prefixes := []string{prefix}
+	for len(prefixes) > 0 {
+		raws, err := s.db.Get(IDBDirStore, prefixes)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read directory index")
+		}
+
+		var next []string
+		for i, raw := range raws {
+			names, err := decodeIDBDir(raw)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid directory index for %q", prefixes[i])
+			}
+
+			for _, name := range names {
+				keys = append(keys, prefixes[i]+name)
+				if recursive && name != "" {
+					next = append(next, prefixes[i]+name+"/")
+				}
+			}
+		}
+
+		prefixes = next
+	}
+
+	return keys, nil
+}
+
+// values returns the values of the given keys. Keys that don't exist have a
+// nil value.
+func (s *IDBStore) values(keys []string) ([]StoredValue, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	raws, err := s.db.Get(IDBMetaStore, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]StoredValue, len(keys))
+	metas := make([]idbMeta, len(keys))
+	var blockKeys []string
+
+	for i, raw := range raws {
+		if raw == nil {
+			continue
+		}
+		if err := json.Unmarshal(raw, &metas[i]); err != nil {
+			return nil, errors.Wrapf(err, "invalid metadata for %q", keys[i])
+		}
+		for j := range metas[i].Blocks {
+			blockKeys = append(blockKeys, idbBlockKey(keys[i], j))
+		}
+	}
+
+	var blocks [][]byte
+	if len(blockKeys) > 0 {
+		blocks, err = s.db.Get(IDBBlockStore, blockKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, raw := range raws {
+		if raw == nil {
+			continue
+		}
+
+		v, err := UnmarshalStoredValue(metas[i].Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value for %q", keys[i])
+		}
+
+		if f, ok := v.(StoredFile); ok && len(metas[i].Blocks) > 0 {
+			data := make([]byte, 0, metas[i].Size)
+			for range metas[i].Blocks {
+				if blocks[0] == nil {
+					return nil, errors.Errorf("missing block in %q", keys[i])
+				}
+				data = append(data, blocks[0]...)
+				blocks = blocks[1:]
+			}
+			f.Data = data
+			v = f
+		}
+
+		values[i] = v
+	}
+
+	return values, nil
+}
+
+func (s *IDBStore) batch() *idbBatch {
+	return &idbBatch{
+		s:     s,
+		metas: make(map[string]*idbMeta),
+		dirs:  make(map[string][]string),
+	}
+}
+
+// idbBatch collects writes to be committed in a single transaction. It
+// tracks the metadata and directory indices that it changed, so that later
+// changes within the batch build on earlier ones.
+type idbBatch struct {
+	s      *IDBStore
+	writes []IDBWrite
+	metas  map[string]*idbMeta // nil if deleted
+	dirs   map[string][]string
+	paths  []string
+}
+
+func (b *idbBatch) meta(key string) (*idbMeta, error) {
+	if m, ok := b.metas[key]; ok {
+		return m, nil
+	}
+
+	raws, err := b.s.db.Get(IDBMetaStore, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	if raws[0] == nil {
+		return nil, nil
+	}
+
+	var m idbMeta
+	if err := json.Unmarshal(raws[0], &m); err != nil {
+		return nil, errors.Wrapf(err, "invalid metadata for %q", key)
+	}
+
+	return &m, nil
+}
+
+func (b *idbBatch) dir(prefix string) ([]string, error) {
+	if names, ok := b.dirs[prefix]; ok {
+		return names, nil
+	}
+
+	raws, err := b.s.db.Get(IDBDirStore, []string{prefix})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read directory index")
+	}
+
+	return decodeIDBDir(raws[0])
+}
+
+func (b *idbBatch) set(key string, v StoredValue) error {
+	old, err := b.meta(key)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if f, ok := v.(StoredFile); ok {
+		data = f.Data
+		f.Data = nil
+		v = f
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal value")
+	}
+
+	m := &idbMeta{Value: value, Size: int64(len(data))}
+	for i := 0; len(data) > 0; i++ {
+		block := data[:min(len(data), IDBBlockSize)]
+		data = data[len(block):]
+
+		sum := sha256.Sum256(block)
+		hash := base64.RawStdEncoding.EncodeToString(sum[:16])
+		m.Blocks = append(m.Blocks, hash)
+
+		if old != nil && i < len(old.Blocks) && old.Blocks[i] == hash {
+			continue
+		}
+		b.put(IDBBlockStore, idbBlockKey(key, i), block)
+	}
+
+	if old != nil {
+		for i := len(m.Blocks); i < len(old.Blocks); i++ {
+			b.put(IDBBlockStore, idbBlockKey(key, i), nil)
+		}
+	}
+
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal metadata")
+	}
+
+	b.put(IDBMetaStore, key, raw)
+	b.metas[key] = m
+	b.paths = append(b.paths, key)
+
+	if old == nil {
+		return b.updateDir(key, true)
+	}
+	return nil
+}
+
+func (b *idbBatch) delete(key string) error {
+	old, err := b.meta(key)
+	if err != nil || old == nil {
+		return err
+	}
+
+	for i := range old.Blocks {
+		b.put(IDBBlockStore, idbBlockKey(key, i), nil)
+	}
+
+	b.put(IDBMetaStore, key, nil)
+	b.metas[key] = nil
+	b.paths = append(b.paths, key)
+
+	return b.updateDir(key, false)
+}
+
+// updateDir adds or removes key from the index of its directory.
+func (b *idbBatch) updateDir(key string, add bool) error {
+	prefix, name := idbSplit(key)
+
+	names, err := b.dir(prefix)
+	if err != nil {
+		return err
+	}
+
+	i := sort.SearchStrings(names, name)
+	exists := i < len(names) && names[i] == name
+
+	switch {
+	case add && !exists:
+		names = append(names[:i:i], append([]string{name}, names[i:]...)...)
+	case !add && exists:
+		names = append(names[:i:i], names[i+1:]...)
+	default:
+		return nil
+	}
+
+	b.dirs[prefix] = names
+	return nil
+}
+
+func (b *idbBatch) put(store, key string, value []byte) {
+	b.writes = append(b.writes, IDBWrite{Store: store, Key: key, Value: value})
+}
+
+func (b *idbBatch) commit() error {
+	prefixes := make([]string, 0, len(b.dirs))
+	for prefix := range b.dirs {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		var raw []byte
+		if names := b.dirs[prefix]; len(names) > 0 {
+			var err error
+			raw, err = json.Marshal(names)
+			if err != nil {
+				return errors.Wrap(err, "failed to marshal directory index")
+			}
+		}
+		b.put(IDBDirStore, prefix, raw)
+	}
+
+	if len(b.writes) == 0 {
+		return nil
+	}
+
+	return b.s.db.Write(b.writes)
+}
+
+// idbBlockKey returns the key of the i-th block of the file at key.
+func idbBlockKey(key string, i int) string {
+	return key + "\x00" + strconv.Itoa(i)
+}
+
+// idbSplit splits key into the prefix of its directory and its name.
+func idbSplit(key string) (prefix, name string) {
+	i := strings.LastIndexByte(key, '/')
+	return key[:i+1], key[i+1:]
+}
+
+func decodeIDBDir(raw []byte) ([]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	var names []string
+	err := json.Unmarshal(raw, &names)
+	return names, err
+}
+
+// MigrateStore moves every value from src into dst. Each value is deleted
+// from src once it is in dst, so an interrupted migration can simply be run
+// again. The number of values moved is returned.
+func MigrateStore(dst, src Store) (int, error) {
+	values, err := src.List(root, true)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot list values to migrate")
+	}
+
+	for i, v := range values {
+		if err := dst.Set(v.Path, v.StoredValue); err != nil {
+			return i, errors.Wrapf(err, "cannot migrate %q", v.Path)
+		}
+		if err := src.Delete(v.Path); err != nil {
+			return i + 1, errors.Wrapf(err, "cannot delete migrated %q", v.Path)
+		}
+	}
+
+	return len(values), nil
+}
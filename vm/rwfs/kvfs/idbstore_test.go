@@ -0,0 +1,178 @@
+package kvfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	rwfspkg "libdb.so/vm/rwfs"
+)
+
+// recordingIDB records the writes that go through it.
+type recordingIDB struct {
+	IDBBackend
+	writes []IDBWrite
+}
+
+func (db *recordingIDB) Write(writes []IDBWrite) error {
+	db.writes = append(db.writes, writes...)
+	return db.IDBBackend.Write(writes)
+}
+
+// take returns the keys written to the given object store since the last
+// call.
+func (db *recordingIDB) take(store string) []string {
+	var keys []string
+	for _, w := range db.writes {
+		if w.Store == store {
+			keys = append(keys, w.Key)
+		}
+	}
+	db.writes = nil
+	return keys
+}
+
+func TestIDBStore(t *testing.T) {
+	db := &recordingIDB{IDBBackend: MemoryIDB(0)}
+	store := NewIDBStore(db)
+	rwfs := New(store)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), IDBBlockSize*5/2/16)
+
+	t.Run("blocks", func(t *testing.T) {
+		assert.NoError(t, rwfs.MkdirAll("a/b", 0755))
+		writeFile(t, rwfs, "a/big", string(data))
+		assert.Equal(t, 3, len(db.take(IDBBlockStore)))
+
+		b, err := fs.ReadFile(rwfs, "a/big")
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(data, b))
+	})
+
+	t.Run("rewrite changed blocks", func(t *testing.T) {
+		data[IDBBlockSize+1] = 'x'
+
+		f, err := rwfs.OpenFile("a/big", os.O_WRONLY, 0)
+		assert.NoError(t, err)
+		_, err = f.(io.WriterAt).WriteAt([]byte("x"), IDBBlockSize+1)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+
+		assert.Equal(t, []string{idbBlockKey("/a/big", 1)}, db.take(IDBBlockStore))
+
+		b, err := fs.ReadFile(rwfs, "a/big")
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(data, b))
+	})
+
+	t.Run("shrink", func(t *testing.T) {
+		assert.NoError(t, store.Set("/a/big", StoredFile{Data: data[:10]}))
+		assert.Equal(t,
+			[]string{idbBlockKey("/a/big", 0), idbBlockKey("/a/big", 1), idbBlockKey("/a/big", 2)},
+			db.take(IDBBlockStore))
+
+		blocks, err := db.Get(IDBBlockStore, []string{idbBlockKey("/a/big", 1), idbBlockKey("/a/big", 2)})
+		assert.NoError(t, err)
+		assert.Equal(t, [][]byte{nil, nil}, blocks)
+
+		b, err := fs.ReadFile(rwfs, "a/big")
+		assert.NoError(t, err)
+		assert.Equal(t, "0123456789", string(b))
+	})
+
+	t.Run("list", func(t *testing.T) {
+		writeFile(t, rwfs, "a/b/c", "hello")
+		writeFile(t, rwfs, "ab", "sibling")
+
+		paths := func(values []PathedStoreValue) []string {
+			var paths []string
+			for _, v := range values {
+				paths = append(paths, v.Path)
+			}
+			return paths
+		}
+
+		values, err := store.List("/a/", false)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"/a/b", "/a/big"}, paths(values))
+
+		values, err = store.List("/a/", true)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"/a/b", "/a/big", "/a/b/c"}, paths(values))
+
+		assert.NoError(t, rwfs.Remove("a/b/c"))
+
+		names, err := db.Get(IDBDirStore, []string{"/a/b/"})
+		assert.NoError(t, err)
+		assert.Equal(t, [][]byte{nil}, names)
+	})
+
+	t.Run("quota", func(t *testing.T) {
+		store := NewIDBStore(MemoryIDB(IDBBlockSize))
+		rwfs := New(store)
+
+		writeFile(t, rwfs, "small", "hello")
+
+		used, quota, err := store.Usage()
+		assert.NoError(t, err)
+		assert.True(t, used > 0)
+		assert.Equal(t, int64(IDBBlockSize), quota)
+
+		// The FS reports the store's usage, which is what df shows.
+		fsUsed, fsQuota, err := rwfspkg.Usage(rwfs)
+		assert.NoError(t, err)
+		assert.Equal(t, used, fsUsed)
+		assert.Equal(t, quota, fsQuota)
+
+		_, _, err = rwfspkg.Usage(New(MemoryStorage()))
+		assert.True(t, errors.Is(err, errors.ErrUnsupported))
+
+		f, err := rwfs.OpenFile("small", os.O_WRONLY, 0)
+		assert.NoError(t, err)
+		_, err = f.Write(data)
+		assert.NoError(t, err)
+		assert.True(t, errors.Is(f.Close(), ErrQuotaExceeded))
+
+		b, err := fs.ReadFile(rwfs, "small")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+
+		after, _, err := store.Usage()
+		assert.NoError(t, err)
+		assert.Equal(t, used, after)
+
+		// MkdirAll is applied as a single transaction, so none of it is
+		// created if it doesn't fit.
+		deep := strings.Repeat("directory/", 300)
+		assert.True(t, errors.Is(rwfs.MkdirAll(deep, 0755), ErrQuotaExceeded))
+
+		_, err = fs.Stat(rwfs, "directory")
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+	})
+
+	t.Run("migrate", func(t *testing.T) {
+		src := MemoryStorage()
+		old := New(src)
+		assert.NoError(t, old.MkdirAll("x/y", 0755))
+		writeFile(t, old, "x/y/z", "migrated")
+
+		dst := NewIDBStore(MemoryIDB(0))
+		n, err := MigrateStore(dst, src)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, n)
+
+		b, err := fs.ReadFile(New(dst), "x/y/z")
+		assert.NoError(t, err)
+		assert.Equal(t, "migrated", string(b))
+
+		left, err := src.List(root, true)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(left))
+	})
+}
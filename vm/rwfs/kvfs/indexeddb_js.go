@@ -0,0 +1,242 @@
+package kvfs
+
+import (
+	stderrors "errors"
+	"syscall/js"
+
+	"github.com/pkg/errors"
+)
+
+// indexedDBVersion is the version of the database schema. It must be bumped
+// whenever IDBStores changes.
+const indexedDBVersion = 1
+
+// IndexedDBStorage opens an IDBStore that is persisted to the browser's
+// IndexedDB database of the given name.
+func IndexedDBStorage(name string) (*IDBStore, error) {
+	db, err := IndexedDB(name)
+	if err != nil {
+		return nil, err
+	}
+
+	store := NewIDBStore(db)
+	broadcastIDB(store, name)
+	return store, nil
+}
+
+// IndexedDB opens the browser's IndexedDB database of the given name as an
+// IDBBackend, creating it if needed.
+func IndexedDB(name string) (IDBBackend, error) {
+	factory := js.Global().Get("indexedDB")
+	if factory.IsUndefined() {
+		return nil, errors.Wrap(stderrors.ErrUnsupported, "no IndexedDB")
+	}
+
+	req := factory.Call("open", name, indexedDBVersion)
+
+	upgrade := js.FuncOf(func(this js.Value, args []js.Value) any {
+		db := req.Get("result")
+		for _, store := range IDBStores {
+			if !db.Get("objectStoreNames").Call("contains", store).Bool() {
+				db.Call("createObjectStore", store)
+			}
+		}
+		return nil
+	})
+	defer upgrade.Release()
+	req.Set("onupgradeneeded", upgrade)
+
+	db, err := awaitIDB(req, "success", "error")
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open database %q", name)
+	}
+
+	return indexedDB{db.Get("result")}, nil
+}
+
+// broadcastIDB tells other tabs using the same database about changes made to
+// store through a BroadcastChannel, and tells the store's subscribers about
+// changes made by other tabs.
+func broadcastIDB(store *IDBStore, name string) {
+	ctor := js.Global().Get("BroadcastChannel")
+	if ctor.IsUndefined() {
+		return
+	}
+
+	channel := ctor.New("kvfs:" + name)
+
+	store.broadcast = func(paths []string) {
+		msg := make([]any, len(paths))
+		for i, path := range paths {
+			msg[i] = path
+		}
+		channel.Call("postMessage", msg)
+	}
+
+	// This lives as long as the store, so it's never released.
+	channel.Set("onmessage", js.FuncOf(func(this js.Value, args []js.Value) any {
+		data := args[0].Get("data")
+		paths := make([]string, data.Length())
+		for i := range paths {
+			paths[i] = data.Index(i).String()
+		}
+		// Subscribers may read from the store, which blocks, so they can't be
+		// called from within a JS event handler.
+		go store.notify(paths)
+		return nil
+	}))
+}
+
+type indexedDB struct {
+	db js.Value
+}
+
+func (db indexedDB) Get(store string, keys []string) ([][]byte, error) {
+	tx := db.db.Call("transaction", store, "readonly")
+	objects := tx.Call("objectStore", store)
+
+	reqs := make([]js.Value, len(keys))
+	for i, key := range keys {
+		reqs[i] = objects.Call("get", key)
+	}
+
+	if _, err := awaitIDB(tx, "complete", "error", "abort"); err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(keys))
+	for i, req := range reqs {
+		result := req.Get("result")
+		if result.IsUndefined() {
+			continue
+		}
+		values[i] = make([]byte, result.Get("byteLength").Int())
+		js.CopyBytesToGo(values[i], result)
+	}
+
+	return values, nil
+}
+
+func (db indexedDB) Write(writes []IDBWrite) error {
+	var stores []any
+	seen := make(map[string]bool)
+	for _, w := range writes {
+		if !seen[w.Store] {
+			seen[w.Store] = true
+			stores = append(stores, w.Store)
+		}
+	}
+
+	tx := db.db.Call("transaction", stores, "readwrite")
+	for _, w := range writes {
+		objects := tx.Call("objectStore", w.Store)
+		if w.Value == nil {
+			objects.Call("delete", w.Key)
+			continue
+		}
+		value := js.Global().Get("Uint8Array").New(len(w.Value))
+		js.CopyBytesToJS(value, w.Value)
+		objects.Call("put", value, w.Key)
+	}
+
+	_, err := awaitIDB(tx, "complete", "error", "abort")
+	return err
+}
+
+func (db indexedDB) Estimate() (usage, quota int64, err error) {
+	storage := js.Global().Get("navigator").Get("storage")
+	if storage.IsUndefined() || storage.Get("estimate").IsUndefined() {
+		return 0, 0, errors.Wrap(stderrors.ErrUnsupported, "no storage estimate")
+	}
+
+	estimate, err := awaitPromise(storage.Call("estimate"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if v := estimate.Get("usage"); !v.IsUndefined() {
+		usage = int64(v.Float())
+	}
+	if v := estimate.Get("quota"); !v.IsUndefined() {
+		quota = int64(v.Float())
+	}
+
+	return usage, quota, nil
+}
+
+// awaitIDB blocks until the given IndexedDB request or transaction fires its
+// success event or one of its failure events.
+func awaitIDB(target js.Value, success string, failures ...string) (js.Value, error) {
+	done := make(chan error, 1)
+
+	var funcs []js.Func
+	defer func() {
+		for _, fn := range funcs {
+			fn.Release()
+		}
+	}()
+
+	on := func(event string, fn func() error) {
+		f := js.FuncOf(func(this js.Value, args []js.Value) any {
+			select {
+			case done <- fn():
+			default:
+			}
+			return nil
+		})
+		funcs = append(funcs, f)
+		target.Set("on"+event, f)
+	}
+
+	on(success, func() error { return nil })
+	for _, failure := range failures {
+		on(failure, func() error { return idbError(target.Get("error")) })
+	}
+
+	if err := <-done; err != nil {
+		return js.Undefined(), err
+	}
+
+	return target, nil
+}
+
+// awaitPromise blocks until the given promise settles.
+func awaitPromise(promise js.Value) (js.Value, error) {
+	type result struct {
+		v   js.Value
+		err error
+	}
+	done := make(chan result, 1)
+
+	then := js.FuncOf(func(this js.Value, args []js.Value) any {
+		done <- result{v: args[0]}
+		return nil
+	})
+	defer then.Release()
+
+	catch := js.FuncOf(func(this js.Value, args []js.Value) any {
+		done <- result{err: idbError(args[0])}
+		return nil
+	})
+	defer catch.Release()
+
+	promise.Call("then", then, catch)
+
+	r := <-done
+	return r.v, r.err
+}
+
+// idbError converts the given DOMException into an error. A
+// QuotaExceededError becomes ErrQuotaExceeded.
+func idbError(v js.Value) error {
+	if v.IsUndefined() || v.IsNull() {
+		return errors.New("indexeddb: unknown error")
+	}
+
+	name := v.Get("name").String()
+	if name == "QuotaExceededError" {
+		return ErrQuotaExceeded
+	}
+
+	return errors.Errorf("indexeddb: %s: %s", name, v.Get("message").String())
+}
@@ -1,8 +1,8 @@
 package kvfs
 
 import (
-	"bytes"
 	"encoding/json"
+	stderrors "errors"
 	"io/fs"
 	"os"
 	"path"
@@ -30,6 +30,52 @@ type Store interface {
 	List(prefix string, recursive bool) ([]PathedStoreValue, error)
 }
 
+// RenameStore is a Store that can move keys by itself. kvfs uses it to rename
+// files and directories atomically.
+type RenameStore interface {
+	Store
+	// Rename moves the value at oldpath, along with every value whose key
+	// starts with oldpath + "/", to newpath. Existing values at newpath are
+	// replaced. The whole operation must be atomic.
+	Rename(oldpath, newpath string) error
+}
+
+// TxStore is a Store that can apply several changes at once. kvfs uses it for
+// every change that touches more than one key, so that a failure halfway
+// through never leaves the store half-written.
+type TxStore interface {
+	Store
+	// Apply applies all of the given changes in order. Either all of them
+	// are applied or none of them are.
+	Apply(changes []Change) error
+}
+
+// Change is a single change within TxStore.Apply.
+type Change struct {
+	Path string
+	// Value is the new value. Path is deleted if it is nil.
+	Value StoredValue
+}
+
+// NotifyStore is a Store that tells its subscribers about changes to it, no
+// matter which FS made them. FS instances that share a store use it to stay
+// coherent with each other.
+type NotifyStore interface {
+	Store
+	// Subscribe calls fn with the paths of every change made to the store
+	// until the returned function is called. Changes applied together are
+	// reported together. fn must not block.
+	Subscribe(fn func(paths []string)) (unsubscribe func())
+}
+
+// UsageStore is a Store that knows how much space it uses.
+type UsageStore interface {
+	Store
+	// Usage returns the number of bytes used and the number of bytes that
+	// may be used in total. A quota of 0 means that it is unknown.
+	Usage() (used, quota int64, err error)
+}
+
 // PathedStoreValue is specifically used to handle Store's List method.
 type PathedStoreValue struct {
 	StoredValue
@@ -43,24 +89,31 @@ type StoredValue interface {
 
 func (StoredFile) storedValue()      {}
 func (StoredDirectory) storedValue() {}
+func (StoredSymlink) storedValue()   {}
 
 // UnmarshalStoredValue unmarshals the given stored value into the given value.
 func UnmarshalStoredValue(b json.RawMessage) (StoredValue, error) {
-	var isDir struct {
-		IsDir bool `json:"is_dir"`
+	var kind struct {
+		IsDir     bool `json:"is_dir"`
+		IsSymlink bool `json:"is_symlink"`
 	}
 
-	if err := json.Unmarshal(b, &isDir); err != nil {
+	if err := json.Unmarshal(b, &kind); err != nil {
 		return nil, errors.Wrap(err, "invalid json")
 	}
 
 	var v StoredValue
 	var err error
-	if isDir.IsDir {
+	switch {
+	case kind.IsDir:
 		var d StoredDirectory
 		err = json.Unmarshal(b, &d)
 		v = d
-	} else {
+	case kind.IsSymlink:
+		var l StoredSymlink
+		err = json.Unmarshal(b, &l)
+		v = l
+	default:
 		var f StoredFile
 		err = json.Unmarshal(b, &f)
 		v = f
@@ -73,8 +126,9 @@ func UnmarshalStoredValue(b json.RawMessage) (StoredValue, error) {
 type StoredFile struct {
 	// ModTime is the file's modification time.
 	ModTime int64 `json:"mod_time"`
-	// Mode is the file's mode and permission bits.
-	Mode os.FileMode `json:"mode,omitempty"`
+	// Mode is the file's permission bits. It is nil for files stored before
+	// modes were persisted, which have the legacy mode.
+	Mode *os.FileMode `json:"mode,omitempty"`
 	// Data is the file's data.
 	Data []byte `json:"data,omitempty"`
 }
@@ -83,15 +137,52 @@ type StoredFile struct {
 type StoredDirectory struct {
 	// CreateTime is the directory's creation time.
 	CreateTime int64 `json:"create_time"`
+	// ModTime is the directory's modification time as set by Chtimes. It is
+	// zero if it was never set.
+	ModTime int64 `json:"mod_time,omitempty"`
+	// Mode is the directory's permission bits. Like StoredFile's, it is nil
+	// for directories stored before modes were persisted.
+	Mode *os.FileMode `json:"mode,omitempty"`
 	// IsDir is always true.
 	IsDir bool `json:"is_dir"`
 }
 
-// dirMtime returns the latest modification time of the given directory.
-func dirMtime(store Store, dirpath string, createTime int64) int64 {
-	time := createTime
+// StoredSymlink is a symbolic link that is stored in a key-value store.
+type StoredSymlink struct {
+	// ModTime is the link's modification time.
+	ModTime int64 `json:"mod_time"`
+	// Target is the path that the link points to. It is relative to the
+	// directory containing the link unless it is absolute.
+	Target string `json:"target"`
+	// IsSymlink is always true.
+	IsSymlink bool `json:"is_symlink"`
+}
 
-	files, err := store.List(dirpath, false)
+// legacyMode is the mode of files and directories that were stored without
+// one.
+const legacyMode fs.FileMode = 0777
+
+// storedMode returns the permission bits of a stored value's mode.
+func storedMode(mode *os.FileMode) fs.FileMode {
+	if mode == nil {
+		return legacyMode
+	}
+	return mode.Perm()
+}
+
+// newMode returns a mode to be stored from the given permission bits.
+func newMode(perm fs.FileMode) *os.FileMode {
+	perm = perm.Perm()
+	return &perm
+}
+
+// dirMtime returns the latest modification time of the given directory. It
+// is the latest of its creation time, the time set by Chtimes and the
+// modification times of its entries.
+func dirMtime(store Store, dirpath string, d StoredDirectory) int64 {
+	time := max(d.CreateTime, d.ModTime)
+
+	files, err := store.List(dirPrefix(dirpath), false)
 	if err != nil {
 		return time
 	}
@@ -106,6 +197,10 @@ func dirMtime(store Store, dirpath string, createTime int64) int64 {
 			if v.CreateTime > time {
 				time = v.CreateTime
 			}
+		case StoredSymlink:
+			if v.ModTime > time {
+				time = v.ModTime
+			}
 		}
 	}
 
@@ -116,21 +211,81 @@ func dirMtime(store Store, dirpath string, createTime int64) int64 {
 type FS struct {
 	store Store
 	lock  sync.RWMutex
+	watch watchState
+
+	// files holds the data of the open files by their paths.
+	filesMu sync.Mutex
+	files   map[string]*fileData
 }
 
 var (
-	_ fs.FS        = (*FS)(nil)
-	_ fs.ReadDirFS = (*FS)(nil)
-	_ rwfs.FS      = (*FS)(nil)
+	_ fs.FS          = (*FS)(nil)
+	_ fs.ReadDirFS   = (*FS)(nil)
+	_ rwfs.FS        = (*FS)(nil)
+	_ rwfs.RenameFS  = (*FS)(nil)
+	_ rwfs.ChmodFS   = (*FS)(nil)
+	_ rwfs.ChtimesFS = (*FS)(nil)
+	_ rwfs.SymlinkFS = (*FS)(nil)
+	_ rwfs.UsageFS   = (*FS)(nil)
 )
 
-// New returns a new FS that uses the given store. Be careful when constructing
-// two different FS's with the same store, as they will conflict with each
-// other.
+// New returns a new FS that uses the given store. Several FS's may share the
+// same store if it implements TxStore, since each of their changes is then
+// applied atomically. If it also implements NotifyStore, then changes made
+// through one of them can be watched through the others.
 func New(store Store) *FS {
 	return &FS{store: store}
 }
 
+// Usage implements rwfs.UsageFS if the store implements UsageStore. Otherwise,
+// an error wrapping errors.ErrUnsupported is returned.
+func (kvfs *FS) Usage() (used, quota int64, err error) {
+	us, ok := kvfs.store.(UsageStore)
+	if !ok {
+		return 0, 0, stderrors.ErrUnsupported
+	}
+	return us.Usage()
+}
+
+// StoreOf returns the store of fsys if it is an FS, or if it is an overlay
+// whose read-write filesystem is one.
+func StoreOf(fsys fs.FS) (Store, bool) {
+	if upper, ok := rwfs.OverlayUpper(fsys); ok {
+		fsys = upper
+	}
+	kvfs, ok := fsys.(*FS)
+	if !ok {
+		return nil, false
+	}
+	return kvfs.store, true
+}
+
+// apply applies the given changes in order. They are applied atomically if
+// the store implements TxStore.
+func (kvfs *FS) apply(changes []Change) error {
+	return applyChanges(kvfs.store, changes)
+}
+
+func applyChanges(store Store, changes []Change) error {
+	if tx, ok := store.(TxStore); ok {
+		return tx.Apply(changes)
+	}
+
+	for _, c := range changes {
+		var err error
+		if c.Value == nil {
+			err = store.Delete(c.Path)
+		} else {
+			err = store.Set(c.Path, c.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Open implements fs.FS.
 func (kvfs *FS) Open(fullpath string) (fs.File, error) {
 	fullpath = clean(fullpath)
@@ -138,23 +293,30 @@ func (kvfs *FS) Open(fullpath string) (fs.File, error) {
 	kvfs.lock.RLock()
 	defer kvfs.lock.RUnlock()
 
-	v, err := kvfs.store.Get(fullpath)
+	name := path.Base(fullpath)
+
+	fullpath, err := kvfs.resolve(fullpath, true)
 	if err != nil {
 		return nil, pathErr("open", fullpath, err)
 	}
 
+	v, err := kvfs.store.Get(fullpath)
+	if err != nil {
+		if fullpath != root {
+			return nil, pathErr("open", fullpath, err)
+		}
+		// The root directory always exists, even if it's not stored.
+		v = StoredDirectory{Mode: newMode(0755), IsDir: true}
+	}
+
 	switch v := v.(type) {
 	case StoredFile:
-		return &fsFile{
-			parent: kvfs,
-			info:   fileInfo(fullpath, v),
-			buf:    *bytes.NewBuffer(v.Data),
-			flag:   os.O_RDONLY,
-		}, nil
+		info := fileInfo(fullpath, v).withName(name)
+		return newFile(kvfs, info, kvfs.openData(fullpath, v.Data, true), os.O_RDONLY), nil
 	case StoredDirectory:
 		return &fsDir{
 			parent: kvfs,
-			info:   dirInfo(kvfs.store, fullpath, v),
+			info:   dirInfo(kvfs.store, fullpath, v).withName(name),
 		}, nil
 	default:
 		panic("unknown (impossible) stored value type")
@@ -164,11 +326,6 @@ func (kvfs *FS) Open(fullpath string) (fs.File, error) {
 func (kvfs *FS) OpenFile(fullpath string, flag int, perm fs.FileMode) (rwfs.File, error) {
 	fullpath = clean(fullpath)
 
-	// Don't support O_RDWR because we don't have any way to do that.
-	if flagHas(flag, os.O_RDWR) {
-		return nil, pathErr("open", fullpath, errors.New("O_RDWR not supported"))
-	}
-
 	if flagHas(flag, os.O_RDONLY) {
 		kvfs.lock.RLock()
 		defer kvfs.lock.RUnlock()
@@ -177,6 +334,19 @@ func (kvfs *FS) OpenFile(fullpath string, flag int, perm fs.FileMode) (rwfs.File
 		defer kvfs.lock.Unlock()
 	}
 
+	name := path.Base(fullpath)
+
+	linkpath := fullpath
+	fullpath, err := kvfs.resolve(fullpath, true)
+	if err != nil {
+		return nil, pathErr("open", linkpath, err)
+	}
+
+	if flagHas(flag, os.O_EXCL) && fullpath != linkpath {
+		// Like open(2), O_EXCL fails on symbolic links, even dangling ones.
+		return nil, pathErr("open", linkpath, fs.ErrExist)
+	}
+
 	now := time.Now().Unix()
 	var stored StoredFile
 
@@ -191,6 +361,10 @@ func (kvfs *FS) OpenFile(fullpath string, flag int, perm fs.FileMode) (rwfs.File
 	if flagHas(flag, os.O_CREATE) {
 		// Update ModTime right now so we can reuse it.
 		stored.ModTime = now
+		if err != nil {
+			// We're creating a new file.
+			stored.Mode = newMode(perm)
+		}
 
 		if flagHas(flag, os.O_EXCL) && err == nil {
 			// File exists but we want to only create the file when there's
@@ -224,26 +398,64 @@ func (kvfs *FS) OpenFile(fullpath string, flag int, perm fs.FileMode) (rwfs.File
 			stored.Data = nil
 		}
 
+		op := watchWrite
+		if err != nil {
+			op = watchCreate
+		}
+		defer kvfs.hint(op, fullpath)()
+
 		if err := kvfs.store.Set(fullpath, stored); err != nil {
 			return nil, pathErr("open", fullpath,
 				errors.Wrap(err, "failed to truncate file"))
 		}
 	}
 
-	var buf bytes.Buffer
-	// We need the data in the buffer for reading. For writing, we're either
-	// writing the data to the start or end (or overriding), so we don't need
-	// to initialize the buffer.
-	if flagRead(flag) {
-		buf = *bytes.NewBuffer(stored.Data)
+	// A file that doesn't exist in the store anymore was removed, so handles
+	// that are still open on it don't share their data with the new one.
+	data := kvfs.openData(fullpath, stored.Data, err == nil)
+	if flagHas(flag, os.O_TRUNC) {
+		data.mu.Lock()
+		data.data = data.data[:0]
+		data.mu.Unlock()
+	}
+
+	return newFile(kvfs, fileInfo(fullpath, stored).withName(name), data, flag), nil
+}
+
+// openData returns the data of the file at fullpath for a new handle. Handles
+// share the data of the file if it's already open and reuse is true.
+// Otherwise, the data starts as stored. The whole file is kept in memory while
+// it's open, so that it can be read and written at any offset.
+func (kvfs *FS) openData(fullpath string, stored []byte, reuse bool) *fileData {
+	kvfs.filesMu.Lock()
+	defer kvfs.filesMu.Unlock()
+
+	if data, ok := kvfs.files[fullpath]; ok && reuse {
+		data.refs++
+		return data
 	}
 
-	return &fsFile{
-		parent: kvfs,
-		info:   fileInfo(fullpath, stored),
-		buf:    buf,
-		flag:   flag,
-	}, nil
+	if kvfs.files == nil {
+		kvfs.files = make(map[string]*fileData)
+	}
+
+	// Copy the data, since writes modify it in place and the store may still
+	// be holding onto it.
+	data := &fileData{data: append([]byte(nil), stored...), refs: 1}
+	kvfs.files[fullpath] = data
+	return data
+}
+
+// closeData releases the data of a handle of the file at fullpath once it's
+// closed.
+func (kvfs *FS) closeData(fullpath string, data *fileData) {
+	kvfs.filesMu.Lock()
+	defer kvfs.filesMu.Unlock()
+
+	data.refs--
+	if data.refs == 0 && kvfs.files[fullpath] == data {
+		delete(kvfs.files, fullpath)
+	}
 }
 
 func (kvfs *FS) write(file *fsFile) error {
@@ -258,27 +470,27 @@ func (kvfs *FS) write(file *fsFile) error {
 	v, err := kvfs.store.Get(file.info.path)
 	if err == nil {
 		// Our existing file must've still been a file. Things might've changed
-		// while the file was open, so we need to check again. We also have to
-		// do this to get existing data for O_APPEND just in case.
+		// while the file was open, so we need to check again.
 		if f, ok = v.(StoredFile); !ok {
 			return pathErr("write", file.info.path, fs.ErrInvalid)
 		}
 	}
 
 	f.ModTime = now
-
-	// Be careful with this. If we're appending, then we'll read again and
-	// append to that file. This means that while the file is open, the appended
-	// bytes might be very different.
-	switch {
-	case flagHas(file.flag, os.O_APPEND):
-		f.Data = append(f.Data, file.buf.Bytes()...)
-	case flagHas(file.flag, os.O_TRUNC):
-		f.Data = file.buf.Bytes()
-	default:
-		f.Data = append(file.buf.Bytes(), f.Data...)
+	if f.Mode == nil {
+		// The file was removed while it was open, so keep the mode that it was
+		// opened with.
+		f.Mode = newMode(file.info.mode)
 	}
 
+	// The data is shared with the other handles of the file, which may still
+	// be writing to it.
+	file.mu.Lock()
+	f.Data = append([]byte(nil), file.data...)
+	file.mu.Unlock()
+
+	defer kvfs.hint(watchWrite, file.info.path)()
+
 	if err := kvfs.store.Set(file.info.path, f); err != nil {
 		return pathErr("write", file.info.path, err)
 	}
@@ -295,6 +507,11 @@ func (rwfs *FS) readDir(fullpath string, n int) ([]fs.DirEntry, error) {
 	rwfs.lock.RLock()
 	defer rwfs.lock.RUnlock()
 
+	fullpath, err := rwfs.resolve(fullpath, true)
+	if err != nil {
+		return nil, pathErr("readdir", fullpath, err)
+	}
+
 	// Check that this is an actual directory.
 	if fullpath != root {
 		dir, err := rwfs.store.Get(fullpath)
@@ -325,8 +542,9 @@ func (rwfs *FS) readDir(fullpath string, n int) ([]fs.DirEntry, error) {
 	for _, f := range files {
 		name := strings.TrimPrefix(f.Path, prefix)
 		// If the file has a slash, then it's in a subdirectory, so we'll ignore
-		// it. Ideally, Store should do this, but we'll be lenient.
-		if strings.Contains(name, "/") {
+		// it. Ideally, Store should do this, but we'll be lenient. The root
+		// directory itself may also be listed if it has been stored.
+		if name == "" || strings.Contains(name, "/") {
 			continue
 		}
 
@@ -335,6 +553,8 @@ func (rwfs *FS) readDir(fullpath string, n int) ([]fs.DirEntry, error) {
 			entries = append(entries, fileInfo(f.Path, v))
 		case StoredDirectory:
 			entries = append(entries, dirInfo(rwfs.store, f.Path, v))
+		case StoredSymlink:
+			entries = append(entries, symlinkInfo(f.Path, v))
 		}
 	}
 
@@ -356,6 +576,11 @@ func (rwfs *FS) Remove(fullpath string) error {
 	rwfs.lock.Lock()
 	defer rwfs.lock.Unlock()
 
+	fullpath, err := rwfs.resolve(fullpath, false)
+	if err != nil {
+		return pathErr("remove", fullpath, err)
+	}
+
 	// Remove only supports removing files, not directories. We check for that.
 	v, err := rwfs.store.Get(fullpath)
 	if err != nil {
@@ -363,11 +588,12 @@ func (rwfs *FS) Remove(fullpath string) error {
 		return nil
 	}
 
-	_, ok := v.(StoredFile)
-	if !ok {
+	if _, ok := v.(StoredDirectory); ok {
 		return pathErr("remove", fullpath, fs.ErrInvalid)
 	}
 
+	defer rwfs.hint(watchRemove, fullpath)()
+
 	if err := rwfs.store.Delete(fullpath); err != nil {
 		return pathErr("remove", fullpath, err)
 	}
@@ -387,16 +613,23 @@ func (rwfs *FS) Mkdir(fullpath string, perm fs.FileMode) error {
 
 	now := time.Now().Unix()
 
-	_, err := rwfs.store.Get(fullpath)
-	if err == nil {
+	fullpath, err := rwfs.resolve(fullpath, false)
+	if err != nil {
+		return pathErr("mkdir", fullpath, err)
+	}
+
+	if _, err := rwfs.store.Get(fullpath); err == nil {
 		return pathErr("mkdir", fullpath, fs.ErrExist)
 	}
 
 	value := StoredDirectory{
 		CreateTime: now,
+		Mode:       newMode(perm),
 		IsDir:      true,
 	}
 
+	defer rwfs.hint(watchCreate, fullpath)()
+
 	if err := rwfs.store.Set(fullpath, value); err != nil {
 		return pathErr("mkdir", fullpath, err)
 	}
@@ -427,10 +660,16 @@ func (rwfs *FS) MkdirAll(fullpath string, perm fs.FileMode) error {
 
 	now := time.Now().Unix()
 
+	fullpath, err = rwfs.resolve(fullpath, true)
+	if err != nil {
+		return pathErr("mkdirall", fullpath, err)
+	}
+
 	// We need to create the path. We'll do this by splitting the path and
-	// creating each directory one by one.
+	// creating each missing directory, all at once.
 	parts := split(fullpath)
 
+	var changes []Change
 	for i := range parts {
 		path := "/" + strings.Join(parts[:i+1], "/")
 
@@ -439,12 +678,20 @@ func (rwfs *FS) MkdirAll(fullpath string, perm fs.FileMode) error {
 			continue
 		}
 
-		if err := rwfs.store.Set(path, StoredDirectory{
-			CreateTime: now,
-			IsDir:      true,
-		}); err != nil {
-			return pathErr("mkdirall", fullpath, err)
-		}
+		changes = append(changes, Change{
+			Path: path,
+			Value: StoredDirectory{
+				CreateTime: now,
+				Mode:       newMode(perm),
+				IsDir:      true,
+			},
+		})
+	}
+
+	defer rwfs.hint(watchCreate, changedPaths(changes)...)()
+
+	if err := rwfs.apply(changes); err != nil {
+		return pathErr("mkdirall", fullpath, err)
 	}
 
 	return nil
@@ -456,26 +703,377 @@ func (rwfs *FS) RemoveAll(fullpath string) error {
 	rwfs.lock.Lock()
 	defer rwfs.lock.Unlock()
 
-	files, err := rwfs.store.List(fullpath, true)
+	fullpath, err := rwfs.resolve(fullpath, false)
+	if err != nil {
+		return pathErr("removeall", fullpath, err)
+	}
+
+	var changes []Change
+
+	files, err := rwfs.store.List(dirPrefix(fullpath), true)
 	if err == nil {
-		// Path is a directory, remove everything it has.
+		// Path is a directory, remove everything it has. Children are
+		// removed before their parents.
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Path > files[j].Path
+		})
 		for _, file := range files {
-			if err := rwfs.store.Delete(file.Path); err != nil {
-				return pathErr("removeall", fullpath, err)
+			if file.Path != root {
+				changes = append(changes, Change{Path: file.Path})
 			}
 		}
 	}
 
 	if fullpath != root {
-		if err := rwfs.store.Delete(fullpath); err != nil {
-			return pathErr("removeall", fullpath,
-				errors.Wrap(err, "failed to remove top-most directory"))
+		changes = append(changes, Change{Path: fullpath})
+	}
+
+	defer rwfs.hint(watchRemove, changedPaths(changes)...)()
+
+	if err := rwfs.apply(changes); err != nil {
+		return pathErr("removeall", fullpath, err)
+	}
+
+	return nil
+}
+
+// Rename implements rwfs.RenameFS. The whole subtree is re-keyed while holding
+// the lock. If the store implements RenameStore or TxStore, then this is
+// atomic. Otherwise, every new key is written before any old key is deleted,
+// so an interrupted rename may leave duplicates behind but never loses data.
+func (rwfs *FS) Rename(oldname, newname string) error {
+	oldname = clean(oldname)
+	newname = clean(newname)
+
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+
+	if oldname == root || newname == root {
+		return linkErr(fs.ErrInvalid)
+	}
+
+	rwfs.lock.Lock()
+	defer rwfs.lock.Unlock()
+
+	var err error
+	if oldname, err = rwfs.resolve(oldname, false); err != nil {
+		return linkErr(err)
+	}
+	if newname, err = rwfs.resolve(newname, false); err != nil {
+		return linkErr(err)
+	}
+
+	src, err := rwfs.store.Get(oldname)
+	if err != nil {
+		return linkErr(err)
+	}
+
+	if oldname == newname {
+		return nil
+	}
+
+	_, srcIsDir := src.(StoredDirectory)
+	if srcIsDir && strings.HasPrefix(newname, oldname+"/") {
+		return linkErr(errors.New("cannot move a directory into itself"))
+	}
+
+	if parent := path.Dir(newname); parent != root {
+		v, err := rwfs.store.Get(parent)
+		if err != nil {
+			return linkErr(errors.Wrap(err, "failed to get parent directory"))
+		}
+		if _, ok := v.(StoredDirectory); !ok {
+			return linkErr(errors.New("parent is not a directory"))
+		}
+	}
+
+	if dst, err := rwfs.store.Get(newname); err == nil {
+		_, dstIsDir := dst.(StoredDirectory)
+		switch {
+		case srcIsDir && !dstIsDir:
+			return linkErr(errors.New("not a directory"))
+		case !srcIsDir && dstIsDir:
+			return linkErr(errors.New("is a directory"))
+		case dstIsDir:
+			children, err := rwfs.store.List(dirPrefix(newname), false)
+			if err != nil {
+				return linkErr(err)
+			}
+			if len(children) > 0 {
+				return linkErr(fs.ErrExist)
+			}
+		}
+	}
+
+	defer rwfs.hint(watchRename, oldname)()
+	defer rwfs.hint(watchCreate, newname)()
+
+	if rs, ok := rwfs.store.(RenameStore); ok {
+		if err := rs.Rename(oldname, newname); err != nil {
+			return linkErr(err)
+		}
+		return nil
+	}
+
+	values := []PathedStoreValue{{StoredValue: src, Path: oldname}}
+	if srcIsDir {
+		children, err := rwfs.store.List(dirPrefix(oldname), true)
+		if err != nil {
+			return linkErr(errors.Wrap(err, "failed to list files"))
+		}
+		values = append(values, children...)
+	}
+
+	// Write parents before their children.
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Path < values[j].Path
+	})
+
+	changes := make([]Change, 0, 2*len(values))
+	for _, v := range values {
+		changes = append(changes, Change{
+			Path:  newname + strings.TrimPrefix(v.Path, oldname),
+			Value: v.StoredValue,
+		})
+	}
+	for i := len(values) - 1; i >= 0; i-- {
+		changes = append(changes, Change{Path: values[i].Path})
+	}
+
+	if err := rwfs.apply(changes); err != nil {
+		return linkErr(err)
+	}
+
+	return nil
+}
+
+// Chmod implements rwfs.ChmodFS.
+func (rwfs *FS) Chmod(fullpath string, mode fs.FileMode) error {
+	return rwfs.update("chmod", fullpath, func(v StoredValue) StoredValue {
+		switch v := v.(type) {
+		case StoredFile:
+			v.Mode = newMode(mode)
+			return v
+		case StoredDirectory:
+			v.Mode = newMode(mode)
+			return v
+		}
+		return v
+	})
+}
+
+// Chtimes implements rwfs.ChtimesFS. Access times aren't stored, so atime is
+// ignored.
+func (rwfs *FS) Chtimes(fullpath string, atime, mtime time.Time) error {
+	return rwfs.update("chtimes", fullpath, func(v StoredValue) StoredValue {
+		switch v := v.(type) {
+		case StoredFile:
+			v.ModTime = mtime.Unix()
+			return v
+		case StoredDirectory:
+			v.ModTime = mtime.Unix()
+			return v
+		case StoredSymlink:
+			v.ModTime = mtime.Unix()
+			return v
+		}
+		return v
+	})
+}
+
+// update replaces the stored value at fullpath with the one returned by fn.
+// The root directory is stored if it isn't already.
+func (rwfs *FS) update(op, fullpath string, fn func(StoredValue) StoredValue) error {
+	fullpath = clean(fullpath)
+
+	rwfs.lock.Lock()
+	defer rwfs.lock.Unlock()
+
+	fullpath, err := rwfs.resolve(fullpath, true)
+	if err != nil {
+		return pathErr(op, fullpath, err)
+	}
+
+	v, err := rwfs.store.Get(fullpath)
+	if err != nil {
+		if fullpath != root {
+			return pathErr(op, fullpath, err)
+		}
+		v = StoredDirectory{Mode: newMode(0755), IsDir: true}
+	}
+
+	defer rwfs.hint(watchChmod, fullpath)()
+
+	if err := rwfs.store.Set(fullpath, fn(v)); err != nil {
+		return pathErr(op, fullpath, err)
+	}
+
+	return nil
+}
+
+// Symlink implements rwfs.SymlinkFS.
+func (rwfs *FS) Symlink(oldname, newname string) error {
+	newname = clean(newname)
+
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+
+	if oldname == "" {
+		return linkErr(fs.ErrInvalid)
+	}
+
+	rwfs.lock.Lock()
+	defer rwfs.lock.Unlock()
+
+	newname, err := rwfs.resolve(newname, false)
+	if err != nil {
+		return linkErr(err)
+	}
+
+	if newname == root {
+		return linkErr(fs.ErrExist)
+	}
+
+	if _, err := rwfs.store.Get(newname); err == nil {
+		return linkErr(fs.ErrExist)
+	}
+
+	if parent := path.Dir(newname); parent != root {
+		v, err := rwfs.store.Get(parent)
+		if err != nil {
+			return linkErr(errors.Wrap(err, "failed to get parent directory"))
+		}
+		if _, ok := v.(StoredDirectory); !ok {
+			return linkErr(errors.New("parent is not a directory"))
 		}
 	}
 
+	link := StoredSymlink{
+		ModTime:   time.Now().Unix(),
+		Target:    oldname,
+		IsSymlink: true,
+	}
+
+	defer rwfs.hint(watchCreate, newname)()
+
+	if err := rwfs.store.Set(newname, link); err != nil {
+		return linkErr(err)
+	}
+
 	return nil
 }
 
+// Readlink implements rwfs.ReadlinkFS.
+func (rwfs *FS) Readlink(fullpath string) (string, error) {
+	v, fullpath, err := rwfs.lget(fullpath)
+	if err != nil {
+		return "", pathErr("readlink", fullpath, err)
+	}
+
+	link, ok := v.(StoredSymlink)
+	if !ok {
+		return "", pathErr("readlink", fullpath, fs.ErrInvalid)
+	}
+
+	return link.Target, nil
+}
+
+// Lstat implements rwfs.ReadlinkFS.
+func (rwfs *FS) Lstat(fullpath string) (fs.FileInfo, error) {
+	v, fullpath, err := rwfs.lget(fullpath)
+	if err != nil {
+		return nil, pathErr("lstat", fullpath, err)
+	}
+
+	switch v := v.(type) {
+	case StoredFile:
+		return fileInfo(fullpath, v), nil
+	case StoredDirectory:
+		return dirInfo(rwfs.store, fullpath, v), nil
+	case StoredSymlink:
+		return symlinkInfo(fullpath, v), nil
+	default:
+		panic("unknown (impossible) stored value type")
+	}
+}
+
+// lget gets the value at fullpath without following it if it is a symbolic
+// link. The resolved path is also returned.
+func (rwfs *FS) lget(fullpath string) (StoredValue, string, error) {
+	fullpath = clean(fullpath)
+
+	rwfs.lock.RLock()
+	defer rwfs.lock.RUnlock()
+
+	fullpath, err := rwfs.resolve(fullpath, false)
+	if err != nil {
+		return nil, fullpath, err
+	}
+
+	v, err := rwfs.store.Get(fullpath)
+	if err != nil {
+		if fullpath != root {
+			return nil, fullpath, err
+		}
+		v = StoredDirectory{Mode: newMode(0755), IsDir: true}
+	}
+
+	return v, fullpath, nil
+}
+
+// resolve resolves the symbolic links in fullpath, which must be clean. The
+// last component is only followed if followLast is true. The caller must hold
+// the lock.
+func (kvfs *FS) resolve(fullpath string, followLast bool) (string, error) {
+	parts := split(fullpath)
+	resolved := root
+	links := 0
+
+	for i := 0; i < len(parts); i++ {
+		next := path.Join(resolved, parts[i])
+		if i == len(parts)-1 && !followLast {
+			return next, nil
+		}
+
+		v, err := kvfs.store.Get(next)
+		if err != nil {
+			// Nothing under a missing path can exist either.
+			return path.Join(append([]string{next}, parts[i+1:]...)...), nil
+		}
+
+		link, ok := v.(StoredSymlink)
+		if !ok {
+			resolved = next
+			continue
+		}
+
+		if links++; links > rwfs.MaxSymlinks {
+			return fullpath, rwfs.ErrSymlinkLoop
+		}
+
+		target := link.Target
+		if !path.IsAbs(target) {
+			target = path.Join(resolved, target)
+		}
+
+		parts = append(split(target), parts[i+1:]...)
+		resolved = root
+		i = -1
+	}
+
+	return resolved, nil
+}
+
+// dirPrefix returns the prefix of all paths within the given directory.
+func dirPrefix(dir string) string {
+	if dir == root {
+		return root
+	}
+	return dir + "/"
+}
+
 func clean(fullpath string) string {
 	fullpath = rwfs.ConvertAbs(fullpath)
 	if fullpath == "." {
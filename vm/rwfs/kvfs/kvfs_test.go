@@ -1,13 +1,19 @@
 package kvfs
 
 import (
+	"context"
+	"errors"
 	"io"
 	"io/fs"
 	"os"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/alecthomas/assert/v2"
 	"github.com/davecgh/go-spew/spew"
+
+	rwfspkg "libdb.so/vm/rwfs"
 )
 
 func TestFS(t *testing.T) {
@@ -31,7 +37,7 @@ func TestFS(t *testing.T) {
 
 		var err error
 
-		err = rwfs.MkdirAll("foo/bar", 0)
+		err = rwfs.MkdirAll("foo/bar", 0755)
 		assert.NoError(t, err)
 
 		err = rwfs.MkdirAll("foo/bar", 0)
@@ -50,13 +56,13 @@ func TestFS(t *testing.T) {
 		barStat, err := bar.Stat()
 		assert.NoError(t, err)
 		assert.True(t, barStat.IsDir())
-		assert.Equal(t, dmask|os.ModeDir, barStat.Mode())
+		assert.Equal(t, 0755|os.ModeDir, barStat.Mode())
 	})
 
 	t.Run("create", func(t *testing.T) {
 		t.Cleanup(func() { t.Log(spew.Sdump(store.m)) })
 
-		f, err := rwfs.OpenFile("foo/bar/baz", os.O_WRONLY|os.O_CREATE, 0)
+		f, err := rwfs.OpenFile("foo/bar/baz", os.O_WRONLY|os.O_CREATE, 0644)
 		assert.NoError(t, err)
 
 		defer f.Close()
@@ -70,7 +76,7 @@ func TestFS(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, "baz", stat.Name())
 		assert.Equal(t, false, stat.IsDir())
-		assert.Equal(t, 0777, stat.Mode())
+		assert.Equal(t, 0644, stat.Mode())
 
 		impl, ok := f.(*fsFile)
 		assert.True(t, ok)
@@ -192,6 +198,107 @@ func TestFS(t *testing.T) {
 		assert.Equal(t, "goodbye", string(b))
 	})
 
+	t.Run("overwrite", func(t *testing.T) {
+		t.Cleanup(func() { t.Log(spew.Sdump(store.m)) })
+
+		f, err := rwfs.OpenFile("foo/bar/baz", os.O_WRONLY, 0)
+		assert.NoError(t, err)
+
+		defer f.Close()
+
+		_, err = f.Write([]byte("GOOD"))
+		assert.NoError(t, err)
+
+		assert.NoError(t, f.Close())
+
+		b, err := fs.ReadFile(rwfs, "foo/bar/baz")
+		assert.NoError(t, err)
+		assert.Equal(t, "GOODbye", string(b))
+	})
+
+	t.Run("read-write", func(t *testing.T) {
+		t.Cleanup(func() { t.Log(spew.Sdump(store.m)) })
+
+		f, err := rwfs.OpenFile("foo/bar/baz", os.O_RDWR, 0)
+		assert.NoError(t, err)
+
+		defer f.Close()
+
+		sf, ok := f.(interface {
+			io.ReadWriteSeeker
+			io.ReaderAt
+			io.WriterAt
+			Truncate(int64) error
+		})
+		assert.True(t, ok, "must be seekable")
+
+		b := make([]byte, 4)
+		_, err = io.ReadFull(sf, b)
+		assert.NoError(t, err)
+		assert.Equal(t, "GOOD", string(b))
+
+		_, err = sf.Write([]byte("night"))
+		assert.NoError(t, err)
+
+		off, err := sf.Seek(-2, io.SeekEnd)
+		assert.NoError(t, err)
+		assert.Equal(t, 7, off)
+
+		_, err = sf.ReadAt(b[:2], 0)
+		assert.NoError(t, err)
+		assert.Equal(t, "GO", string(b[:2]))
+
+		_, err = sf.WriteAt([]byte("!"), 11)
+		assert.NoError(t, err)
+
+		_, err = sf.ReadAt(b, 9)
+		assert.Equal(t, io.EOF, err)
+
+		assert.NoError(t, sf.Truncate(9))
+		assert.NoError(t, f.Close())
+
+		b, err = fs.ReadFile(rwfs, "foo/bar/baz")
+		assert.NoError(t, err)
+		assert.Equal(t, "GOODnight", string(b))
+	})
+
+	t.Run("chmod", func(t *testing.T) {
+		t.Cleanup(func() { t.Log(spew.Sdump(store.m)) })
+
+		assert.NoError(t, rwfs.Chmod("foo/bar/baz", 0755))
+		assert.NoError(t, rwfs.Chmod("foo/bar", 0700))
+		assert.NoError(t, rwfs.Chmod("/", 0750))
+
+		mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+		assert.NoError(t, rwfs.Chtimes("foo/bar/baz", mtime, mtime))
+
+		stat, err := fs.Stat(rwfs, "foo/bar/baz")
+		assert.NoError(t, err)
+		assert.Equal(t, 0755, stat.Mode())
+		assert.True(t, stat.ModTime().Equal(mtime))
+
+		// Writing to the file keeps its mode.
+		writeFile(t, rwfs, "foo/bar/baz", "GOODnight")
+
+		stat, err = fs.Stat(rwfs, "foo/bar/baz")
+		assert.NoError(t, err)
+		assert.Equal(t, 0755, stat.Mode())
+
+		stat, err = fs.Stat(rwfs, "foo/bar")
+		assert.NoError(t, err)
+		assert.Equal(t, 0700|os.ModeDir, stat.Mode())
+
+		root, err := rwfs.ReadDir("/")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(root))
+
+		stat, err = fs.Stat(rwfs, "/")
+		assert.NoError(t, err)
+		assert.Equal(t, 0750|os.ModeDir, stat.Mode())
+
+		assert.Error(t, rwfs.Chmod("foo/nope", 0644))
+	})
+
 	t.Run("delete", func(t *testing.T) {
 		t.Cleanup(func() { t.Log(spew.Sdump(store.m)) })
 
@@ -221,3 +328,270 @@ func TestFS(t *testing.T) {
 
 	t.Log(spew.Sdump(store.m))
 }
+
+func TestSharedHandles(t *testing.T) {
+	rwfs := New(MemoryStorage())
+	writeFile(t, rwfs, "file", "0123456789")
+
+	open := func(flag int) interface {
+		io.ReadWriteCloser
+		io.ReaderAt
+		io.WriterAt
+	} {
+		f, err := rwfs.OpenFile("file", flag, 0)
+		assert.NoError(t, err)
+		return f.(*fsFile)
+	}
+
+	a := open(os.O_RDWR)
+	b := open(os.O_RDWR)
+	r := open(os.O_RDONLY)
+
+	_, err := a.WriteAt([]byte("AA"), 0)
+	assert.NoError(t, err)
+	_, err = b.WriteAt([]byte("BB"), 5)
+	assert.NoError(t, err)
+
+	// Every handle sees the writes of the others right away.
+	buf := make([]byte, 10)
+	_, err = r.ReadAt(buf, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "AA234BB789", string(buf))
+
+	// Closing a handle writes back everything written so far, and the other
+	// handles keep writing to the same file.
+	assert.NoError(t, a.Close())
+
+	c := open(os.O_WRONLY | os.O_APPEND)
+	_, err = c.Write([]byte("!"))
+	assert.NoError(t, err)
+	_, err = b.WriteAt([]byte("CC"), 8)
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Close())
+	assert.NoError(t, b.Close())
+	assert.NoError(t, r.Close())
+
+	data, err := fs.ReadFile(rwfs, "file")
+	assert.NoError(t, err)
+	assert.Equal(t, "AA234BB7CC!", string(data))
+
+	// The shared data is dropped once every handle is closed.
+	assert.Equal(t, 0, len(rwfs.files))
+}
+
+func TestRename(t *testing.T) {
+	stores := map[string]func() Store{
+		"atomic": MemoryStorage,
+		// plainStore hides memoryStorage's Rename method, so FS has to move
+		// each key by itself.
+		"fallback": func() Store { return plainStore{MemoryStorage()} },
+		"idb":      func() Store { return NewIDBStore(MemoryIDB(0)) },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			rwfs := New(newStore())
+
+			assert.NoError(t, rwfs.MkdirAll("a/b", 0))
+			assert.NoError(t, rwfs.MkdirAll("ab", 0))
+			assert.NoError(t, rwfs.MkdirAll("empty", 0))
+			writeFile(t, rwfs, "a/b/c", "hello")
+			writeFile(t, rwfs, "a/d", "world")
+			writeFile(t, rwfs, "ab/e", "sibling")
+
+			assert.Error(t, rwfs.Rename("a", "a/b/a"))
+			assert.Error(t, rwfs.Rename("a", "ab"), "ab is not empty")
+			assert.Error(t, rwfs.Rename("a/d", "empty"), "cannot replace a directory with a file")
+			assert.Error(t, rwfs.Rename("missing", "x"))
+
+			assert.NoError(t, rwfs.Rename("a", "empty"))
+
+			_, err := fs.Stat(rwfs, "a")
+			assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+			b, err := fs.ReadFile(rwfs, "empty/b/c")
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(b))
+
+			assert.NoError(t, rwfs.Rename("empty/d", "ab/e"))
+
+			b, err = fs.ReadFile(rwfs, "ab/e")
+			assert.NoError(t, err)
+			assert.Equal(t, "world", string(b))
+
+			entries, err := rwfs.ReadDir("empty")
+			assert.NoError(t, err)
+			assert.Equal(t, 1, len(entries))
+			assert.Equal(t, "b", entries[0].Name())
+		})
+	}
+}
+
+type plainStore struct{ Store }
+
+func TestSymlink(t *testing.T) {
+	rwfs := New(MemoryStorage())
+
+	assert.NoError(t, rwfs.MkdirAll("a/b", 0755))
+	writeFile(t, rwfs, "a/b/c", "hello")
+
+	assert.NoError(t, rwfs.Symlink("b", "a/rel"))
+	assert.NoError(t, rwfs.Symlink("/a/b/c", "abs"))
+	assert.NoError(t, rwfs.Symlink("missing", "a/dangling"))
+	assert.NoError(t, rwfs.Symlink("loop2", "loop1"))
+	assert.NoError(t, rwfs.Symlink("loop1", "loop2"))
+	assert.Error(t, rwfs.Symlink("b", "a/rel"), "a/rel already exists")
+
+	b, err := fs.ReadFile(rwfs, "a/rel/c")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	b, err = fs.ReadFile(rwfs, "abs")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	stat, err := fs.Stat(rwfs, "abs")
+	assert.NoError(t, err)
+	assert.Equal(t, "abs", stat.Name())
+	assert.True(t, stat.Mode().IsRegular())
+
+	stat, err = rwfs.Lstat("abs")
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeSymlink, stat.Mode().Type())
+
+	target, err := rwfs.Readlink("a/rel")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", target)
+
+	_, err = rwfs.Readlink("a/b/c")
+	assert.Error(t, err)
+
+	entries, err := rwfs.ReadDir("a/rel")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+
+	_, err = fs.Stat(rwfs, "loop1")
+	assert.True(t, errors.Is(err, rwfspkg.ErrSymlinkLoop))
+
+	// Writing through a dangling link creates its target.
+	writeFile(t, rwfs, "a/dangling", "created")
+	b, err = fs.ReadFile(rwfs, "a/missing")
+	assert.NoError(t, err)
+	assert.Equal(t, "created", string(b))
+
+	// Removing a link leaves its target alone.
+	assert.NoError(t, rwfs.Remove("abs"))
+	_, err = rwfs.Lstat("abs")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = fs.Stat(rwfs, "a/b/c")
+	assert.NoError(t, err)
+}
+
+func writeFile(t *testing.T, rwfs *FS, name, data string) {
+	t.Helper()
+
+	f, err := rwfs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0)
+	assert.NoError(t, err)
+
+	_, err = f.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+}
+
+func TestNotify(t *testing.T) {
+	store := MemoryStorage()
+	fs1 := New(store)
+	fs2 := New(store)
+
+	var changes [][]string
+	unsubscribe := store.(NotifyStore).Subscribe(func(paths []string) {
+		changes = append(changes, paths)
+	})
+
+	assert.NoError(t, fs1.MkdirAll("a/b/c", 0755))
+	assert.Equal(t, [][]string{{"/a", "/a/b", "/a/b/c"}}, changes)
+
+	entries, err := fs2.ReadDir("a/b")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+
+	changes = nil
+	assert.NoError(t, fs2.RemoveAll("a"))
+	assert.Equal(t, [][]string{{"/a/b/c", "/a/b", "/a"}}, changes)
+
+	_, err = fs.Stat(fs1, "a")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	unsubscribe()
+	changes = nil
+	assert.NoError(t, fs1.Mkdir("d", 0755))
+	assert.Equal(t, 0, len(changes))
+}
+
+func TestWatch(t *testing.T) {
+	store := MemoryStorage()
+	rwfs := New(store)
+	other := New(store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := rwfs.Watch(ctx, "a", true)
+	assert.NoError(t, err)
+
+	expect := func(t *testing.T, want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case ev := <-events:
+				assert.Equal(t, w, ev.String())
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for %q", w)
+			}
+		}
+	}
+
+	assert.NoError(t, rwfs.MkdirAll("a/b", 0755))
+	expect(t, "CREATE a", "CREATE a/b")
+
+	writeFile(t, rwfs, "a/b/c", "hello")
+	expect(t, "CREATE a/b/c", "WRITE a/b/c")
+
+	assert.NoError(t, rwfs.Chmod("a/b/c", 0600))
+	expect(t, "CHMOD a/b/c")
+
+	assert.NoError(t, rwfs.Rename("a/b/c", "a/d"))
+	expect(t, "RENAME a/b/c", "CREATE a/d")
+
+	// Changes from other FS's on the same store can't be told apart.
+	writeFile(t, other, "a/d", "world")
+	expect(t, "WRITE a/d", "WRITE a/d")
+
+	assert.NoError(t, other.RemoveAll("a/b"))
+	expect(t, "REMOVE a/b")
+
+	// Nothing outside of a is seen.
+	assert.NoError(t, rwfs.Mkdir("b", 0755))
+	assert.NoError(t, rwfs.Remove("a/d"))
+	expect(t, "REMOVE a/d")
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestStoreOf(t *testing.T) {
+	store := MemoryStorage()
+
+	got, ok := StoreOf(New(store))
+	assert.True(t, ok)
+	assert.Equal(t, store, got)
+
+	got, ok = StoreOf(rwfspkg.OverlayFS(New(store), fstest.MapFS{}))
+	assert.True(t, ok)
+	assert.Equal(t, store, got)
+
+	_, ok = StoreOf(rwfspkg.ReadOnlyFS(New(store)))
+	assert.False(t, ok)
+}
@@ -13,22 +13,41 @@ import (
 const localStoragePrefix = "__kvfs"
 
 // LocalStorage is a simple key-value store that is persisted to the
-// browser's local storage.
+// browser's local storage. Changes made by other tabs are reported to its
+// subscribers through the window's storage events.
 func LocalStorage() Store {
-	return localStorage{
-		js.Global().Get("localStorage"),
-		js.Global().Get("Object"),
+	s := &localStorage{
+		js:     js.Global().Get("localStorage"),
+		object: js.Global().Get("Object"),
 	}
+
+	// This lives as long as the page, so it's never released.
+	js.Global().Call("addEventListener", "storage", js.FuncOf(func(this js.Value, args []js.Value) any {
+		key := args[0].Get("key")
+		if key.IsNull() || !strings.HasPrefix(key.String(), localStoragePrefix) {
+			return nil
+		}
+		// Subscribers may read from the store, which shouldn't be done
+		// from within a JS event handler.
+		go s.notify([]string{strings.TrimPrefix(key.String(), localStoragePrefix)})
+		return nil
+	}))
+
+	return s
 }
 
-var _ Store = localStorage{}
+var (
+	_ NotifyStore = (*localStorage)(nil)
+	_ TxStore     = (*localStorage)(nil)
+)
 
 type localStorage struct {
+	notifier
 	js     js.Value
 	object js.Value
 }
 
-func (s localStorage) Get(fullpath string) (StoredValue, error) {
+func (s *localStorage) Get(fullpath string) (StoredValue, error) {
 	fullpath = path.Clean("/" + fullpath)
 	key := localStoragePrefix + fullpath
 
@@ -45,29 +64,95 @@ func (s localStorage) Get(fullpath string) (StoredValue, error) {
 	return v, nil
 }
 
-func (s localStorage) Set(fullpath string, v StoredValue) error {
-	fullpath = path.Clean("/" + fullpath)
-	key := localStoragePrefix + fullpath
+func (s *localStorage) Set(fullpath string, v StoredValue) error {
+	return s.Apply([]Change{{Path: fullpath, Value: v}})
+}
 
-	b, err := json.Marshal(v)
-	if err != nil {
-		return errors.Wrap(err, "failed to marshal value")
+func (s *localStorage) Delete(fullpath string) error {
+	return s.Apply([]Change{{Path: fullpath}})
+}
+
+// Apply implements TxStore. Local storage has no transactions, so the old
+// values are read up front and written back if any of the changes fail, e.g.
+// because the quota is exceeded.
+func (s *localStorage) Apply(changes []Change) error {
+	paths := make([]string, len(changes))
+	values := make([]string, len(changes))
+	for i, c := range changes {
+		paths[i] = path.Clean("/" + c.Path)
+		if c.Value == nil {
+			continue
+		}
+
+		b, err := json.Marshal(c.Value)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal value")
+		}
+		values[i] = string(b)
 	}
 
-	s.js.Call("setItem", key, string(b))
+	old := make([]js.Value, len(changes))
+	for i, p := range paths {
+		old[i] = s.js.Call("getItem", localStoragePrefix+p)
+	}
+
+	for i, c := range changes {
+		key := localStoragePrefix + paths[i]
+
+		var err error
+		if c.Value == nil {
+			err = s.call("removeItem", key)
+		} else {
+			err = s.call("setItem", key, values[i])
+		}
+		if err != nil {
+			// Undo in reverse, so that a key changed more than once ends
+			// up with the value that it had before the first change.
+			for j := i - 1; j >= 0; j-- {
+				s.restore(localStoragePrefix+paths[j], old[j])
+			}
+			return errors.Wrapf(err, "cannot write %q", paths[i])
+		}
+	}
 
+	s.notify(paths)
 	return nil
 }
 
-func (s localStorage) Delete(fullpath string) error {
-	fullpath = path.Clean("/" + fullpath)
-	key := localStoragePrefix + fullpath
+// restore sets key back to its old value, as returned by getItem. It is
+// best-effort, since there is nothing left to do if it fails.
+func (s *localStorage) restore(key string, old js.Value) {
+	if old.IsNull() {
+		s.call("removeItem", key)
+	} else {
+		s.call("setItem", key, old.String())
+	}
+}
+
+// call calls a method of local storage. Exceptions thrown by it are returned
+// as errors, and running out of space is returned as ErrQuotaExceeded.
+func (s *localStorage) call(method string, args ...any) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		jsErr, ok := r.(js.Error)
+		if !ok {
+			panic(r)
+		}
+		if jsErr.Get("name").String() == "QuotaExceededError" {
+			err = ErrQuotaExceeded
+		} else {
+			err = jsErr
+		}
+	}()
 
-	s.js.Call("removeItem", key)
+	s.js.Call(method, args...)
 	return nil
 }
 
-func (s localStorage) List(prefix string, recursive bool) ([]PathedStoreValue, error) {
+func (s *localStorage) List(prefix string, recursive bool) ([]PathedStoreValue, error) {
 	localPrefix := localStoragePrefix + prefix
 
 	var values []PathedStoreValue
@@ -0,0 +1,102 @@
+package kvfs
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MemoryIDB is an IDBBackend that keeps everything in memory. Writes that
+// would take more than quota bytes fail with ErrQuotaExceeded, unless quota is
+// 0. It is mostly useful for testing IDBStore.
+func MemoryIDB(quota int64) IDBBackend {
+	stores := make(map[string]map[string][]byte, len(IDBStores))
+	for _, name := range IDBStores {
+		stores[name] = make(map[string][]byte)
+	}
+
+	return &memoryIDB{
+		stores: stores,
+		quota:  quota,
+	}
+}
+
+type memoryIDB struct {
+	mu     sync.RWMutex
+	stores map[string]map[string][]byte
+	usage  int64
+	quota  int64
+}
+
+func (db *memoryIDB) Get(store string, keys []string) ([][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	s, ok := db.stores[store]
+	if !ok {
+		return nil, errors.Errorf("no object store %q", store)
+	}
+
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		if v, ok := s[key]; ok {
+			values[i] = append([]byte{}, v...)
+		}
+	}
+
+	return values, nil
+}
+
+func (db *memoryIDB) Write(writes []IDBWrite) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Work out the usage first, so that nothing is applied if the quota is
+	// exceeded.
+	sizes := make(map[[2]string]int64)
+	usage := db.usage
+	for _, w := range writes {
+		s, ok := db.stores[w.Store]
+		if !ok {
+			return errors.Errorf("no object store %q", w.Store)
+		}
+
+		k := [2]string{w.Store, w.Key}
+		old, ok := sizes[k]
+		if !ok {
+			if v, exists := s[w.Key]; exists {
+				old = int64(len(w.Key) + len(v))
+			}
+		}
+
+		var size int64
+		if w.Value != nil {
+			size = int64(len(w.Key) + len(w.Value))
+		}
+
+		usage += size - old
+		sizes[k] = size
+	}
+
+	if db.quota > 0 && usage > db.quota {
+		return ErrQuotaExceeded
+	}
+
+	for _, w := range writes {
+		if w.Value == nil {
+			delete(db.stores[w.Store], w.Key)
+		} else {
+			db.stores[w.Store][w.Key] = append([]byte{}, w.Value...)
+		}
+	}
+	db.usage = usage
+
+	return nil
+}
+
+func (db *memoryIDB) Estimate() (usage, quota int64, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.usage, db.quota, nil
+}
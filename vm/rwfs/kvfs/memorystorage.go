@@ -21,11 +21,16 @@ func MemoryStorageFromExisting(m map[string]StoredValue) Store {
 }
 
 type memoryStorage struct {
+	notifier
 	mu sync.RWMutex
 	m  map[string]StoredValue
 }
 
-var _ Store = (*memoryStorage)(nil)
+var (
+	_ RenameStore = (*memoryStorage)(nil)
+	_ TxStore     = (*memoryStorage)(nil)
+	_ NotifyStore = (*memoryStorage)(nil)
+)
 
 func (s *memoryStorage) Get(fullpath string) (StoredValue, error) {
 	s.mu.RLock()
@@ -40,20 +45,25 @@ func (s *memoryStorage) Get(fullpath string) (StoredValue, error) {
 }
 
 func (s *memoryStorage) Set(fullpath string, v StoredValue) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	s.m[fullpath] = v
-
-	return nil
+	return s.Apply([]Change{{Path: fullpath, Value: v}})
 }
 
 func (s *memoryStorage) Delete(fullpath string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.Apply([]Change{{Path: fullpath}})
+}
 
-	delete(s.m, fullpath)
+func (s *memoryStorage) Apply(changes []Change) error {
+	s.mu.Lock()
+	for _, c := range changes {
+		if c.Value == nil {
+			delete(s.m, c.Path)
+		} else {
+			s.m[c.Path] = c.Value
+		}
+	}
+	s.mu.Unlock()
 
+	s.notify(changedPaths(changes))
 	return nil
 }
 
@@ -80,3 +90,34 @@ func (s *memoryStorage) List(fullpath string, recursive bool) ([]PathedStoreValu
 
 	return values, nil
 }
+
+func (s *memoryStorage) Rename(oldpath, newpath string) error {
+	s.mu.Lock()
+
+	v, ok := s.m[oldpath]
+	if !ok {
+		s.mu.Unlock()
+		return fs.ErrNotExist
+	}
+
+	paths := []string{oldpath, newpath}
+	moved := map[string]StoredValue{newpath: v}
+	for filepath, value := range s.m {
+		if strings.HasPrefix(filepath, oldpath+"/") {
+			newfilepath := newpath + strings.TrimPrefix(filepath, oldpath)
+			moved[newfilepath] = value
+			delete(s.m, filepath)
+			paths = append(paths, filepath, newfilepath)
+		}
+	}
+	delete(s.m, oldpath)
+
+	for filepath, value := range moved {
+		s.m[filepath] = value
+	}
+
+	s.mu.Unlock()
+
+	s.notify(paths)
+	return nil
+}
@@ -0,0 +1,59 @@
+package kvfs
+
+import "sync"
+
+// notifier keeps the subscribers of a NotifyStore. Its zero value is ready to
+// be used.
+type notifier struct {
+	mu   sync.Mutex
+	subs map[int]func([]string)
+	next int
+}
+
+// Subscribe implements NotifyStore.
+func (n *notifier) Subscribe(fn func(paths []string)) func() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.subs == nil {
+		n.subs = make(map[int]func([]string))
+	}
+
+	id := n.next
+	n.next++
+	n.subs[id] = fn
+
+	return func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.subs, id)
+	}
+}
+
+// notify calls every subscriber with the given paths. It must not be called
+// while holding the store's lock, since subscribers may read from it.
+func (n *notifier) notify(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+
+	n.mu.Lock()
+	subs := make([]func([]string), 0, len(n.subs))
+	for _, fn := range n.subs {
+		subs = append(subs, fn)
+	}
+	n.mu.Unlock()
+
+	for _, fn := range subs {
+		fn(paths)
+	}
+}
+
+// changedPaths returns the paths of the given changes.
+func changedPaths(changes []Change) []string {
+	paths := make([]string, len(changes))
+	for i, c := range changes {
+		paths[i] = c.Path
+	}
+	return paths
+}
@@ -0,0 +1,401 @@
+package kvfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errNotDir = errors.New("not a directory")
+
+// ImportPolicy decides what Import does with files that already exist.
+type ImportPolicy uint8
+
+const (
+	// Merge adds the files of the snapshot that don't exist yet and keeps
+	// the existing ones.
+	Merge ImportPolicy = iota
+	// Overwrite adds the files of the snapshot, replacing the existing ones.
+	// Existing files that aren't in the snapshot are kept.
+	Overwrite
+	// Replace deletes everything in the directory before restoring the
+	// snapshot into it.
+	Replace
+)
+
+// ImportPolicies is the list of all import policies.
+var ImportPolicies = []ImportPolicy{Merge, Overwrite, Replace}
+
+// String returns the name of the policy.
+func (p ImportPolicy) String() string {
+	switch p {
+	case Merge:
+		return "merge"
+	case Overwrite:
+		return "overwrite"
+	case Replace:
+		return "replace"
+	default:
+		return fmt.Sprintf("ImportPolicy(%d)", uint8(p))
+	}
+}
+
+// ParseImportPolicy parses the name of an import policy.
+func ParseImportPolicy(name string) (ImportPolicy, error) {
+	for _, p := range ImportPolicies {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown import policy %q", name)
+}
+
+// ImportStats counts what Import did.
+type ImportStats struct {
+	// Added is the number of files that didn't exist before.
+	Added int
+	// Replaced is the number of existing files that were replaced.
+	Replaced int
+	// Skipped is the number of files in the snapshot that weren't imported,
+	// either because they already exist or because kvfs can't store them.
+	Skipped int
+}
+
+// Export writes a snapshot of the directory dir in store to w. The snapshot is
+// a gzip-compressed tar archive of the directory's contents, so it can be
+// read by anything that reads those.
+func Export(w io.Writer, store Store, dir string) error {
+	dir = clean(dir)
+
+	v, err := store.Get(dir)
+	if err != nil && (dir != root || !errors.Is(err, fs.ErrNotExist)) {
+		return pathErr("export", dir, err)
+	}
+	if _, ok := v.(StoredDirectory); v != nil && !ok {
+		return pathErr("export", dir, errNotDir)
+	}
+
+	values, err := store.List(dirPrefix(dir), true)
+	if err != nil {
+		return pathErr("export", dir, err)
+	}
+
+	// Parents sort before their children.
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Path < values[j].Path
+	})
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, v := range values {
+		if v.Path == dir {
+			continue
+		}
+
+		hdr := snapshotHeader(v)
+		hdr.Name = strings.TrimPrefix(v.Path, dirPrefix(dir))
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return errors.Wrapf(err, "cannot write %s", v.Path)
+		}
+
+		if f, ok := v.StoredValue.(StoredFile); ok {
+			if _, err := tw.Write(f.Data); err != nil {
+				return errors.Wrapf(err, "cannot write %s", v.Path)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func snapshotHeader(v PathedStoreValue) *tar.Header {
+	switch sv := v.StoredValue.(type) {
+	case StoredFile:
+		return &tar.Header{
+			Typeflag: tar.TypeReg,
+			Mode:     int64(storedMode(sv.Mode)),
+			ModTime:  time.Unix(sv.ModTime, 0),
+			Size:     int64(len(sv.Data)),
+		}
+	case StoredDirectory:
+		return &tar.Header{
+			Typeflag: tar.TypeDir,
+			Mode:     int64(storedMode(sv.Mode)),
+			ModTime:  time.Unix(max(sv.CreateTime, sv.ModTime), 0),
+		}
+	case StoredSymlink:
+		return &tar.Header{
+			Typeflag: tar.TypeSymlink,
+			Mode:     0777,
+			ModTime:  time.Unix(sv.ModTime, 0),
+			Linkname: sv.Target,
+		}
+	default:
+		panic("unknown (impossible) stored value type")
+	}
+}
+
+// Import restores a snapshot made by Export from r into the directory dir in
+// store, which is created if needed. Uncompressed tar archives are also
+// accepted. The policy decides what happens to files that already exist.
+//
+// The snapshot is read completely before anything is changed, and all
+// changes are applied at once if the store implements TxStore.
+func Import(r io.Reader, store Store, dir string, policy ImportPolicy) (ImportStats, error) {
+	dir = clean(dir)
+
+	im := importer{
+		store:   store,
+		policy:  policy,
+		now:     time.Now().Unix(),
+		pending: make(map[string]StoredValue),
+	}
+
+	existing, err := store.List(dirPrefix(dir), true)
+	if err != nil {
+		return ImportStats{}, pathErr("import", dir, err)
+	}
+	im.existing = make(map[string]StoredValue, len(existing))
+	for _, v := range existing {
+		im.existing[v.Path] = v.StoredValue
+	}
+
+	if policy == Replace {
+		for p := range im.existing {
+			if p != dir {
+				im.pending[p] = nil
+			}
+		}
+	}
+
+	if err := im.makeDirs(dir); err != nil {
+		return ImportStats{}, pathErr("import", dir, err)
+	}
+
+	tr, err := newSnapshotReader(r)
+	if err != nil {
+		return ImportStats{}, err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return ImportStats{}, errors.Wrap(err, "cannot read snapshot")
+		}
+
+		key := path.Join(dir, path.Clean("/"+hdr.Name))
+		if key == dir {
+			continue
+		}
+
+		v, err := snapshotValue(hdr, tr)
+		if err != nil {
+			return ImportStats{}, errors.Wrapf(err, "cannot read %s", hdr.Name)
+		}
+		if v == nil {
+			im.stats.Skipped++
+			continue
+		}
+
+		im.add(key, v)
+	}
+
+	if err := applyChanges(store, im.changes()); err != nil {
+		return ImportStats{}, pathErr("import", dir, err)
+	}
+
+	return im.stats, nil
+}
+
+// newSnapshotReader returns a tar reader over r, which may be gzip-compressed.
+func newSnapshotReader(r io.Reader) (*tar.Reader, error) {
+	br := bufio.NewReader(r)
+
+	magic, _ := br.Peek(2)
+	if !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return tar.NewReader(br), nil
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read snapshot")
+	}
+	return tar.NewReader(gz), nil
+}
+
+// snapshotValue returns the value to store for the tar entry, or nil if kvfs
+// can't store it.
+func snapshotValue(hdr *tar.Header, r io.Reader) (StoredValue, error) {
+	mode := fs.FileMode(hdr.Mode).Perm()
+	mtime := hdr.ModTime.Unix()
+
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return StoredFile{ModTime: mtime, Mode: newMode(mode), Data: data}, nil
+	case tar.TypeDir:
+		return StoredDirectory{CreateTime: mtime, Mode: newMode(mode), IsDir: true}, nil
+	case tar.TypeSymlink:
+		return StoredSymlink{ModTime: mtime, Target: hdr.Linkname, IsSymlink: true}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// importer works out the changes that Import makes. pending holds the values
+// that are changed, with nil for deleted ones, and existing holds the values
+// that were there before.
+type importer struct {
+	store    Store
+	policy   ImportPolicy
+	now      int64
+	existing map[string]StoredValue
+	pending  map[string]StoredValue
+	stats    ImportStats
+}
+
+// get returns the value at p as it will be after the pending changes.
+func (im *importer) get(p string) StoredValue {
+	if v, ok := im.pending[p]; ok {
+		return v
+	}
+	if v, ok := im.existing[p]; ok {
+		return v
+	}
+	if v, err := im.store.Get(p); err == nil {
+		return v
+	}
+	return nil
+}
+
+// makeDirs makes sure that the directory at p and its parents exist.
+func (im *importer) makeDirs(p string) error {
+	if p == root {
+		return nil
+	}
+
+	switch v := im.get(p); v.(type) {
+	case StoredDirectory:
+		return nil
+	case nil:
+		if err := im.makeDirs(path.Dir(p)); err != nil {
+			return err
+		}
+		im.pending[p] = StoredDirectory{CreateTime: im.now, Mode: newMode(0755), IsDir: true}
+		return nil
+	default:
+		return errNotDir
+	}
+}
+
+// add adds the value from the snapshot at key, following the policy.
+func (im *importer) add(key string, v StoredValue) {
+	if !im.addParent(path.Dir(key)) {
+		im.stats.Skipped++
+		return
+	}
+
+	old := im.get(key)
+	_, oldIsDir := old.(StoredDirectory)
+	_, newIsDir := v.(StoredDirectory)
+
+	switch {
+	case old == nil:
+		im.stats.Added++
+	case oldIsDir && newIsDir:
+		// Directories are merged, so only their metadata is replaced.
+		if im.policy == Merge {
+			return
+		}
+		im.stats.Replaced++
+	case im.policy == Merge:
+		im.stats.Skipped++
+		return
+	default:
+		if oldIsDir {
+			im.deleteChildren(key)
+		}
+		im.stats.Replaced++
+	}
+
+	im.pending[key] = v
+}
+
+// addParent makes sure that the parent directory p of a file in the snapshot
+// exists. Snapshots made by Export list directories before their contents, so
+// it only makes missing directories for other archives. It returns false if
+// p is a file that the policy keeps.
+func (im *importer) addParent(p string) bool {
+	switch im.get(p).(type) {
+	case StoredDirectory:
+		return true
+	case nil:
+		if !im.addParent(path.Dir(p)) {
+			return false
+		}
+		im.pending[p] = StoredDirectory{CreateTime: im.now, Mode: newMode(0755), IsDir: true}
+		im.stats.Added++
+		return true
+	default:
+		if im.policy == Merge {
+			return false
+		}
+		if !im.addParent(path.Dir(p)) {
+			return false
+		}
+		im.pending[p] = StoredDirectory{CreateTime: im.now, Mode: newMode(0755), IsDir: true}
+		im.stats.Replaced++
+		return true
+	}
+}
+
+// deleteChildren deletes everything inside the directory at p.
+func (im *importer) deleteChildren(p string) {
+	prefix := dirPrefix(p)
+	for _, m := range []map[string]StoredValue{im.existing, im.pending} {
+		for k := range m {
+			if strings.HasPrefix(k, prefix) {
+				im.pending[k] = nil
+			}
+		}
+	}
+}
+
+// changes returns the pending changes in a stable order.
+func (im *importer) changes() []Change {
+	changes := make([]Change, 0, len(im.pending))
+	for p, v := range im.pending {
+		if v == nil {
+			if _, ok := im.existing[p]; !ok {
+				// Never stored, so nothing to delete.
+				continue
+			}
+		}
+		changes = append(changes, Change{Path: p, Value: v})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
@@ -0,0 +1,174 @@
+package kvfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	rwfspkg "libdb.so/vm/rwfs"
+)
+
+func readFile(t *testing.T, fsys *FS, name string) string {
+	t.Helper()
+	b, err := fs.ReadFile(fsys, name)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestSnapshot(t *testing.T) {
+	src := MemoryStorage()
+	srcFS := New(src)
+
+	assert.NoError(t, srcFS.MkdirAll("home/user/docs", 0700))
+	writeFile(t, srcFS, "home/user/a.txt", "a from snapshot")
+	assert.NoError(t, srcFS.Chmod("home/user/a.txt", 0640))
+	writeFile(t, srcFS, "home/user/docs/b.txt", "b from snapshot")
+	assert.NoError(t, srcFS.Symlink("docs/b.txt", "home/user/link"))
+	writeFile(t, srcFS, "outside.txt", "not in the snapshot")
+
+	var snapshot bytes.Buffer
+	assert.NoError(t, Export(&snapshot, src, "/home/user"))
+
+	t.Run("archive", func(t *testing.T) {
+		gz, err := gzip.NewReader(bytes.NewReader(snapshot.Bytes()))
+		assert.NoError(t, err)
+
+		var names []string
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err)
+			names = append(names, hdr.Name)
+		}
+
+		assert.Equal(t, []string{"a.txt", "docs", "docs/b.txt", "link"}, names)
+	})
+
+	// newDst returns a store with existing files that conflict with the
+	// snapshot.
+	newDst := func(t *testing.T) (Store, *FS) {
+		dst := MemoryStorage()
+		dstFS := New(dst)
+		assert.NoError(t, dstFS.MkdirAll("restored/docs", 0755))
+		writeFile(t, dstFS, "restored/a.txt", "existing a")
+		writeFile(t, dstFS, "restored/c.txt", "existing c")
+		return dst, dstFS
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		dst, dstFS := newDst(t)
+
+		stats, err := Import(bytes.NewReader(snapshot.Bytes()), dst, "/restored", Merge)
+		assert.NoError(t, err)
+		assert.Equal(t, ImportStats{Added: 2, Skipped: 1}, stats)
+
+		assert.Equal(t, "existing a", readFile(t, dstFS, "restored/a.txt"))
+		assert.Equal(t, "existing c", readFile(t, dstFS, "restored/c.txt"))
+		assert.Equal(t, "b from snapshot", readFile(t, dstFS, "restored/link"))
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		dst, dstFS := newDst(t)
+
+		stats, err := Import(bytes.NewReader(snapshot.Bytes()), dst, "/restored", Overwrite)
+		assert.NoError(t, err)
+		assert.Equal(t, ImportStats{Added: 2, Replaced: 2}, stats)
+
+		assert.Equal(t, "a from snapshot", readFile(t, dstFS, "restored/a.txt"))
+		assert.Equal(t, "existing c", readFile(t, dstFS, "restored/c.txt"))
+		assert.Equal(t, "b from snapshot", readFile(t, dstFS, "restored/docs/b.txt"))
+
+		info, err := fs.Stat(dstFS, "restored/docs")
+		assert.NoError(t, err)
+		assert.Equal(t, fs.ModeDir|0700, info.Mode())
+
+		info, err = fs.Stat(dstFS, "restored/a.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, fs.FileMode(0640), info.Mode())
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		dst, dstFS := newDst(t)
+
+		stats, err := Import(bytes.NewReader(snapshot.Bytes()), dst, "/restored", Replace)
+		assert.NoError(t, err)
+		assert.Equal(t, ImportStats{Added: 4}, stats)
+
+		_, err = fs.Stat(dstFS, "restored/c.txt")
+		assert.IsError(t, err, fs.ErrNotExist)
+
+		target, err := rwfspkg.Readlink(dstFS, "restored/link")
+		assert.NoError(t, err)
+		assert.Equal(t, "docs/b.txt", target)
+	})
+
+	t.Run("new directory", func(t *testing.T) {
+		dst := MemoryStorage()
+		dstFS := New(dst)
+
+		stats, err := Import(bytes.NewReader(snapshot.Bytes()), dst, "/a/b", Merge)
+		assert.NoError(t, err)
+		assert.Equal(t, ImportStats{Added: 4}, stats)
+		assert.Equal(t, "a from snapshot", readFile(t, dstFS, "a/b/a.txt"))
+	})
+
+	t.Run("not a directory", func(t *testing.T) {
+		_, err := Import(bytes.NewReader(snapshot.Bytes()), src, "/outside.txt/x", Merge)
+		assert.Error(t, err)
+
+		err = Export(io.Discard, src, "/outside.txt")
+		assert.Error(t, err)
+	})
+}
+
+func TestImportTar(t *testing.T) {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+
+	files := []*tar.Header{
+		// Files whose parent directories aren't in the archive.
+		{Name: "./a/b/c.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		// Entries that can't escape the directory.
+		{Name: "../../escape.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		// Entries that kvfs can't store.
+		{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0644},
+	}
+	for _, hdr := range files {
+		assert.NoError(t, tw.WriteHeader(hdr))
+		_, err := io.WriteString(tw, string(make([]byte, hdr.Size)))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+
+	store := MemoryStorage()
+	fsys := New(store)
+
+	stats, err := Import(&b, store, "/dst", Merge)
+	assert.NoError(t, err)
+	assert.Equal(t, ImportStats{Added: 4, Skipped: 1}, stats)
+
+	_, err = fs.Stat(fsys, "dst/a/b/c.txt")
+	assert.NoError(t, err)
+
+	_, err = fs.Stat(fsys, "dst/escape.txt")
+	assert.NoError(t, err)
+}
+
+func TestParseImportPolicy(t *testing.T) {
+	for _, p := range ImportPolicies {
+		parsed, err := ParseImportPolicy(p.String())
+		assert.NoError(t, err)
+		assert.Equal(t, p, parsed)
+	}
+
+	_, err := ParseImportPolicy("nope")
+	assert.Error(t, err)
+}
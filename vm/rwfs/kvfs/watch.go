@@ -0,0 +1,112 @@
+package kvfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+
+	"libdb.so/vm/rwfs"
+)
+
+// watchState keeps the watches of an FS. Events are made from the store's
+// change notifications, so changes made through other FS's sharing the same
+// store are seen too. The FS's own write paths leave hints about what kind of
+// change each path went through. Changes without a hint, such as those from
+// other FS's, are reported as writes or removals.
+type watchState struct {
+	watchers rwfs.Watchers
+	once     sync.Once
+	active   atomic.Bool
+
+	mu    sync.Mutex
+	hints map[string]rwfs.WatchOp
+}
+
+var _ rwfs.WatchFS = (*FS)(nil)
+
+// Watch implements rwfs.WatchFS. It requires the store to implement
+// NotifyStore.
+func (kvfs *FS) Watch(ctx context.Context, name string, recursive bool) (<-chan rwfs.WatchEvent, error) {
+	ns, ok := kvfs.store.(NotifyStore)
+	if !ok {
+		return nil, pathErr("watch", clean(name), errors.ErrUnsupported)
+	}
+
+	kvfs.watch.once.Do(func() {
+		// This lives as long as the FS, so it's never unsubscribed.
+		ns.Subscribe(kvfs.notified)
+		kvfs.watch.active.Store(true)
+	})
+
+	return kvfs.watch.watchers.Watch(ctx, name, recursive), nil
+}
+
+// hint tells watches what kind of change is about to be made to the given
+// full paths. The returned function must be called once the change is done.
+func (kvfs *FS) hint(op rwfs.WatchOp, fullpaths ...string) func() {
+	if !kvfs.watch.active.Load() || len(fullpaths) == 0 {
+		return func() {}
+	}
+
+	w := &kvfs.watch
+
+	w.mu.Lock()
+	if w.hints == nil {
+		w.hints = make(map[string]rwfs.WatchOp)
+	}
+	for _, p := range fullpaths {
+		w.hints[p] = op
+	}
+	w.mu.Unlock()
+
+	return func() {
+		w.mu.Lock()
+		for _, p := range fullpaths {
+			delete(w.hints, p)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// notified is subscribed to the store once something is watched.
+func (kvfs *FS) notified(fullpaths []string) {
+	if kvfs.watch.watchers.Len() == 0 {
+		return
+	}
+
+	events := make([]rwfs.WatchEvent, 0, len(fullpaths))
+	for _, p := range fullpaths {
+		kvfs.watch.mu.Lock()
+		op, ok := kvfs.watch.hints[p]
+		kvfs.watch.mu.Unlock()
+
+		if !ok {
+			// This only needs the store, not the FS's lock, which the
+			// writer may still be holding.
+			_, err := kvfs.store.Get(p)
+			switch {
+			case err == nil:
+				op = rwfs.WatchWrite
+			case errors.Is(err, fs.ErrNotExist):
+				op = rwfs.WatchRemove
+			default:
+				continue
+			}
+		}
+
+		events = append(events, rwfs.WatchEvent{Name: p, Op: op})
+	}
+
+	kvfs.watch.watchers.Send(events...)
+}
+
+// These are for methods whose receiver shadows the rwfs package.
+const (
+	watchCreate = rwfs.WatchCreate
+	watchWrite  = rwfs.WatchWrite
+	watchRemove = rwfs.WatchRemove
+	watchRename = rwfs.WatchRename
+	watchChmod  = rwfs.WatchChmod
+)
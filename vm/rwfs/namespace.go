@@ -0,0 +1,510 @@
+package rwfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrBusy is returned when removing, renaming or unmounting a mount point
+	// that is in use.
+	ErrBusy = errors.New("device or resource busy")
+	// ErrCrossDevice is returned when renaming a file across mounts.
+	ErrCrossDevice = errors.New("invalid cross-device link")
+)
+
+// Mount is a filesystem mounted in a Namespace.
+type Mount struct {
+	// Point is the absolute path that the filesystem is mounted on.
+	Point string
+	// FS is the mounted filesystem. If it implements io.Closer, then it is
+	// closed when it's unmounted.
+	FS FS
+	// Type is the type of the filesystem, such as "kvfs" or "tmpfs".
+	Type string
+	// Source is where the filesystem comes from, such as a URL or a file.
+	Source string
+	// Options are the mount options, such as "ro".
+	Options []string
+}
+
+// Namespace is a read-writable filesystem that is made of filesystems mounted
+// on its directories. Paths are routed to the filesystem with the longest
+// mount point that contains them, and symbolic links are resolved across
+// mounts. Something must be mounted on "/" before it can be used.
+//
+// Renaming files across mounts fails with ErrCrossDevice.
+type Namespace struct {
+	mu     sync.RWMutex
+	mounts []Mount // sorted by mount point
+}
+
+var (
+	_ FS           = (*Namespace)(nil)
+	_ RenameFS     = (*Namespace)(nil)
+	_ ChmodFS      = (*Namespace)(nil)
+	_ ChtimesFS    = (*Namespace)(nil)
+	_ SymlinkFS    = (*Namespace)(nil)
+	_ WatchFS      = (*Namespace)(nil)
+	_ fs.StatFS    = (*Namespace)(nil)
+	_ fs.ReadDirFS = (*Namespace)(nil)
+)
+
+// NewNamespace returns a new Namespace with root mounted on "/". Its Point is
+// ignored.
+func NewNamespace(root Mount) *Namespace {
+	root.Point = "/"
+	return &Namespace{mounts: []Mount{root}}
+}
+
+// Mount mounts m.FS on m.Point, which must be an existing directory that
+// nothing is mounted on yet.
+func (ns *Namespace) Mount(m Mount) error {
+	point, err := ns.resolve(ConvertAbs(m.Point), true)
+	if err != nil {
+		return &fs.PathError{Op: "mount", Path: m.Point, Err: err}
+	}
+
+	s, err := ns.Stat(point)
+	if err != nil {
+		return err
+	}
+	if !s.IsDir() {
+		return &fs.PathError{Op: "mount", Path: m.Point, Err: fs.ErrInvalid}
+	}
+
+	m.Point = "/" + strings.TrimPrefix(point, ".")
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	i, found := ns.find(m.Point)
+	if found {
+		return &fs.PathError{Op: "mount", Path: m.Point, Err: ErrBusy}
+	}
+
+	ns.mounts = append(ns.mounts, Mount{})
+	copy(ns.mounts[i+1:], ns.mounts[i:])
+	ns.mounts[i] = m
+
+	return nil
+}
+
+// Unmount unmounts the filesystem mounted on point. It fails with ErrBusy if
+// point is "/" or if other filesystems are mounted under it.
+func (ns *Namespace) Unmount(point string) error {
+	point = "/" + strings.TrimPrefix(ConvertAbs(point), ".")
+
+	ns.mu.Lock()
+
+	i, found := ns.find(point)
+	if !found {
+		ns.mu.Unlock()
+		return &fs.PathError{Op: "umount", Path: point, Err: fs.ErrInvalid}
+	}
+
+	if point == "/" || ns.hasMountsUnder(point) {
+		ns.mu.Unlock()
+		return &fs.PathError{Op: "umount", Path: point, Err: ErrBusy}
+	}
+
+	m := ns.mounts[i]
+	ns.mounts = append(ns.mounts[:i], ns.mounts[i+1:]...)
+
+	ns.mu.Unlock()
+
+	if closer, ok := m.FS.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return &fs.PathError{Op: "umount", Path: point, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// Mounts returns all mounts sorted by their mount points.
+func (ns *Namespace) Mounts() []Mount {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	mounts := make([]Mount, len(ns.mounts))
+	copy(mounts, ns.mounts)
+	return mounts
+}
+
+// find returns the index of the mount on point, or where it would be.
+func (ns *Namespace) find(point string) (int, bool) {
+	return sort.Find(len(ns.mounts), func(i int) int {
+		return strings.Compare(point, ns.mounts[i].Point)
+	})
+}
+
+// hasMountsUnder returns true if anything is mounted under dir, not counting
+// dir itself.
+func (ns *Namespace) hasMountsUnder(dir string) bool {
+	for _, m := range ns.mounts {
+		if m.Point != dir && isUnder(m.Point, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPoint returns true if something is mounted on the given cleaned name.
+func (ns *Namespace) isPoint(name string) bool {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	_, found := ns.find("/" + strings.TrimPrefix(name, "."))
+	return found
+}
+
+// isUnder returns true if the absolute path name is dir or is inside it.
+func isUnder(name, dir string) bool {
+	return dir == "/" || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// route returns the mount that has the given cleaned name, along with the
+// name within it.
+func (ns *Namespace) route(name string) (Mount, string) {
+	abs := "/" + strings.TrimPrefix(name, ".")
+
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	var mount Mount
+	for _, m := range ns.mounts {
+		if isUnder(abs, m.Point) && len(m.Point) >= len(mount.Point) {
+			mount = m
+		}
+	}
+
+	return mount, ConvertAbs(strings.TrimPrefix(abs, strings.TrimSuffix(mount.Point, "/")))
+}
+
+// resolve resolves the symbolic links in the cleaned name, so that links can
+// point across mounts. The last component is only followed if followLast is
+// true.
+func (ns *Namespace) resolve(name string, followLast bool) (string, error) {
+	parts := Split(name)
+	resolved := "."
+	links := 0
+
+	for i := 0; i < len(parts); i++ {
+		next := path.Join(resolved, parts[i])
+		if i == len(parts)-1 && !followLast {
+			return next, nil
+		}
+
+		m, rel := ns.route(next)
+		s, err := Lstat(m.FS, rel)
+		if err != nil {
+			// Nothing under a missing path can exist either.
+			return path.Join(append([]string{next}, parts[i+1:]...)...), nil
+		}
+
+		if s.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > MaxSymlinks {
+			return name, ErrSymlinkLoop
+		}
+
+		target, err := Readlink(m.FS, rel)
+		if err != nil {
+			return name, err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join("/", resolved, target)
+		}
+
+		parts = append(Split(target), parts[i+1:]...)
+		resolved = "."
+		i = -1
+	}
+
+	return resolved, nil
+}
+
+// Lookup resolves the symbolic links in name and returns the mount that has
+// it, along with its name within the mount.
+func (ns *Namespace) Lookup(name string) (Mount, string, error) {
+	return ns.lookup("lookup", name, true)
+}
+
+// lookup resolves name and routes it to its mount.
+func (ns *Namespace) lookup(op, name string, followLast bool) (Mount, string, error) {
+	resolved, err := ns.resolve(ConvertAbs(name), followLast)
+	if err != nil {
+		return Mount{}, "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	m, rel := ns.route(resolved)
+	return m, rel, nil
+}
+
+// Open implements fs.FS.
+func (ns *Namespace) Open(name string) (fs.File, error) {
+	m, rel, err := ns.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := m.FS.Open(rel)
+	return f, m.fixErr(err)
+}
+
+// OpenFile implements FS.
+func (ns *Namespace) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m, rel, err := ns.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := m.FS.OpenFile(rel, flag, perm)
+	return f, m.fixErr(err)
+}
+
+// Stat implements fs.StatFS.
+func (ns *Namespace) Stat(name string) (fs.FileInfo, error) {
+	m, rel, err := ns.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(m.FS, rel)
+	return info, m.fixErr(err)
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (ns *Namespace) ReadDir(name string) ([]fs.DirEntry, error) {
+	m, rel, err := ns.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(m.FS, rel)
+	return entries, m.fixErr(err)
+}
+
+// Remove implements FS. Mount points can't be removed.
+func (ns *Namespace) Remove(name string) error {
+	resolved, err := ns.resolve(ConvertAbs(name), false)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if ns.isPoint(resolved) {
+		return &fs.PathError{Op: "remove", Path: name, Err: ErrBusy}
+	}
+
+	m, rel := ns.route(resolved)
+	return m.fixErr(m.FS.Remove(rel))
+}
+
+// RemoveAll implements FS. Directories that have mount points in them can't
+// be removed.
+func (ns *Namespace) RemoveAll(name string) error {
+	resolved, err := ns.resolve(ConvertAbs(name), false)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	abs := "/" + strings.TrimPrefix(resolved, ".")
+
+	ns.mu.RLock()
+	_, isPoint := ns.find(abs)
+	busy := isPoint || ns.hasMountsUnder(abs)
+	ns.mu.RUnlock()
+
+	if busy {
+		return &fs.PathError{Op: "remove", Path: name, Err: ErrBusy}
+	}
+
+	m, rel := ns.route(resolved)
+	return m.fixErr(m.FS.RemoveAll(rel))
+}
+
+// Mkdir implements FS.
+func (ns *Namespace) Mkdir(name string, perm fs.FileMode) error {
+	m, rel, err := ns.lookup("mkdir", name, false)
+	if err != nil {
+		return err
+	}
+	return m.fixErr(m.FS.Mkdir(rel, perm))
+}
+
+// MkdirAll implements FS.
+func (ns *Namespace) MkdirAll(name string, perm fs.FileMode) error {
+	m, rel, err := ns.lookup("mkdir", name, true)
+	if err != nil {
+		return err
+	}
+	return m.fixErr(m.FS.MkdirAll(rel, perm))
+}
+
+// Rename implements RenameFS. Both names must be on the same mount, and
+// neither can be a mount point.
+func (ns *Namespace) Rename(oldname, newname string) error {
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+
+	oldResolved, err := ns.resolve(ConvertAbs(oldname), false)
+	if err != nil {
+		return linkErr(err)
+	}
+	newResolved, err := ns.resolve(ConvertAbs(newname), false)
+	if err != nil {
+		return linkErr(err)
+	}
+
+	if ns.isPoint(oldResolved) || ns.isPoint(newResolved) {
+		return linkErr(ErrBusy)
+	}
+
+	oldMount, oldRel := ns.route(oldResolved)
+	newMount, newRel := ns.route(newResolved)
+	if oldMount.Point != newMount.Point {
+		return linkErr(ErrCrossDevice)
+	}
+
+	return oldMount.fixErr(Rename(oldMount.FS, oldRel, newRel))
+}
+
+// Chmod implements ChmodFS.
+func (ns *Namespace) Chmod(name string, mode fs.FileMode) error {
+	m, rel, err := ns.lookup("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	return m.fixErr(Chmod(m.FS, rel, mode))
+}
+
+// Chtimes implements ChtimesFS.
+func (ns *Namespace) Chtimes(name string, atime, mtime time.Time) error {
+	m, rel, err := ns.lookup("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	return m.fixErr(Chtimes(m.FS, rel, atime, mtime))
+}
+
+// Lstat implements ReadlinkFS.
+func (ns *Namespace) Lstat(name string) (fs.FileInfo, error) {
+	m, rel, err := ns.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	info, err := Lstat(m.FS, rel)
+	return info, m.fixErr(err)
+}
+
+// Readlink implements ReadlinkFS.
+func (ns *Namespace) Readlink(name string) (string, error) {
+	m, rel, err := ns.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	target, err := Readlink(m.FS, rel)
+	return target, m.fixErr(err)
+}
+
+// Symlink implements SymlinkFS. Absolute targets are resolved from the root
+// of the namespace, not of the link's mount.
+func (ns *Namespace) Symlink(oldname, newname string) error {
+	resolved, err := ns.resolve(ConvertAbs(newname), false)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+
+	m, rel := ns.route(resolved)
+	return m.fixErr(Symlink(m.FS, oldname, rel))
+}
+
+// Watch implements WatchFS. Only the mount that has name is watched, so
+// recursive watches don't see changes on filesystems mounted under name.
+func (ns *Namespace) Watch(ctx context.Context, name string, recursive bool) (<-chan WatchEvent, error) {
+	m, rel, err := ns.lookup("watch", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := Watch(ctx, m.FS, rel, recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Point == "/" {
+		return in, nil
+	}
+
+	out := make(chan WatchEvent)
+	go func() {
+		defer close(out)
+
+		for ev := range in {
+			ev.Name = ConvertAbs(path.Join(m.Point, ev.Name))
+
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// fixErr prefixes the paths in errors from the mounted filesystem with the
+// mount point, so that they're paths in the namespace.
+func (m Mount) fixErr(err error) error {
+	if err == nil || m.Point == "/" {
+		return err
+	}
+
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		pathErr.Path = path.Join(m.Point, pathErr.Path)
+	}
+
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		// The old name of a symlink is its target, which is kept as-is.
+		if linkErr.Op != "symlink" {
+			linkErr.Old = path.Join(m.Point, linkErr.Old)
+		}
+		linkErr.New = path.Join(m.Point, linkErr.New)
+	}
+
+	return err
+}
+
+// String returns the mount in the format of /proc/mounts.
+func (m Mount) String() string {
+	opts := "rw"
+	if len(m.Options) > 0 {
+		opts = strings.Join(m.Options, ",")
+	}
+	return fmt.Sprintf("%s %s %s %s 0 0", escapeMountField(m.Source), escapeMountField(m.Point), m.Type, opts)
+}
+
+// escapeMountField escapes whitespace in a field of /proc/mounts like Linux
+// does.
+func escapeMountField(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return strings.NewReplacer(
+		" ", `\040`,
+		"\t", `\011`,
+		"\n", `\012`,
+		`\`, `\134`,
+	).Replace(s)
+}
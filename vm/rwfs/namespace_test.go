@@ -0,0 +1,200 @@
+package rwfs_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/kvfs"
+)
+
+func newTestFS() rwfs.FS {
+	return kvfs.New(kvfs.MemoryStorage())
+}
+
+// newTestNamespace returns a namespace with an empty filesystem mounted on
+// each of the given directories, which are created first.
+func newTestNamespace(t *testing.T, points ...string) *rwfs.Namespace {
+	t.Helper()
+
+	ns := rwfs.NewNamespace(rwfs.Mount{FS: newTestFS(), Type: "kvfs", Source: "root"})
+	for _, point := range points {
+		assert.NoError(t, ns.MkdirAll(point, 0755))
+		assert.NoError(t, ns.Mount(rwfs.Mount{Point: point, FS: newTestFS(), Type: "tmpfs"}))
+	}
+	return ns
+}
+
+func TestNamespaceRouting(t *testing.T) {
+	ns := rwfs.NewNamespace(rwfs.Mount{FS: newTestFS(), Type: "kvfs"})
+	tmp := newTestFS()
+
+	assert.NoError(t, ns.Mkdir("/tmp", 0755))
+	assert.NoError(t, ns.Mkdir("/tmpfoo", 0755))
+	assert.NoError(t, ns.Mount(rwfs.Mount{Point: "/tmp", FS: tmp, Type: "tmpfs"}))
+
+	writeFile(t, ns, "/tmp/a", "in tmp")
+	writeFile(t, ns, "/tmpfoo/a", "in root")
+
+	// /tmp/a is on the mount, at its root.
+	b, err := fs.ReadFile(tmp, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "in tmp", string(b))
+
+	// /tmpfoo only shares a prefix with /tmp, so it stays on the root.
+	_, err = fs.Stat(tmp, "foo")
+	assert.IsError(t, err, fs.ErrNotExist)
+	b, err = fs.ReadFile(ns, "tmpfoo/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "in root", string(b))
+
+	// Nested mounts win over their parents.
+	assert.NoError(t, ns.Mkdir("/tmp/sub", 0755))
+	sub := newTestFS()
+	assert.NoError(t, ns.Mount(rwfs.Mount{Point: "/tmp/sub", FS: sub, Type: "tmpfs"}))
+
+	writeFile(t, ns, "/tmp/sub/b", "in sub")
+	_, err = fs.Stat(sub, "b")
+	assert.NoError(t, err)
+	_, err = fs.Stat(tmp, "sub/b")
+	assert.IsError(t, err, fs.ErrNotExist)
+
+	m, rel, err := ns.Lookup("/tmp/sub/b")
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/sub", m.Point)
+	assert.Equal(t, "b", rel)
+
+	m, rel, err = ns.Lookup("/tmpfoo")
+	assert.NoError(t, err)
+	assert.Equal(t, "/", m.Point)
+	assert.Equal(t, "tmpfoo", rel)
+
+	// Errors have paths in the namespace.
+	_, err = ns.Open("/tmp/sub/missing")
+	var pathErr *fs.PathError
+	assert.True(t, errors.As(err, &pathErr))
+	assert.Equal(t, "/tmp/sub/missing", pathErr.Path)
+}
+
+func TestNamespaceMount(t *testing.T) {
+	ns := newTestNamespace(t, "/mnt")
+
+	// Mount points must be existing directories that aren't mounted on.
+	writeFile(t, ns, "/file", "")
+	assert.Error(t, ns.Mount(rwfs.Mount{Point: "/file", FS: newTestFS()}))
+	assert.Error(t, ns.Mount(rwfs.Mount{Point: "/missing", FS: newTestFS()}))
+	assert.IsError(t, ns.Mount(rwfs.Mount{Point: "/mnt", FS: newTestFS()}), rwfs.ErrBusy)
+}
+
+func TestNamespaceUnmount(t *testing.T) {
+	ns := newTestNamespace(t, "/mnt", "/mnt/inner")
+
+	assert.IsError(t, ns.Unmount("/"), rwfs.ErrBusy)
+	assert.IsError(t, ns.Unmount("/mnt"), rwfs.ErrBusy)
+	assert.IsError(t, ns.Unmount("/nothing"), fs.ErrInvalid)
+
+	assert.NoError(t, ns.Unmount("/mnt/inner"))
+	assert.NoError(t, ns.Unmount("/mnt"))
+
+	points := make([]string, 0, 1)
+	for _, m := range ns.Mounts() {
+		points = append(points, m.Point)
+	}
+	assert.Equal(t, []string{"/"}, points)
+}
+
+func TestNamespaceBusy(t *testing.T) {
+	ns := newTestNamespace(t, "/mnt/a")
+
+	assert.IsError(t, ns.Remove("/mnt/a"), rwfs.ErrBusy)
+	assert.IsError(t, ns.RemoveAll("/mnt"), rwfs.ErrBusy)
+	assert.IsError(t, rwfs.Rename(ns, "/mnt/a", "/mnt/b"), rwfs.ErrBusy)
+}
+
+func TestNamespaceRename(t *testing.T) {
+	ns := newTestNamespace(t, "/mnt")
+
+	writeFile(t, ns, "/a", "a")
+	writeFile(t, ns, "/mnt/b", "b")
+
+	assert.IsError(t, rwfs.Rename(ns, "/a", "/mnt/a"), rwfs.ErrCrossDevice)
+	assert.IsError(t, rwfs.Rename(ns, "/mnt/b", "/b"), rwfs.ErrCrossDevice)
+
+	// Renames within a mount work as usual.
+	assert.NoError(t, rwfs.Rename(ns, "/mnt/b", "/mnt/c"))
+	b, err := fs.ReadFile(ns, "mnt/c")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", string(b))
+}
+
+func TestNamespaceSymlinks(t *testing.T) {
+	ns := newTestNamespace(t, "/mnt")
+
+	assert.NoError(t, ns.Mkdir("/mnt/dir", 0755))
+	writeFile(t, ns, "/mnt/dir/file", "on mnt")
+	writeFile(t, ns, "/root.txt", "on root")
+
+	// An absolute link on the root into the mount.
+	assert.NoError(t, rwfs.Symlink(ns, "/mnt/dir", "/link"))
+	b, err := fs.ReadFile(ns, "link/file")
+	assert.NoError(t, err)
+	assert.Equal(t, "on mnt", string(b))
+
+	// A relative link on the mount out to the root.
+	assert.NoError(t, rwfs.Symlink(ns, "../../root.txt", "/mnt/dir/up"))
+	b, err = fs.ReadFile(ns, "mnt/dir/up")
+	assert.NoError(t, err)
+	assert.Equal(t, "on root", string(b))
+
+	// Absolute targets are resolved from the root of the namespace, even
+	// when the link is on a mount.
+	assert.NoError(t, rwfs.Symlink(ns, "/root.txt", "/mnt/abs"))
+	b, err = fs.ReadFile(ns, "mnt/abs")
+	assert.NoError(t, err)
+	assert.Equal(t, "on root", string(b))
+
+	// Files created through a link land on the link's target mount.
+	writeFile(t, ns, "/link/new", "new")
+	_, err = fs.Stat(ns, "mnt/dir/new")
+	assert.NoError(t, err)
+
+	target, err := rwfs.Readlink(ns, "/link")
+	assert.NoError(t, err)
+	assert.Equal(t, "/mnt/dir", target)
+}
+
+func TestNamespaceReadDir(t *testing.T) {
+	ns := newTestNamespace(t, "/mnt", "/tmp")
+	writeFile(t, ns, "/file", "")
+	writeFile(t, ns, "/mnt/inside", "")
+
+	assert.Equal(t, []string{"file", "mnt", "tmp"}, readDirNames(t, ns, "."))
+	assert.Equal(t, []string{"inside"}, readDirNames(t, ns, "mnt"))
+	assert.Equal(t, []string{}, readDirNames(t, ns, "tmp"))
+}
+
+func TestMountString(t *testing.T) {
+	tests := []struct {
+		mount rwfs.Mount
+		line  string
+	}{
+		{
+			mount: rwfs.Mount{Point: "/", Type: "kvfs", Source: "idb"},
+			line:  "idb / kvfs rw 0 0",
+		},
+		{
+			mount: rwfs.Mount{Point: "/tmp", Type: "tmpfs"},
+			line:  "none /tmp tmpfs rw 0 0",
+		},
+		{
+			mount: rwfs.Mount{Point: "/my files", Type: "zip", Source: `a\b.zip`, Options: []string{"ro", "noatime"}},
+			line:  `a\134b.zip /my\040files zip ro,noatime 0 0`,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.line, test.mount.String())
+	}
+}
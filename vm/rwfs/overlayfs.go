@@ -1,18 +1,57 @@
 package rwfs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path"
+	"sort"
+	"strings"
+	"time"
 )
 
 // OverlayFS is a read-writable filesystem that overlays multiple filesystems.
+//
+// Writes always go to the read-write filesystem. Files and directories that
+// only exist on the read-only filesystems are copied up to the read-write
+// filesystem before they're written to, and the copy wins on lookup.
+// Removing something that exists on the read-only filesystems leaves a
+// whiteout file on the read-write filesystem that hides it.
 func OverlayFS(rw FS, ro ...fs.FS) FS {
 	return overlayFS{rw, ro}
 }
 
+// OverlayUpper returns the read-write filesystem of fsys if it was made by
+// OverlayFS.
+func OverlayUpper(fsys fs.FS) (FS, bool) {
+	o, ok := fsys.(overlayFS)
+	return o.rw, ok
+}
+
+const (
+	// WhiteoutPrefix is the prefix of whiteout files. A file named
+	// ".wh.name" on the read-write filesystem hides "name" on the read-only
+	// filesystems.
+	WhiteoutPrefix = ".wh."
+	// OpaqueMarker is the name of the file that marks a directory on the
+	// read-write filesystem as opaque. Nothing under an opaque directory is
+	// looked up on the read-only filesystems.
+	OpaqueMarker = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+)
+
+// isMarker returns true if name is a whiteout or an opaque marker. Markers
+// are never visible through the overlay.
+func isMarker(name string) bool {
+	return strings.HasPrefix(path.Base(name), WhiteoutPrefix)
+}
+
+// whiteoutPath returns the path of the whiteout file for name.
+func whiteoutPath(name string) string {
+	return path.Join(path.Dir(name), WhiteoutPrefix+path.Base(name))
+}
+
 type overlayFS struct {
 	rw FS
 	ro []fs.FS
@@ -20,178 +59,835 @@ type overlayFS struct {
 
 var (
 	_ FS           = overlayFS{}
+	_ RenameFS     = overlayFS{}
+	_ ChmodFS      = overlayFS{}
+	_ ChtimesFS    = overlayFS{}
+	_ SymlinkFS    = overlayFS{}
+	_ fs.StatFS    = overlayFS{}
 	_ fs.ReadDirFS = overlayFS{}
+	_ WatchFS      = overlayFS{}
+	_ UsageFS      = overlayFS{}
 )
 
 func (o overlayFS) Open(name string) (fs.File, error) {
-	name = ConvertAbs(name)
+	name, err := o.resolve(ConvertAbs(name), true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
 
-	// Check the read-only filesystem first because it's read-only, so it cannot
-	// be removed or written to.
-	for _, ro := range o.ro {
-		if f, err := ro.Open(name); !errors.Is(err, fs.ErrNotExist) {
-			if err != nil {
-				return nil, err
-			}
-			return readDirableROFile{f, name, o}, nil
+	if isMarker(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	// Check the read-write filesystem first, since it has the copied-up
+	// versions of the files on the read-only filesystems.
+	f, err := o.rw.Open(name)
+	if err == nil {
+		return o.wrapDir(f, name), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	ro, _, err := o.statRO(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
 		}
+		return nil, err
 	}
 
-	// Then check the read-write filesystem.
-	if f, err := o.rw.Open(name); err == nil {
-		return readDirableROFile{f, name, o}, nil
+	f, err = ro.Open(name)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	return o.wrapDir(f, name), nil
 }
 
 func (o overlayFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
-	name = ConvertAbs(name)
+	linkname := ConvertAbs(name)
+
+	name, err := o.resolve(linkname, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "openfile", Path: linkname, Err: err}
+	}
+
+	if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL && name != linkname {
+		// Like open(2), O_EXCL fails on symbolic links, even dangling ones.
+		return nil, &fs.PathError{Op: "openfile", Path: linkname, Err: fs.ErrExist}
+	}
+
+	if isMarker(name) {
+		err := fs.ErrNotExist
+		if flag&os.O_CREATE != 0 {
+			err = fs.ErrInvalid
+		}
+		return nil, &fs.PathError{Op: "openfile", Path: name, Err: err}
+	}
+
+	if _, err := fs.Stat(o.rw, name); err == nil {
+		return o.rw.OpenFile(name, flag, perm)
+	}
+
+	write := flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC|os.O_CREATE) != 0
 
-	for _, ro := range o.ro {
+	ro, s, err := o.statRO(name)
+	switch {
+	case err == nil && !write:
 		f, err := ro.Open(name)
-		if err == nil {
-			if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND) != 0 {
-				f.Close()
-				return nil, &fs.PathError{
-					Op:   "openfile",
-					Path: name,
-					Err:  fs.ErrPermission,
-				}
-			}
-			return roFile{f}, nil
-		} else {
-			if !errors.Is(err, fs.ErrNotExist) {
-				return nil, err
-			}
+		if err != nil {
+			return nil, err
+		}
+		return WrapFile(f), nil
+
+	case err == nil:
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, &fs.PathError{Op: "openfile", Path: name, Err: fs.ErrExist}
 		}
+		if s.IsDir() {
+			return nil, &fs.PathError{Op: "openfile", Path: name, Err: fs.ErrInvalid}
+		}
+		// Copy the file up, so that the write goes to our own copy.
+		if err := o.copyUp(name, ro, s); err != nil {
+			return nil, &fs.PathError{Op: "openfile", Path: name, Err: err}
+		}
+		return o.rw.OpenFile(name, flag, perm)
+
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	case flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "openfile", Path: name, Err: fs.ErrNotExist}
 	}
 
-	// If we're creating a file, then there's a chance that the directory that
+	// We're creating a new file. There's a chance that the directory that
 	// we're in exists on the read-only filesystem but not the read-write
 	// filesystem. In that case, we'll need to create the directory.
-	if flag&os.O_CREATE != 0 {
-		dir := path.Dir(name)
+	if err := o.copyUpDir(path.Dir(name)); err != nil {
+		return nil, &fs.PathError{Op: "openfile", Path: name, Err: err}
+	}
+
+	f, err := o.rw.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.replaceWhiteout(name, false); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Stat implements fs.StatFS. The returned file info keeps the given name even
+// if it is a symbolic link.
+func (o overlayFS) Stat(name string) (fs.FileInfo, error) {
+	linkname := ConvertAbs(name)
+
+	name, err := o.resolve(linkname, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: linkname, Err: err}
+	}
+
+	s, err := o.lstat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != linkname {
+		s = namedFileInfo{s, path.Base(linkname)}
+	}
+
+	return s, nil
+}
+
+// Lstat implements ReadlinkFS.
+func (o overlayFS) Lstat(name string) (fs.FileInfo, error) {
+	name, err := o.resolve(ConvertAbs(name), false)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return o.lstat(name)
+}
+
+// Readlink implements ReadlinkFS.
+func (o overlayFS) Readlink(name string) (string, error) {
+	name, err := o.resolve(ConvertAbs(name), false)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return o.readlink(name)
+}
+
+// Symlink implements SymlinkFS. The link is always created on the read-write
+// filesystem, but it may point to anything in the overlay.
+func (o overlayFS) Symlink(oldname, newname string) error {
+	newname = ConvertAbs(newname)
+
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+
+	newname, err := o.resolve(newname, false)
+	if err != nil {
+		return linkErr(err)
+	}
+
+	if newname == "." || isMarker(newname) {
+		return linkErr(fs.ErrInvalid)
+	}
+
+	if _, err := o.lstat(newname); err == nil {
+		return linkErr(fs.ErrExist)
+	}
+
+	if err := o.copyUpDir(path.Dir(newname)); err != nil {
+		return linkErr(err)
+	}
+
+	if err := Symlink(o.rw, oldname, newname); err != nil {
+		return err
+	}
+
+	return o.replaceWhiteout(newname, false)
+}
+
+// resolve resolves the symbolic links in name using the merged view, so that
+// links on one filesystem can point to files on another. The last component
+// is only followed if followLast is true.
+func (o overlayFS) resolve(name string, followLast bool) (string, error) {
+	parts := Split(name)
+	resolved := "."
+	hidden := false // whether resolved is hidden on the read-only filesystems
+	links := 0
+
+	for i := 0; i < len(parts); i++ {
+		next := path.Join(resolved, parts[i])
+		if i == len(parts)-1 && !followLast {
+			return next, nil
+		}
+
+		nextHidden := o.hiddenIn(next, hidden)
+
+		s, err := o.lstatHidden(next, nextHidden)
+		if err != nil {
+			// Nothing under a missing path can exist either.
+			return path.Join(append([]string{next}, parts[i+1:]...)...), nil
+		}
+
+		if s.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			hidden = nextHidden
+			continue
+		}
+
+		if links++; links > MaxSymlinks {
+			return name, ErrSymlinkLoop
+		}
+
+		target, err := o.readlink(next)
+		if err != nil {
+			return name, err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join("/", resolved, target)
+		}
+
+		parts = append(Split(target), parts[i+1:]...)
+		resolved = "."
+		hidden = false
+		i = -1
+	}
 
+	return resolved, nil
+}
+
+// lstat returns the file info of name without following it if it is a
+// symbolic link. Its parent directories must already be resolved.
+func (o overlayFS) lstat(name string) (fs.FileInfo, error) {
+	return o.lstatHidden(name, o.roHidden(name))
+}
+
+// lstatHidden is like lstat, but takes whether name is hidden on the
+// read-only filesystems instead of looking it up.
+func (o overlayFS) lstatHidden(name string, hidden bool) (fs.FileInfo, error) {
+	if isMarker(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	s, err := Lstat(o.rw, name)
+	if err == nil {
+		return s, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	_, s, err = o.statROHidden(name, hidden)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return s, nil
+}
+
+// readlink returns the target of the symbolic link name. Its parent
+// directories must already be resolved.
+func (o overlayFS) readlink(name string) (string, error) {
+	if _, err := Lstat(o.rw, name); err == nil {
+		return Readlink(o.rw, name)
+	}
+
+	ro, _, err := o.statRO(name)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return Readlink(ro, name)
+}
+
+// roHidden returns true if name on the read-only filesystems is hidden by a
+// whiteout of it or one of its parents, or by an opaque parent directory.
+func (o overlayFS) roHidden(name string) bool {
+	if name == "." {
+		return false
+	}
+
+	var p string
+	for i, part := range strings.Split(name, "/") {
+		if i == 0 {
+			p = part
+		} else {
+			p += "/" + part
+		}
+		if o.hiddenIn(p, false) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hiddenIn returns true if name on the read-only filesystems is hidden, given
+// whether its parent directory is. Only name's own whiteout and its parent's
+// opaque marker are checked, so that paths can be checked one component at a
+// time.
+func (o overlayFS) hiddenIn(name string, parentHidden bool) bool {
+	if parentHidden {
+		return true
+	}
+	if _, err := fs.Stat(o.rw, whiteoutPath(name)); err == nil {
+		return true
+	}
+	if dir := path.Dir(name); dir != "." {
+		if _, err := fs.Stat(o.rw, path.Join(dir, OpaqueMarker)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// statRO returns the first read-only filesystem that has name, along with
+// its file info. fs.ErrNotExist is returned if none of them have it or if it
+// is hidden.
+func (o overlayFS) statRO(name string) (fs.FS, fs.FileInfo, error) {
+	return o.statROHidden(name, o.roHidden(name))
+}
+
+// statROHidden is like statRO, but takes whether name is hidden instead of
+// looking it up.
+func (o overlayFS) statROHidden(name string, hidden bool) (fs.FS, fs.FileInfo, error) {
+	if !hidden {
 		for _, ro := range o.ro {
-			s, err := fs.Stat(ro, dir)
+			s, err := Lstat(ro, name)
 			if err == nil {
-				if !s.IsDir() {
-					return nil, &fs.PathError{
-						Op:   "openfile",
-						Path: name,
-						Err:  fs.ErrInvalid,
-					}
-				}
+				return ro, s, nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, nil, err
+			}
+		}
+	}
+	return nil, nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
 
-				if err := o.rw.MkdirAll(dir, s.Mode()); err != nil {
-					return nil, &fs.PathError{
-						Op:   "openfile",
-						Path: name,
-						Err:  err,
-					}
-				}
+// copyUpDir creates the given directory on the read-write filesystem if it
+// only exists on the read-only filesystems. Its parents are copied up as
+// well. fs.ErrInvalid is returned if it is not a directory.
+func (o overlayFS) copyUpDir(dir string) error {
+	if dir == "." {
+		return nil
+	}
 
-				break
-			} else {
-				if !errors.Is(err, fs.ErrNotExist) {
-					return nil, err
-				}
-			}
+	if s, err := fs.Stat(o.rw, dir); err == nil {
+		if !s.IsDir() {
+			return fs.ErrInvalid
+		}
+		return nil
+	}
+
+	_, s, err := o.statRO(dir)
+	if err != nil {
+		return err
+	}
+	if !s.IsDir() {
+		return fs.ErrInvalid
+	}
+
+	if err := o.copyUpDir(path.Dir(dir)); err != nil {
+		return err
+	}
+
+	if err := o.rw.Mkdir(dir, s.Mode().Perm()); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: dir, Err: err}
+	}
+
+	return nil
+}
+
+// copyUp copies name from the read-only filesystem ro to the read-write
+// filesystem, keeping its mode and modification time. s is its file info.
+func (o overlayFS) copyUp(name string, ro fs.FS, s fs.FileInfo) error {
+	if s.IsDir() {
+		return o.copyUpDir(name)
+	}
+
+	if err := o.copyUpDir(path.Dir(name)); err != nil {
+		return err
+	}
+
+	if err := Copy(o.rw, name, ro, name); err != nil {
+		return err
+	}
+
+	if err := Chtimes(o.rw, name, s.ModTime(), s.ModTime()); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+
+	return nil
+}
+
+// copyUpTree copies src as seen through the overlay to dst on the read-write
+// filesystem. Directories are copied recursively.
+func (o overlayFS) copyUpTree(dst, src string) error {
+	s, err := o.lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case s.Mode()&fs.ModeSymlink != 0:
+		target, err := o.readlink(src)
+		if err != nil {
+			return err
+		}
+		return Symlink(o.rw, target, dst)
+	case !s.IsDir():
+		return Copy(o.rw, dst, o, src)
+	}
+
+	if err := o.rw.Mkdir(dst, s.Mode().Perm()); err != nil {
+		return err
+	}
+
+	entries, err := o.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		err := o.copyUpTree(path.Join(dst, entry.Name()), path.Join(src, entry.Name()))
+		if err != nil {
+			return err
 		}
 	}
 
-	return o.rw.OpenFile(name, flag, perm)
+	return nil
+}
+
+// whiteout hides name on the read-only filesystems.
+func (o overlayFS) whiteout(name string) error {
+	if err := o.copyUpDir(path.Dir(name)); err != nil {
+		return &fs.PathError{Op: "whiteout", Path: name, Err: err}
+	}
+	return touch(o.rw, whiteoutPath(name))
+}
+
+// replaceWhiteout removes the whiteout of name, which was just created on the
+// read-write filesystem in its place. A directory is made opaque, so that
+// whatever was under the old name on the read-only filesystems stays hidden.
+func (o overlayFS) replaceWhiteout(name string, isDir bool) error {
+	wh := whiteoutPath(name)
+	if _, err := fs.Stat(o.rw, wh); err != nil {
+		return nil
+	}
+
+	if isDir {
+		if err := touch(o.rw, path.Join(name, OpaqueMarker)); err != nil {
+			return err
+		}
+	}
+
+	if err := o.rw.Remove(wh); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func touch(fsys FS, name string) error {
+	f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Chmod implements ChmodFS. Files on the read-only filesystems are copied up
+// first.
+func (o overlayFS) Chmod(name string, mode fs.FileMode) error {
+	name, err := o.resolve(ConvertAbs(name), true)
+	if err != nil {
+		return &fs.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	if err := o.copyUpAny("chmod", name); err != nil {
+		return err
+	}
+	return Chmod(o.rw, name, mode)
+}
+
+// Chtimes implements ChtimesFS. Files on the read-only filesystems are copied
+// up first.
+func (o overlayFS) Chtimes(name string, atime, mtime time.Time) error {
+	name, err := o.resolve(ConvertAbs(name), true)
+	if err != nil {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	if err := o.copyUpAny("chtimes", name); err != nil {
+		return err
+	}
+	return Chtimes(o.rw, name, atime, mtime)
+}
+
+// copyUpAny copies name up if it is only on the read-only filesystems.
+func (o overlayFS) copyUpAny(op, name string) error {
+	if isMarker(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	if _, err := fs.Stat(o.rw, name); err == nil {
+		return nil
+	}
+
+	ro, s, err := o.statRO(name)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	if err := o.copyUp(name, ro, s); err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	return nil
 }
 
 func (o overlayFS) Remove(name string) error {
-	name = ConvertAbs(name)
+	name, err := o.resolve(ConvertAbs(name), false)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
 
-	if err := o.rw.Remove(name); err != nil {
-		if !errors.Is(err, fs.ErrNotExist) {
+	if name == "." || isMarker(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+
+	s, err := o.lstat(name)
+	if err != nil {
+		return err
+	}
+
+	if s.IsDir() {
+		entries, err := o.ReadDir(name)
+		if err != nil {
 			return err
 		}
+		if len(entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+	}
 
-		// Don't allow if we're removing a read-only file or directory.
-		for _, ro := range o.ro {
-			_, err1 := fs.Stat(ro, name)
-			if err1 == nil {
-				return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
-			}
+	return o.remove(name, s.IsDir())
+}
+
+// remove removes name from the read-write filesystem, then whites it out if
+// it is still visible on the read-only filesystems.
+func (o overlayFS) remove(name string, isDir bool) error {
+	if _, err := Lstat(o.rw, name); err == nil {
+		// Directories may still have markers in them, so they're never
+		// empty on the read-write filesystem.
+		rm := o.rw.Remove
+		if isDir {
+			rm = o.rw.RemoveAll
+		}
+		if err := rm(name); err != nil {
+			return err
 		}
 	}
 
+	if _, _, err := o.statRO(name); err == nil {
+		return o.whiteout(name)
+	}
+
 	return nil
 }
 
-func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
-	name = ConvertAbs(name)
+// Rename implements RenameFS. Anything that exists on the read-only
+// filesystems is copied up to newname and whited out at oldname.
+func (o overlayFS) Rename(oldname, newname string) error {
+	oldname = ConvertAbs(oldname)
+	newname = ConvertAbs(newname)
 
-	var entries []fs.DirEntry
-	for i, ro := range o.ro {
-		roEntries, err := fs.ReadDir(ro, name)
-		if err == nil {
-			entries = append(entries, roEntries...)
-		} else {
-			if !errors.Is(err, fs.ErrNotExist) {
-				return nil, fmt.Errorf("error at fs %d: %w", i, err)
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+
+	var err error
+	if oldname, err = o.resolve(oldname, false); err != nil {
+		return linkErr(err)
+	}
+	if newname, err = o.resolve(newname, false); err != nil {
+		return linkErr(err)
+	}
+
+	if oldname == "." || newname == "." || isMarker(oldname) || isMarker(newname) {
+		return linkErr(fs.ErrInvalid)
+	}
+
+	src, err := o.lstat(oldname)
+	if err != nil {
+		return linkErr(fs.ErrNotExist)
+	}
+
+	if oldname == newname {
+		return nil
+	}
+
+	if src.IsDir() && strings.HasPrefix(newname, oldname+"/") {
+		return linkErr(fs.ErrInvalid)
+	}
+
+	dst, err := o.lstat(newname)
+	switch {
+	case err == nil:
+		switch {
+		case src.IsDir() && !dst.IsDir():
+			return linkErr(errors.New("not a directory"))
+		case !src.IsDir() && dst.IsDir():
+			return linkErr(errors.New("is a directory"))
+		case dst.IsDir():
+			entries, err := o.ReadDir(newname)
+			if err != nil {
+				return linkErr(err)
+			}
+			if len(entries) > 0 {
+				return linkErr(errors.New("directory not empty"))
 			}
 		}
+		// Remove the destination first. This whites it out if it's on the
+		// read-only filesystems.
+		if err := o.remove(newname, dst.IsDir()); err != nil {
+			return linkErr(err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return linkErr(err)
+	}
+
+	if err := o.copyUpDir(path.Dir(newname)); err != nil {
+		return linkErr(err)
+	}
+
+	// Things that only exist on the read-write filesystem can be renamed
+	// there. Otherwise, the merged view is copied up to newname, and oldname
+	// is removed, which whites it out.
+	_, _, err = o.statRO(oldname)
+	copyUp := err == nil
+	if !copyUp {
+		err := Rename(o.rw, oldname, newname)
+		if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+		copyUp = err != nil
+	}
+
+	if copyUp {
+		if err := o.copyUpTree(newname, oldname); err != nil {
+			return linkErr(err)
+		}
+		if err := o.remove(oldname, src.IsDir()); err != nil {
+			return linkErr(err)
+		}
+	}
+
+	if err := o.replaceWhiteout(newname, src.IsDir()); err != nil {
+		return linkErr(err)
+	}
+
+	return nil
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name, err := o.resolve(ConvertAbs(name), true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
 	}
 
 	rwEntries, err := fs.ReadDir(o.rw, name)
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return nil, fmt.Errorf("error at rw fs: %w", err)
 	}
-	entries = append(entries, rwEntries...)
+	found := err == nil
+
+	var entries []fs.DirEntry
+	var opaque bool
+	whiteouts := make(map[string]bool)
+
+	for _, entry := range rwEntries {
+		switch {
+		case entry.Name() == OpaqueMarker:
+			opaque = true
+		case strings.HasPrefix(entry.Name(), WhiteoutPrefix):
+			whiteouts[strings.TrimPrefix(entry.Name(), WhiteoutPrefix)] = true
+		default:
+			entries = append(entries, entry)
+		}
+	}
+
+	if !opaque && !o.roHidden(name) {
+		for i, ro := range o.ro {
+			roEntries, err := fs.ReadDir(ro, name)
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					return nil, fmt.Errorf("error at fs %d: %w", i, err)
+				}
+				continue
+			}
+			found = true
+
+			for _, entry := range roEntries {
+				if !whiteouts[entry.Name()] {
+					entries = append(entries, entry)
+				}
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
 
 	// Deduplicate paths, because we're handling writes to a read-only directory
-	// by making it on the read-write filesystem as well.
+	// by making it on the read-write filesystem as well. The read-write entries
+	// come first, so they win.
 	entries = DeduplicateDirEntries(entries)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
 	return entries, nil
 }
 
 func (o overlayFS) Mkdir(name string, perm fs.FileMode) error {
-	name = ConvertAbs(name)
+	name, err := o.resolve(ConvertAbs(name), false)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
 
-	// Check if the directory already exists on either filesystems.
-	_, err := fs.Stat(o.rw, name)
-	if errors.Is(err, fs.ErrExist) {
-		return err
+	if isMarker(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
 	}
-	for _, ro := range o.ro {
-		_, err := fs.Stat(ro, name)
-		if errors.Is(err, fs.ErrExist) {
-			return err
-		}
+
+	if _, err := o.lstat(name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+
+	if err := o.copyUpDir(path.Dir(name)); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
 	}
 
 	// We can only do this on a read-write filesystem.
-	return o.rw.Mkdir(name, perm)
+	if err := o.rw.Mkdir(name, perm); err != nil {
+		return err
+	}
+
+	return o.replaceWhiteout(name, true)
 }
 
 func (o overlayFS) MkdirAll(name string, perm fs.FileMode) error {
 	name = ConvertAbs(name)
 
-	return o.rw.MkdirAll(name, perm)
+	if name == "." {
+		return nil
+	}
+
+	if s, err := fs.Stat(o, name); err == nil {
+		if !s.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+
+	if err := o.MkdirAll(path.Dir(name), perm); err != nil {
+		return err
+	}
+
+	return o.Mkdir(name, perm)
 }
 
+// RemoveAll removes name and everything under it. Anything on the read-only
+// filesystems is whited out.
 func (o overlayFS) RemoveAll(name string) error {
-	name = ConvertAbs(name)
+	name, err := o.resolve(ConvertAbs(name), false)
+	if err != nil {
+		return &fs.PathError{Op: "removeall", Path: name, Err: err}
+	}
 
-	// Does the directory exist on the read-only filesystems?
-	for _, ro := range o.ro {
-		_, err1 := fs.Stat(ro, name)
-		if err1 == nil {
-			return &fs.PathError{Op: "removeall", Path: name, Err: fs.ErrPermission}
+	if isMarker(name) {
+		return nil
+	}
+
+	s, err := o.lstat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if name != "." {
+		return o.remove(name, s.IsDir())
+	}
+
+	// The root can't be whited out, so remove everything in it instead.
+	entries, err := o.ReadDir(name)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		if err := o.RemoveAll(entry.Name()); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
-	// Otherwise, the error is whatever the read-write filesystem returns.
-	return o.rw.RemoveAll(name)
+	return errors.Join(errs...)
+}
 
-	// Note to self: I think rm -f will actually try to remove RW files off a RO
-	// directory. Maybe rm itself implements this logic?
+// Usage implements UsageFS if the read-write filesystem does, since that is
+// the only filesystem that the overlay uses space on.
+func (o overlayFS) Usage() (used, quota int64, err error) {
+	return Usage(o.rw)
 }
 
 func errorsOrNotFound(errs []error) error {
@@ -201,6 +897,25 @@ func errorsOrNotFound(errs []error) error {
 	return errors.Join(errs...)
 }
 
+// namedFileInfo overrides the name of a file info, which is used for the
+// targets of symbolic links.
+type namedFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (s namedFileInfo) Name() string { return s.name }
+
+// wrapDir wraps a directory so that reading it lists the entries of all
+// filesystems. Files are returned as-is, so that their optional interfaces
+// such as io.Seeker are kept.
+func (o overlayFS) wrapDir(f fs.File, name string) fs.File {
+	if _, ok := f.(fs.ReadDirFile); ok {
+		return readDirableROFile{f, name, o}
+	}
+	return f
+}
+
 var _ fs.ReadDirFile = readDirableROFile{}
 var _ fs.ReadDirFile = readDirableRWFile{}
 
@@ -229,3 +944,84 @@ func (f readDirableRWFile) ReadDir(n int) ([]fs.DirEntry, error) {
 	}
 	return f.overlay.ReadDir(f.path)
 }
+
+// Watch implements WatchFS if the read-write filesystem does. The read-only
+// filesystems never change, so only changes to the read-write filesystem are
+// reported. Whiteouts are reported as removals of the files that they hide,
+// and files that are copied up are reported as created.
+func (o overlayFS) Watch(ctx context.Context, name string, recursive bool) (<-chan WatchEvent, error) {
+	name = ConvertAbs(name)
+
+	in, err := Watch(ctx, o.rw, name, recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	// The whiteout of name itself lives in its parent directory.
+	var parent <-chan WatchEvent
+	if name != "." {
+		parent, err = Watch(ctx, o.rw, path.Dir(name), false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(chan WatchEvent)
+	go func() {
+		defer close(out)
+
+		for in != nil || parent != nil {
+			var ev WatchEvent
+			var ok bool
+
+			select {
+			case ev, ok = <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+			case ev, ok = <-parent:
+				if !ok {
+					parent = nil
+					continue
+				}
+				if ev.Name != whiteoutPath(name) {
+					continue
+				}
+			}
+
+			ev, ok = translateWatchEvent(ev)
+			if !ok || !WatchMatches(name, recursive, ev.Name) {
+				continue
+			}
+
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// translateWatchEvent translates an event from the read-write filesystem
+// into one for the overlay. False is returned if the event should be ignored.
+func translateWatchEvent(ev WatchEvent) (WatchEvent, bool) {
+	base := path.Base(ev.Name)
+	if !strings.HasPrefix(base, WhiteoutPrefix) {
+		return ev, true
+	}
+
+	// Only the appearance of a whiteout matters. It is removed when
+	// something replaces the file, which has its own event.
+	if base == OpaqueMarker || ev.Op&(WatchCreate|WatchWrite) == 0 {
+		return ev, false
+	}
+
+	return WatchEvent{
+		Name: path.Join(path.Dir(ev.Name), strings.TrimPrefix(base, WhiteoutPrefix)),
+		Op:   WatchRemove,
+	}, true
+}
@@ -0,0 +1,165 @@
+package rwfs_test
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"libdb.so/vm/rwfs"
+	"libdb.so/vm/rwfs/kvfs"
+)
+
+// newTestOverlay returns an overlay of an empty kvfs over a read-only tree,
+// along with the kvfs.
+func newTestOverlay() (rwfs.FS, rwfs.FS) {
+	lower := fstest.MapFS{
+		"a":       {Mode: fs.ModeDir | 0755},
+		"a/x":     {Data: []byte("lower x"), Mode: 0644},
+		"a/y":     {Data: []byte("lower y"), Mode: 0644},
+		"a/b":     {Mode: fs.ModeDir | 0755},
+		"a/b/c":   {Data: []byte("lower c"), Mode: 0644},
+		"top.txt": {Data: []byte("top"), Mode: 0644},
+	}
+	upper := kvfs.New(kvfs.MemoryStorage())
+	return rwfs.OverlayFS(upper, lower), upper
+}
+
+func readDirNames(t *testing.T, fsys fs.FS, name string) []string {
+	t.Helper()
+
+	entries, err := fs.ReadDir(fsys, name)
+	assert.NoError(t, err)
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names
+}
+
+func writeFile(t *testing.T, fsys rwfs.FS, name, data string) {
+	t.Helper()
+
+	f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+}
+
+func TestOverlayFS(t *testing.T) {
+	t.Run("remove", func(t *testing.T) {
+		o, upper := newTestOverlay()
+
+		assert.NoError(t, o.Remove("/a/x"))
+		assert.Equal(t, []string{"b", "y"}, readDirNames(t, o, "a"))
+
+		_, err := fs.Stat(o, "a/x")
+		assert.IsError(t, err, fs.ErrNotExist)
+
+		// The whiteout is on the upper filesystem, but never shown.
+		_, err = fs.Stat(upper, "a/.wh.x")
+		assert.NoError(t, err)
+		_, err = fs.Stat(o, "a/.wh.x")
+		assert.IsError(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("recreate", func(t *testing.T) {
+		o, upper := newTestOverlay()
+
+		assert.NoError(t, o.Remove("a/x"))
+		writeFile(t, o, "a/x", "upper x")
+
+		assert.Equal(t, []string{"b", "x", "y"}, readDirNames(t, o, "a"))
+
+		b, err := fs.ReadFile(o, "a/x")
+		assert.NoError(t, err)
+		assert.Equal(t, "upper x", string(b))
+
+		_, err = fs.Stat(upper, "a/.wh.x")
+		assert.IsError(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("remove_nested", func(t *testing.T) {
+		o, _ := newTestOverlay()
+
+		assert.NoError(t, o.RemoveAll("a"))
+		assert.Equal(t, []string{"top.txt"}, readDirNames(t, o, "."))
+
+		for _, name := range []string{"a", "a/x", "a/b", "a/b/c"} {
+			_, err := fs.Stat(o, name)
+			assert.IsError(t, err, fs.ErrNotExist, name)
+		}
+	})
+
+	t.Run("remove_all_then_mkdir", func(t *testing.T) {
+		o, upper := newTestOverlay()
+
+		assert.NoError(t, o.RemoveAll("a"))
+		assert.NoError(t, o.Mkdir("a", 0755))
+
+		assert.Equal(t, []string{}, readDirNames(t, o, "a"))
+
+		_, err := fs.Stat(o, "a/b/c")
+		assert.IsError(t, err, fs.ErrNotExist)
+
+		_, err = fs.Stat(upper, "a/"+rwfs.OpaqueMarker)
+		assert.NoError(t, err)
+
+		// New files in the opaque directory are visible as usual.
+		writeFile(t, o, "a/x", "new x")
+		assert.Equal(t, []string{"x"}, readDirNames(t, o, "a"))
+	})
+
+	t.Run("rename_lower_dir", func(t *testing.T) {
+		o, _ := newTestOverlay()
+
+		assert.NoError(t, rwfs.Rename(o, "a", "z"))
+		assert.Equal(t, []string{"top.txt", "z"}, readDirNames(t, o, "."))
+		assert.Equal(t, []string{"b", "x", "y"}, readDirNames(t, o, "z"))
+
+		b, err := fs.ReadFile(o, "z/b/c")
+		assert.NoError(t, err)
+		assert.Equal(t, "lower c", string(b))
+
+		_, err = fs.Stat(o, "a")
+		assert.IsError(t, err, fs.ErrNotExist)
+
+		// Recreating the old name doesn't bring back its old contents.
+		assert.NoError(t, o.Mkdir("a", 0755))
+		assert.Equal(t, []string{}, readDirNames(t, o, "a"))
+	})
+
+	t.Run("chmod", func(t *testing.T) {
+		o, upper := newTestOverlay()
+
+		assert.NoError(t, rwfs.Chmod(o, "a/x", 0600))
+
+		s, err := fs.Stat(o, "a/x")
+		assert.NoError(t, err)
+		assert.Equal(t, fs.FileMode(0600), s.Mode().Perm())
+
+		// The file was copied up with its contents.
+		b, err := fs.ReadFile(upper, "a/x")
+		assert.NoError(t, err)
+		assert.Equal(t, "lower x", string(b))
+	})
+
+	t.Run("chtimes", func(t *testing.T) {
+		o, upper := newTestOverlay()
+
+		mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		assert.NoError(t, rwfs.Chtimes(o, "a/b/c", mtime, mtime))
+
+		s, err := fs.Stat(o, "a/b/c")
+		assert.NoError(t, err)
+		assert.True(t, s.ModTime().Equal(mtime), "got mtime %v", s.ModTime())
+
+		b, err := fs.ReadFile(upper, "a/b/c")
+		assert.NoError(t, err)
+		assert.Equal(t, "lower c", string(b))
+	})
+}
@@ -0,0 +1,128 @@
+package rwfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// ProcFS returns a read-only filesystem of generated files, like Linux's
+// /proc. It has a file for each function in files, whose contents are
+// generated by calling it every time the file is opened.
+func ProcFS(files map[string]func() []byte) FS {
+	return ReadOnlyFS(procFS(files))
+}
+
+type procFS map[string]func() []byte
+
+var _ fs.ReadDirFS = procFS(nil)
+
+func (p procFS) Open(name string) (fs.File, error) {
+	name = ConvertAbs(name)
+	if name == "." {
+		return &procDir{info: procInfo{name: ".", dir: true}, entries: p.entries()}, nil
+	}
+
+	gen, ok := p[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	data := gen()
+	return &procFile{
+		Reader: bytes.NewReader(data),
+		info:   procInfo{name: name, size: int64(len(data))},
+	}, nil
+}
+
+func (p procFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if ConvertAbs(name) != "." {
+		if _, ok := p[ConvertAbs(name)]; ok {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return p.entries(), nil
+}
+
+func (p procFS) entries() []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(p))
+	for name := range p {
+		// The size isn't known until the file is generated.
+		entries = append(entries, procInfo{name: name})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+type procInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+var (
+	_ fs.FileInfo = procInfo{}
+	_ fs.DirEntry = procInfo{}
+)
+
+func (i procInfo) Name() string       { return i.name }
+func (i procInfo) Size() int64        { return i.size }
+func (i procInfo) ModTime() time.Time { return time.Now() }
+func (i procInfo) IsDir() bool        { return i.dir }
+func (i procInfo) Sys() any           { return nil }
+func (i procInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i procInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i procInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+type procFile struct {
+	*bytes.Reader
+	info procInfo
+}
+
+var (
+	_ fs.File     = (*procFile)(nil)
+	_ io.Seeker   = (*procFile)(nil)
+	_ io.ReaderAt = (*procFile)(nil)
+)
+
+func (f *procFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *procFile) Close() error               { return nil }
+
+type procDir struct {
+	info    procInfo
+	entries []fs.DirEntry
+}
+
+var _ fs.ReadDirFile = (*procDir)(nil)
+
+func (d *procDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *procDir) Read([]byte) (int, error)   { return 0, fs.ErrInvalid }
+func (d *procDir) Close() error               { return nil }
+
+func (d *procDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
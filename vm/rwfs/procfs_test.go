@@ -0,0 +1,85 @@
+package rwfs_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"libdb.so/vm/rwfs"
+)
+
+func TestProcFS(t *testing.T) {
+	var n int
+	proc := rwfs.ProcFS(map[string]func() []byte{
+		"count": func() []byte {
+			n++
+			return []byte(fmt.Sprint(n))
+		},
+		"empty": func() []byte { return nil },
+	})
+
+	assert.Equal(t, []string{"count", "empty"}, readDirNames(t, proc, "."))
+
+	// Files are generated every time they're opened.
+	for _, want := range []string{"1", "2"} {
+		b, err := fs.ReadFile(proc, "count")
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(b))
+	}
+
+	s, err := fs.Stat(proc, "count")
+	assert.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0444), s.Mode())
+
+	_, err = fs.Stat(proc, "missing")
+	assert.IsError(t, err, fs.ErrNotExist)
+
+	_, err = fs.ReadDir(proc, "count")
+	assert.Error(t, err)
+
+	_, err = proc.OpenFile("count", os.O_WRONLY, 0)
+	assert.Error(t, err)
+	assert.Error(t, proc.Remove("count"))
+}
+
+func TestProcMounts(t *testing.T) {
+	ns := newTestNamespace(t, "/tmp")
+
+	assert.NoError(t, ns.Mkdir("/proc", 0555))
+	assert.NoError(t, ns.Mount(rwfs.Mount{
+		Point: "/proc",
+		FS: rwfs.ProcFS(map[string]func() []byte{
+			"mounts": func() []byte {
+				var b bytes.Buffer
+				for _, m := range ns.Mounts() {
+					fmt.Fprintln(&b, m)
+				}
+				return b.Bytes()
+			},
+		}),
+		Type:    "proc",
+		Source:  "proc",
+		Options: []string{"ro"},
+	}))
+
+	b, err := fs.ReadFile(ns, "proc/mounts")
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"root / kvfs rw 0 0\n"+
+		"proc /proc proc ro 0 0\n"+
+		"none /tmp tmpfs rw 0 0\n",
+		string(b))
+
+	// The file follows the mounts as they change.
+	assert.NoError(t, ns.Unmount("/tmp"))
+
+	b, err = fs.ReadFile(ns, "proc/mounts")
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"root / kvfs rw 0 0\n"+
+		"proc /proc proc ro 0 0\n",
+		string(b))
+}
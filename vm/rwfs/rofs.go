@@ -1,19 +1,28 @@
 package rwfs
 
 import (
+	"io"
 	"io/fs"
 	"os"
+	"time"
 )
 
 // ReadOnlyFS wraps a read-only filesystem into a read-writable filesystem. Any
-// functions that write to the filesystem will return an error.
+// functions that write to the filesystem will return an error. Closing the
+// returned filesystem closes fs if it implements io.Closer.
 func ReadOnlyFS(fs fs.FS) FS {
 	return rofs{fs}
 }
 
 type rofs struct{ fs fs.FS }
 
-var _ FS = rofs{}
+var (
+	_ FS        = rofs{}
+	_ RenameFS  = rofs{}
+	_ ChmodFS   = rofs{}
+	_ ChtimesFS = rofs{}
+	_ SymlinkFS = rofs{}
+)
 
 func (ro rofs) Open(name string) (fs.File, error) {
 	f, err := ro.fs.Open(ConvertAbs(name))
@@ -21,7 +30,7 @@ func (ro rofs) Open(name string) (fs.File, error) {
 		return nil, err
 	}
 
-	return roFile{f}, nil
+	return WrapFile(f), nil
 }
 
 func (ro rofs) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
@@ -29,12 +38,12 @@ func (ro rofs) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
 		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
 	}
 
-	f, err := ro.Open(ConvertAbs(name))
+	f, err := ro.fs.Open(ConvertAbs(name))
 	if err != nil {
 		return nil, err
 	}
 
-	return roFile{f}, nil
+	return WrapFile(f), nil
 }
 
 func (ro rofs) Remove(name string) error {
@@ -66,16 +75,71 @@ func (ro rofs) RemoveAll(name string) error {
 	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
 }
 
+func (ro rofs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrPermission}
+}
+
+func (ro rofs) Chmod(name string, mode fs.FileMode) error {
+	return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrPermission}
+}
+
+func (ro rofs) Chtimes(name string, atime, mtime time.Time) error {
+	return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrPermission}
+}
+
+func (ro rofs) Close() error {
+	if closer, ok := ro.fs.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (ro rofs) Symlink(oldname, newname string) error {
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: fs.ErrPermission}
+}
+
+func (ro rofs) Readlink(name string) (string, error) {
+	return Readlink(ro.fs, ConvertAbs(name))
+}
+
+func (ro rofs) Lstat(name string) (fs.FileInfo, error) {
+	s, err := Lstat(ro.fs, ConvertAbs(name))
+	if err != nil {
+		return nil, err
+	}
+	return roStat{s}, nil
+}
+
 type roFile struct{ fs.File }
 
 var _ File = roFile{}
 
 // WrapFile wraps a read-only file into a read-writable file. Any functions that
-// write to the file will return an error.
+// write to the file will return an error. If the file supports seeking and
+// ReadAt, then so does the returned file.
 func WrapFile(f fs.File) File {
+	if s, ok := f.(readSeekerAt); ok {
+		return roSeekableFile{roFile{f}, s}
+	}
 	return roFile{f}
 }
 
+type readSeekerAt interface {
+	io.Seeker
+	io.ReaderAt
+}
+
+// roSeekableFile is a roFile that can seek.
+type roSeekableFile struct {
+	roFile
+	readSeekerAt
+}
+
+var (
+	_ io.Seeker   = roSeekableFile{}
+	_ io.ReaderAt = roSeekableFile{}
+)
+
 func (f roFile) Write([]byte) (int, error) {
 	return 0, fs.ErrPermission
 }
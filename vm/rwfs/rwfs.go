@@ -1,8 +1,10 @@
 package rwfs
 
 import (
+	"errors"
 	"io"
 	"io/fs"
+	"time"
 )
 
 // FS implements a read-writable filesystem.
@@ -24,12 +26,91 @@ type FS interface {
 	RemoveAll(name string) error
 }
 
+// RenameFS is an FS that can rename files and directories.
+type RenameFS interface {
+	FS
+	// Rename renames (moves) oldname to newname. Like os.Rename, an existing
+	// file at newname is replaced, and so is an existing empty directory if
+	// oldname is also a directory. Errors are of type *os.LinkError.
+	Rename(oldname, newname string) error
+}
+
+// ChmodFS is an FS that can change the permission bits of files and
+// directories.
+type ChmodFS interface {
+	FS
+	// Chmod changes the permission bits of the named file to mode.Perm().
+	// Other bits in mode are ignored.
+	Chmod(name string, mode fs.FileMode) error
+}
+
+// ChtimesFS is an FS that can change the times of files and directories.
+type ChtimesFS interface {
+	FS
+	// Chtimes changes the access and modification times of the named file,
+	// like os.Chtimes. Filesystems that don't keep access times may ignore
+	// atime.
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// ReadlinkFS is a filesystem that can have symbolic links. Open and Stat
+// follow them, while Lstat and Readlink don't.
+type ReadlinkFS interface {
+	fs.FS
+	// Readlink returns the target of the named symbolic link.
+	Readlink(name string) (string, error)
+	// Lstat returns the file info of the named file. If the file is a
+	// symbolic link, then the returned file info describes the link itself.
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// SymlinkFS is an FS that can create symbolic links.
+type SymlinkFS interface {
+	FS
+	ReadlinkFS
+	// Symlink creates newname as a symbolic link to oldname. oldname is
+	// stored as-is, so relative links are resolved relative to the
+	// directory of newname. Errors are of type *os.LinkError.
+	Symlink(oldname, newname string) error
+}
+
+// UsageFS is an FS that can report how much space its storage uses, such as
+// a browser's storage quota.
+type UsageFS interface {
+	FS
+	// Usage returns the number of bytes used and the number of bytes that
+	// may be used in total. A quota of 0 means that it is unknown.
+	Usage() (used, quota int64, err error)
+}
+
+// MaxSymlinks is the maximum number of symbolic links that are followed while
+// resolving a path. Resolving a path with more links fails with
+// ErrSymlinkLoop.
+const MaxSymlinks = 40
+
+// ErrSymlinkLoop is returned when resolving a path that has too many symbolic
+// links, which is likely because the links form a loop.
+var ErrSymlinkLoop = errors.New("too many levels of symbolic links")
+
 // File is a read-writable file.
 type File interface {
 	fs.File
 	io.ReadWriteCloser
 }
 
+// SeekableFile is a File that also supports random access. Files returned by
+// an FS may optionally implement it, so callers should type-assert for it.
+// Read-only files may instead only implement io.Seeker and io.ReaderAt.
+type SeekableFile interface {
+	File
+	io.Seeker
+	io.ReaderAt
+	io.WriterAt
+	// Truncate changes the size of the file. It does not change the offset
+	// used by Read, Write and Seek.
+	Truncate(size int64) error
+}
+
 // Type aliases.
 type (
 	FileInfo = fs.FileInfo
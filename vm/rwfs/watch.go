@@ -0,0 +1,204 @@
+package rwfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// WatchFS is a filesystem that can tell when its files change.
+type WatchFS interface {
+	fs.FS
+	// Watch returns a channel that receives an event for every change to
+	// the named file or directory and its direct children, or to everything
+	// under it if recursive is true. The file doesn't have to exist yet.
+	// Events are never dropped. The channel is closed once ctx is done.
+	Watch(ctx context.Context, name string, recursive bool) (<-chan WatchEvent, error)
+}
+
+// WatchOp describes what happened to a file.
+type WatchOp uint8
+
+const (
+	// WatchCreate is sent when a file is created.
+	WatchCreate WatchOp = 1 << iota
+	// WatchWrite is sent when a file is written to. It is also sent when a
+	// file changed in a way that the filesystem can't tell apart, such as
+	// from another tab.
+	WatchWrite
+	// WatchRemove is sent when a file is removed.
+	WatchRemove
+	// WatchRename is sent for the old name of a renamed file. The new name
+	// gets a WatchCreate.
+	WatchRename
+	// WatchChmod is sent when a file's permission bits or times change.
+	WatchChmod
+)
+
+// String returns the name of the operation, like fsnotify does.
+func (op WatchOp) String() string {
+	var names []string
+	for _, o := range []struct {
+		op   WatchOp
+		name string
+	}{
+		{WatchCreate, "CREATE"},
+		{WatchWrite, "WRITE"},
+		{WatchRemove, "REMOVE"},
+		{WatchRename, "RENAME"},
+		{WatchChmod, "CHMOD"},
+	} {
+		if op&o.op != 0 {
+			names = append(names, o.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// WatchEvent is a change to a file.
+type WatchEvent struct {
+	// Name is the path of the file that changed, in the same form as the
+	// names given to the filesystem.
+	Name string
+	Op   WatchOp
+}
+
+// String returns the event in the form "OP name".
+func (ev WatchEvent) String() string {
+	return ev.Op.String() + " " + ev.Name
+}
+
+// Watch watches the named file on fsys if it implements WatchFS. Otherwise,
+// an error wrapping errors.ErrUnsupported is returned.
+func Watch(ctx context.Context, fsys fs.FS, name string, recursive bool) (<-chan WatchEvent, error) {
+	if wfs, ok := fsys.(WatchFS); ok {
+		return wfs.Watch(ctx, name, recursive)
+	}
+	return nil, &fs.PathError{Op: "watch", Path: name, Err: errors.ErrUnsupported}
+}
+
+// WatchMatches returns true if a watch on name, as described by WatchFS,
+// would receive events for the file at event. Both names must be cleaned.
+func WatchMatches(name string, recursive bool, event string) bool {
+	switch {
+	case event == name:
+		return true
+	case path.Dir(event) == name:
+		return true
+	case recursive && name == ".":
+		return true
+	case recursive:
+		return strings.HasPrefix(event, name+"/")
+	default:
+		return false
+	}
+}
+
+// Watchers is a set of watches that a WatchFS sends its events to. The zero
+// value is ready to be used.
+type Watchers struct {
+	mu      sync.Mutex
+	watches map[*watch]struct{}
+}
+
+// Watch adds a new watch, as described by WatchFS. name is cleaned with
+// ConvertAbs.
+func (w *Watchers) Watch(ctx context.Context, name string, recursive bool) <-chan WatchEvent {
+	wt := &watch{
+		name:      ConvertAbs(name),
+		recursive: recursive,
+		signal:    make(chan struct{}, 1),
+		out:       make(chan WatchEvent),
+	}
+
+	w.mu.Lock()
+	if w.watches == nil {
+		w.watches = make(map[*watch]struct{})
+	}
+	w.watches[wt] = struct{}{}
+	w.mu.Unlock()
+
+	go func() {
+		wt.run(ctx)
+
+		w.mu.Lock()
+		delete(w.watches, wt)
+		w.mu.Unlock()
+	}()
+
+	return wt.out
+}
+
+// Len returns the number of active watches.
+func (w *Watchers) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.watches)
+}
+
+// Send sends the given events to every watch that matches them. It never
+// blocks. Event names are cleaned with ConvertAbs.
+func (w *Watchers) Send(events ...WatchEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for wt := range w.watches {
+		for _, ev := range events {
+			ev.Name = ConvertAbs(ev.Name)
+			if WatchMatches(wt.name, wt.recursive, ev.Name) {
+				wt.push(ev)
+			}
+		}
+	}
+}
+
+// watch queues up events so that Send never blocks on a slow receiver.
+type watch struct {
+	name      string
+	recursive bool
+
+	mu     sync.Mutex
+	queue  []WatchEvent
+	signal chan struct{}
+	out    chan WatchEvent
+}
+
+func (wt *watch) push(ev WatchEvent) {
+	wt.mu.Lock()
+	wt.queue = append(wt.queue, ev)
+	wt.mu.Unlock()
+
+	select {
+	case wt.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (wt *watch) run(ctx context.Context) {
+	defer close(wt.out)
+
+	for {
+		wt.mu.Lock()
+		queue := wt.queue
+		wt.queue = nil
+		wt.mu.Unlock()
+
+		for _, ev := range queue {
+			select {
+			case wt.out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-wt.signal:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
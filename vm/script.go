@@ -0,0 +1,287 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// DefaultPath is the value of $PATH used if it is unset.
+const DefaultPath = "/bin"
+
+// maxShebangDepth is the maximum number of shebang interpreters that may run
+// each other, like Linux's BINPRM_MAX_RECURSION. It stops scripts whose
+// shebangs name themselves or each other.
+const maxShebangDepth = 4
+
+// shells are the shebang interpreters that run scripts using our own shell.
+var shells = map[string]bool{
+	"sh":   true,
+	"bash": true,
+}
+
+// execute executes the command with the given arguments. Names containing a
+// slash are resolved as paths. Other names are looked up in env.Programs, then
+// in the shell builtins, then in $PATH. Files are run as scripts.
+func (inst *Interpreter) execute(ctx context.Context, env Environment, args ...string) error {
+	if !strings.Contains(args[0], "/") {
+		if _, ok := env.Programs[args[0]]; ok {
+			return execHandler(ctx, env, args...)
+		}
+		if slices.Contains(builtinCommands, args[0]) {
+			return inst.runBuiltin(ctx, env, args)
+		}
+	}
+
+	file, err := lookPath(env, args[0])
+	if err != nil {
+		return err
+	}
+
+	return inst.runScript(ctx, env, file, args)
+}
+
+// lookPath resolves the command name into a file within the filesystem. Names
+// containing a slash are resolved relative to the current directory, while
+// other names are searched for as executable files in each directory of
+// $PATH.
+func lookPath(env Environment, name string) (string, error) {
+	if strings.Contains(name, "/") {
+		if !path.IsAbs(name) {
+			name = path.Join(env.Cwd, name)
+		}
+		if _, err := fs.Stat(env.Filesystem, name); err != nil {
+			return "", WrapError(127, err)
+		}
+		return name, nil
+	}
+
+	for _, dir := range pathDirs(env) {
+		if !path.IsAbs(dir) {
+			dir = path.Join(env.Cwd, dir)
+		}
+
+		file := path.Join(dir, name)
+		if isExecutable(env, file) {
+			return file, nil
+		}
+	}
+
+	return "", &UnknownProgramError{name}
+}
+
+// isExecutable returns true if file is a regular file that can be run, either
+// because it has an exec bit or because it starts with a shebang.
+func isExecutable(env Environment, file string) bool {
+	s, err := fs.Stat(env.Filesystem, file)
+	if err != nil || !s.Mode().IsRegular() {
+		return false
+	}
+	if s.Mode()&0111 != 0 {
+		return true
+	}
+
+	f, err := env.Filesystem.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	head := make([]byte, 2)
+	_, err = io.ReadFull(f, head)
+	return err == nil && string(head) == "#!"
+}
+
+// pathDirs returns the directories in $PATH.
+func pathDirs(env Environment) []string {
+	v := env.Environ.Get("PATH")
+	if !v.IsSet() {
+		return strings.Split(DefaultPath, ":")
+	}
+	return strings.Split(v.String(), ":")
+}
+
+// runScript runs the given file as a script. If the script has a shebang
+// naming a shell or no shebang at all, it is run using a new shell runner
+// with args as its positional parameters. Otherwise, the shebang's
+// interpreter is executed with the file as its argument. Files without a
+// shebang must be executable.
+func (inst *Interpreter) runScript(ctx context.Context, env Environment, file string, args []string) error {
+	s, err := fs.Stat(env.Filesystem, file)
+	if err != nil {
+		return WrapError(126, err)
+	}
+	if s.IsDir() {
+		return WrapError(126, &fs.PathError{Op: "exec", Path: file, Err: errors.New("is a directory")})
+	}
+
+	src, err := fs.ReadFile(env.Filesystem, file)
+	if err != nil {
+		return WrapError(126, err)
+	}
+
+	shebang, ok := parseShebang(src)
+	if !ok && s.Mode()&0111 == 0 {
+		return WrapError(126, &fs.PathError{Op: "exec", Path: file, Err: fs.ErrPermission})
+	}
+
+	if ok && !shells[path.Base(shebang[0])] {
+		depth, _ := ctx.Value(shebangDepthKey).(int)
+		if depth >= maxShebangDepth {
+			return WrapError(126, &fs.PathError{Op: "exec", Path: file, Err: syscall.ELOOP})
+		}
+		ctx = context.WithValue(ctx, shebangDepthKey, depth+1)
+
+		// Let the interpreter named by the shebang run the script, e.g.
+		// #!/bin/cat. Interpreters are usually named by their absolute paths,
+		// but we're likely to have them as programs instead.
+		if _, isProg := env.Programs[path.Base(shebang[0])]; isProg {
+			shebang[0] = path.Base(shebang[0])
+		}
+		argv := append(shebang, file)
+		argv = append(argv, args[1:]...)
+		return env.Execute(ctx, env, argv...)
+	}
+
+	parser := syntax.NewParser(
+		syntax.KeepComments(false),
+		syntax.Variant(syntax.LangBash),
+	)
+
+	prog, err := parser.Parse(bytes.NewReader(src), file)
+	if err != nil {
+		return WrapError(2, err)
+	}
+
+	sh, err := inst.newProgramShell(env, args[1:])
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range prog.Stmts {
+		err = inst.runStmt(ctx, sh, stmt)
+		if _, isExit := interp.IsExitStatus(err); sh.runner.Exited() || (err != nil && !isExit) {
+			break
+		}
+	}
+	return err
+}
+
+// runBuiltin runs a shell builtin, e.g. echo, as if it was a program. This lets
+// programs like xargs run builtins.
+func (inst *Interpreter) runBuiltin(ctx context.Context, env Environment, args []string) error {
+	words := make([]string, len(args))
+	for i, arg := range args {
+		word, err := syntax.Quote(arg, syntax.LangBash)
+		if err != nil {
+			return WrapError(2, errors.Wrapf(err, "cannot quote argument %d", i))
+		}
+		words[i] = word
+	}
+
+	stmt, err := parseStmt(strings.Join(words, " "))
+	if err != nil {
+		return WrapError(2, err)
+	}
+
+	sh, err := inst.newProgramShell(env, nil)
+	if err != nil {
+		return err
+	}
+
+	return inst.runStmt(ctx, sh, stmt)
+}
+
+// parseStmt parses a single statement.
+func parseStmt(src string) (*syntax.Stmt, error) {
+	f, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(src), "")
+	if err != nil {
+		return nil, err
+	}
+	return f.Stmts[0], nil
+}
+
+// newProgramShell creates a new shell that runs within the given environment,
+// like a separate shell process would. params are its positional parameters.
+func (inst *Interpreter) newProgramShell(env Environment, params []string) (*shell, error) {
+	stdio := env.Terminal.IO
+
+	sh, err := newShell(exportedEnviron(env.Environ), env.Umask, append(inst.runnerOptions(),
+		interp.StdIO(stdio.Stdin, stdio.Stdout, stdio.Stderr),
+		interp.Params(append([]string{"--"}, params...)...),
+		interp.Dir(env.Cwd),
+	)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to init shell runner")
+	}
+	return sh, nil
+}
+
+// exportedEnviron returns only the exported variables of env, which is what a
+// script gets to see.
+func exportedEnviron(env expand.Environ) expand.Environ {
+	var pairs []string
+	env.Each(func(name string, vr expand.Variable) bool {
+		if vr.Exported && vr.IsSet() {
+			pairs = append(pairs, name+"="+vr.String())
+		}
+		return true
+	})
+	return expand.ListEnviron(pairs...)
+}
+
+// parseShebang parses the shebang line of the given script into the
+// interpreter and its optional argument. /usr/bin/env is skipped over, so the
+// interpreter is the program that env would have run.
+func parseShebang(src []byte) ([]string, bool) {
+	if !bytes.HasPrefix(src, []byte("#!")) {
+		return nil, false
+	}
+
+	line, _ := bufio.NewReader(bytes.NewReader(src[2:])).ReadString('\n')
+
+	// Like Linux, only split the interpreter from its argument once.
+	line = strings.TrimSpace(line)
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	if name == "" {
+		return nil, false
+	}
+
+	if path.Base(name) == "env" && arg != "" {
+		return strings.Fields(arg), true
+	}
+
+	if arg != "" {
+		return []string{name, arg}, true
+	}
+	return []string{name}, true
+}
+
+// pathExecutables returns the names of files in $PATH starting with prefix.
+func pathExecutables(env Environment, prefix string) []string {
+	var names []string
+	for _, dir := range pathDirs(env) {
+		entries, err := fs.ReadDir(env.Filesystem, dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), prefix) {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+	return names
+}
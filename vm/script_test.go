@@ -0,0 +1,147 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"libdb.so/vm/rwfs/kvfs"
+	"mvdan.cc/sh/v3/expand"
+)
+
+// testProgram is a program defined by a function.
+type testProgram struct {
+	name string
+	run  func(ctx context.Context, env Environment, args []string) error
+}
+
+func (p testProgram) Name() string { return p.name }
+
+func (p testProgram) Run(ctx context.Context, env Environment, args []string) error {
+	return p.run(ctx, env, args)
+}
+
+// lockedBuffer is a buffer that pipeline stages can write to concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// testInterpreter is an interpreter running on an in-memory filesystem, with
+// its output kept in buffers.
+type testInterpreter struct {
+	*Interpreter
+	stdout lockedBuffer
+	stderr lockedBuffer
+}
+
+func newTestInterpreter(t *testing.T, programs ...Program) *testInterpreter {
+	t.Helper()
+
+	var inst testInterpreter
+
+	env := &Environment{
+		Terminal: NewTerminal(IO{
+			Stdin:  io.NopCloser(strings.NewReader("")),
+			Stdout: &inst.stdout,
+			Stderr: &inst.stderr,
+		}, TerminalQuery{}),
+		Filesystem: kvfs.New(kvfs.MemoryStorage()),
+		Cwd:        "/",
+		Programs:   make(map[string]Program, len(programs)),
+		Environ:    expand.ListEnviron("PATH=/bin"),
+		Umask:      DefaultUmask,
+	}
+	for _, prog := range programs {
+		env.Programs[prog.Name()] = prog
+	}
+
+	var err error
+	inst.Interpreter, err = NewInterpreter(env, InterpreterOpts{})
+	assert.NoError(t, err)
+	t.Cleanup(func() { inst.Close() })
+
+	return &inst
+}
+
+// run runs the given code like a line typed into the prompt.
+func (inst *testInterpreter) run(code string) {
+	ctx := context.WithValue(context.Background(), environmentKey, inst.env)
+	ctx = context.WithValue(ctx, loggerKey, inst.logger)
+	inst.exec(ctx, code)
+}
+
+func (inst *testInterpreter) writeFile(t *testing.T, name, data string, perm os.FileMode) {
+	t.Helper()
+
+	f, err := inst.env.Filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+}
+
+// argsProgram prints its arguments.
+var argsProgram = testProgram{
+	name: "args",
+	run: func(ctx context.Context, env Environment, args []string) error {
+		env.Println(strings.Join(args, " "))
+		return nil
+	},
+}
+
+func TestShebang(t *testing.T) {
+	inst := newTestInterpreter(t, argsProgram)
+	assert.NoError(t, inst.env.Filesystem.Mkdir("/bin", 0755))
+	inst.writeFile(t, "/bin/a", "#!/bin/b\n", 0755)
+	inst.writeFile(t, "/bin/b", "#!/usr/bin/env args -x\n", 0755)
+	inst.writeFile(t, "/bin/sh-script", "#!/bin/sh\necho \"$1\"\n", 0644)
+
+	inst.run("a 1; sh-script 2")
+	assert.Equal(t, "args -x /bin/b /bin/a 1\n2\n", inst.stdout.String())
+	assert.Equal(t, "", inst.stderr.String())
+}
+
+func TestShebangLoop(t *testing.T) {
+	inst := newTestInterpreter(t, argsProgram)
+	assert.NoError(t, inst.env.Filesystem.Mkdir("/bin", 0755))
+
+	// Scripts running themselves, or each other.
+	inst.writeFile(t, "/bin/self", "#!/bin/self\n", 0755)
+	inst.writeFile(t, "/bin/ping", "#!/bin/pong\n", 0755)
+	inst.writeFile(t, "/bin/pong", "#!/bin/ping\n", 0755)
+
+	// Chains up to the limit are fine.
+	for i := 1; i <= maxShebangDepth; i++ {
+		shebang := "#!/bin/chain" + strconv.Itoa(i-1) + "\n"
+		if i == 1 {
+			shebang = "#!args\n"
+		}
+		inst.writeFile(t, "/bin/chain"+strconv.Itoa(i), shebang, 0755)
+	}
+
+	inst.run("self; echo $?; ping; echo $?; chain4")
+	assert.Equal(t, "126\n126\nargs /bin/chain1 /bin/chain2 /bin/chain3 /bin/chain4\n", inst.stdout.String())
+	assert.Equal(t, ""+
+		"exec /bin/self: too many levels of symbolic links\n"+
+		"exec /bin/ping: too many levels of symbolic links\n",
+		inst.stderr.String())
+}
@@ -0,0 +1,426 @@
+package vm
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// shell is a shell runner along with the state that we keep for it.
+//
+// Statements are rewritten before the runner sees them, so that it hands its
+// pipelines, subshells and background statements back to us instead of
+// running them itself. Every runner that runs code is then one of ours, which
+// our handlers can find in their context.
+type shell struct {
+	runner *interp.Runner
+
+	mu         sync.Mutex
+	umask      fs.FileMode
+	pipeStatus []int
+	// sourcing is the name of the file that the source builtin is about to
+	// open.
+	sourcing string
+
+	// bg tracks the background statements started by the shell.
+	bg sync.WaitGroup
+}
+
+type shellKey struct{}
+
+// withShell returns a context for running code with the given shell.
+func withShell(ctx context.Context, sh *shell) context.Context {
+	return context.WithValue(ctx, shellKey{}, sh)
+}
+
+// shellFromContext returns the shell that is calling a handler, or nil if
+// there is none.
+func shellFromContext(ctx context.Context) *shell {
+	sh, _ := ctx.Value(shellKey{}).(*shell)
+	return sh
+}
+
+// newShell creates a new shell whose runner starts with the given environment,
+// umask and options.
+func newShell(env expand.Environ, umask fs.FileMode, opts ...interp.RunnerOption) (*shell, error) {
+	sh := &shell{umask: umask}
+
+	runner, err := interp.New(append(opts, interp.Env(shellEnviron{env, sh}))...)
+	if err != nil {
+		return nil, err
+	}
+
+	sh.runner = runner
+	return sh, nil
+}
+
+// subshell returns a copy of the shell, which can run concurrently with it.
+// Like interp.Runner.Subshell, it must not be called while the shell is
+// running, except from within one of its handlers.
+func (sh *shell) subshell() *shell {
+	return &shell{
+		runner: sh.runner.Subshell(),
+		umask:  sh.getUmask(),
+	}
+}
+
+func (sh *shell) getUmask() fs.FileMode {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.umask
+}
+
+func (sh *shell) setUmask(umask fs.FileMode) {
+	sh.mu.Lock()
+	sh.umask = umask
+	sh.mu.Unlock()
+}
+
+// setPipeStatus sets $PIPESTATUS to the given exit statuses.
+func (sh *shell) setPipeStatus(statuses []int) {
+	sh.mu.Lock()
+	sh.pipeStatus = statuses
+	sh.mu.Unlock()
+}
+
+func (sh *shell) pipeStatusVar() expand.Variable {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.pipeStatus == nil {
+		return expand.Variable{}
+	}
+
+	list := make([]string, len(sh.pipeStatus))
+	for i, status := range sh.pipeStatus {
+		list[i] = strconv.Itoa(status)
+	}
+	return expand.Variable{Kind: expand.Indexed, List: list}
+}
+
+// sourceNext marks name as the file that the source builtin opens next.
+func (sh *shell) sourceNext(name string) {
+	sh.mu.Lock()
+	sh.sourcing = name
+	sh.mu.Unlock()
+}
+
+// isSourcing returns true if name is the file being opened by the source
+// builtin. It only returns true once for each call to sourceNext.
+func (sh *shell) isSourcing(name string) bool {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	ok := sh.sourcing != "" && sh.sourcing == name
+	sh.sourcing = ""
+	return ok
+}
+
+// shellEnviron is the environment that a shell's runner starts with. It adds
+// the variables that we keep for the runner, which the runner has no API for.
+//
+// Subshells get a copy of their parent's variables instead, so they see the
+// $PIPESTATUS of when they were started, even after running pipelines of their
+// own.
+type shellEnviron struct {
+	expand.Environ
+	sh *shell
+}
+
+func (env shellEnviron) Get(name string) expand.Variable {
+	if name == "PIPESTATUS" {
+		return env.sh.pipeStatusVar()
+	}
+	return env.Environ.Get(name)
+}
+
+func (env shellEnviron) Each(f func(name string, vr expand.Variable) bool) {
+	more := true
+	env.Environ.Each(func(name string, vr expand.Variable) bool {
+		if name == "PIPESTATUS" {
+			return true
+		}
+		more = f(name, vr)
+		return more
+	})
+	if vr := env.sh.pipeStatusVar(); more && vr.IsSet() {
+		f("PIPESTATUS", vr)
+	}
+}
+
+// runStmt runs the statement using the given shell.
+func (inst *Interpreter) runStmt(ctx context.Context, sh *shell, stmt *syntax.Stmt) error {
+	inst.rewrite(stmt)
+	return sh.runner.Run(withShell(ctx, sh), stmt)
+}
+
+// The builtins that rewritten statements call to hand their code back to us.
+// Their arguments are the source of the code and its signature. Only we can
+// sign code, so these can't be called with any other code, e.g. by the user.
+const (
+	pipelineBuiltin   = builtinPrefix + "pipeline"
+	subshellBuiltin   = builtinPrefix + "subshell"
+	backgroundBuiltin = builtinPrefix + "background"
+)
+
+var shellCodeBuiltins = map[string]bool{
+	pipelineBuiltin:   true,
+	subshellBuiltin:   true,
+	backgroundBuiltin: true,
+}
+
+// rewrite replaces the pipelines, subshells, command substitutions and
+// background statements within node with calls to our builtins, which run them
+// using our own shells. The runner would otherwise run them in subshells that
+// we can't reach, and connect pipelines using OS pipes, which aren't available
+// everywhere, e.g. on js/wasm.
+//
+// The code is carried by the calls themselves, so it lives exactly as long as
+// the statement that runs it, or the function that it's part of. It is only
+// rewritten in turn once it runs.
+func (inst *Interpreter) rewrite(node syntax.Node) {
+	syntax.Walk(node, func(node syntax.Node) bool {
+		switch node := node.(type) {
+		case *syntax.Stmt:
+			if node.Background {
+				stmt := *node
+				stmt.Background = false
+
+				call := inst.shellCall(backgroundBuiltin, &stmt)
+				if call == nil {
+					return true
+				}
+
+				*node = syntax.Stmt{
+					Position:  node.Position,
+					Semicolon: node.Semicolon,
+					Cmd:       call,
+				}
+				return false
+			}
+
+			var call *syntax.CallExpr
+			switch cmd := node.Cmd.(type) {
+			case *syntax.BinaryCmd:
+				if cmd.Op != syntax.Pipe && cmd.Op != syntax.PipeAll {
+					return true
+				}
+				call = inst.shellCall(pipelineBuiltin, cmd)
+			case *syntax.Subshell:
+				call = inst.shellCall(subshellBuiltin, &syntax.File{Stmts: cmd.Stmts})
+			}
+			if call == nil {
+				return true
+			}
+
+			node.Cmd = call
+			for _, redir := range node.Redirs {
+				inst.rewrite(redir)
+			}
+			return false
+
+		case *syntax.CmdSubst:
+			if len(node.Stmts) == 0 || isCatShortcut(node) {
+				return false
+			}
+
+			call := inst.shellCall(subshellBuiltin, &syntax.File{Stmts: node.Stmts})
+			if call == nil {
+				return true
+			}
+
+			node.Stmts = []*syntax.Stmt{{
+				Position: node.Left,
+				Cmd:      call,
+			}}
+			return false
+
+		case *syntax.ProcSubst:
+			// Process substitutions run concurrently with the shell that
+			// started them, so they're left to the runner.
+			return false
+		}
+		return true
+	})
+}
+
+// shellCall returns a call to the builtin that runs the code of node. It
+// returns nil if node can't be printed back into code, in which case it's left
+// to the runner.
+func (inst *Interpreter) shellCall(builtin string, node syntax.Node) *syntax.CallExpr {
+	var src strings.Builder
+	if err := syntax.NewPrinter().Print(&src, node); err != nil {
+		return nil
+	}
+
+	quoted, err := syntax.Quote(src.String(), syntax.LangBash)
+	if err != nil {
+		return nil
+	}
+
+	stmt, err := parseStmt(builtin + " " + quoted + " " + inst.signCode(builtin, src.String()))
+	if err != nil {
+		return nil
+	}
+
+	call, _ := stmt.Cmd.(*syntax.CallExpr)
+	return call
+}
+
+// signCode returns the signature of the code given to the builtin.
+func (inst *Interpreter) signCode(builtin, src string) string {
+	mac := hmac.New(sha256.New, inst.codeKey)
+	io.WriteString(mac, builtin)
+	mac.Write([]byte{0})
+	io.WriteString(mac, src)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isShellCode returns true if args is a call to one of our builtins that was
+// made by rewrite.
+func (inst *Interpreter) isShellCode(args []string) bool {
+	if len(args) != 3 || !shellCodeBuiltins[args[0]] {
+		return false
+	}
+	return hmac.Equal([]byte(args[2]), []byte(inst.signCode(args[0], args[1])))
+}
+
+func literalWord(s string) *syntax.Word {
+	return &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: s}}}
+}
+
+// isCatShortcut returns true if cs is $(<file), which the runner reads
+// without a subshell.
+func isCatShortcut(cs *syntax.CmdSubst) bool {
+	if len(cs.Stmts) != 1 {
+		return false
+	}
+	stmt := cs.Stmts[0]
+	return stmt.Cmd == nil && len(stmt.Redirs) == 1 && stmt.Redirs[0].Op == syntax.RdrIn
+}
+
+// rewriteSource rewrites the shell code in src for runners that parse code
+// themselves, like eval and source do. It returns false if src can't be
+// parsed, in which case the runner reports the error.
+func (inst *Interpreter) rewriteSource(src string) (string, bool) {
+	f, err := syntax.NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		return "", false
+	}
+
+	inst.rewrite(f)
+
+	var b strings.Builder
+	if err := syntax.NewPrinter().Print(&b, f); err != nil {
+		return "", false
+	}
+	return b.String(), true
+}
+
+// openSource reads the file opened for the source builtin and returns it
+// rewritten.
+func (inst *Interpreter) openSource(f io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	src, ok := inst.rewriteSource(string(b))
+	if !ok {
+		src = string(b)
+	}
+	return readOnlyFile{strings.NewReader(src)}, nil
+}
+
+// readOnlyFile is an in-memory file that can only be read.
+type readOnlyFile struct {
+	io.Reader
+}
+
+func (readOnlyFile) Write([]byte) (int, error) { return 0, fs.ErrPermission }
+func (readOnlyFile) Close() error              { return nil }
+
+// runShellCode runs the code that a rewritten statement handed back to us,
+// using the shell that called the builtin.
+func (inst *Interpreter) runShellCode(ctx context.Context, args []string) error {
+	sh := shellFromContext(ctx)
+	if sh == nil {
+		return fmt.Errorf("%s: not running in a shell", args[0])
+	}
+
+	f, err := syntax.NewParser().Parse(strings.NewReader(args[1]), "")
+	if err != nil {
+		return errors.Wrapf(err, "%s: cannot parse code", args[0])
+	}
+
+	if args[0] == pipelineBuiltin {
+		var bin *syntax.BinaryCmd
+		if len(f.Stmts) == 1 {
+			bin, _ = pipeline(f.Stmts[0])
+		}
+		if bin == nil {
+			return fmt.Errorf("%s: not a pipeline", args[0])
+		}
+
+		stages := pipelineStages(bin)
+		for _, stage := range stages {
+			inst.rewrite(stage.stmt)
+		}
+		return runPipeline(ctx, sh, stages)
+	}
+
+	for _, stmt := range f.Stmts {
+		inst.rewrite(stmt)
+	}
+
+	handler := interp.HandlerCtx(ctx)
+	sub := sh.subshell()
+	interp.StdIO(handler.Stdin, handler.Stdout, handler.Stderr)(sub.runner)
+
+	if args[0] == backgroundBuiltin {
+		sh.bg.Add(1)
+		go func() {
+			defer sh.bg.Done()
+			sub.runner.Run(withShell(ctx, sub), &syntax.Block{Stmts: f.Stmts})
+		}()
+		return nil
+	}
+
+	return sub.runner.Run(withShell(ctx, sub), &syntax.Block{Stmts: f.Stmts})
+}
+
+// waitBackground waits for the background statements started by the shell
+// calling the handler.
+func waitBackground(ctx context.Context) error {
+	sh := shellFromContext(ctx)
+	if sh == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		sh.bg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
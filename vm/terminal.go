@@ -1,13 +1,9 @@
 package vm
 
 import (
-	"fmt"
 	"io"
-	"os"
 	"strings"
 
-	"github.com/pkg/errors"
-	"golang.org/x/crypto/ssh/terminal"
 	"libdb.so/vm/internal/syncg"
 )
 
@@ -35,22 +31,6 @@ func NoIOExceptStderr(stderr io.Writer) IO {
 	return io
 }
 
-func (io IO) makeRaw() (func() error, error) {
-	stdin, ok := io.Stdin.(*os.File)
-	if !ok {
-		return nil, fmt.Errorf("stdin is not a file but %T", io.Stdin)
-	}
-
-	oldState, err := terminal.MakeRaw(int(stdin.Fd()))
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to put terminal in raw mode")
-	}
-
-	return func() error {
-		return terminal.Restore(0, oldState)
-	}, nil
-}
-
 // TerminalQuery is a query to the terminal. It contains relevant terminal info
 // needed for various purposes. For the most part, it's an extension to
 // TIOCGWINSZ.
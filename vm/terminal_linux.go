@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// makeRaw puts the terminal behind io.Stdin into raw mode. It returns a
+// function that restores the terminal to its previous state.
+//
+// Unlike cfmakeraw(3), output post-processing is kept enabled, so programs can
+// keep writing "\n" without having to care about the carriage return. Signal
+// generation is disabled, so ^C and ^\ are delivered to the interpreter as
+// regular input instead of killing the whole process.
+func (io IO) makeRaw() (func() error, error) {
+	stdin, ok := io.Stdin.(*os.File)
+	if !ok {
+		return nil, fmt.Errorf("stdin is not a file but %T", io.Stdin)
+	}
+
+	fd := int(stdin.Fd())
+
+	oldState, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get terminal state")
+	}
+
+	newState := *oldState
+	newState.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	newState.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG |
+		unix.IEXTEN
+	newState.Cflag &^= unix.CSIZE | unix.PARENB
+	newState.Cflag |= unix.CS8
+	newState.Cc[unix.VMIN] = 1
+	newState.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &newState); err != nil {
+		return nil, errors.Wrap(err, "failed to put terminal in raw mode")
+	}
+
+	return func() error {
+		return unix.IoctlSetTermios(fd, unix.TCSETS, oldState)
+	}, nil
+}
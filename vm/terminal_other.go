@@ -0,0 +1,29 @@
+//go:build !linux
+
+package vm
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func (io IO) makeRaw() (func() error, error) {
+	stdin, ok := io.Stdin.(*os.File)
+	if !ok {
+		return nil, fmt.Errorf("stdin is not a file but %T", io.Stdin)
+	}
+
+	fd := int(stdin.Fd())
+
+	oldState, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to put terminal in raw mode")
+	}
+
+	return func() error {
+		return terminal.Restore(fd, oldState)
+	}, nil
+}
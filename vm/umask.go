@@ -0,0 +1,78 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"libdb.so/vm/internal/filemode"
+)
+
+const umaskUsage = "umask [-p] [-S] [MODE]"
+
+// umaskBuiltin implements the umask builtin. It prints the umask in octal, or
+// symbolically with -S, and sets it to MODE if given. A symbolic MODE gives
+// the permissions to keep, like umask(1). The umask belongs to the shell
+// running the builtin, so subshells can't change their parent's.
+func (inst *Interpreter) umaskBuiltin(ctx context.Context, env Environment, args []string) error {
+	var symbolic, reusable bool
+
+	args = args[1:]
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		for _, c := range args[0][1:] {
+			switch c {
+			case 'S':
+				symbolic = true
+			case 'p':
+				reusable = true
+			default:
+				return &UsageError{
+					Err:   fmt.Errorf("-%c: invalid option", c),
+					Usage: umaskUsage,
+				}
+			}
+		}
+		args = args[1:]
+	}
+
+	switch len(args) {
+	case 0:
+		s := fmt.Sprintf("%04o", env.Umask)
+		if symbolic {
+			s = filemode.Symbolic(0777 &^ env.Umask)
+		}
+		if reusable {
+			// Print a command that sets the umask back to this.
+			if symbolic {
+				s = "-S " + s
+			}
+			s = "umask " + s
+		}
+		env.Println(s)
+		return nil
+	case 1:
+		// ok
+	default:
+		return &UsageError{Usage: umaskUsage}
+	}
+
+	var umask fs.FileMode
+	if filemode.IsOctal(args[0]) {
+		perm, err := filemode.Apply(args[0], 0, 0, false)
+		if err != nil {
+			return err
+		}
+		umask = perm
+	} else {
+		perm, err := filemode.Apply(args[0], 0777&^env.Umask, 0, true)
+		if err != nil {
+			return err
+		}
+		umask = 0777 &^ perm
+	}
+
+	if sh := shellFromContext(ctx); sh != nil {
+		sh.setUmask(umask)
+	}
+	return nil
+}